@@ -0,0 +1,369 @@
+// Package aggregator merges the order books of one pair on several exchanges into a consolidated book.
+package aggregator
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+// Venue an exchange whose order book takes part in the consolidated book
+type Venue struct {
+	Name     string
+	Exchange ExchangeApi.IExchange
+	Symbol   string  // the symbol subscribed on this exchange, the symbol of the aggregator if empty
+	TakerFee float64 // the taker fee rate, eg. 0.001 means 0.1%, it is applied to the prices if Options.ApplyFees
+}
+
+type Options struct {
+	Level         int           // the level passed to SubscribeOrderBook
+	Speed         int           // the speed passed to SubscribeOrderBook
+	IsIncremental bool          // whether subscribe the incremental order books
+	Depth         int           // the levels of each venue merged, all of the levels if 0
+	ApplyFees     bool          // the bids are lowered and the asks are raised by the taker fee, so the prices are what a taker gets
+	StaleTimeout  time.Duration // a venue without order book for the time is stale and left out, 0 means never
+}
+
+// VenueLevel the part of a consolidated level from one venue
+type VenueLevel struct {
+	Venue  string
+	Price  float64 // the price on the venue, before the fee applied
+	Amount float64
+}
+
+// Level a price level of the consolidated book
+type Level struct {
+	Price  float64 // the price after the fee applied if Options.ApplyFees
+	Amount float64 // the sum of the amounts of the venues
+	Venues []VenueLevel
+}
+
+// Book the consolidated order book, it is the data of the MsgOrderBook published by the aggregator
+type Book struct {
+	Symbol string
+	Bids   []Level  // from the highest price
+	Asks   []Level  // from the lowest price
+	Venues []string // the venues merged
+	Stale  []string // the venues left out, they have no order book for Options.StaleTimeout or are out of sync
+	Time   time.Time
+}
+
+// OrderBook the consolidated book as an ExchangeApi.OrderBook, so the analytics of OrderBook work on it
+func (b Book) OrderBook() ExchangeApi.OrderBook {
+	depth := func(levels []Level) ExchangeApi.Depth {
+		d := make(ExchangeApi.Depth, 0, len(levels))
+		for _, l := range levels {
+			d = append(d, ExchangeApi.DepthItem{
+				Price:  strconv.FormatFloat(l.Price, 'f', -1, 64),
+				Amount: strconv.FormatFloat(l.Amount, 'f', -1, 64),
+			})
+		}
+		return d
+	}
+	return ExchangeApi.OrderBook{Symbol: b.Symbol, Bids: depth(b.Bids), Asks: depth(b.Asks)}
+}
+
+var (
+	ErrStarted  = errors.New("aggregator already started")
+	ErrNoVenues = errors.New("no venue to aggregate")
+)
+
+type venueState struct {
+	Venue
+	book    ExchangeApi.OrderBook
+	ok      bool      // whether the book is usable, false before the first one or while out of sync
+	updated time.Time // when the last book came, or when subscribed
+	sub     *ExchangeApi.Resubscription
+	msgChan ExchangeApi.MessageChan
+}
+
+type venueMessage struct {
+	venue *venueState
+	msg   ExchangeApi.Message
+}
+
+// Aggregator subscribe the order books through the IExchange of each venue and publish the consolidated book
+type Aggregator struct {
+	symbol  string
+	options Options
+	venues  []*venueState
+
+	lock    sync.Mutex
+	last    Book
+	started bool
+	updates chan venueMessage
+	stop    chan struct{}
+	loops   sync.WaitGroup
+}
+
+func New(symbol string, venues []Venue, options Options) *Aggregator {
+	a := &Aggregator{symbol: symbol, options: options}
+	for _, v := range venues {
+		if v.Symbol == "" {
+			v.Symbol = symbol
+		}
+		a.venues = append(a.venues, &venueState{Venue: v})
+	}
+	return a
+}
+
+// Start subscribe the order books of all venues, the consolidated books are sent to out.
+// It fails if any venue can't be subscribed, the subscriptions made are closed then.
+func (a *Aggregator) Start(out ExchangeApi.MessageChan) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.started {
+		return ErrStarted
+	}
+	if len(a.venues) == 0 {
+		return ErrNoVenues
+	}
+	a.updates = make(chan venueMessage)
+	a.stop = make(chan struct{})
+	for _, v := range a.venues {
+		v.msgChan = make(ExchangeApi.MessageChan)
+		v.ok, v.updated = false, time.Now()
+		a.loops.Add(1)
+		go a.forward(v)
+		if err := a.subscribe(v); err != nil {
+			close(a.stop)
+			a.closeSubscriptions()
+			a.loops.Wait()
+			return err
+		}
+	}
+	a.started = true
+	a.loops.Add(1)
+	go a.loop(out)
+	return nil
+}
+
+// Stop close the subscriptions and wait for the goroutines to exit
+func (a *Aggregator) Stop() error {
+	a.lock.Lock()
+	if !a.started {
+		a.lock.Unlock()
+		return nil
+	}
+	a.started = false
+	close(a.stop)
+	err := a.closeSubscriptions()
+	a.lock.Unlock()
+	a.loops.Wait()
+	return err
+}
+
+// Book the last consolidated book
+func (a *Aggregator) Book() Book {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.last
+}
+
+// StaleVenues the venues left out of the last consolidated book
+func (a *Aggregator) StaleVenues() []string {
+	return a.Book().Stale
+}
+
+func (a *Aggregator) subscribe(v *venueState) error {
+	msgChan := v.msgChan
+	sub, err := ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+		return v.Exchange.SubscribeOrderBook(v.Symbol, a.options.Level, a.options.Speed, a.options.IsIncremental, msgChan)
+	})
+	if err != nil {
+		return err
+	}
+	v.sub = sub
+	return nil
+}
+
+func (a *Aggregator) closeSubscriptions() error {
+	var subs ExchangeApi.Resubscriptions
+	for _, v := range a.venues {
+		subs = append(subs, v.sub)
+		v.sub = nil
+	}
+	return subs.Close()
+}
+
+// forward pass the messages of a venue to the loop, so the books are merged by one goroutine
+func (a *Aggregator) forward(v *venueState) {
+	defer a.loops.Done()
+	for {
+		select {
+		case msg := <-v.msgChan:
+			select {
+			case a.updates <- venueMessage{venue: v, msg: msg}:
+			case <-a.stop:
+				return
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *Aggregator) loop(out ExchangeApi.MessageChan) {
+	defer a.loops.Done()
+	var tick <-chan time.Time
+	if a.options.StaleTimeout > 0 {
+		ticker := time.NewTicker(a.options.StaleTimeout / 2)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var lastStale []string
+	for {
+		select {
+		case update := <-a.updates:
+			if !a.handle(update.venue, update.msg) {
+				continue
+			}
+		case <-tick:
+			// a venue becoming stale is published without waiting for the others to update
+			if equalStrings(a.staleVenues(time.Now()), lastStale) {
+				continue
+			}
+		case <-a.stop:
+			return
+		}
+		book := a.merge(time.Now())
+		lastStale = book.Stale
+		a.lock.Lock()
+		a.last = book
+		a.lock.Unlock()
+		select {
+		case out <- ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: book}:
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// handle update the state of the venue by its message, true if the consolidated book changes
+func (a *Aggregator) handle(v *venueState, msg ExchangeApi.Message) bool {
+	// the data of a connection is sent to all of its channels, the other symbols are dropped
+	switch msg.Type {
+	case ExchangeApi.MsgOrderBook:
+		switch data := msg.Data.(type) {
+		case ExchangeApi.OrderBook:
+			if !strings.EqualFold(data.Symbol, v.Symbol) {
+				return false
+			}
+			v.book, v.ok, v.updated = data, true, time.Now()
+			return true
+		case ExchangeApi.ExError:
+			// the dirty data of the exchanges without resync
+			if symbol, ok := data.Data["symbol"].(string); ok && !strings.EqualFold(symbol, v.Symbol) {
+				return false
+			}
+			v.ok = false
+			return true
+		}
+	case ExchangeApi.MsgOrderBookStatus:
+		if status, ok := msg.Data.(ExchangeApi.OrderBookStatus); ok && status.State == ExchangeApi.BookResyncing && strings.EqualFold(status.Symbol, v.Symbol) {
+			v.ok = false
+			return true
+		}
+	case ExchangeApi.MsgDisConnected, ExchangeApi.MsgClosed:
+		v.ok = false
+		return true
+	case ExchangeApi.MsgReConnected:
+		v.ok = false
+		a.lock.Lock()
+		if v.sub != nil {
+			v.sub.Renew()
+		}
+		a.lock.Unlock()
+		return true
+	}
+	return false
+}
+
+func (a *Aggregator) isStale(v *venueState, now time.Time) bool {
+	if a.options.StaleTimeout > 0 && now.Sub(v.updated) > a.options.StaleTimeout {
+		return true
+	}
+	return !v.ok
+}
+
+func (a *Aggregator) staleVenues(now time.Time) []string {
+	var stale []string
+	for _, v := range a.venues {
+		if a.isStale(v, now) {
+			stale = append(stale, v.Name)
+		}
+	}
+	return stale
+}
+
+// merge the books of the venues up to date
+func (a *Aggregator) merge(now time.Time) Book {
+	book := Book{Symbol: a.symbol, Time: now}
+	bids, asks := map[float64]*Level{}, map[float64]*Level{}
+	add := func(levels map[float64]*Level, v *venueState, d ExchangeApi.Depth, fee float64) {
+		if a.options.Depth > 0 && len(d) > a.options.Depth {
+			d = d[:a.options.Depth]
+		}
+		for _, item := range d {
+			price, amount := SafeParseFloat(item.Price), SafeParseFloat(item.Amount)
+			if amount <= 0 {
+				continue
+			}
+			key := price
+			if a.options.ApplyFees {
+				key = price * (1 + fee)
+			}
+			l, ok := levels[key]
+			if !ok {
+				l = &Level{Price: key}
+				levels[key] = l
+			}
+			l.Amount += amount
+			l.Venues = append(l.Venues, VenueLevel{Venue: v.Name, Price: price, Amount: amount})
+		}
+	}
+	for _, v := range a.venues {
+		if a.isStale(v, now) {
+			book.Stale = append(book.Stale, v.Name)
+			continue
+		}
+		book.Venues = append(book.Venues, v.Name)
+		add(bids, v, v.book.Bids, -v.TakerFee)
+		add(asks, v, v.book.Asks, v.TakerFee)
+	}
+	book.Bids = sortLevels(bids, true)
+	book.Asks = sortLevels(asks, false)
+	return book
+}
+
+func sortLevels(levels map[float64]*Level, reverse bool) []Level {
+	ret := make([]Level, 0, len(levels))
+	for _, l := range levels {
+		ret = append(ret, *l)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if reverse {
+			return ret[i].Price > ret[j].Price
+		}
+		return ret[i].Price < ret[j].Price
+	})
+	return ret
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package aggregator
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+// next wait for the next consolidated book
+func next(t *testing.T, out ExchangeApi.MessageChan) Book {
+	t.Helper()
+	return testutil.Receive(t, out, "consolidated book").Data.(Book)
+}
+
+func TestAggregator_Merge(t *testing.T) {
+	binance, okex := mock.New("binance"), mock.New("okex")
+	a := New("BTC/USDT", []Venue{
+		{Name: "binance", Exchange: binance, TakerFee: 0.001},
+		{Name: "okex", Exchange: okex, Symbol: "BTC-USDT", TakerFee: 0.002},
+	}, Options{Depth: 2})
+	out := make(ExchangeApi.MessageChan)
+	if err := a.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	if err := a.Start(out); err != ErrStarted {
+		t.Errorf("expect started error, got %v", err)
+	}
+
+	binance.SetOrderBook(ExchangeApi.OrderBook{
+		Symbol: "BTC/USDT",
+		Bids:   ExchangeApi.Depth{{Price: "100", Amount: "1"}, {Price: "99", Amount: "2"}, {Price: "98", Amount: "100"}},
+		Asks:   ExchangeApi.Depth{{Price: "101", Amount: "1"}},
+	})
+	book := next(t, out)
+	if len(book.Venues) != 1 || len(book.Stale) != 1 || book.Stale[0] != "okex" {
+		t.Errorf("expect okex not ready, got venues %v stale %v", book.Venues, book.Stale)
+	}
+
+	okex.SetOrderBook(ExchangeApi.OrderBook{
+		Symbol: "BTC-USDT",
+		Bids:   ExchangeApi.Depth{{Price: "100", Amount: "3"}},
+		Asks:   ExchangeApi.Depth{{Price: "100.5", Amount: "2"}, {Price: "101", Amount: "4"}},
+	})
+	book = next(t, out)
+	if len(book.Stale) != 0 {
+		t.Errorf("expect no stale venue, got %v", book.Stale)
+	}
+	// the third bid of binance is beyond the depth
+	if len(book.Bids) != 2 || book.Bids[0].Price != 100 || book.Bids[0].Amount != 4 || len(book.Bids[0].Venues) != 2 {
+		t.Errorf("unexpected bids %+v", book.Bids)
+	}
+	if len(book.Asks) != 2 || book.Asks[0].Price != 100.5 || book.Asks[1].Amount != 5 {
+		t.Errorf("unexpected asks %+v", book.Asks)
+	}
+	if ob := book.OrderBook(); ob.Mid() != 100.25 {
+		t.Errorf("expect mid 100.25, got %v", ob.Mid())
+	}
+
+	// the venue out of sync is left out until its book comes again
+	okex.Publish("BTC-USDT", ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookStatus, Data: ExchangeApi.OrderBookStatus{
+		Symbol: "BTC-USDT", State: ExchangeApi.BookResyncing,
+	}})
+	book = next(t, out)
+	if len(book.Stale) != 1 || book.Stale[0] != "okex" || book.Asks[0].Price != 101 {
+		t.Errorf("expect okex left out, got %+v", book)
+	}
+
+	// the books of the other symbols on the connection are dropped
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "ETH/USDT", Bids: ExchangeApi.Depth{{Price: "3000", Amount: "1"}}})
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Bids: ExchangeApi.Depth{{Price: "100", Amount: "2"}}})
+	book = next(t, out)
+	if len(book.Bids) != 1 || book.Bids[0].Price != 100 || book.Bids[0].Amount != 2 {
+		t.Errorf("unexpected book %+v", book)
+	}
+}
+
+func TestAggregator_Fees(t *testing.T) {
+	binance, huobi := mock.New("binance"), mock.New("huobi")
+	a := New("BTC/USDT", []Venue{
+		{Name: "binance", Exchange: binance, TakerFee: 0.001},
+		{Name: "huobi", Exchange: huobi, TakerFee: 0.002},
+	}, Options{ApplyFees: true})
+	out := make(ExchangeApi.MessageChan)
+	if err := a.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Asks: ExchangeApi.Depth{{Price: "1000", Amount: "1"}}})
+	next(t, out)
+	huobi.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Asks: ExchangeApi.Depth{{Price: "999.5", Amount: "1"}}})
+	book := next(t, out)
+	// huobi is cheaper but costs more after the fee: 999.5 * 1.002 > 1000 * 1.001
+	if len(book.Asks) != 2 || book.Asks[0].Venues[0].Venue != "binance" || math.Abs(book.Asks[0].Price-1001) > 1e-9 {
+		t.Errorf("unexpected asks after fees %+v", book.Asks)
+	}
+}
+
+func TestAggregator_Stale(t *testing.T) {
+	binance, okex := mock.New("binance"), mock.New("okex")
+	a := New("BTC/USDT", []Venue{
+		{Name: "binance", Exchange: binance},
+		{Name: "okex", Exchange: okex},
+	}, Options{StaleTimeout: time.Millisecond * 200})
+	out := make(ExchangeApi.MessageChan)
+	if err := a.Start(out); err != nil {
+		t.Fatal(err)
+	}
+
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Bids: ExchangeApi.Depth{{Price: "100", Amount: "1"}}})
+	next(t, out)
+	okex.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Bids: ExchangeApi.Depth{{Price: "100", Amount: "1"}}})
+	next(t, out)
+
+	// only binance keeps updating, okex becomes stale
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond * 50):
+				binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Bids: ExchangeApi.Depth{{Price: "100", Amount: "1"}}})
+			}
+		}
+	}()
+	deadline := time.After(time.Second * 2)
+	for stale := false; !stale; {
+		select {
+		case msg := <-out:
+			book := msg.Data.(Book)
+			stale = len(book.Stale) == 1 && book.Stale[0] == "okex"
+		case <-deadline:
+			t.Fatal("okex not reported stale")
+		}
+	}
+	if stale := a.StaleVenues(); len(stale) != 1 || stale[0] != "okex" {
+		t.Errorf("expect okex stale, got %v", stale)
+	}
+
+	close(stop)
+	<-stopped
+	if err := a.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if binance.Subscribers(ExchangeApi.MsgOrderBook, "BTC/USDT") != 0 {
+		t.Error("expect unsubscribed after stopped")
+	}
+}
@@ -0,0 +1,217 @@
+package ExchangeApi
+
+import (
+	"math"
+
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+// The analytics of OrderBook, they work on the order books of FetchOrderBook and MsgOrderBook alike.
+// The bids are expected from the highest price and the asks from the lowest, as the exchanges return them,
+// call OrderBook.Sort first if the book is built by hand.
+// The side of the methods taking one is the side of the order taking the book, Buy is about the asks and Sell the bids.
+// All of the values are 0 if the side needed is empty.
+
+// bps 1 basis point = 0.01%
+const bps = 10000
+
+type bookLevel struct {
+	price  float64
+	amount float64
+}
+
+// parseLevels parse the best n levels of the depth, all of the levels if n <= 0
+func parseLevels(d Depth, n int) []bookLevel {
+	if n <= 0 || n > len(d) {
+		n = len(d)
+	}
+	ret := make([]bookLevel, 0, n)
+	for _, item := range d[:n] {
+		ret = append(ret, parseLevel(item))
+	}
+	return ret
+}
+
+func parseLevel(item DepthItem) bookLevel {
+	return bookLevel{price: SafeParseFloat(item.Price), amount: SafeParseFloat(item.Amount)}
+}
+
+func bestLevel(d Depth) (bookLevel, bool) {
+	if len(d) == 0 {
+		return bookLevel{}, false
+	}
+	return parseLevel(d[0]), true
+}
+
+// isBuySide whether the order of the side takes the asks
+func isBuySide(side Side) bool {
+	return side == Buy || side == OpenLong || side == CloseShort
+}
+
+// Mid the middle of the best bid and the best ask
+func (o OrderBook) Mid() float64 {
+	bid, ok1 := bestLevel(o.Bids)
+	ask, ok2 := bestLevel(o.Asks)
+	if !ok1 || !ok2 {
+		return 0
+	}
+	return (bid.price + ask.price) / 2
+}
+
+// MicroPrice the mid weighted by the amounts of the best levels, it leans to the side with less amount,
+// where the price is more likely to move
+func (o OrderBook) MicroPrice() float64 {
+	bid, ok1 := bestLevel(o.Bids)
+	ask, ok2 := bestLevel(o.Asks)
+	if !ok1 || !ok2 || bid.amount+ask.amount == 0 {
+		return 0
+	}
+	return (bid.price*ask.amount + ask.price*bid.amount) / (bid.amount + ask.amount)
+}
+
+// Spread the best ask - the best bid
+func (o OrderBook) Spread() float64 {
+	bid, ok1 := bestLevel(o.Bids)
+	ask, ok2 := bestLevel(o.Asks)
+	if !ok1 || !ok2 {
+		return 0
+	}
+	return ask.price - bid.price
+}
+
+// SpreadBps the spread relative to the mid, in basis points
+func (o OrderBook) SpreadBps() float64 {
+	mid := o.Mid()
+	if mid == 0 {
+		return 0
+	}
+	return o.Spread() / mid * bps
+}
+
+// DepthWithin the amount a buy order (side Buy) takes from the asks, or a sell order (side Sell) from the bids,
+// priced within n basis points of the mid, in base and quote currency
+func (o OrderBook) DepthWithin(side Side, n float64) (base, quote float64) {
+	mid := o.Mid()
+	if mid == 0 {
+		return 0, 0
+	}
+	d, limit := o.Bids, mid*(1-n/bps)
+	if isBuySide(side) {
+		d, limit = o.Asks, mid*(1+n/bps)
+	}
+	for _, item := range d {
+		l := parseLevel(item)
+		if (isBuySide(side) && l.price > limit) || (!isBuySide(side) && l.price < limit) {
+			break
+		}
+		base += l.amount
+		quote += l.amount * l.price
+	}
+	return
+}
+
+// Imbalance (bid amount - ask amount) / (bid amount + ask amount) of the best n levels of each side, all of the levels if n <= 0.
+// It's in [-1, 1], a positive value means more buying interest
+func (o OrderBook) Imbalance(n int) float64 {
+	var bid, ask float64
+	for _, l := range parseLevels(o.Bids, n) {
+		bid += l.amount
+	}
+	for _, l := range parseLevels(o.Asks, n) {
+		ask += l.amount
+	}
+	if bid+ask == 0 {
+		return 0
+	}
+	return (bid - ask) / (bid + ask)
+}
+
+// DepthPoint a point of the cumulative depth curve
+type DepthPoint struct {
+	Price  float64 // the price of the level
+	Amount float64 // the base amount of the levels up to this price
+	Quote  float64 // the quote amount of the levels up to this price
+}
+
+// DepthCurve the cumulative depth of the best n levels of the asks taken by a buy order (side Buy),
+// or of the bids taken by a sell order (side Sell), all of the levels if n <= 0
+func (o OrderBook) DepthCurve(side Side, n int) []DepthPoint {
+	d := o.Bids
+	if isBuySide(side) {
+		d = o.Asks
+	}
+	curve := make([]DepthPoint, 0, len(d))
+	var point DepthPoint
+	for _, l := range parseLevels(d, n) {
+		point.Price = l.price
+		point.Amount += l.amount
+		point.Quote += l.amount * l.price
+		curve = append(curve, point)
+	}
+	return curve
+}
+
+// FillEstimate the estimation of a market order walking through the book
+type FillEstimate struct {
+	AvgPrice    float64 // the volume weighted average price
+	Amount      float64 // the base amount filled
+	Quote       float64 // the quote amount filled
+	WorstPrice  float64 // the price of the last level taken
+	Levels      int     // the number of levels taken
+	Complete    bool    // false if the book is not deep enough for the size
+	SlippageBps float64 // how much worse the average price is than the best price, in basis points
+}
+
+// EstimateFill walk through the asks by a buy order (side Buy), or the bids by a sell order (side Sell), of the base amount.
+// The levels without a positive price are skipped.
+func (o OrderBook) EstimateFill(side Side, amount float64) FillEstimate {
+	return o.estimateFill(side, amount, false)
+}
+
+// EstimateFillQuote walk through the asks by a buy order (side Buy), or the bids by a sell order (side Sell), of the quote amount.
+// The levels without a positive price are skipped.
+func (o OrderBook) EstimateFillQuote(side Side, quote float64) FillEstimate {
+	return o.estimateFill(side, quote, true)
+}
+
+func (o OrderBook) estimateFill(side Side, size float64, byQuote bool) FillEstimate {
+	d := o.Bids
+	if isBuySide(side) {
+		d = o.Asks
+	}
+	var fill FillEstimate
+	var bestPrice float64
+	remain := size
+	for _, item := range d {
+		if remain <= 0 {
+			break
+		}
+		l := parseLevel(item)
+		if l.price <= 0 {
+			continue
+		}
+		if bestPrice == 0 {
+			bestPrice = l.price
+		}
+		amount := l.amount
+		if byQuote {
+			amount = math.Min(amount, remain/l.price)
+			remain -= amount * l.price
+		} else {
+			amount = math.Min(amount, remain)
+			remain -= amount
+		}
+		fill.Amount += amount
+		fill.Quote += amount * l.price
+		fill.WorstPrice = l.price
+		fill.Levels++
+	}
+	if fill.Amount == 0 {
+		return fill
+	}
+	// the size is matched up to the float error
+	fill.Complete = remain <= size*1e-12
+	fill.AvgPrice = fill.Quote / fill.Amount
+	fill.SlippageBps = math.Abs(fill.AvgPrice-bestPrice) / bestPrice * bps
+	return fill
+}
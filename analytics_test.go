@@ -0,0 +1,88 @@
+package ExchangeApi
+
+import (
+	"math"
+	"testing"
+)
+
+func testOrderBook() OrderBook {
+	return OrderBook{
+		Symbol: "BTC/USDT",
+		Bids:   Depth{{"99", "1"}, {"98", "2"}, {"97", "3"}},
+		Asks:   Depth{{"101", "3"}, {"102", "1"}, {"110", "5"}},
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestOrderBook_Prices(t *testing.T) {
+	ob := testOrderBook()
+	if mid := ob.Mid(); mid != 100 {
+		t.Errorf("expect mid 100, got %v", mid)
+	}
+	// the bid has less amount, the price leans to it
+	if micro := ob.MicroPrice(); !almostEqual(micro, 99*0.75+101*0.25) {
+		t.Errorf("unexpected micro price %v", micro)
+	}
+	if spread := ob.SpreadBps(); !almostEqual(spread, 200) {
+		t.Errorf("expect spread 200 bps, got %v", spread)
+	}
+	if imbalance := ob.Imbalance(1); !almostEqual(imbalance, -0.5) {
+		t.Errorf("expect imbalance -0.5, got %v", imbalance)
+	}
+	if imbalance := ob.Imbalance(0); !almostEqual(imbalance, (6.0-9.0)/15.0) {
+		t.Errorf("unexpected imbalance of all levels %v", imbalance)
+	}
+	if mid := (OrderBook{Bids: ob.Bids}).Mid(); mid != 0 {
+		t.Errorf("expect no mid of one side book, got %v", mid)
+	}
+}
+
+func TestOrderBook_Depth(t *testing.T) {
+	ob := testOrderBook()
+	// 200 bps of 100 is [98, 102]
+	// a buy order takes the asks, a sell order the bids
+	if base, quote := ob.DepthWithin(Buy, 200); base != 4 || quote != 101*3+102 {
+		t.Errorf("unexpected ask depth %v %v", base, quote)
+	}
+	if base, quote := ob.DepthWithin(Sell, 200); base != 3 || quote != 99+98*2 {
+		t.Errorf("unexpected bid depth %v %v", base, quote)
+	}
+	curve := ob.DepthCurve(Buy, 2)
+	if len(curve) != 2 || curve[1] != (DepthPoint{Price: 102, Amount: 4, Quote: 405}) {
+		t.Errorf("unexpected depth curve %+v", curve)
+	}
+	if curve := ob.DepthCurve(Sell, 0); len(curve) != 3 || curve[2] != (DepthPoint{Price: 97, Amount: 6, Quote: 99 + 98*2 + 97*3}) {
+		t.Errorf("unexpected bid depth curve %+v", curve)
+	}
+}
+
+func TestOrderBook_EstimateFill(t *testing.T) {
+	ob := testOrderBook()
+	fill := ob.EstimateFill(Buy, 3.5)
+	if !fill.Complete || fill.Levels != 2 || fill.WorstPrice != 102 || !almostEqual(fill.AvgPrice, (303+51)/3.5) {
+		t.Errorf("unexpected buy fill %+v", fill)
+	}
+	if !almostEqual(fill.SlippageBps, (fill.AvgPrice-101)/101*10000) {
+		t.Errorf("unexpected slippage %v", fill.SlippageBps)
+	}
+
+	fill = ob.EstimateFillQuote(Sell, 99+98)
+	if !fill.Complete || !almostEqual(fill.Amount, 2) || !almostEqual(fill.AvgPrice, 98.5) {
+		t.Errorf("unexpected sell fill by quote %+v", fill)
+	}
+
+	fill = ob.EstimateFill(Sell, 10)
+	if fill.Complete || fill.Amount != 6 || fill.Levels != 3 {
+		t.Errorf("expect incomplete fill, got %+v", fill)
+	}
+
+	// the levels without a price are skipped
+	ob.Asks = append(Depth{{"0", "5"}, {"-1", "5"}}, ob.Asks...)
+	fill = ob.EstimateFillQuote(Buy, 303)
+	if !fill.Complete || !almostEqual(fill.Amount, 3) || fill.Levels != 1 || fill.SlippageBps != 0 {
+		t.Errorf("unexpected fill by quote %+v", fill)
+	}
+}
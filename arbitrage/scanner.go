@@ -0,0 +1,471 @@
+// Package arbitrage finds the arbitrage opportunities: the Scanner compares the books of a symbol across exchanges,
+// Triangular follows the cycles of the pairs of one exchange.
+package arbitrage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+var (
+	ErrStarted  = errors.New("scanner already started")
+	ErrNoVenues = errors.New("at least two venues are needed")
+)
+
+// Venue an exchange scanned, the symbols are the unified Market.Symbol on every venue, eg. BTC/USDT
+type Venue struct {
+	Name     string
+	Exchange ExchangeApi.IExchange
+	TakerFee float64 // the taker fee rate, eg. 0.001 means 0.1%
+}
+
+type Options struct {
+	Tickers      bool          // subscribe the tickers rather than the order books, only the best levels are known then
+	Level        int           // the level passed to SubscribeOrderBook
+	Speed        int           // the speed passed to SubscribeOrderBook
+	Depth        int           // the levels of each book walked for the executable amount, all of the levels if 0
+	Threshold    float64       // the net spread an opportunity must beat, eg. 0.001 means 0.1% after the fees
+	Hold         time.Duration // how long the spread must beat the threshold before the opportunity is sent
+	StaleTimeout time.Duration // a book not updated for the time is left out, 0 means never
+}
+
+// Opportunity buying on one venue and selling on another is profitable after the fees.
+// It's sent as MsgArbitrage once found, again whenever it changes, and at last Closed when it's gone.
+type Opportunity struct {
+	Symbol    string
+	BuyVenue  string
+	SellVenue string
+	Ask       float64 // the best ask of BuyVenue
+	Bid       float64 // the best bid of SellVenue
+	Spread    float64 // the net return of the best levels after the fees, eg. 0.002 means 0.2%
+	Amount    float64 // the base amount of the levels beating the threshold on both books, 0 if the tickers have no amounts
+	Cost      float64 // the quote amount paid for Amount on BuyVenue, the fee included
+	Profit    float64 // the quote amount earned by Amount after the fees
+	Since     time.Time
+	Time      time.Time
+	Closed    bool // the spread no longer beats the threshold, or a book is gone
+}
+
+// level a price level of a book
+type level struct {
+	price  float64
+	amount float64
+}
+
+// feed the book of a symbol on a venue
+type feed struct {
+	venue   int
+	symbol  string
+	bids    []level
+	asks    []level
+	ok      bool
+	updated time.Time
+	sub     *ExchangeApi.Resubscription
+	msgChan ExchangeApi.MessageChan
+}
+
+// owns whether the data of symbol is for the feed
+func (f *feed) owns(symbol string) bool {
+	return strings.EqualFold(symbol, f.symbol)
+}
+
+type feedMessage struct {
+	feed *feed
+	msg  ExchangeApi.Message
+}
+
+// route buying on a venue and selling on another
+type route struct {
+	symbol    string
+	buy, sell int
+}
+
+// streak how long a route has beaten the threshold
+type streak struct {
+	since time.Time
+	open  bool // the opportunity has been sent
+	last  Opportunity
+}
+
+// Scanner subscribe the symbols on all venues and send the cross-venue opportunities
+type Scanner struct {
+	symbols []string
+	venues  []Venue
+	options Options
+	feeds   map[string][]*feed // by symbol, in the order of the venues
+
+	lock    sync.Mutex
+	streaks map[route]*streak
+	started bool
+	updates chan feedMessage
+	stop    chan struct{}
+	loops   sync.WaitGroup
+}
+
+func New(symbols []string, venues []Venue, options Options) *Scanner {
+	s := &Scanner{symbols: symbols, venues: venues, options: options, feeds: make(map[string][]*feed)}
+	for _, symbol := range symbols {
+		for i := range venues {
+			s.feeds[symbol] = append(s.feeds[symbol], &feed{venue: i, symbol: symbol})
+		}
+	}
+	return s
+}
+
+// CommonSymbols the symbols listed on two venues at least, by the unified Market.Symbol
+func CommonSymbols(venues []Venue) ([]string, error) {
+	count := make(map[string]int)
+	for _, v := range venues {
+		markets, err := v.Exchange.FetchMarkets()
+		if err != nil {
+			return nil, err
+		}
+		for _, market := range markets {
+			count[market.Symbol]++
+		}
+	}
+	var symbols []string
+	for symbol, n := range count {
+		if n >= 2 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols, nil
+}
+
+// Start subscribe the symbols on all venues, the opportunities are sent to out.
+// It fails if any of them can't be subscribed, the subscriptions made are closed then.
+func (s *Scanner) Start(out ExchangeApi.MessageChan) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.started {
+		return ErrStarted
+	}
+	if len(s.venues) < 2 {
+		return ErrNoVenues
+	}
+	s.updates = make(chan feedMessage)
+	s.stop = make(chan struct{})
+	s.streaks = make(map[route]*streak)
+	for _, symbol := range s.symbols {
+		for _, f := range s.feeds[symbol] {
+			f.msgChan = make(ExchangeApi.MessageChan)
+			f.ok, f.updated = false, time.Now()
+			s.loops.Add(1)
+			go s.forward(f)
+			if err := s.subscribe(f); err != nil {
+				close(s.stop)
+				s.closeSubscriptions()
+				s.loops.Wait()
+				return err
+			}
+		}
+	}
+	s.started = true
+	s.loops.Add(1)
+	go s.loop(out)
+	return nil
+}
+
+// Stop close the subscriptions and wait for the goroutines to exit
+func (s *Scanner) Stop() error {
+	s.lock.Lock()
+	if !s.started {
+		s.lock.Unlock()
+		return nil
+	}
+	s.started = false
+	close(s.stop)
+	err := s.closeSubscriptions()
+	s.lock.Unlock()
+	s.loops.Wait()
+	return err
+}
+
+// Opportunities the opportunities open now, from the best spread
+func (s *Scanner) Opportunities() []Opportunity {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var ret []Opportunity
+	for _, st := range s.streaks {
+		if st.open {
+			ret = append(ret, st.last)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Spread > ret[j].Spread })
+	return ret
+}
+
+func (s *Scanner) subscribe(f *feed) error {
+	exchange, msgChan := s.venues[f.venue].Exchange, f.msgChan
+	sub, err := ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+		if s.options.Tickers {
+			return exchange.SubscribeTicker(f.symbol, msgChan)
+		}
+		return exchange.SubscribeOrderBook(f.symbol, s.options.Level, s.options.Speed, false, msgChan)
+	})
+	if err != nil {
+		return err
+	}
+	f.sub = sub
+	return nil
+}
+
+func (s *Scanner) closeSubscriptions() error {
+	var subs ExchangeApi.Resubscriptions
+	for _, feeds := range s.feeds {
+		for _, f := range feeds {
+			subs = append(subs, f.sub)
+			f.sub = nil
+		}
+	}
+	return subs.Close()
+}
+
+// forward pass the messages of a feed to the loop, so the books are compared by one goroutine
+func (s *Scanner) forward(f *feed) {
+	defer s.loops.Done()
+	for {
+		select {
+		case msg := <-f.msgChan:
+			select {
+			case s.updates <- feedMessage{feed: f, msg: msg}:
+			case <-s.stop:
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Scanner) loop(out ExchangeApi.MessageChan) {
+	defer s.loops.Done()
+	// the holds and the stale books are checked without waiting for the updates
+	var tick <-chan time.Time
+	if interval := s.tickInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		var found []Opportunity
+		select {
+		case update := <-s.updates:
+			if !s.handle(update.feed, update.msg) {
+				continue
+			}
+			found = s.evaluate(update.feed.symbol, update.feed.venue, time.Now())
+		case <-tick:
+			now := time.Now()
+			for _, symbol := range s.symbols {
+				found = append(found, s.evaluate(symbol, -1, now)...)
+			}
+		case <-s.stop:
+			return
+		}
+		for _, o := range found {
+			select {
+			case out <- ExchangeApi.Message{Type: ExchangeApi.MsgArbitrage, Data: o}:
+			case <-s.stop:
+				return
+			}
+		}
+	}
+}
+
+func (s *Scanner) tickInterval() time.Duration {
+	interval := s.options.Hold
+	if t := s.options.StaleTimeout; t > 0 && (interval <= 0 || t < interval) {
+		interval = t
+	}
+	return interval / 2
+}
+
+// handle update the feed by its message, true if the book changes
+func (s *Scanner) handle(f *feed, msg ExchangeApi.Message) bool {
+	// the data of a connection is sent to all of its channels, the other symbols are dropped
+	switch msg.Type {
+	case ExchangeApi.MsgOrderBook:
+		switch data := msg.Data.(type) {
+		case ExchangeApi.OrderBook:
+			if !f.owns(data.Symbol) {
+				return false
+			}
+			f.bids, f.asks = s.levels(data.Bids), s.levels(data.Asks)
+			f.ok, f.updated = true, time.Now()
+			return true
+		case ExchangeApi.ExError:
+			// the dirty data of the exchanges without resync
+			if symbol, ok := data.Data["symbol"].(string); ok && !f.owns(symbol) {
+				return false
+			}
+			f.ok = false
+			return true
+		}
+	case ExchangeApi.MsgTicker:
+		if t, ok := msg.Data.(ExchangeApi.Ticker); ok && f.owns(t.Symbol) {
+			f.bids, f.asks = nil, nil
+			if t.BestBuyPrice > 0 {
+				f.bids = []level{{price: t.BestBuyPrice, amount: t.BestBuyAmount}}
+			}
+			if t.BestSellPrice > 0 {
+				f.asks = []level{{price: t.BestSellPrice, amount: t.BestSellAmount}}
+			}
+			f.ok, f.updated = true, time.Now()
+			return true
+		}
+	case ExchangeApi.MsgOrderBookStatus:
+		if status, ok := msg.Data.(ExchangeApi.OrderBookStatus); ok && status.State == ExchangeApi.BookResyncing && f.owns(status.Symbol) {
+			f.ok = false
+			return true
+		}
+	case ExchangeApi.MsgDisConnected, ExchangeApi.MsgClosed:
+		f.ok = false
+		return true
+	case ExchangeApi.MsgReConnected:
+		f.ok = false
+		s.lock.Lock()
+		if f.sub != nil {
+			f.sub.Renew()
+		}
+		s.lock.Unlock()
+		return true
+	}
+	return false
+}
+
+func (s *Scanner) levels(d ExchangeApi.Depth) []level {
+	if s.options.Depth > 0 && len(d) > s.options.Depth {
+		d = d[:s.options.Depth]
+	}
+	levels := make([]level, 0, len(d))
+	for _, item := range d {
+		if price, amount := SafeParseFloat(item.Price), SafeParseFloat(item.Amount); price > 0 && amount > 0 {
+			levels = append(levels, level{price: price, amount: amount})
+		}
+	}
+	return levels
+}
+
+func (s *Scanner) usable(f *feed, now time.Time) bool {
+	if s.options.StaleTimeout > 0 && now.Sub(f.updated) > s.options.StaleTimeout {
+		return false
+	}
+	return f.ok
+}
+
+// evaluate the routes of the symbol through the venue, or all of its routes if venue is -1,
+// it returns the opportunities to send
+func (s *Scanner) evaluate(symbol string, venue int, now time.Time) []Opportunity {
+	feeds := s.feeds[symbol]
+	var found []Opportunity
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, buy := range feeds {
+		for _, sell := range feeds {
+			if buy == sell || venue >= 0 && buy.venue != venue && sell.venue != venue {
+				continue
+			}
+			if o, ok := s.update(route{symbol: symbol, buy: buy.venue, sell: sell.venue}, buy, sell, now); ok {
+				found = append(found, o)
+			}
+		}
+	}
+	return found
+}
+
+// update the streak of the route, it returns the opportunity to send if any, must be called with the lock held
+func (s *Scanner) update(r route, buy, sell *feed, now time.Time) (Opportunity, bool) {
+	st := s.streaks[r]
+	var o Opportunity
+	beaten := false
+	if s.usable(buy, now) && s.usable(sell, now) {
+		o, beaten = s.opportunity(r.symbol, buy, sell)
+	}
+	if !beaten {
+		if st == nil {
+			return o, false
+		}
+		delete(s.streaks, r)
+		if !st.open {
+			return o, false
+		}
+		closed := st.last
+		closed.Closed, closed.Time = true, now
+		return closed, true
+	}
+	if st == nil {
+		st = &streak{since: now}
+		s.streaks[r] = st
+	}
+	if now.Sub(st.since) < s.options.Hold {
+		return o, false
+	}
+	o.Since, o.Time = st.since, now
+	if st.open && !changed(st.last, o) {
+		return o, false
+	}
+	st.open, st.last = true, o
+	return o, true
+}
+
+// opportunity walk the asks of buy and the bids of sell while the levels beat the threshold after the fees
+func (s *Scanner) opportunity(symbol string, buy, sell *feed) (Opportunity, bool) {
+	if len(buy.asks) == 0 || len(sell.bids) == 0 {
+		return Opportunity{}, false
+	}
+	buyFee, sellFee := s.venues[buy.venue].TakerFee, s.venues[sell.venue].TakerFee
+	net := func(ask, bid float64) float64 {
+		return bid*(1-sellFee)/(ask*(1+buyFee)) - 1
+	}
+	o := Opportunity{
+		Symbol:    symbol,
+		BuyVenue:  s.venues[buy.venue].Name,
+		SellVenue: s.venues[sell.venue].Name,
+		Ask:       buy.asks[0].price,
+		Bid:       sell.bids[0].price,
+	}
+	o.Spread = net(o.Ask, o.Bid)
+	if o.Spread <= s.options.Threshold {
+		return o, false
+	}
+	asks, bids := buy.asks, sell.bids
+	askLeft, bidLeft := asks[0].amount, bids[0].amount
+	for len(asks) > 0 && len(bids) > 0 && net(asks[0].price, bids[0].price) > s.options.Threshold {
+		take := math.Min(askLeft, bidLeft)
+		if take <= 0 {
+			break
+		}
+		cost := take * asks[0].price * (1 + buyFee)
+		o.Amount += take
+		o.Cost += cost
+		o.Profit += take*bids[0].price*(1-sellFee) - cost
+		askLeft -= take
+		bidLeft -= take
+		if askLeft <= 0 {
+			if asks = asks[1:]; len(asks) > 0 {
+				askLeft = asks[0].amount
+			}
+		}
+		if bidLeft <= 0 {
+			if bids = bids[1:]; len(bids) > 0 {
+				bidLeft = bids[0].amount
+			}
+		}
+	}
+	return o, true
+}
+
+// changed whether the opportunity is worth sending again
+func changed(a, b Opportunity) bool {
+	return a.Ask != b.Ask || a.Bid != b.Bid || a.Amount != b.Amount
+}
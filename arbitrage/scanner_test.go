@@ -0,0 +1,165 @@
+package arbitrage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+// nextOpportunity wait for the next opportunity
+func nextOpportunity(t *testing.T, out ExchangeApi.MessageChan) Opportunity {
+	t.Helper()
+	msg := testutil.Receive(t, out, "opportunity")
+	if msg.Type != ExchangeApi.MsgArbitrage {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	return msg.Data.(Opportunity)
+}
+
+func noOpportunity(t *testing.T, out ExchangeApi.MessageChan, wait time.Duration) {
+	t.Helper()
+	select {
+	case msg := <-out:
+		t.Fatalf("unexpected opportunity %+v", msg.Data)
+	case <-time.After(wait):
+	}
+}
+
+func TestScanner(t *testing.T) {
+	binance, okex := mock.New("binance"), mock.New("okex")
+	s := New([]string{"BTC/USDT"}, []Venue{
+		{Name: "binance", Exchange: binance, TakerFee: 0.001},
+		{Name: "okex", Exchange: okex, TakerFee: 0.001},
+	}, Options{Threshold: 0.001})
+	out := make(ExchangeApi.MessageChan)
+	if err := s.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if err := s.Start(out); err != ErrStarted {
+		t.Errorf("expect started error, got %v", err)
+	}
+
+	binance.SetOrderBook(ExchangeApi.OrderBook{
+		Symbol: "BTC/USDT",
+		Bids:   ExchangeApi.Depth{{Price: "99", Amount: "1"}},
+		Asks:   ExchangeApi.Depth{{Price: "100", Amount: "1"}, {Price: "100.1", Amount: "2"}},
+	})
+	okex.SetOrderBook(ExchangeApi.OrderBook{
+		Symbol: "BTC/USDT",
+		Bids:   ExchangeApi.Depth{{Price: "101", Amount: "1.5"}, {Price: "100.2", Amount: "1"}},
+		Asks:   ExchangeApi.Depth{{Price: "102", Amount: "1"}},
+	})
+	o := nextOpportunity(t, out)
+	if o.BuyVenue != "binance" || o.SellVenue != "okex" || o.Ask != 100 || o.Bid != 101 || o.Closed {
+		t.Fatalf("unexpected opportunity %+v", o)
+	}
+	// the second ask still beats the threshold against the rest of the first bid, the second bid doesn't
+	if !testutil.Near(o.Spread, 101*0.999/(100*1.001)-1) || !testutil.Near(o.Amount, 1.5) || !testutil.Near(o.Cost, 100.1+0.5*100.1*1.001) {
+		t.Errorf("unexpected spread %v amount %v cost %v", o.Spread, o.Amount, o.Cost)
+	}
+	if !testutil.Near(o.Profit, 1.5*101*0.999-o.Cost) {
+		t.Errorf("unexpected profit %v", o.Profit)
+	}
+	if ops := s.Opportunities(); len(ops) != 1 || ops[0].SellVenue != "okex" {
+		t.Errorf("unexpected opportunities %+v", ops)
+	}
+
+	// the spread is gone after the fees
+	okex.SetOrderBook(ExchangeApi.OrderBook{
+		Symbol: "BTC/USDT",
+		Bids:   ExchangeApi.Depth{{Price: "100.2", Amount: "1"}},
+		Asks:   ExchangeApi.Depth{{Price: "102", Amount: "1"}},
+	})
+	o = nextOpportunity(t, out)
+	if !o.Closed || o.BuyVenue != "binance" || len(s.Opportunities()) != 0 {
+		t.Fatalf("expect the opportunity closed, got %+v", o)
+	}
+
+	// the books of the other symbols on the connection are dropped
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "ETH/USDT", Asks: ExchangeApi.Depth{{Price: "50", Amount: "1"}}})
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Asks: ExchangeApi.Depth{{Price: "99", Amount: "1"}}})
+	if o = nextOpportunity(t, out); o.Closed || o.Symbol != "BTC/USDT" || o.Ask != 99 {
+		t.Fatalf("unexpected opportunity %+v", o)
+	}
+}
+
+func TestScanner_Hold(t *testing.T) {
+	binance, huobi := mock.New("binance"), mock.New("huobi")
+	s := New([]string{"ETH/USDT"}, []Venue{
+		{Name: "binance", Exchange: binance},
+		{Name: "huobi", Exchange: huobi},
+	}, Options{Tickers: true, Hold: time.Millisecond * 100})
+	out := make(ExchangeApi.MessageChan)
+	if err := s.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	start := time.Now()
+	binance.SetTicker(ExchangeApi.Ticker{Symbol: "ETH/USDT", BestBuyPrice: 2000, BestSellPrice: 2001, BestSellAmount: 3})
+	huobi.SetTicker(ExchangeApi.Ticker{Symbol: "ETH/USDT", BestBuyPrice: 2010, BestSellPrice: 2011, BestBuyAmount: 2})
+	o := nextOpportunity(t, out)
+	if time.Since(start) < time.Millisecond*100 || o.Time.Sub(o.Since) < time.Millisecond*100 {
+		t.Errorf("the opportunity is sent before the hold, since %v time %v", o.Since, o.Time)
+	}
+	if o.BuyVenue != "binance" || o.SellVenue != "huobi" || o.Amount != 2 || !testutil.Near(o.Profit, 2*9) {
+		t.Fatalf("unexpected opportunity %+v", o)
+	}
+
+	// a spread shorter than the hold is never sent
+	huobi.SetTicker(ExchangeApi.Ticker{Symbol: "ETH/USDT", BestBuyPrice: 2000, BestSellPrice: 2001})
+	if o = nextOpportunity(t, out); !o.Closed {
+		t.Fatalf("expect the opportunity closed, got %+v", o)
+	}
+	huobi.SetTicker(ExchangeApi.Ticker{Symbol: "ETH/USDT", BestBuyPrice: 2010, BestSellPrice: 2011})
+	huobi.SetTicker(ExchangeApi.Ticker{Symbol: "ETH/USDT", BestBuyPrice: 2000, BestSellPrice: 2001})
+	noOpportunity(t, out, time.Millisecond*200)
+}
+
+func TestScanner_Stale(t *testing.T) {
+	binance, okex := mock.New("binance"), mock.New("okex")
+	s := New([]string{"BTC/USDT"}, []Venue{
+		{Name: "binance", Exchange: binance},
+		{Name: "okex", Exchange: okex},
+	}, Options{StaleTimeout: time.Millisecond * 100})
+	out := make(ExchangeApi.MessageChan)
+	if err := s.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Asks: ExchangeApi.Depth{{Price: "100", Amount: "1"}}})
+	okex.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Bids: ExchangeApi.Depth{{Price: "101", Amount: "1"}}})
+	if o := nextOpportunity(t, out); o.Closed {
+		t.Fatalf("unexpected opportunity %+v", o)
+	}
+	// the books are not updated any more
+	if o := nextOpportunity(t, out); !o.Closed {
+		t.Fatalf("expect the stale opportunity closed, got %+v", o)
+	}
+
+	okex.Disconnect()
+	binance.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Asks: ExchangeApi.Depth{{Price: "100", Amount: "1"}}})
+	noOpportunity(t, out, time.Millisecond*50)
+}
+
+func TestCommonSymbols(t *testing.T) {
+	binance, okex, huobi := mock.New("binance"), mock.New("okex"), mock.New("huobi")
+	binance.SetMarket(ExchangeApi.Market{Symbol: "BTC/USDT"})
+	binance.SetMarket(ExchangeApi.Market{Symbol: "BNB/USDT"})
+	okex.SetMarket(ExchangeApi.Market{Symbol: "BTC/USDT"})
+	okex.SetMarket(ExchangeApi.Market{Symbol: "OKB/USDT"})
+	huobi.SetMarket(ExchangeApi.Market{Symbol: "OKB/USDT"})
+	symbols, err := CommonSymbols([]Venue{{Exchange: binance}, {Exchange: okex}, {Exchange: huobi}})
+	if err != nil || len(symbols) != 2 || symbols[0] != "BTC/USDT" || symbols[1] != "OKB/USDT" {
+		t.Fatalf("unexpected symbols %v %v", symbols, err)
+	}
+
+	if err := New(symbols, []Venue{{Exchange: binance}}, Options{}).Start(make(ExchangeApi.MessageChan)); err != ErrNoVenues {
+		t.Errorf("expect ErrNoVenues, got %v", err)
+	}
+}
@@ -0,0 +1,438 @@
+package arbitrage
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+const (
+	DefaultLength = 3
+	MaxLength     = 5
+	DefaultTop    = 10
+)
+
+var ErrLength = errors.New("the length of the cycles must be from 3 to MaxLength")
+
+type TriangularOptions struct {
+	TakerFee  float64  // the taker fee rate of every leg, eg. 0.001 means 0.1%
+	Length    int      // the pairs of a cycle, DefaultLength if 0
+	Assets    []string // the cycles start and end at one of the assets, eg. USDT, any asset if empty
+	Threshold float64  // the net return a cycle must beat, eg. 0.001 means 0.1% after the fees
+	Top       int      // the best cycles sent, DefaultTop if 0
+}
+
+// Leg a trade of a cycle
+type Leg struct {
+	Symbol string
+	Side   ExchangeApi.Side // Buy pays the quote for the base at the ask, Sell gets the quote for the base at the bid
+	From   string           // the asset paid
+	To     string           // the asset got
+	Price  float64
+}
+
+// Cycle trading through the legs turns the start asset into more of it after the fees.
+// The best cycles are sent as MsgArbitrage with []Cycle whenever they change, an empty one when none is left.
+type Cycle struct {
+	Start  string
+	Legs   []Leg
+	Return float64 // the net return after the fees, eg. 0.002 means 0.2%
+	Amount float64 // the start asset that can go round at the best levels, 0 if the tickers have no amounts
+	Profit float64 // the start asset earned by Amount
+	Time   time.Time
+}
+
+// quote the best levels of a symbol
+type quote struct {
+	bid, bidAmount float64
+	ask, askAmount float64
+	ok             bool
+}
+
+// edge trading a symbol from an asset to another
+type edge struct {
+	symbol   string
+	from, to int
+	buy      bool
+}
+
+// cycleState a cycle of the graph and its last return
+type cycleState struct {
+	start int
+	edges []edge
+	ret   float64
+	ok    bool // whether all of the legs have quotes
+}
+
+// Triangular find the profitable cycles of the pairs of one exchange from its tickers.
+// The cycles are found once from the markets, a ticker only updates the cycles through its symbol.
+type Triangular struct {
+	exchange ExchangeApi.IExchange
+	options  TriangularOptions
+
+	assets   []string
+	cycles   []*cycleState
+	bySymbol map[string][]int // the cycles through the symbol
+
+	lock       sync.Mutex
+	quotes     map[string]*quote
+	profitable map[int]struct{} // the cycles beating the threshold
+	started    bool
+	sub        *ExchangeApi.Resubscription
+	msgChan    ExchangeApi.MessageChan
+	stop       chan struct{}
+	loops      sync.WaitGroup
+}
+
+func NewTriangular(exchange ExchangeApi.IExchange, options TriangularOptions) *Triangular {
+	if options.Length == 0 {
+		options.Length = DefaultLength
+	}
+	if options.Top <= 0 {
+		options.Top = DefaultTop
+	}
+	return &Triangular{exchange: exchange, options: options}
+}
+
+// Start fetch the markets to find the cycles, then subscribe all of the tickers, the best cycles are sent to out.
+// The tickers are seeded by FetchAllTicker if it works.
+func (t *Triangular) Start(out ExchangeApi.MessageChan) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.started {
+		return ErrStarted
+	}
+	if t.options.Length < 3 || t.options.Length > MaxLength {
+		return ErrLength
+	}
+	markets, err := t.exchange.FetchMarkets()
+	if err != nil {
+		return err
+	}
+	t.build(markets)
+	t.quotes = make(map[string]*quote)
+	t.profitable = make(map[int]struct{})
+	if tickers, err := t.exchange.FetchAllTicker(); err == nil {
+		for _, ticker := range tickers {
+			t.apply(ticker)
+		}
+		for i := range t.cycles {
+			t.evaluate(i)
+		}
+	}
+	t.msgChan = make(ExchangeApi.MessageChan)
+	t.stop = make(chan struct{})
+	msgChan := t.msgChan
+	sub, err := ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+		return t.exchange.SubscribeAllTicker(msgChan)
+	})
+	if err != nil {
+		return err
+	}
+	t.sub, t.started = sub, true
+	t.loops.Add(1)
+	go t.loop(out)
+	return nil
+}
+
+// Stop close the subscription and wait for the goroutine to exit
+func (t *Triangular) Stop() error {
+	t.lock.Lock()
+	if !t.started {
+		t.lock.Unlock()
+		return nil
+	}
+	t.started = false
+	close(t.stop)
+	err := t.sub.Close()
+	t.lock.Unlock()
+	t.loops.Wait()
+	return err
+}
+
+// CycleCount the cycles of the graph
+func (t *Triangular) CycleCount() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.cycles)
+}
+
+// Cycles the best cycles beating the threshold now
+func (t *Triangular) Cycles() []Cycle {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.best(time.Now())
+}
+
+// build find every cycle of the length once. A cycle starts at its first asset by rank, the assets of the options
+// rank first, so each cycle is found in one rotation only.
+func (t *Triangular) build(markets map[string]ExchangeApi.Market) {
+	t.assets = nil
+	index := make(map[string]int)
+	node := func(asset string) int {
+		i, ok := index[asset]
+		if !ok {
+			i = len(t.assets)
+			index[asset] = i
+			t.assets = append(t.assets, asset)
+		}
+		return i
+	}
+	for _, asset := range t.options.Assets {
+		node(strings.ToUpper(asset))
+	}
+	starts := len(t.assets)
+	// the symbols are sorted so the cycles are found in the same order every time
+	symbols := make([]string, 0, len(markets))
+	for symbol := range markets {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	adjacent := make(map[int][]edge)
+	for _, symbol := range symbols {
+		// the unified symbol names the assets alike on all of the exchanges, and the tickers by it
+		symbol = markets[symbol].Symbol
+		pair := strings.Split(strings.ToUpper(symbol), "/")
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			continue
+		}
+		b, q := node(pair[0]), node(pair[1])
+		adjacent[q] = append(adjacent[q], edge{symbol: symbol, from: q, to: b, buy: true})
+		adjacent[b] = append(adjacent[b], edge{symbol: symbol, from: b, to: q})
+	}
+	if len(t.options.Assets) == 0 {
+		starts = len(t.assets)
+	}
+
+	t.cycles, t.bySymbol = nil, make(map[string][]int)
+	visited := make([]bool, len(t.assets))
+	var path []edge
+	var walk func(start, at int)
+	walk = func(start, at int) {
+		for _, e := range adjacent[at] {
+			if len(path) == t.options.Length-1 {
+				if e.to == start {
+					t.addCycle(start, append(append([]edge{}, path...), e))
+				}
+				continue
+			}
+			// the assets after the start rank after it
+			if e.to <= start || visited[e.to] {
+				continue
+			}
+			visited[e.to] = true
+			path = append(path, e)
+			walk(start, e.to)
+			path = path[:len(path)-1]
+			visited[e.to] = false
+		}
+	}
+	for start := 0; start < starts; start++ {
+		visited[start] = true
+		walk(start, start)
+		visited[start] = false
+	}
+}
+
+func (t *Triangular) addCycle(start int, edges []edge) {
+	i := len(t.cycles)
+	t.cycles = append(t.cycles, &cycleState{start: start, edges: edges})
+	// the assets of a cycle are distinct, so are its symbols
+	for _, e := range edges {
+		t.bySymbol[e.symbol] = append(t.bySymbol[e.symbol], i)
+	}
+}
+
+func (t *Triangular) loop(out ExchangeApi.MessageChan) {
+	defer t.loops.Done()
+	for {
+		select {
+		case msg := <-t.msgChan:
+			var tickers []ExchangeApi.Ticker
+			switch msg.Type {
+			case ExchangeApi.MsgAllTicker, ExchangeApi.MsgTicker:
+				switch data := msg.Data.(type) {
+				case ExchangeApi.Ticker:
+					tickers = []ExchangeApi.Ticker{data}
+				case []ExchangeApi.Ticker:
+					tickers = data
+				case map[string]ExchangeApi.Ticker:
+					for _, ticker := range data {
+						tickers = append(tickers, ticker)
+					}
+				}
+			case ExchangeApi.MsgDisConnected, ExchangeApi.MsgClosed:
+				// the quotes are unknown until the tickers come again
+				t.lock.Lock()
+				changed := len(t.profitable) > 0
+				t.reset()
+				t.lock.Unlock()
+				if changed && !t.emit(out, nil) {
+					return
+				}
+				continue
+			case ExchangeApi.MsgReConnected:
+				t.sub.Renew()
+				continue
+			}
+			if len(tickers) == 0 {
+				continue
+			}
+			if cycles, changed := t.update(tickers, time.Now()); changed && !t.emit(out, cycles) {
+				return
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// update apply the tickers and evaluate the cycles through their symbols,
+// it returns the best cycles if the profitable ones have changed
+func (t *Triangular) update(tickers []ExchangeApi.Ticker, now time.Time) ([]Cycle, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	affected := make(map[int]struct{})
+	for _, ticker := range tickers {
+		if !t.apply(ticker) {
+			continue
+		}
+		for _, i := range t.bySymbol[ticker.Symbol] {
+			affected[i] = struct{}{}
+		}
+	}
+	changed := false
+	for i := range affected {
+		_, was := t.profitable[i]
+		if t.evaluate(i) || was {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	return t.best(now), true
+}
+
+// apply the best levels of the ticker, false if its symbol is in no cycle
+func (t *Triangular) apply(ticker ExchangeApi.Ticker) bool {
+	if _, ok := t.bySymbol[ticker.Symbol]; !ok {
+		return false
+	}
+	q, ok := t.quotes[ticker.Symbol]
+	if !ok {
+		q = &quote{}
+		t.quotes[ticker.Symbol] = q
+	}
+	q.bid, q.bidAmount = ticker.BestBuyPrice, ticker.BestBuyAmount
+	q.ask, q.askAmount = ticker.BestSellPrice, ticker.BestSellAmount
+	q.ok = q.bid > 0 && q.ask > 0
+	return true
+}
+
+func (t *Triangular) reset() {
+	for _, q := range t.quotes {
+		q.ok = false
+	}
+	for _, c := range t.cycles {
+		c.ok = false
+	}
+	t.profitable = make(map[int]struct{})
+}
+
+// rate how much of the asset got for one of the asset paid after the fee
+func (t *Triangular) rate(e edge) (float64, bool) {
+	q, ok := t.quotes[e.symbol]
+	if !ok || !q.ok {
+		return 0, false
+	}
+	if e.buy {
+		return (1 - t.options.TakerFee) / q.ask, true
+	}
+	return q.bid * (1 - t.options.TakerFee), true
+}
+
+// evaluate the return of the cycle, it returns whether it beats the threshold
+func (t *Triangular) evaluate(i int) bool {
+	c := t.cycles[i]
+	c.ret, c.ok = 1, true
+	for _, e := range c.edges {
+		r, ok := t.rate(e)
+		if !ok {
+			c.ok = false
+			break
+		}
+		c.ret *= r
+	}
+	c.ret--
+	if c.ok && c.ret > t.options.Threshold {
+		t.profitable[i] = struct{}{}
+		return true
+	}
+	delete(t.profitable, i)
+	return false
+}
+
+// best the top profitable cycles from the best return
+func (t *Triangular) best(now time.Time) []Cycle {
+	ids := make([]int, 0, len(t.profitable))
+	for i := range t.profitable {
+		ids = append(ids, i)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		if t.cycles[ids[a]].ret != t.cycles[ids[b]].ret {
+			return t.cycles[ids[a]].ret > t.cycles[ids[b]].ret
+		}
+		return ids[a] < ids[b]
+	})
+	if len(ids) > t.options.Top {
+		ids = ids[:t.options.Top]
+	}
+	cycles := make([]Cycle, 0, len(ids))
+	for _, i := range ids {
+		cycles = append(cycles, t.cycle(t.cycles[i], now))
+	}
+	return cycles
+}
+
+// cycle the legs of the cycle and the start asset it can take: each leg takes no more than its best level
+func (t *Triangular) cycle(c *cycleState, now time.Time) Cycle {
+	ret := Cycle{Start: t.assets[c.start], Return: c.ret, Time: now}
+	amount := -1.0
+	held := 1.0 // the asset held before the leg for one of the start asset
+	for _, e := range c.edges {
+		q := t.quotes[e.symbol]
+		leg := Leg{Symbol: e.symbol, From: t.assets[e.from], To: t.assets[e.to], Side: ExchangeApi.Sell, Price: q.bid}
+		// the level as the asset paid
+		size := q.bidAmount
+		if e.buy {
+			leg.Side, leg.Price = ExchangeApi.Buy, q.ask
+			size = q.askAmount * q.ask
+		}
+		if limit := size / held; amount < 0 || limit < amount {
+			amount = limit
+		}
+		r, _ := t.rate(e)
+		held *= r
+		ret.Legs = append(ret.Legs, leg)
+	}
+	ret.Amount = amount
+	ret.Profit = amount * c.ret
+	return ret
+}
+
+func (t *Triangular) emit(out ExchangeApi.MessageChan, cycles []Cycle) bool {
+	if cycles == nil {
+		cycles = []Cycle{}
+	}
+	select {
+	case out <- ExchangeApi.Message{Type: ExchangeApi.MsgArbitrage, Data: cycles}:
+		return true
+	case <-t.stop:
+		return false
+	}
+}
@@ -0,0 +1,113 @@
+package arbitrage
+
+import (
+	"testing"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+func nextCycles(t *testing.T, out ExchangeApi.MessageChan) []Cycle {
+	t.Helper()
+	msg := testutil.Receive(t, out, "cycles")
+	if msg.Type != ExchangeApi.MsgArbitrage {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	return msg.Data.([]Cycle)
+}
+
+func publishTickers(exchange *mock.Exchange, tickers ...ExchangeApi.Ticker) {
+	exchange.Publish("", ExchangeApi.Message{Type: ExchangeApi.MsgAllTicker, Data: tickers})
+}
+
+func TestTriangular_Graph(t *testing.T) {
+	// every two of the four assets are a pair
+	exchange := mock.New("binance")
+	for _, symbol := range []string{"BTC/USDT", "ETH/USDT", "BNB/USDT", "ETH/BTC", "BNB/BTC", "BNB/ETH"} {
+		exchange.SetMarket(ExchangeApi.Market{Symbol: symbol})
+	}
+	for _, c := range []struct {
+		options TriangularOptions
+		cycles  int
+	}{
+		{TriangularOptions{}, 8},                         // 4 triangles in both directions
+		{TriangularOptions{Length: 4}, 6},                // 3 squares in both directions
+		{TriangularOptions{Assets: []string{"usdt"}}, 6}, // the triangles through USDT
+		{TriangularOptions{Assets: []string{"USDT", "BTC"}}, 8},
+	} {
+		tri := NewTriangular(exchange, c.options)
+		if err := tri.Start(make(ExchangeApi.MessageChan)); err != nil {
+			t.Fatal(err)
+		}
+		if n := tri.CycleCount(); n != c.cycles {
+			t.Errorf("expect %d cycles of %+v, got %d", c.cycles, c.options, n)
+		}
+		tri.Stop()
+	}
+	if err := NewTriangular(exchange, TriangularOptions{Length: 2}).Start(make(ExchangeApi.MessageChan)); err != ErrLength {
+		t.Errorf("expect ErrLength, got %v", err)
+	}
+}
+
+func TestTriangular(t *testing.T) {
+	exchange := mock.New("binance")
+	for _, symbol := range []string{"BTC/USDT", "ETH/USDT", "ETH/BTC", "BNB/USDT"} {
+		exchange.SetMarket(ExchangeApi.Market{Symbol: symbol})
+	}
+	btc := ExchangeApi.Ticker{Symbol: "BTC/USDT", BestBuyPrice: 50000, BestBuyAmount: 1, BestSellPrice: 50010, BestSellAmount: 0.5}
+	ethBtc := ExchangeApi.Ticker{Symbol: "ETH/BTC", BestBuyPrice: 0.07, BestBuyAmount: 10, BestSellPrice: 0.0701, BestSellAmount: 10}
+	eth := ExchangeApi.Ticker{Symbol: "ETH/USDT", BestBuyPrice: 3600, BestBuyAmount: 5, BestSellPrice: 3601, BestSellAmount: 5}
+	for _, ticker := range []ExchangeApi.Ticker{btc, ethBtc, eth} {
+		exchange.SetTicker(ticker)
+	}
+
+	tri := NewTriangular(exchange, TriangularOptions{Assets: []string{"USDT"}, Threshold: 0.001})
+	out := make(ExchangeApi.MessageChan)
+	if err := tri.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	defer tri.Stop()
+
+	// seeded by FetchAllTicker: USDT buys BTC, BTC buys ETH, ETH is sold for USDT
+	cycles := tri.Cycles()
+	if len(cycles) != 1 {
+		t.Fatalf("expect 1 cycle, got %+v", cycles)
+	}
+	c := cycles[0]
+	if c.Start != "USDT" || len(c.Legs) != 3 || !testutil.Near(c.Return, 3600/(50010*0.0701)-1) {
+		t.Fatalf("unexpected cycle %+v", c)
+	}
+	if c.Legs[0].Symbol != "BTC/USDT" || c.Legs[0].Side != ExchangeApi.Buy || c.Legs[1].Symbol != "ETH/BTC" || c.Legs[1].Side != ExchangeApi.Buy ||
+		c.Legs[2].Symbol != "ETH/USDT" || c.Legs[2].Side != ExchangeApi.Sell || c.Legs[2].From != "ETH" || c.Legs[2].To != "USDT" {
+		t.Errorf("unexpected legs %+v", c.Legs)
+	}
+	// the bid of ETH/USDT is the smallest: 5 ETH is worth 5*0.0701*50010 USDT at the prices before it
+	if !testutil.Near(c.Amount, 5*0.0701*50010) || !testutil.Near(c.Profit, c.Amount*c.Return) {
+		t.Errorf("unexpected amount %v profit %v", c.Amount, c.Profit)
+	}
+
+	// the cycle is gone
+	eth.BestBuyPrice = 3500
+	publishTickers(exchange, eth)
+	if cycles := nextCycles(t, out); len(cycles) != 0 {
+		t.Fatalf("expect no cycle, got %+v", cycles)
+	}
+	// a symbol of no profitable cycle sends nothing
+	publishTickers(exchange, ExchangeApi.Ticker{Symbol: "BNB/USDT", BestBuyPrice: 300, BestSellPrice: 301})
+	eth.BestBuyPrice = 3600
+	exchange.Publish("", ExchangeApi.Message{Type: ExchangeApi.MsgAllTicker, Data: map[string]ExchangeApi.Ticker{eth.Symbol: eth}})
+	if cycles := nextCycles(t, out); len(cycles) != 1 || cycles[0].Legs[2].Price != 3600 {
+		t.Fatalf("expect the cycle back, got %+v", cycles)
+	}
+
+	// the quotes are dropped with the connection
+	exchange.Disconnect()
+	if cycles := nextCycles(t, out); len(cycles) != 0 || len(tri.Cycles()) != 0 {
+		t.Fatalf("expect no cycle after disconnected, got %+v", cycles)
+	}
+	publishTickers(exchange, btc, ethBtc, eth)
+	if cycles := nextCycles(t, out); len(cycles) != 1 {
+		t.Fatalf("expect the cycle when the tickers come again, got %+v", cycles)
+	}
+}
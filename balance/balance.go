@@ -0,0 +1,252 @@
+// Package balance keeps the balances of an exchange account up to date from its balance subscription,
+// and reserves the funds of the orders in flight.
+package balance
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+var ErrStarted = errors.New("balance cache already started")
+
+type asset struct {
+	balance  ExchangeApi.Balance
+	updated  time.Duration // the UpdateTime of the last amounts from the subscription, 0 after seeded
+	reserved float64
+}
+
+// Cache the balances of an exchange instance, it is safe for concurrent use
+type Cache struct {
+	exchange ExchangeApi.IExchange
+
+	lock    sync.RWMutex
+	assets  map[string]*asset
+	seeded  bool
+	started bool
+	sub     *ExchangeApi.Resubscription
+	msgChan ExchangeApi.MessageChan
+	stop    chan struct{}
+	loop    sync.WaitGroup
+}
+
+func New(exchange ExchangeApi.IExchange) *Cache {
+	return &Cache{exchange: exchange, assets: make(map[string]*asset)}
+}
+
+// Start subscribe the balances, then seed them by FetchBalance, so no change is missed in between.
+// They are seeded again after the connection is reconnected.
+func (c *Cache) Start() error {
+	c.lock.Lock()
+	if c.started {
+		c.lock.Unlock()
+		return ErrStarted
+	}
+	c.msgChan = make(ExchangeApi.MessageChan)
+	c.stop = make(chan struct{})
+	msgChan := c.msgChan
+	sub, err := ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+		return c.exchange.SubscribeBalance("", msgChan)
+	})
+	if err != nil {
+		c.lock.Unlock()
+		return err
+	}
+	c.sub, c.started = sub, true
+	c.loop.Add(1)
+	go c.run()
+	c.lock.Unlock()
+	if err := c.Seed(); err != nil {
+		c.Stop()
+		return err
+	}
+	return nil
+}
+
+// Stop close the subscription and wait for the goroutine to exit, the balances are kept
+func (c *Cache) Stop() error {
+	c.lock.Lock()
+	if !c.started {
+		c.lock.Unlock()
+		return nil
+	}
+	c.started = false
+	close(c.stop)
+	err := c.sub.Close()
+	c.lock.Unlock()
+	c.loop.Wait()
+	return err
+}
+
+// Seed replace the balances by FetchBalance, the reservations are kept
+func (c *Cache) Seed() error {
+	balances, err := c.exchange.FetchBalance()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, a := range c.assets {
+		if _, ok := balances[name]; !ok && a.reserved == 0 {
+			delete(c.assets, name)
+		}
+	}
+	for _, b := range balances {
+		a := c.asset(b.Asset)
+		a.balance.Available, a.balance.Frozen = b.Available, b.Frozen
+		// the REST balances have no time, the changes subscribed after them are all newer
+		a.updated = 0
+	}
+	c.seeded = true
+	return nil
+}
+
+// Ready whether the balances are seeded
+func (c *Cache) Ready() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.seeded
+}
+
+// Balance the balance of the asset
+func (c *Cache) Balance(name string) (ExchangeApi.Balance, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	a, ok := c.assets[strings.ToUpper(name)]
+	if !ok {
+		return ExchangeApi.Balance{Asset: strings.ToUpper(name)}, false
+	}
+	return a.balance, true
+}
+
+// Balances the balances of all assets, key: the asset
+func (c *Cache) Balances() map[string]ExchangeApi.Balance {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	balances := make(map[string]ExchangeApi.Balance, len(c.assets))
+	for name, a := range c.assets {
+		balances[name] = a.balance
+	}
+	return balances
+}
+
+// Free the available amount of the asset not reserved
+func (c *Cache) Free(name string) float64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	a, ok := c.assets[strings.ToUpper(name)]
+	if !ok {
+		return 0
+	}
+	return a.balance.Available - a.reserved
+}
+
+// Reserved the amount of the asset reserved
+func (c *Cache) Reserved(name string) float64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if a, ok := c.assets[strings.ToUpper(name)]; ok {
+		return a.reserved
+	}
+	return 0
+}
+
+// Reservation the amount of an asset held for an order in flight
+type Reservation struct {
+	cache  *Cache
+	asset  string
+	amount float64
+	once   sync.Once
+}
+
+// Reserve hold the amount of the asset for an order about to be created, it fails with ErrInsufficientFunds if the
+// free amount is less. The reservation must be released once the order is created or failed, the exchange freezes
+// the funds of a created order itself.
+func (c *Cache) Reserve(name string, amount float64) (*Reservation, error) {
+	name = strings.ToUpper(name)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	a := c.asset(name)
+	if free := a.balance.Available - a.reserved; amount > free {
+		return nil, ExchangeApi.ExError{
+			Code:    ExchangeApi.ErrInsufficientFunds,
+			Message: "reserve " + strconv.FormatFloat(amount, 'f', -1, 64) + " " + name + ", free " + strconv.FormatFloat(free, 'f', -1, 64),
+		}
+	}
+	a.reserved += amount
+	return &Reservation{cache: c, asset: name, amount: amount}, nil
+}
+
+// Release return the reserved amount, it may be called more than once
+func (r *Reservation) Release() {
+	r.once.Do(func() {
+		c := r.cache
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		a := c.asset(r.asset)
+		a.reserved -= r.amount
+		if a.reserved < 1e-12 {
+			a.reserved = 0
+		}
+	})
+}
+
+// asset the state of the asset, created if not exist, it must be called with the lock held
+func (c *Cache) asset(name string) *asset {
+	a, ok := c.assets[name]
+	if !ok {
+		a = &asset{balance: ExchangeApi.Balance{Asset: name}}
+		c.assets[name] = a
+	}
+	return a
+}
+
+// apply the balances subscribed, the messages may come out of order:
+// the amounts older than the last ones are dropped, and the changes no newer than them are taken as counted by them
+func (c *Cache) apply(update ExchangeApi.BalanceUpdate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for name, b := range update.Balances {
+		if b.Asset != "" {
+			name = b.Asset
+		}
+		a := c.asset(strings.ToUpper(name))
+		if update.Delta {
+			if update.UpdateTime > 0 && update.UpdateTime <= a.updated {
+				continue
+			}
+			a.balance.Available += b.Available
+			continue
+		}
+		if update.UpdateTime < a.updated {
+			continue
+		}
+		a.balance.Available, a.balance.Frozen = b.Available, b.Frozen
+		a.updated = update.UpdateTime
+	}
+}
+
+func (c *Cache) run() {
+	defer c.loop.Done()
+	for {
+		select {
+		case msg := <-c.msgChan:
+			switch msg.Type {
+			case ExchangeApi.MsgBalance:
+				if update, ok := msg.Data.(ExchangeApi.BalanceUpdate); ok {
+					c.apply(update)
+				}
+			case ExchangeApi.MsgReConnected:
+				// the changes in between are fetched
+				c.sub.Renew()
+				c.Seed()
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
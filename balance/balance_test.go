@@ -0,0 +1,59 @@
+package balance
+
+import (
+	"testing"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+func TestCache(t *testing.T) {
+	exchange := mock.New("binance")
+	exchange.SetBalance(ExchangeApi.Balance{Asset: "USDT", Available: 100, Frozen: 10})
+	c := New(exchange)
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if b, ok := c.Balance("usdt"); !ok || b.Available != 100 || b.Frozen != 10 || !c.Ready() {
+		t.Fatalf("unexpected seeded balance %+v", b)
+	}
+
+	exchange.AddBalance("USDT", 50)
+	testutil.WaitFor(t, func() bool { b, _ := c.Balance("USDT"); return b.Available == 150 })
+	exchange.SetBalance(ExchangeApi.Balance{Asset: "BTC", Available: 1})
+	testutil.WaitFor(t, func() bool { b, _ := c.Balance("BTC"); return b.Available == 1 })
+
+	r, err := c.Reserve("USDT", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free := c.Free("USDT"); free != 30 {
+		t.Fatalf("unexpected free %v", free)
+	}
+	if _, err := c.Reserve("USDT", 40); err == nil || err.(ExchangeApi.ExError).Code != ExchangeApi.ErrInsufficientFunds {
+		t.Fatalf("expected ErrInsufficientFunds, got %v", err)
+	}
+	r.Release()
+	r.Release()
+	if free := c.Free("USDT"); free != 150 || c.Reserved("USDT") != 0 {
+		t.Fatalf("unexpected free %v after released", free)
+	}
+}
+
+func TestCache_Apply(t *testing.T) {
+	c := New(mock.New("binance"))
+	c.apply(ExchangeApi.BalanceUpdate{UpdateTime: 10, Balances: map[string]ExchangeApi.Balance{"BTC": {Asset: "BTC", Available: 2}}})
+	// an older amount is dropped
+	c.apply(ExchangeApi.BalanceUpdate{UpdateTime: 5, Balances: map[string]ExchangeApi.Balance{"BTC": {Asset: "BTC", Available: 1}}})
+	// a change counted by the amount already is dropped, a newer one is added
+	c.apply(ExchangeApi.BalanceUpdate{UpdateTime: 10, Delta: true, Balances: map[string]ExchangeApi.Balance{"BTC": {Asset: "BTC", Available: 1}}})
+	c.apply(ExchangeApi.BalanceUpdate{UpdateTime: 11, Delta: true, Balances: map[string]ExchangeApi.Balance{"btc": {Available: -0.5}}})
+	if b, _ := c.Balance("BTC"); b.Available != 1.5 {
+		t.Fatalf("unexpected balance %+v", b)
+	}
+	if balances := c.Balances(); len(balances) != 1 {
+		t.Fatalf("unexpected balances %+v", balances)
+	}
+}
@@ -0,0 +1,173 @@
+// Package bars samples the trades into the bars of activity: tick, volume, dollar and tick imbalance bars.
+// The bars are built by the same Builder live from a subscription or offline from the historical trades.
+package bars
+
+import (
+	"errors"
+	"math"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// DefaultAlpha the default weight of the last bar in the moving estimates of the imbalance bars
+const DefaultAlpha = 0.1
+
+var (
+	ErrThreshold = errors.New("the bar threshold must be positive")
+	ErrType      = errors.New("unsupported bar type")
+)
+
+type Options struct {
+	Type ExchangeApi.BarType
+	// Threshold closes the bar: the trades of a TickBar, the base amount of a VolumeBar, the quote amount of a DollarBar
+	// and the expected trades of the first TickImbalanceBar.
+	// The trade crossing the threshold is not split, it is counted by the bar it closes.
+	Threshold float64
+	// Alpha the weight of the last bar in the exponential moving estimates of a TickImbalanceBar, DefaultAlpha if 0
+	Alpha float64
+}
+
+// Builder build the bars of a symbol, it is not safe for concurrent use
+type Builder struct {
+	symbol  string
+	options Options
+
+	bar    ExchangeApi.Bar
+	open   bool
+	last   float64 // the price of the last trade for the tick rule
+	sign   float64 // the sign of the last trade, kept while the price is unchanged
+	ticks  float64 // the expected trades of an imbalance bar
+	signs  float64 // the expected sign of a trade, 2P[buy]-1
+	warmed bool
+}
+
+func New(symbol string, options Options) (*Builder, error) {
+	if options.Threshold <= 0 || math.IsInf(options.Threshold, 0) || math.IsNaN(options.Threshold) {
+		return nil, ErrThreshold
+	}
+	if options.Type < ExchangeApi.TickBar || options.Type > ExchangeApi.TickImbalanceBar {
+		return nil, ErrType
+	}
+	if options.Alpha <= 0 || options.Alpha > 1 {
+		options.Alpha = DefaultAlpha
+	}
+	return &Builder{symbol: symbol, options: options, ticks: options.Threshold}, nil
+}
+
+// FromTrades build the bars of the historical trades, the last bar is returned only if it is closed
+func FromTrades(symbol string, trades []ExchangeApi.Trade, options Options) ([]ExchangeApi.Bar, error) {
+	b, err := New(symbol, options)
+	if err != nil {
+		return nil, err
+	}
+	var bars []ExchangeApi.Bar
+	for _, trade := range trades {
+		if bar, ok := b.Add(trade); ok {
+			bars = append(bars, bar)
+		}
+	}
+	return bars, nil
+}
+
+// Add count the trade in the bar, the bar is returned if the trade closed it
+func (b *Builder) Add(trade ExchangeApi.Trade) (ExchangeApi.Bar, bool) {
+	sign := b.tickSign(trade)
+	if !b.open {
+		b.bar = ExchangeApi.Bar{
+			Symbol: b.symbol,
+			Type:   b.options.Type,
+			Start:  trade.Timestamp,
+			Open:   trade.Price,
+			High:   trade.Price,
+			Low:    trade.Price,
+		}
+		b.open = true
+	}
+	bar := &b.bar
+	bar.End = trade.Timestamp
+	bar.High = math.Max(bar.High, trade.Price)
+	bar.Low = math.Min(bar.Low, trade.Price)
+	bar.Close = trade.Price
+	bar.Volume += trade.Amount
+	bar.QuoteVolume += trade.Amount * trade.Price
+	if sign > 0 {
+		bar.BuyVolume += trade.Amount
+	}
+	bar.Trades++
+	bar.Imbalance += sign
+
+	if !b.closed() {
+		return ExchangeApi.Bar{}, false
+	}
+	if b.options.Type == ExchangeApi.TickImbalanceBar {
+		b.estimate()
+	}
+	b.open = false
+	return b.bar, true
+}
+
+// Current the bar in progress
+func (b *Builder) Current() (ExchangeApi.Bar, bool) {
+	return b.bar, b.open
+}
+
+// Threshold the imbalance that closes the current TickImbalanceBar, or Options.Threshold for the other types
+func (b *Builder) Threshold() float64 {
+	if b.options.Type != ExchangeApi.TickImbalanceBar {
+		return b.options.Threshold
+	}
+	if !b.warmed {
+		// no estimate of the signs before the first bar, which is closed after the expected trades
+		return math.Inf(1)
+	}
+	return b.ticks * math.Abs(b.signs)
+}
+
+// tickSign the side of the trade if known, otherwise by the tick rule: up is a buy, down is a sell, unchanged is as the last one
+func (b *Builder) tickSign(trade ExchangeApi.Trade) float64 {
+	switch {
+	case trade.Side == ExchangeApi.Buy:
+		b.sign = 1
+	case trade.Side == ExchangeApi.Sell:
+		b.sign = -1
+	case b.last != 0 && trade.Price > b.last:
+		b.sign = 1
+	case b.last != 0 && trade.Price < b.last:
+		b.sign = -1
+	case b.sign == 0:
+		b.sign = 1
+	}
+	b.last = trade.Price
+	return b.sign
+}
+
+func (b *Builder) closed() bool {
+	bar := b.bar
+	switch b.options.Type {
+	case ExchangeApi.TickBar:
+		return float64(bar.Trades) >= b.options.Threshold
+	case ExchangeApi.VolumeBar:
+		return bar.Volume >= b.options.Threshold
+	case ExchangeApi.DollarBar:
+		return bar.QuoteVolume >= b.options.Threshold
+	case ExchangeApi.TickImbalanceBar:
+		if !b.warmed {
+			return float64(bar.Trades) >= b.ticks
+		}
+		return math.Abs(bar.Imbalance) >= b.Threshold()
+	}
+	return false
+}
+
+// estimate update the expected trades and the expected sign by the closed bar
+func (b *Builder) estimate() {
+	trades := float64(b.bar.Trades)
+	signs := b.bar.Imbalance / trades
+	if !b.warmed {
+		b.ticks, b.signs, b.warmed = trades, signs, true
+		return
+	}
+	alpha := b.options.Alpha
+	b.ticks = alpha*trades + (1-alpha)*b.ticks
+	b.signs = alpha*signs + (1-alpha)*b.signs
+}
@@ -0,0 +1,120 @@
+package bars
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+func trade(ms int64, price, amount float64) ExchangeApi.Trade {
+	return ExchangeApi.Trade{Symbol: "BTC/USDT", Timestamp: time.Duration(ms), Price: price, Amount: amount}
+}
+
+func TestFromTrades(t *testing.T) {
+	trades := []ExchangeApi.Trade{
+		trade(1, 10, 1), trade(2, 12, 2), trade(3, 9, 1),
+		trade(4, 11, 3), trade(5, 11, 1), trade(6, 10, 1),
+	}
+	tests := []struct {
+		options Options
+		closes  []float64
+		volumes []float64
+	}{
+		{Options{Type: ExchangeApi.TickBar, Threshold: 2}, []float64{12, 11, 10}, []float64{3, 4, 2}},
+		{Options{Type: ExchangeApi.VolumeBar, Threshold: 3}, []float64{12, 11}, []float64{3, 4}},
+		{Options{Type: ExchangeApi.DollarBar, Threshold: 40}, []float64{9, 11}, []float64{4, 4}},
+	}
+	for _, test := range tests {
+		bars, err := FromTrades("BTC/USDT", trades, test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bars) != len(test.closes) {
+			t.Fatalf("%s: unexpected bars %+v", test.options.Type, bars)
+		}
+		for i, bar := range bars {
+			if bar.Close != test.closes[i] || bar.Volume != test.volumes[i] || bar.Type != test.options.Type {
+				t.Errorf("%s: unexpected bar %d %+v", test.options.Type, i, bar)
+			}
+		}
+	}
+
+	bars, _ := FromTrades("BTC/USDT", trades, Options{Type: ExchangeApi.TickBar, Threshold: 3})
+	first := bars[0]
+	if first.Start != 1 || first.End != 3 || first.Open != 10 || first.High != 12 || first.Low != 9 || first.Trades != 3 {
+		t.Errorf("unexpected bar %+v", first)
+	}
+	// up, up, down by the tick rule
+	if first.Imbalance != 1 || first.BuyVolume != 3 || math.Abs(first.VWAP()-(10+24+9)/4.0) > 1e-9 {
+		t.Errorf("unexpected imbalance of bar %+v", first)
+	}
+
+	if _, err := FromTrades("BTC/USDT", trades, Options{Type: ExchangeApi.TickBar}); err != ErrThreshold {
+		t.Errorf("expected ErrThreshold, got %v", err)
+	}
+}
+
+func TestBuilder_TickImbalance(t *testing.T) {
+	b, err := New("BTC/USDT", Options{Type: ExchangeApi.TickImbalanceBar, Threshold: 4, Alpha: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sides := []ExchangeApi.Side{ExchangeApi.Buy, ExchangeApi.Buy, ExchangeApi.Sell, ExchangeApi.Buy}
+	for i, side := range sides {
+		bar, ok := b.Add(ExchangeApi.Trade{Timestamp: time.Duration(i), Price: 1, Amount: 1, Side: side})
+		if ok != (i == 3) {
+			t.Fatalf("the first bar is closed after the expected trades, trade %d closed %v", i, ok)
+		}
+		if ok && bar.Imbalance != 2 {
+			t.Fatalf("unexpected imbalance %+v", bar)
+		}
+	}
+	// 4 expected trades with the expected sign 0.5
+	if threshold := b.Threshold(); threshold != 2 {
+		t.Fatalf("unexpected threshold %v", threshold)
+	}
+	if _, ok := b.Add(ExchangeApi.Trade{Price: 1, Amount: 1, Side: ExchangeApi.Sell}); ok {
+		t.Fatal("bar closed below the threshold")
+	}
+	if bar, ok := b.Add(ExchangeApi.Trade{Price: 1, Amount: 1, Side: ExchangeApi.Sell}); !ok || bar.Imbalance != -2 || bar.Trades != 2 {
+		t.Fatalf("unexpected bar %+v %v", bar, ok)
+	}
+	// the estimates move half way to the bar of 2 trades with the sign -1
+	if threshold := b.Threshold(); math.Abs(threshold-3*0.25) > 1e-9 {
+		t.Fatalf("unexpected threshold %v", threshold)
+	}
+}
+
+func TestStream(t *testing.T) {
+	exchange := mock.New("binance")
+	out := make(ExchangeApi.MessageChan, 10)
+	s, err := Start(exchange, "BTC/USDT", Options{Type: ExchangeApi.VolumeBar, Threshold: 2}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	exchange.Publish("BTC/USDT", ExchangeApi.Message{Type: ExchangeApi.MsgTrade, Data: []ExchangeApi.Trade{
+		trade(1, 10, 1), {Symbol: "ETH/USDT", Timestamp: 1, Price: 1, Amount: 5}, trade(2, 11, 1),
+	}})
+	select {
+	case msg := <-out:
+		bar := msg.Data.(ExchangeApi.Bar)
+		if msg.Type != ExchangeApi.MsgBar || bar.Symbol != "BTC/USDT" || bar.Volume != 2 || bar.Close != 11 {
+			t.Errorf("unexpected bar %+v", bar)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("bar not received")
+	}
+	if _, ok := s.Current(); ok {
+		t.Error("no bar should be in progress")
+	}
+
+	// the trades are subscribed again on the new connection
+	exchange.Reconnect()
+	testutil.WaitFor(t, func() bool { return exchange.Subscribers(ExchangeApi.MsgTrade, "BTC/USDT") == 2 })
+}
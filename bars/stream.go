@@ -0,0 +1,53 @@
+package bars
+
+import (
+	"sync"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// Stream build the bars of a symbol from the trades subscribed through an IExchange
+type Stream struct {
+	trades *ExchangeApi.TradeStream
+
+	lock    sync.Mutex
+	builder *Builder
+}
+
+// Start subscribe the trades of the symbol, the closed bars are sent to out as the MsgBar messages of ExchangeApi.Bar
+func Start(exchange ExchangeApi.IExchange, symbol string, options Options, out ExchangeApi.MessageChan) (*Stream, error) {
+	builder, err := New(symbol, options)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{builder: builder}
+	if s.trades, err = ExchangeApi.SubscribeTradeStream(exchange, symbol); err != nil {
+		return nil, err
+	}
+	s.trades.Run(out, 0, s.add, nil)
+	return s, nil
+}
+
+// Stop close the subscription and wait for the goroutine to exit
+func (s *Stream) Stop() error {
+	return s.trades.Stop()
+}
+
+// Current the bar in progress
+func (s *Stream) Current() (ExchangeApi.Bar, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.builder.Current()
+}
+
+func (s *Stream) add(trades []ExchangeApi.Trade) []ExchangeApi.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var msgs []ExchangeApi.Message
+	for _, trade := range trades {
+		if bar, ok := s.builder.Add(trade); ok {
+			msgs = append(msgs, ExchangeApi.Message{Type: ExchangeApi.MsgBar, Data: bar})
+		}
+	}
+	return msgs
+}
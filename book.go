@@ -0,0 +1,453 @@
+package ExchangeApi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PriceScale the prices are kept as fixed-point integers with 8 decimals, the prices of more decimals are rejected
+	PriceScale = 100000000
+	// DefaultBookDepth the levels of each side kept by a Book if Options.OrderBookDepth is not set
+	DefaultBookDepth = 400
+
+	maxPriceInteger = math.MaxInt64 / PriceScale
+	maxBookLevel    = 16 // the skip list holds 4^16 levels at least
+)
+
+// ParsePrice convert a decimal price to the fixed-point key, the plain decimals are converted without float64,
+// the scientific notation (eg. 1e-05 formatted by the float prices of some exchanges) falls back to ParseFloat.
+// An error is returned if the price has a non-zero decimal beyond the 8th, the key would merge it with another price.
+func ParsePrice(price string) (int64, error) {
+	var integer, fraction int64
+	scale := int64(PriceScale)
+	dot, digits := false, 0
+	for i := 0; i < len(price); i++ {
+		c := price[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+			if dot {
+				if scale == 1 {
+					if c != '0' {
+						return 0, fmt.Errorf("price %s has more than 8 decimals", price)
+					}
+					continue
+				}
+				scale /= 10
+				fraction += int64(c-'0') * scale
+			} else {
+				if integer > maxPriceInteger/10 {
+					return 0, fmt.Errorf("price %s out of range", price)
+				}
+				integer = integer*10 + int64(c-'0')
+			}
+		case c == '.' && !dot:
+			dot = true
+		default:
+			return parseFloatPrice(price)
+		}
+	}
+	if digits == 0 || integer > maxPriceInteger {
+		return 0, fmt.Errorf("invalid price %s", price)
+	}
+	return integer*PriceScale + fraction, nil
+}
+
+func parseFloatPrice(price string) (int64, error) {
+	f, err := strconv.ParseFloat(price, 64)
+	if err != nil || f < 0 || f > maxPriceInteger {
+		return 0, fmt.Errorf("invalid price %s", price)
+	}
+	scaled := f * PriceScale
+	key := math.Round(scaled)
+	// the float error is tolerated, not the decimals beyond the 8th
+	if math.Abs(scaled-key) > math.Max(1e-6, scaled*1e-15) {
+		return 0, fmt.Errorf("price %s has more than 8 decimals", price)
+	}
+	return int64(key), nil
+}
+
+// FormatPrice convert the fixed-point key back to the shortest decimal
+func FormatPrice(key int64) string {
+	integer := strconv.FormatInt(key/PriceScale, 10)
+	fraction := key % PriceScale
+	if fraction == 0 {
+		return integer
+	}
+	// PriceScale + fraction keeps the leading zeros of the fraction
+	digits := strings.TrimRight(strconv.FormatInt(PriceScale+fraction, 10)[1:], "0")
+	return integer + "." + digits
+}
+
+// normalizeAmount trim the trailing zeros of the amount, the scientific notation is converted to the plain decimal
+func normalizeAmount(amount string) string {
+	if isZeroAmount(amount) {
+		return "0"
+	}
+	if strings.ContainsAny(amount, "eE") {
+		if f, err := strconv.ParseFloat(amount, 64); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return amount
+	}
+	if strings.IndexByte(amount, '.') >= 0 {
+		amount = strings.TrimRight(strings.TrimRight(amount, "0"), ".")
+	}
+	return amount
+}
+
+// isZeroAmount whether the amount removes the level, it's true if no digit of the mantissa is non-zero
+func isZeroAmount(amount string) bool {
+	for i := 0; i < len(amount); i++ {
+		c := amount[i]
+		if c >= '1' && c <= '9' {
+			return false
+		}
+		if c == 'e' || c == 'E' {
+			break
+		}
+	}
+	return true
+}
+
+type bookNode struct {
+	key  int64
+	item DepthItem
+	next []*bookNode
+}
+
+// bookSide a skip list of the levels of one side sorted by key ascending, the keys of bids are negative prices,
+// so the best level of both sides comes first
+type bookSide struct {
+	head   bookNode
+	level  int
+	size   int
+	seed   uint64
+	update [maxBookLevel]*bookNode
+}
+
+func newBookSide(seed uint64) *bookSide {
+	return &bookSide{head: bookNode{next: make([]*bookNode, maxBookLevel)}, level: 1, seed: seed}
+}
+
+// randomLevel the level of a new node, each level is taken by 1/4 of the nodes of the level below
+func (s *bookSide) randomLevel() int {
+	// xorshift64, it is cheaper than math/rand and the side is not shared by goroutines anyway
+	s.seed ^= s.seed << 13
+	s.seed ^= s.seed >> 7
+	s.seed ^= s.seed << 17
+	level, r := 1, s.seed
+	for level < maxBookLevel && r&3 == 0 {
+		level++
+		r >>= 2
+	}
+	return level
+}
+
+// search fill s.update with the last node before key on each level, the node of key is returned if exists
+func (s *bookSide) search(key int64) *bookNode {
+	x := &s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		s.update[i] = x
+	}
+	if x = x.next[0]; x != nil && x.key == key {
+		return x
+	}
+	return nil
+}
+
+func (s *bookSide) set(key int64, item DepthItem) {
+	if x := s.search(key); x != nil {
+		x.item = item
+		return
+	}
+	level := s.randomLevel()
+	for ; s.level < level; s.level++ {
+		s.update[s.level] = &s.head
+	}
+	x := &bookNode{key: key, item: item, next: make([]*bookNode, level)}
+	for i := 0; i < level; i++ {
+		x.next[i] = s.update[i].next[i]
+		s.update[i].next[i] = x
+	}
+	s.size++
+}
+
+func (s *bookSide) remove(key int64) bool {
+	x := s.search(key)
+	if x == nil {
+		return false
+	}
+	for i := 0; i < len(x.next); i++ {
+		s.update[i].next[i] = x.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+	s.size--
+	return true
+}
+
+// last the key of the worst level
+func (s *bookSide) last() (int64, bool) {
+	x := &s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.next[i] != nil {
+			x = x.next[i]
+		}
+	}
+	return x.key, x != &s.head
+}
+
+func (s *bookSide) first() (DepthItem, bool) {
+	if x := s.head.next[0]; x != nil {
+		return x.item, true
+	}
+	return DepthItem{}, false
+}
+
+// normalized copy all of the levels with the normalized prices and amounts, sign is -1 for the bids
+func (s *bookSide) normalized(sign int64) Depth {
+	d := make(Depth, 0, s.size)
+	for x := s.head.next[0]; x != nil; x = x.next[0] {
+		d = append(d, DepthItem{Price: FormatPrice(sign * x.key), Amount: normalizeAmount(x.item.Amount)})
+	}
+	return d
+}
+
+// depth copy the best n levels, all of the levels if n <= 0
+func (s *bookSide) depth(n int) Depth {
+	if n <= 0 || n > s.size {
+		n = s.size
+	}
+	d := make(Depth, 0, n)
+	for x := s.head.next[0]; x != nil && len(d) < n; x = x.next[0] {
+		d = append(d, x.item)
+	}
+	return d
+}
+
+func (s *bookSide) reset() {
+	for i := range s.head.next {
+		s.head.next[i] = nil
+	}
+	s.level, s.size = 1, 0
+}
+
+// ErrDeltaGap a delta is missed or comes before any snapshot, the book must be rebuilt by the next snapshot
+var ErrDeltaGap = errors.New("order book delta out of sequence")
+
+// Book the local order book maintained by the incremental depth updates.
+// The levels are kept by the fixed-point price, so the update of a level is O(log n) without re-sorting the side,
+// and the original price and amount strings are kept for the checksums.
+// It is not safe for concurrent use, the subscribers get the immutable copy by Snapshot or Delta.
+type Book struct {
+	Symbol string
+	depth  int
+	bids   *bookSide
+	asks   *bookSide
+
+	seq      uint64 // the Seq of the last delta
+	tracking bool   // whether the changes are recorded for Delta
+	snapshot bool   // the next delta is the whole book, the book is new or reset since the last delta
+	changes  struct {
+		bids Depth
+		asks Depth
+	}
+}
+
+// NewBook create an empty book keeping depth levels of each side, DefaultBookDepth if depth is 0, a negative depth means no limit
+func NewBook(symbol string, depth int) *Book {
+	if depth == 0 {
+		depth = DefaultBookDepth
+	}
+	return &Book{
+		Symbol: symbol,
+		depth:  depth,
+		bids:   newBookSide(0x9E3779B97F4A7C15),
+		asks:   newBookSide(0xD1B54A32D192ED03),
+
+		snapshot: true,
+	}
+}
+
+// Depth the max levels of each side, negative means no limit
+func (b *Book) Depth() int { return b.depth }
+
+// Len the number of levels of each side
+func (b *Book) Len() (bids, asks int) { return b.bids.size, b.asks.size }
+
+// Reset remove all of the levels, eg. before applying a new snapshot
+func (b *Book) Reset() {
+	b.bids.reset()
+	b.asks.reset()
+	b.snapshot = true
+	b.changes.bids, b.changes.asks = nil, nil
+}
+
+// Update apply the levels to the book, a level of zero amount is removed, the invalid levels are skipped
+func (b *Book) Update(bids, asks RawDepth) {
+	for _, raw := range bids {
+		if item, err := raw.ParseRawDepthItem(); err == nil {
+			_ = b.UpdateBid(item)
+		}
+	}
+	for _, raw := range asks {
+		if item, err := raw.ParseRawDepthItem(); err == nil {
+			_ = b.UpdateAsk(item)
+		}
+	}
+}
+
+// UpdateBid set the amount of a bid level, the level is removed if the amount is zero
+func (b *Book) UpdateBid(item DepthItem) error {
+	key, err := ParsePrice(item.Price)
+	if err != nil {
+		return err
+	}
+	b.update(b.bids, &b.changes.bids, -1, -key, item)
+	return nil
+}
+
+// UpdateAsk set the amount of an ask level, the level is removed if the amount is zero
+func (b *Book) UpdateAsk(item DepthItem) error {
+	key, err := ParsePrice(item.Price)
+	if err != nil {
+		return err
+	}
+	b.update(b.asks, &b.changes.asks, 1, key, item)
+	return nil
+}
+
+// update set the level of key, sign is -1 for the bids whose keys are negative prices
+func (b *Book) update(side *bookSide, changes *Depth, sign, key int64, item DepthItem) {
+	if isZeroAmount(item.Amount) {
+		if side.remove(key) {
+			b.record(changes, sign*key, "0")
+		}
+		return
+	}
+	side.set(key, item)
+	b.record(changes, sign*key, item.Amount)
+	if b.depth > 0 && side.size > b.depth {
+		if last, ok := side.last(); ok {
+			side.remove(last)
+			// the level dropped by the depth limit is removed from the books of the delta subscribers too
+			b.record(changes, sign*last, "0")
+		}
+	}
+}
+
+func (b *Book) record(changes *Depth, price int64, amount string) {
+	if !b.tracking || b.snapshot {
+		return
+	}
+	*changes = append(*changes, DepthItem{Price: FormatPrice(price), Amount: normalizeAmount(amount)})
+}
+
+// TrackChanges whether the changes are recorded for Delta, the first delta after it's enabled is the whole book
+func (b *Book) TrackChanges(enable bool) {
+	if enable && !b.tracking {
+		b.snapshot = true
+	}
+	b.tracking = enable
+	if !enable {
+		b.changes.bids, b.changes.asks = nil, nil
+	}
+}
+
+// Seq the Seq of the last delta taken or applied
+func (b *Book) Seq() uint64 { return b.seq }
+
+// Delta take the changes recorded since the last delta and advance Seq, false if nothing changed.
+// The whole book is taken as a snapshot instead if the book is new or reset since the last delta.
+func (b *Book) Delta() (OrderBookDelta, bool) {
+	if b.snapshot {
+		b.snapshot = false
+		b.seq++
+		return b.SnapshotDelta(), true
+	}
+	if len(b.changes.bids) == 0 && len(b.changes.asks) == 0 {
+		return OrderBookDelta{Symbol: b.Symbol, Seq: b.seq}, false
+	}
+	b.seq++
+	delta := OrderBookDelta{Symbol: b.Symbol, Seq: b.seq, Bids: b.changes.bids, Asks: b.changes.asks}
+	b.changes.bids, b.changes.asks = nil, nil
+	return delta, true
+}
+
+// SnapshotDelta the whole book as a snapshot of the current Seq, the next delta follows it
+func (b *Book) SnapshotDelta() OrderBookDelta {
+	return OrderBookDelta{
+		Symbol:   b.Symbol,
+		Seq:      b.seq,
+		Snapshot: true,
+		Bids:     b.bids.normalized(-1),
+		Asks:     b.asks.normalized(1),
+	}
+}
+
+// ApplyDelta keep the book by the deltas of MsgOrderBookDelta, a snapshot replaces the book.
+// ErrDeltaGap is returned and the delta is dropped if its Seq doesn't follow the book,
+// the book stays broken until the next snapshot, which can be requested by IExchange.RequestOrderBookSnapshot.
+// The delta with an invalid price is dropped as a whole, the book is not changed by it.
+func (b *Book) ApplyDelta(delta OrderBookDelta) error {
+	if !delta.Snapshot && (b.seq == 0 || delta.Seq != b.seq+1) {
+		return ErrDeltaGap
+	}
+	bids, err := parsePrices(delta.Bids)
+	if err != nil {
+		return err
+	}
+	asks, err := parsePrices(delta.Asks)
+	if err != nil {
+		return err
+	}
+	if delta.Snapshot {
+		b.Reset()
+	}
+	for i, item := range delta.Bids {
+		b.update(b.bids, &b.changes.bids, -1, -bids[i], item)
+	}
+	for i, item := range delta.Asks {
+		b.update(b.asks, &b.changes.asks, 1, asks[i], item)
+	}
+	b.seq = delta.Seq
+	return nil
+}
+
+// parsePrices the keys of the levels, the first invalid price fails all of them
+func parsePrices(d Depth) ([]int64, error) {
+	keys := make([]int64, len(d))
+	for i, item := range d {
+		key, err := ParsePrice(item.Price)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// BestBid the highest bid, false if there's no bid
+func (b *Book) BestBid() (DepthItem, bool) { return b.bids.first() }
+
+// BestAsk the lowest ask, false if there's no ask
+func (b *Book) BestAsk() (DepthItem, bool) { return b.asks.first() }
+
+// Snapshot copy the best levels of both sides, all of the levels kept if levels <= 0.
+// The returned order book doesn't share memory with the book, so it can be sent to the subscribers.
+func (b *Book) Snapshot(levels int) OrderBook {
+	return OrderBook{
+		Symbol: b.Symbol,
+		Bids:   b.bids.depth(levels),
+		Asks:   b.asks.depth(levels),
+	}
+}
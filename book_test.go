@@ -0,0 +1,183 @@
+package ExchangeApi
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	cases := map[string]int64{
+		"0":              0,
+		"1":              PriceScale,
+		"123.45":         12345000000,
+		"0.00000001":     1,
+		"0.000000010":    1, // the zeros beyond the 8th decimal don't lose the precision
+		"1e-05":          1000,
+		"1.5e-07":        15,
+		"39123.1":        3912310000000,
+		"92233720368.54": 9223372036854000000,
+	}
+	for price, expect := range cases {
+		key, err := ParsePrice(price)
+		if err != nil || key != expect {
+			t.Errorf("price %s expect %d, got %d %v", price, expect, key, err)
+		}
+	}
+	for _, price := range []string{"", ".", "abc", "1.2.3", "-1", "92233720369", "0.000000019", "1e-09"} {
+		if _, err := ParsePrice(price); err == nil {
+			t.Errorf("expect price %q invalid", price)
+		}
+	}
+}
+
+func TestBook_Update(t *testing.T) {
+	book := NewBook("BTC/USDT", 3)
+	book.Update(
+		RawDepth{{"100.5", "1"}, {"101", "2"}, {"99", "3"}, {"98", "4"}},
+		RawDepth{{"102", "1"}, {"101.50", "2"}, {"103", "3"}},
+	)
+	snapshot := book.Snapshot(0)
+	expect := "[{101 2} {100.5 1} {99 3}] [{101.50 2} {102 1} {103 3}]"
+	if got := fmt.Sprint(snapshot.Bids, " ", snapshot.Asks); got != expect {
+		t.Fatalf("expect %s, got %s", expect, got)
+	}
+
+	// the same price of different formats is the same level, zero amount removes it
+	book.Update(RawDepth{{"101.0", "0.000"}, {"99.00", "5"}}, RawDepth{{"101.5", "0"}, {"104", "1"}})
+	if bid, _ := book.BestBid(); bid.Price != "100.5" {
+		t.Errorf("expect best bid 100.5, got %v", bid)
+	}
+	if ask, _ := book.BestAsk(); ask.Price != "102" {
+		t.Errorf("expect best ask 102, got %v", ask)
+	}
+	top := book.Snapshot(2)
+	expect = "[{100.5 1} {99.00 5}] [{102 1} {103 3}]"
+	if got := fmt.Sprint(top.Bids, " ", top.Asks); got != expect {
+		t.Errorf("expect %s, got %s", expect, got)
+	}
+
+	// the snapshot taken before is not changed by the updates
+	if got := fmt.Sprint(snapshot.Bids); got != "[{101 2} {100.5 1} {99 3}]" {
+		t.Errorf("snapshot changed %s", got)
+	}
+	book.Reset()
+	if bids, asks := book.Len(); bids != 0 || asks != 0 {
+		t.Errorf("expect empty book after reset, got %d %d", bids, asks)
+	}
+}
+
+// TestBook_Random compare the book with a map of the levels after random updates
+func TestBook_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	book := NewBook("BTC/USDT", -1)
+	levels := map[int64]string{}
+	for i := 0; i < 20000; i++ {
+		price := int64(r.Intn(2000))
+		amount := "0"
+		if r.Intn(3) > 0 {
+			amount = fmt.Sprint(r.Intn(100) + 1)
+		}
+		_ = book.UpdateAsk(DepthItem{Price: fmt.Sprint(price), Amount: amount})
+		if amount == "0" {
+			delete(levels, price)
+		} else {
+			levels[price] = amount
+		}
+	}
+	prices := make([]int64, 0, len(levels))
+	for price := range levels {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+	asks := book.Snapshot(0).Asks
+	if len(asks) != len(prices) {
+		t.Fatalf("expect %d levels, got %d", len(prices), len(asks))
+	}
+	for i, price := range prices {
+		if asks[i].Price != fmt.Sprint(price) || asks[i].Amount != levels[price] {
+			t.Fatalf("level %d expect %d %s, got %v", i, price, levels[price], asks[i])
+		}
+	}
+}
+
+// TestBook_Delta keep a book by the deltas of another book with a depth limit
+func TestBook_Delta(t *testing.T) {
+	if got := FormatPrice(10005000000); got != "100.05" {
+		t.Errorf("expect 100.05, got %s", got)
+	}
+	r := rand.New(rand.NewSource(2))
+	book, local := NewBook("BTC/USDT", 50), NewBook("BTC/USDT", -1)
+	book.TrackChanges(true)
+	for i := 0; i < 2000; i++ {
+		item := DepthItem{Price: fmt.Sprintf("%d.%d0", 100+r.Intn(100), r.Intn(10)), Amount: fmt.Sprint(r.Intn(3))}
+		if r.Intn(2) == 0 {
+			_ = book.UpdateBid(item)
+		} else {
+			_ = book.UpdateAsk(item)
+		}
+		if i%7 != 0 {
+			continue
+		}
+		delta, changed := book.Delta()
+		if i == 0 && !delta.Snapshot {
+			t.Fatal("expect the first delta is a snapshot")
+		}
+		if changed {
+			if err := local.ApplyDelta(delta); err != nil {
+				t.Fatalf("delta %d: %v", delta.Seq, err)
+			}
+		}
+	}
+	book.Delta()
+	// the levels dropped by the depth limit are removed by the deltas too
+	expect, got := book.SnapshotDelta(), local.SnapshotDelta()
+	if fmt.Sprint(expect.Bids, expect.Asks) != fmt.Sprint(got.Bids, got.Asks) {
+		t.Fatalf("local book differs\nexpect %v %v\ngot %v %v", expect.Bids, expect.Asks, got.Bids, got.Asks)
+	}
+
+	// the best bid, the worst one is dropped by the depth limit
+	_ = book.UpdateBid(DepthItem{Price: "250.00", Amount: "1.500"})
+	delta, _ := book.Delta()
+	if len(delta.Bids) != 2 || delta.Bids[0] != (DepthItem{Price: "250", Amount: "1.5"}) || delta.Bids[1].Amount != "0" {
+		t.Errorf("expect normalized level, got %v", delta.Bids)
+	}
+	if err := local.ApplyDelta(delta); err != ErrDeltaGap {
+		t.Errorf("expect gap, got %v", err)
+	}
+	if err := local.ApplyDelta(book.SnapshotDelta()); err != nil || local.Seq() != book.Seq() {
+		t.Errorf("expect rebuilt by the snapshot, got %v", err)
+	}
+
+	// the delta with an invalid price is dropped as a whole
+	before := local.SnapshotDelta()
+	invalid := OrderBookDelta{Symbol: "BTC/USDT", Seq: local.Seq() + 1, Bids: Depth{{Price: "300", Amount: "1"}}, Asks: Depth{{Price: "abc", Amount: "1"}}}
+	if err := local.ApplyDelta(invalid); err == nil {
+		t.Error("expect the invalid price rejected")
+	}
+	invalid.Snapshot = true
+	if err := local.ApplyDelta(invalid); err == nil {
+		t.Error("expect the invalid snapshot rejected")
+	}
+	if after := local.SnapshotDelta(); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("the book is changed by the invalid deltas\nexpect %v\ngot %v", before, after)
+	}
+	book.Reset()
+	if delta, _ := book.Delta(); !delta.Snapshot {
+		t.Error("expect a snapshot after reset")
+	}
+}
+
+func BenchmarkBook_Update(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	updates := make([]DepthItem, 4096)
+	for i := range updates {
+		updates[i] = DepthItem{Price: fmt.Sprintf("%d.%02d", 30000+r.Intn(50), r.Intn(100)), Amount: fmt.Sprint(r.Intn(3))}
+	}
+	book := NewBook("BTC/USDT", DefaultBookDepth)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = book.UpdateBid(updates[i%len(updates)])
+	}
+}
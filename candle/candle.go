@@ -0,0 +1,255 @@
+// Package candle builds the klines of any interval from the trades, including the seconds and the intervals the exchanges don't offer.
+package candle
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// DefaultMaxCandles the closed candles kept by a Builder if Options.MaxCandles is not set
+const DefaultMaxCandles = 1000
+
+type Options struct {
+	Interval   time.Duration // the length of the candles, a whole number of milliseconds
+	Offset     time.Duration // where the candles start relative to the unix epoch, eg. 96h for the weeks starting on Monday
+	ClosedOnly bool          // emit the closed candles only, the in-progress candle is emitted on each trade otherwise
+	FillGaps   bool          // emit the candles of the intervals without trade, they open and close at the previous close with no volume
+	CloseDelay time.Duration // how long a candle waits for the late trades after its end before closed by the clock
+	History    bool          // merge the klines fetched by FetchKLine at start, see Start
+	MaxCandles int           // the closed candles kept, DefaultMaxCandles if 0
+}
+
+// Candle a kline built from the trades, Timestamp is the open time in milliseconds as the klines of the exchanges,
+// Type is KLineUnknown if the interval is not one of KLineType
+type Candle struct {
+	ExchangeApi.KLine
+	Interval    time.Duration
+	QuoteVolume float64 // the quote amount of the trades, the history is not counted
+	Trades      int     // the number of the trades, the history is not counted
+	Closed      bool    // false for the in-progress candle, it changes with the later trades
+}
+
+// End the close time of the candle in milliseconds
+func (c Candle) End() time.Duration {
+	return c.Timestamp + time.Duration(c.Interval.Milliseconds())
+}
+
+var ErrInterval = errors.New("the candle interval must be a positive whole number of milliseconds")
+
+// Builder aggregate the trades of one symbol into candles, it is not safe for concurrent use.
+// The trades are expected in time order, a trade older than the closed candles is dropped.
+type Builder struct {
+	symbol   string
+	options  Options
+	interval int64 // in milliseconds
+	offset   int64
+	kind     ExchangeApi.KLineType
+
+	current   *Candle
+	closed    []Candle
+	next      int64 // the open time of the next candle, the trades before it are late
+	upTo      int64 // the trades up to it are counted by the history
+	lastClose float64
+	late      int
+}
+
+func New(symbol string, options Options) (*Builder, error) {
+	if options.Interval < time.Millisecond || options.Interval%time.Millisecond != 0 || options.Offset%time.Millisecond != 0 {
+		return nil, ErrInterval
+	}
+	if options.MaxCandles == 0 {
+		options.MaxCandles = DefaultMaxCandles
+	}
+	b := &Builder{
+		symbol:   symbol,
+		options:  options,
+		interval: options.Interval.Milliseconds(),
+		offset:   options.Offset.Milliseconds() % options.Interval.Milliseconds(),
+		next:     -1 << 63,
+		upTo:     -1 << 63,
+	}
+	if b.offset == 0 {
+		b.kind = klineType(options.Interval)
+	}
+	return b, nil
+}
+
+// klineType the KLineType of the interval, KLineUnknown if none
+func klineType(interval time.Duration) ExchangeApi.KLineType {
+	for t := ExchangeApi.KLine1Minute; t <= ExchangeApi.KLine1Week; t++ {
+		if t.Duration() == interval {
+			return t
+		}
+	}
+	return ExchangeApi.KLineUnknown
+}
+
+// historyType the longest KLineType fetched to build the candles of the interval, KLineUnknown if the interval is shorter than a minute.
+// The klines longer than a day are not used, since the exchanges don't agree on where the weeks start
+func historyType(interval, offset time.Duration) ExchangeApi.KLineType {
+	for t := ExchangeApi.KLine1Day; t >= ExchangeApi.KLine1Minute; t-- {
+		if d := t.Duration(); interval%d == 0 && offset%d == 0 {
+			return t
+		}
+	}
+	return ExchangeApi.KLineUnknown
+}
+
+// start the open time of the candle of the time in milliseconds
+func (b *Builder) start(ms int64) int64 {
+	n := (ms - b.offset) / b.interval
+	if (ms-b.offset)%b.interval < 0 {
+		n--
+	}
+	return n*b.interval + b.offset
+}
+
+func (b *Builder) open(start int64, price float64) *Candle {
+	return &Candle{
+		KLine: ExchangeApi.KLine{
+			Symbol:    b.symbol,
+			Timestamp: time.Duration(start),
+			Type:      b.kind,
+			Open:      price,
+			Close:     price,
+			High:      price,
+			Low:       price,
+		},
+		Interval: b.options.Interval,
+	}
+}
+
+// Add aggregate the trade, the candles emitted are returned: the candles closed by it and the in-progress one
+func (b *Builder) Add(trade ExchangeApi.Trade) []Candle {
+	ts := int64(trade.Timestamp)
+	if ts <= b.upTo || ts < b.next || (b.current != nil && ts < int64(b.current.Timestamp)) {
+		b.late++
+		return nil
+	}
+	start := b.start(ts)
+	var out []Candle
+	if b.current != nil && start > int64(b.current.Timestamp) {
+		out = b.closeUntil(start)
+	}
+	if b.current == nil {
+		b.current = b.open(start, trade.Price)
+	}
+	c := b.current
+	if trade.Price > c.High {
+		c.High = trade.Price
+	}
+	if trade.Price < c.Low {
+		c.Low = trade.Price
+	}
+	c.Close = trade.Price
+	c.Volume += trade.Amount
+	c.QuoteVolume += trade.Amount * trade.Price
+	c.Trades++
+	if !b.options.ClosedOnly {
+		out = append(out, *c)
+	}
+	return out
+}
+
+// Flush close the in-progress candle if the clock passed its end by Options.CloseDelay, so a candle is closed without a later trade.
+// now is the time in milliseconds, eg. time.Duration(time.Now().UnixNano() / 1e6)
+func (b *Builder) Flush(now time.Duration) []Candle {
+	ms := int64(now - time.Duration(b.options.CloseDelay.Milliseconds()))
+	if b.current != nil {
+		if ms < int64(b.current.End()) {
+			return nil
+		}
+		return b.closeUntil(b.start(ms))
+	}
+	if b.options.FillGaps && b.next != -1<<63 && ms >= b.next+b.interval {
+		return b.fill(b.start(ms))
+	}
+	return nil
+}
+
+// closeUntil close the in-progress candle and fill the gap until the candle of start
+func (b *Builder) closeUntil(start int64) []Candle {
+	c := b.current
+	b.current = nil
+	c.Closed = true
+	b.push(*c)
+	out := []Candle{*c}
+	if b.options.FillGaps {
+		out = append(out, b.fill(start)...)
+	}
+	return out
+}
+
+// fill close the empty candles from b.next until the candle of start
+func (b *Builder) fill(start int64) []Candle {
+	var out []Candle
+	for ; b.next < start; b.next += b.interval {
+		c := b.open(b.next, b.lastClose)
+		c.Closed = true
+		b.push(*c)
+		out = append(out, *c)
+	}
+	return out
+}
+
+func (b *Builder) push(c Candle) {
+	b.closed = append(b.closed, c)
+	if len(b.closed) > b.options.MaxCandles {
+		b.closed = append(b.closed[:0], b.closed[len(b.closed)-b.options.MaxCandles:]...)
+	}
+	b.next = int64(c.End())
+	b.lastClose = c.Close
+}
+
+// Seed merge the klines of the history before adding any trade, the klines shorter than the interval are resampled.
+// The trades up to upTo (in milliseconds) are counted by the history, they are dropped by Add.
+// The candle of upTo stays in progress, the later trades go on with it.
+func (b *Builder) Seed(history []ExchangeApi.KLine, upTo time.Duration) {
+	klines := append([]ExchangeApi.KLine(nil), history...)
+	sort.Slice(klines, func(i, j int) bool { return klines[i].Timestamp < klines[j].Timestamp })
+	for _, k := range klines {
+		start := b.start(int64(k.Timestamp))
+		if b.current != nil && start > int64(b.current.Timestamp) {
+			b.closeUntil(start)
+		}
+		if b.current == nil {
+			b.current = b.open(start, k.Open)
+		}
+		c := b.current
+		if k.High > c.High {
+			c.High = k.High
+		}
+		if k.Low < c.Low {
+			c.Low = k.Low
+		}
+		c.Close = k.Close
+		c.Volume += k.Volume
+	}
+	if b.current != nil && int64(b.current.End()) <= int64(upTo) {
+		b.closeUntil(b.start(int64(upTo)))
+	}
+	b.upTo = int64(upTo)
+}
+
+// Current the in-progress candle, false if there's none
+func (b *Builder) Current() (Candle, bool) {
+	if b.current == nil {
+		return Candle{}, false
+	}
+	return *b.current, true
+}
+
+// Candles the closed candles kept and the in-progress one, from the oldest
+func (b *Builder) Candles() []Candle {
+	candles := append([]Candle(nil), b.closed...)
+	if b.current != nil {
+		candles = append(candles, *b.current)
+	}
+	return candles
+}
+
+// Late the number of the trades dropped since they're older than the closed candles or counted by the history
+func (b *Builder) Late() int { return b.late }
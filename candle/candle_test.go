@@ -0,0 +1,112 @@
+package candle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+)
+
+func trade(ms int64, price, amount float64) ExchangeApi.Trade {
+	return ExchangeApi.Trade{Symbol: "BTC/USDT", Timestamp: time.Duration(ms), Price: price, Amount: amount}
+}
+
+func TestBuilder_Add(t *testing.T) {
+	b, err := New("BTC/USDT", Options{Interval: time.Second * 5, FillGaps: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Add(trade(1000, 10, 1))
+	b.Add(trade(2000, 12, 1))
+	out := b.Add(trade(4999, 9, 2))
+	if len(out) != 1 || out[0].Closed || out[0].High != 12 || out[0].Low != 9 || out[0].Volume != 4 || out[0].Trades != 3 {
+		t.Errorf("unexpected in-progress candle %+v", out)
+	}
+	// the candle of [5000, 10000) has no trade
+	out = b.Add(trade(10500, 11, 1))
+	if len(out) != 3 || !out[0].Closed || out[0].Close != 9 || out[1].Timestamp != 5000 || out[1].Open != 9 || out[1].Volume != 0 || out[2].Closed {
+		t.Errorf("expect closed, filled and in-progress candles, got %+v", out)
+	}
+	if out := b.Add(trade(4000, 100, 1)); len(out) != 0 || b.Late() != 1 {
+		t.Errorf("expect the late trade dropped, got %+v", out)
+	}
+	if out := b.Flush(14999); len(out) != 0 {
+		t.Errorf("expect not closed before the end, got %+v", out)
+	}
+	if out := b.Flush(15000); len(out) != 1 || !out[0].Closed || out[0].Timestamp != 10000 {
+		t.Errorf("expect closed by the clock, got %+v", out)
+	}
+	if candles := b.Candles(); len(candles) != 3 {
+		t.Errorf("expect 3 candles, got %d", len(candles))
+	}
+	if _, err := New("BTC/USDT", Options{Interval: time.Microsecond}); err != ErrInterval {
+		t.Errorf("expect interval error, got %v", err)
+	}
+}
+
+func TestBuilder_Seed(t *testing.T) {
+	b, _ := New("BTC/USDT", Options{Interval: time.Minute * 7, ClosedOnly: true})
+	if historyType(time.Minute*7, 0) != ExchangeApi.KLine1Minute || historyType(time.Hour*4, 0) != ExchangeApi.KLine4Hour {
+		t.Error("unexpected history type")
+	}
+	minute := int64(time.Minute / time.Millisecond)
+	var history []ExchangeApi.KLine
+	for i := int64(0); i < 10; i++ {
+		history = append(history, ExchangeApi.KLine{Timestamp: time.Duration(i * minute), Open: float64(i), Close: float64(i + 1), High: float64(i + 2), Low: float64(i), Volume: 1})
+	}
+	b.Seed(history, time.Duration(9*minute+30000))
+	candles := b.Candles()
+	if len(candles) != 2 || !candles[0].Closed || candles[0].Open != 0 || candles[0].Close != 7 || candles[0].High != 8 || candles[0].Volume != 7 {
+		t.Fatalf("unexpected candles from history %+v", candles)
+	}
+	// the trades counted by the history are dropped
+	b.Add(trade(9*minute+10000, 100, 1))
+	b.Add(trade(9*minute+40000, 20, 1))
+	if current, _ := b.Current(); current.Volume != 4 || current.High != 20 || current.Open != 7 || current.Trades != 1 {
+		t.Errorf("unexpected in-progress candle %+v", current)
+	}
+	if out := b.Add(trade(14*minute, 21, 1)); len(out) != 1 || out[0].Timestamp != time.Duration(7*minute) || !out[0].Closed {
+		t.Errorf("expect the merged candle closed, got %+v", out)
+	}
+}
+
+func TestStream(t *testing.T) {
+	exchange := mock.New("binance")
+	now := time.Now()
+	minute := time.Minute.Milliseconds()
+	start := now.UnixNano()/1e6/minute*minute - minute
+	exchange.SetKLines("BTC/USDT", ExchangeApi.KLine1Minute, []ExchangeApi.KLine{
+		{Symbol: "BTC/USDT", Timestamp: time.Duration(start), Open: 1, Close: 2, High: 3, Low: 1, Volume: 5},
+	})
+	out := make(ExchangeApi.MessageChan, 10)
+	s, err := Start(exchange, "BTC/USDT", Options{Interval: time.Minute, History: true}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if candles := s.Candles(); len(candles) != 1 || candles[0].Volume != 5 || candles[0].Type != ExchangeApi.KLine1Minute {
+		t.Fatalf("unexpected candles from history %+v", candles)
+	}
+
+	ms := now.UnixNano()/1e6 + 1000
+	exchange.Publish("BTC/USDT", ExchangeApi.Message{Type: ExchangeApi.MsgTrade, Data: []ExchangeApi.Trade{
+		trade(ms, 10, 1), {Symbol: "ETH/USDT", Timestamp: time.Duration(ms), Price: 1, Amount: 1},
+	}})
+	deadline := time.After(time.Second * 3)
+	for {
+		select {
+		case msg := <-out:
+			c := msg.Data.(Candle)
+			if c.Closed || c.Open != 10 {
+				continue
+			}
+			if c.Symbol != "BTC/USDT" || c.Volume != 1 {
+				t.Errorf("unexpected candle %+v", c)
+			}
+			return
+		case <-deadline:
+			t.Fatal("candle of the trade not received")
+		}
+	}
+}
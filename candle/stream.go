@@ -0,0 +1,87 @@
+package candle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// Stream build the candles of a symbol from the trades subscribed through an IExchange
+type Stream struct {
+	trades *ExchangeApi.TradeStream
+
+	lock    sync.Mutex
+	builder *Builder
+}
+
+// Start subscribe the trades of the symbol, the candles are sent to out as the MsgKLine messages of Candle.
+// If Options.History, the klines fetched by FetchKLine are merged first, the trades before the fetch are taken as counted by them.
+// No history is fetched for the intervals shorter than a minute.
+func Start(exchange ExchangeApi.IExchange, symbol string, options Options, out ExchangeApi.MessageChan) (*Stream, error) {
+	builder, err := New(symbol, options)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{builder: builder}
+	// subscribe before fetching, so no trade is missed between them
+	if s.trades, err = ExchangeApi.SubscribeTradeStream(exchange, symbol); err != nil {
+		return nil, err
+	}
+	if t := historyType(options.Interval, options.Offset); options.History && t != ExchangeApi.KLineUnknown {
+		upTo := nowMs()
+		klines, err := exchange.FetchKLine(symbol, t)
+		if err != nil {
+			s.trades.Stop()
+			return nil, err
+		}
+		builder.Seed(klines, upTo)
+	}
+	// the candles are closed by the clock at least every second
+	interval := options.Interval
+	if interval > time.Second {
+		interval = time.Second
+	}
+	s.trades.Run(out, interval, s.add, s.flush)
+	return s, nil
+}
+
+// Stop close the subscription and wait for the goroutine to exit
+func (s *Stream) Stop() error {
+	return s.trades.Stop()
+}
+
+// Candles the closed candles kept and the in-progress one, from the oldest
+func (s *Stream) Candles() []Candle {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.builder.Candles()
+}
+
+func nowMs() time.Duration {
+	return time.Duration(time.Now().UnixNano() / 1e6)
+}
+
+func (s *Stream) add(trades []ExchangeApi.Trade) []ExchangeApi.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var candles []Candle
+	for _, trade := range trades {
+		candles = append(candles, s.builder.Add(trade)...)
+	}
+	return messages(candles)
+}
+
+func (s *Stream) flush() []ExchangeApi.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return messages(s.builder.Flush(nowMs()))
+}
+
+func messages(candles []Candle) []ExchangeApi.Message {
+	msgs := make([]ExchangeApi.Message, len(candles))
+	for i, c := range candles {
+		msgs[i] = ExchangeApi.Message{Type: ExchangeApi.MsgKLine, Data: c}
+	}
+	return msgs
+}
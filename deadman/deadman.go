@@ -0,0 +1,340 @@
+// Package deadman cancels the resting orders when the process loses the exchange, a dead man's switch.
+// On an ICancelAllAfterExchange the countdown of the exchange is armed and refreshed as the heartbeat, so the orders
+// are canceled even if the process dies. A countdown covering the whole account is armed once for all the symbols.
+// On the other exchanges a watchdog on the client side cancels the open orders of the symbols once their websocket
+// has been disconnected longer than the threshold.
+package deadman
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+const (
+	DefaultTimeout   = time.Minute
+	DefaultThreshold = time.Second * 30
+)
+
+var (
+	ErrStarted  = errors.New("dead man's switch already started")
+	ErrInterval = errors.New("the heartbeat interval must be shorter than the timeout")
+)
+
+type Options struct {
+	Timeout    time.Duration // the countdown of the exchange, DefaultTimeout if 0. Its range is checked by the exchange
+	Interval   time.Duration // how often the countdown is refreshed, a quarter of Timeout if 0
+	Threshold  time.Duration // how long the websocket may be disconnected before the watchdog cancels, DefaultThreshold if 0
+	ClientSide bool          // use the watchdog even if the exchange has the countdown
+}
+
+type EventType int
+
+const (
+	HeartbeatFailed EventType = iota // the countdown is not refreshed, the exchange may cancel the orders
+	Triggered                        // the watchdog canceled the orders of the symbol, or failed to and retries
+)
+
+// Event the data of the MsgError sent by the switch
+type Event struct {
+	Type   EventType
+	Symbol string // empty for the heartbeat of a countdown covering the whole account
+	Err    error  // why the heartbeat failed, or the error of CancelAllOrders
+}
+
+func (e Event) Error() string {
+	if e.Type == HeartbeatFailed {
+		if e.Symbol == "" {
+			return fmt.Sprintf("[deadman] the heartbeat of the account failed: %v", e.Err)
+		}
+		return fmt.Sprintf("[deadman] the heartbeat of %s failed: %v", e.Symbol, e.Err)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("[deadman] canceling the orders of %s failed: %v", e.Symbol, e.Err)
+	}
+	return fmt.Sprintf("[deadman] the orders of %s are canceled after the disconnection", e.Symbol)
+}
+
+// watcher the order subscription of a symbol watched on the client side
+type watcher struct {
+	symbol       string
+	sub          *ExchangeApi.Resubscription
+	msgChan      ExchangeApi.MessageChan
+	done         chan struct{}
+	disconnected time.Time // when the websocket was lost, zero if connected
+	triggered    bool      // the orders are canceled for the current disconnection
+}
+
+// Switch the dead man's switch of the symbols of an exchange
+type Switch struct {
+	exchange ExchangeApi.IExchange
+	native   ExchangeApi.ICancelAllAfterExchange // nil if the watchdog is used
+	account  bool                                // the countdown covers the whole account rather than a symbol
+	options  Options
+
+	lock     sync.Mutex
+	watchers map[string]*watcher
+	started  bool
+	stop     chan struct{}
+	loops    sync.WaitGroup
+	out      ExchangeApi.MessageChan
+}
+
+func New(exchange ExchangeApi.IExchange, options Options) *Switch {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.Interval <= 0 {
+		options.Interval = options.Timeout / 4
+	}
+	if options.Threshold <= 0 {
+		options.Threshold = DefaultThreshold
+	}
+	s := &Switch{exchange: exchange, options: options, watchers: make(map[string]*watcher)}
+	if native, ok := exchange.(ExchangeApi.ICancelAllAfterExchange); ok && !options.ClientSide {
+		s.native = native
+		if a, ok := exchange.(ExchangeApi.IAccountCountdownExchange); ok {
+			s.account = a.AccountWideCountdown()
+		}
+	}
+	return s
+}
+
+// Native whether the countdown of the exchange is used rather than the watchdog
+func (s *Switch) Native() bool {
+	return s.native != nil
+}
+
+// Start arm the switch for the symbols, the failed heartbeats and the cancellations of the watchdog are sent to out
+// as MsgError with the Event, out may be nil. A timeout out of the range of the exchange fails with its error
+func (s *Switch) Start(symbols []string, out ExchangeApi.MessageChan) error {
+	s.lock.Lock()
+	if s.started {
+		s.lock.Unlock()
+		return ErrStarted
+	}
+	if s.native != nil && s.options.Interval >= s.options.Timeout {
+		s.lock.Unlock()
+		return ErrInterval
+	}
+	s.started, s.out, s.stop = true, out, make(chan struct{})
+	for _, symbol := range symbols {
+		if err := s.add(symbol); err != nil {
+			s.lock.Unlock()
+			s.Stop()
+			return err
+		}
+	}
+	s.loops.Add(1)
+	go s.run()
+	s.lock.Unlock()
+	return nil
+}
+
+// Stop disarm the countdowns or close the subscriptions, and wait for the goroutines to exit.
+// It returns the first error of disarming, the orders may still be canceled by the exchange then.
+func (s *Switch) Stop() error {
+	s.lock.Lock()
+	if !s.started {
+		s.lock.Unlock()
+		return nil
+	}
+	s.started = false
+	close(s.stop)
+	var first error
+	for symbol := range s.watchers {
+		if err := s.remove(symbol); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.lock.Unlock()
+	s.loops.Wait()
+	return first
+}
+
+// Add arm the switch for one more symbol
+func (s *Switch) Add(symbol string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.started {
+		return errors.New("dead man's switch not started")
+	}
+	return s.add(symbol)
+}
+
+// Remove disarm the switch of the symbol, eg. when it has no more resting orders
+func (s *Switch) Remove(symbol string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.remove(symbol)
+}
+
+// Symbols the symbols armed
+func (s *Switch) Symbols() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	symbols := make([]string, 0, len(s.watchers))
+	for symbol := range s.watchers {
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// Heartbeat refresh the countdowns of all symbols now, it's done every Options.Interval after Start.
+// It returns the first error, the other symbols are still refreshed.
+func (s *Switch) Heartbeat() error {
+	if s.native == nil {
+		return nil
+	}
+	symbols := s.Symbols()
+	if s.account && len(symbols) > 0 {
+		// one request refreshes the countdown of every symbol
+		if err := s.native.CancelAllAfter(symbols[0], s.options.Timeout); err != nil {
+			s.emit(Event{Type: HeartbeatFailed, Err: err})
+			return err
+		}
+		return nil
+	}
+	var first error
+	for _, symbol := range symbols {
+		if err := s.native.CancelAllAfter(symbol, s.options.Timeout); err != nil {
+			s.emit(Event{Type: HeartbeatFailed, Symbol: symbol, Err: err})
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// add must be called with the lock held
+func (s *Switch) add(symbol string) error {
+	if _, ok := s.watchers[symbol]; ok {
+		return nil
+	}
+	w := &watcher{symbol: symbol, done: make(chan struct{})}
+	if s.native != nil {
+		// the countdown of the account is armed by the first symbol
+		if !s.account || len(s.watchers) == 0 {
+			if err := s.native.CancelAllAfter(symbol, s.options.Timeout); err != nil {
+				return err
+			}
+		}
+	} else {
+		w.msgChan = make(ExchangeApi.MessageChan)
+		sub, err := ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+			return s.exchange.SubscribeOrder(symbol, w.msgChan)
+		})
+		if err != nil {
+			return err
+		}
+		w.sub = sub
+		s.loops.Add(1)
+		go s.listen(w)
+	}
+	s.watchers[symbol] = w
+	return nil
+}
+
+// remove must be called with the lock held
+func (s *Switch) remove(symbol string) error {
+	w, ok := s.watchers[symbol]
+	if !ok {
+		return nil
+	}
+	delete(s.watchers, symbol)
+	close(w.done)
+	if s.native != nil {
+		// the countdown of the account still guards the other symbols
+		if s.account && len(s.watchers) > 0 {
+			return nil
+		}
+		return s.native.CancelAllAfter(symbol, 0)
+	}
+	return w.sub.Close()
+}
+
+func (s *Switch) run() {
+	defer s.loops.Done()
+	interval := s.options.Interval
+	if s.native == nil {
+		interval = s.options.Threshold / 4
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if s.native != nil {
+				s.Heartbeat()
+			} else {
+				s.check(now)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// check cancel the orders of the symbols disconnected longer than the threshold, the failed ones are retried next time
+func (s *Switch) check(now time.Time) {
+	s.lock.Lock()
+	var expired []*watcher
+	for _, w := range s.watchers {
+		if !w.disconnected.IsZero() && !w.triggered && now.Sub(w.disconnected) >= s.options.Threshold {
+			expired = append(expired, w)
+		}
+	}
+	s.lock.Unlock()
+	for _, w := range expired {
+		err := s.exchange.CancelAllOrders(w.symbol)
+		if err == nil {
+			s.lock.Lock()
+			w.triggered = true
+			s.lock.Unlock()
+		}
+		s.emit(Event{Type: Triggered, Symbol: w.symbol, Err: err})
+	}
+}
+
+func (s *Switch) listen(w *watcher) {
+	defer s.loops.Done()
+	for {
+		select {
+		case msg := <-w.msgChan:
+			switch msg.Type {
+			case ExchangeApi.MsgDisConnected, ExchangeApi.MsgClosed:
+				s.lock.Lock()
+				if w.disconnected.IsZero() {
+					w.disconnected = time.Now()
+				}
+				s.lock.Unlock()
+			case ExchangeApi.MsgReConnected:
+				s.lock.Lock()
+				w.disconnected, w.triggered = time.Time{}, false
+				s.lock.Unlock()
+				w.sub.Renew()
+			}
+		case <-w.done:
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Switch) emit(event Event) {
+	s.lock.Lock()
+	out, stop := s.out, s.stop
+	s.lock.Unlock()
+	if out == nil {
+		return
+	}
+	select {
+	case out <- ExchangeApi.Message{Type: ExchangeApi.MsgError, Data: event}:
+	case <-stop:
+	}
+}
@@ -0,0 +1,178 @@
+package deadman
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+func receive(t *testing.T, out ExchangeApi.MessageChan) Event {
+	t.Helper()
+	return testutil.Receive(t, out, "event").Data.(Event)
+}
+
+func open(t *testing.T, exchange ExchangeApi.IExchange) int {
+	t.Helper()
+	orders, err := exchange.FetchOpenOrders("BTC/USDT", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(orders)
+}
+
+func TestSwitch_Native(t *testing.T) {
+	exchange := mock.New("binance")
+	exchange.CreateOrder("BTC/USDT", 100, 1, ExchangeApi.OpenLong, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	s := New(exchange, Options{Timeout: time.Millisecond * 100, Interval: time.Millisecond * 20})
+	if !s.Native() {
+		t.Fatal("the countdown of the exchange is not used")
+	}
+	out := make(ExchangeApi.MessageChan, 10)
+	if err := s.Start([]string{"BTC/USDT"}, out); err != nil {
+		t.Fatal(err)
+	}
+	// the heartbeat keeps the orders
+	time.Sleep(time.Millisecond * 250)
+	if open(t, exchange) != 1 {
+		t.Fatal("the orders are canceled while the heartbeat is refreshed")
+	}
+
+	// the heartbeat stops, the exchange cancels the orders once the countdown runs out
+	exchange.SetError("CancelAllAfter", errors.New("timeout"))
+	if e := receive(t, out); e.Type != HeartbeatFailed || e.Symbol != "BTC/USDT" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if open(t, exchange) != 0 {
+		t.Fatal("the orders are not canceled by the countdown")
+	}
+	exchange.SetError("CancelAllAfter", nil)
+	s.Stop()
+
+	// the countdown is disarmed by Stop
+	exchange.CreateOrder("BTC/USDT", 100, 1, ExchangeApi.OpenLong, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	if err := s.Start([]string{"BTC/USDT"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if open(t, exchange) != 1 {
+		t.Fatal("the orders are canceled after the switch is stopped")
+	}
+}
+
+func TestSwitch_Account(t *testing.T) {
+	exchange := &account{Exchange: mock.New("okex")}
+	s := New(exchange, Options{Timeout: time.Hour, Interval: time.Minute})
+	if err := s.Start([]string{"BTC/USDT", "ETH/USDT"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if calls := exchange.countdowns(); len(calls) != 1 || calls[0] != time.Hour {
+		t.Fatalf("expect the countdown armed once, got %v", calls)
+	}
+	if err := s.Heartbeat(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := exchange.countdowns(); len(calls) != 2 {
+		t.Fatalf("expect one request of the heartbeat, got %v", calls)
+	}
+
+	// the countdown is disarmed with the last symbol only
+	if err := s.Remove("BTC/USDT"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := exchange.countdowns(); len(calls) != 2 {
+		t.Fatalf("expect the countdown kept for ETH/USDT, got %v", calls)
+	}
+	if err := s.Remove("ETH/USDT"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := exchange.countdowns(); len(calls) != 3 || calls[2] != 0 {
+		t.Fatalf("expect the countdown disarmed, got %v", calls)
+	}
+}
+
+func TestSwitch_Options(t *testing.T) {
+	exchange := mock.New("okex")
+	if err := New(exchange, Options{Timeout: time.Second, Interval: time.Second}).Start(nil, nil); err != ErrInterval {
+		t.Errorf("expect ErrInterval, got %v", err)
+	}
+	// the timeout out of the range of the exchange
+	exchange.SetError("CancelAllAfter", ExchangeApi.ExError{Code: ExchangeApi.ErrRequestParams})
+	if err := New(exchange, Options{}).Start([]string{"BTC/USDT"}, nil); err == nil {
+		t.Error("expect the error of the exchange")
+	}
+}
+
+func TestSwitch_Watchdog(t *testing.T) {
+	exchange := mock.New("binance")
+	exchange.CreateOrder("BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	s := New(spot{exchange}, Options{Threshold: time.Millisecond * 50})
+	if s.Native() {
+		t.Fatal("the exchange has no countdown")
+	}
+	out := make(ExchangeApi.MessageChan, 10)
+	if err := s.Start([]string{"BTC/USDT"}, out); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	// a disconnection shorter than the threshold
+	exchange.Disconnect()
+	exchange.Reconnect()
+	time.Sleep(time.Millisecond * 100)
+	if open(t, exchange) != 1 {
+		t.Fatal("the orders are canceled after the reconnection")
+	}
+
+	exchange.Disconnect()
+	if e := receive(t, out); e.Type != Triggered || e.Err != nil {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if open(t, exchange) != 0 {
+		t.Fatal("the orders are not canceled by the watchdog")
+	}
+	// it's triggered once for a disconnection
+	exchange.CreateOrder("BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	time.Sleep(time.Millisecond * 100)
+	if open(t, exchange) != 1 {
+		t.Fatal("the watchdog is triggered twice")
+	}
+}
+
+// spot hides CancelAllAfter of the mock
+type spot struct {
+	ExchangeApi.IExchange
+}
+
+// account a mock whose countdown covers the whole account, the timeouts of CancelAllAfter are recorded
+type account struct {
+	*mock.Exchange
+	lock  sync.Mutex
+	calls []time.Duration
+}
+
+func (a *account) AccountWideCountdown() bool {
+	return true
+}
+
+func (a *account) CancelAllAfter(symbol string, timeout time.Duration) error {
+	a.lock.Lock()
+	a.calls = append(a.calls, timeout)
+	a.lock.Unlock()
+	return a.Exchange.CancelAllAfter(symbol, timeout)
+}
+
+func (a *account) countdowns() []time.Duration {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]time.Duration(nil), a.calls...)
+}
@@ -35,4 +35,7 @@ const (
 	ErrTimeout
 	ErrBadRequest
 	ErrBadResponse
+
+	//risk error
+	ErrRiskRejected = 40000 + iota // refused by the pre-trade risk checks, Data tells the check
 )
\ No newline at end of file
@@ -1,22 +1,35 @@
 package ExchangeApi
 
+import (
+	"context"
+	"time"
+)
 
 type IExchange interface {
 	//websocket api
-	SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub MessageChan) (string, error)
+	SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub MessageChan) (*Subscription, error)
 
-	SubscribeTrades(symbol string, sub MessageChan) (string, error)
+	// SubscribeOrderBookDelta subscribe the incremental order book as MsgOrderBookDelta, the whole book comes first as a snapshot,
+	// then the changed levels. A snapshot is sent again every snapshotInterval if it's > 0, and after the book is resynchronized
+	SubscribeOrderBookDelta(symbol string, snapshotInterval time.Duration, sub MessageChan) (*Subscription, error)
 
-	SubscribeTicker(symbol string, sub MessageChan) (string, error)
+	// RequestOrderBookSnapshot send the whole order book to the channel subscribing the deltas of the symbol,
+	// eg. after ErrDeltaGap. It is sent once the book is in sync if it's being resynchronized
+	RequestOrderBookSnapshot(symbol string, sub MessageChan) error
 
-	SubscribeAllTicker(sub MessageChan) (string, error)
+	SubscribeTrades(symbol string, sub MessageChan) (*Subscription, error)
 
-	SubscribeKLine(symbol string, t KLineType, sub MessageChan) (string, error)
+	SubscribeTicker(symbol string, sub MessageChan) (*Subscription, error)
 
-	SubscribeBalance(symbol string, sub MessageChan) (string, error)
+	SubscribeAllTicker(sub MessageChan) (*Subscription, error)
 
-	SubscribeOrder(symbol string, sub MessageChan) (string, error)
+	SubscribeKLine(symbol string, t KLineType, sub MessageChan) (*Subscription, error)
 
+	SubscribeBalance(symbol string, sub MessageChan) (*Subscription, error)
+
+	SubscribeOrder(symbol string, sub MessageChan) (*Subscription, error)
+
+	// UnSubscribe by topic and channel, Subscription.Close is preferred
 	UnSubscribe(topics string, sub MessageChan) error
 
 	//rest api
@@ -43,6 +56,13 @@ type IExchange interface {
 	FetchOrder(symbol, orderID string) (Order, error)
 
 	FetchOpenOrders(symbol string, pageIndex, pageSize int) ([]Order, error)
+
+	// ConnectionStats the latency statistics of the websocket connections, key: the url of the connection
+	ConnectionStats() map[string]ConnStats
+
+	// Shutdown stop all the goroutines, close the subscriptions and connections of the instance,
+	// it returns once everything has exited or ctx is done
+	Shutdown(ctx context.Context) error
 }
 
 type IFutureExchange interface {
@@ -60,7 +80,30 @@ type IFutureExchange interface {
 
 	FetchAllPositions() (positions []FuturePositons, err error)
 
-	SubscribePositions(symbol string, sub MessageChan) (string, error)
+	SubscribePositions(symbol string, sub MessageChan) (*Subscription, error)
+
+	SubscribeMarkPrice(symbol string, sub MessageChan) (*Subscription, error)
+}
+
+// IClientOrderExchange an exchange taking the client order IDs chosen by the caller, so an order whose creation failed
+// ambiguously (eg. timed out) can be looked up. FetchOrder and CancelOrder take the client order ID in place of the order ID,
+// it is told apart by the Options.ClientOrderIDPrefix, eg. an ID made by utils.GenerateOrderClientId with the same prefix
+type IClientOrderExchange interface {
+	// CreateOrderWithClientID create the order with the client order ID, it fails with ErrRequestParams if the ID lacks the prefix
+	CreateOrderWithClientID(symbol string, price, amount float64, side Side, tradeType TradeType, orderType OrderType, clientID string) (Order, error)
+}
+
+// ICancelAllAfterExchange an exchange canceling the open orders by itself once a countdown runs out, as a dead man's switch
+type ICancelAllAfterExchange interface {
+	// CancelAllAfter cancel the open orders of the symbol after timeout unless it's called again before, a zero timeout disarms it.
+	// The countdown of some exchanges covers the whole account, the symbol is ignored then
+	CancelAllAfter(symbol string, timeout time.Duration) error
+}
 
-	SubscribeMarkPrice(symbol string, sub MessageChan) (string, error)
+// IAccountCountdownExchange an ICancelAllAfterExchange telling whether its countdown covers the whole account,
+// such a countdown is armed once for all the symbols and disarmed with the last of them
+type IAccountCountdownExchange interface {
+	ICancelAllAfterExchange
+	// AccountWideCountdown whether CancelAllAfter ignores the symbol
+	AccountWideCountdown() bool
 }
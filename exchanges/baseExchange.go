@@ -1,15 +1,19 @@
 package exchanges
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/utils"
 )
 
 type Request struct {
@@ -38,11 +42,298 @@ type BaseExchange struct {
 	ConnectionMgr *ConnectionManager
 
 	RwLock sync.RWMutex
+
+	subLock       sync.Mutex
+	subscriptions map[string]map[*ExchangeApi.Subscription]time.Time // key: ws url, value: since when it is watched by the stale watchdog
+	deltas        map[*ExchangeApi.Subscription]*deltaState          // the subscriptions of MsgOrderBookDelta
+	feeds         map[feedKey]*ExchangeApi.Book                      // the books in sync of the delta subscriptions, for the snapshots on demand
+
+	// Resubscribe subscribe the topic again on the connection of url, used by the stale watchdog.
+	// the connection is reconnected instead if it's not set
+	Resubscribe func(url, topic string) error
+
+	recvLock     sync.Mutex
+	recvTimes    map[string]map[staleKey]time.Time // when the data was received last time, key: ws url
+	watchdogOnce sync.Once
+	watchdogStop chan struct{}
+	stopOnce     sync.Once
+}
+
+// staleKey the data received is recorded by message type and symbol
+type staleKey struct {
+	t      ExchangeApi.MessageType
+	symbol string
 }
 
+// anySymbol the symbol of staleKey updated by the data of any symbol
+const anySymbol = "*"
+
 func (b *BaseExchange) Init() {
 	b.ConnectionMgr = NewConnectionManager()
 	b.RwLock = sync.RWMutex{}
+	b.subscriptions = make(map[string]map[*ExchangeApi.Subscription]time.Time)
+	b.deltas = make(map[*ExchangeApi.Subscription]*deltaState)
+	b.feeds = make(map[feedKey]*ExchangeApi.Book)
+	b.recvTimes = make(map[string]map[staleKey]time.Time)
+	b.watchdogStop = make(chan struct{})
+	b.ConnectionMgr.SetPublishHook(b.received)
+}
+
+// InitShardLimit apply the websocket connection limits of the option, the default values of the exchange are used if not set
+func (b *BaseExchange) InitShardLimit(maxTopics, subscribeRate int) {
+	if b.Option.WsMaxTopics == 0 {
+		b.Option.WsMaxTopics = maxTopics
+	}
+	if b.Option.WsSubscribeRate == 0 {
+		b.Option.WsSubscribeRate = subscribeRate
+	}
+	b.ConnectionMgr.SetShardLimit(ShardLimit{MaxTopics: b.Option.WsMaxTopics, SubscribeRate: b.Option.WsSubscribeRate})
+	b.ConnectionMgr.SetRawMessage(b.Option.RawMessage)
+}
+
+// NewSubscription create a subscription handle of the url and keep track of it,
+// unsubscribe is invoked when the caller closes the handle
+func (b *BaseExchange) NewSubscription(url, topic, symbol string, t ExchangeApi.MessageType, sub ExchangeApi.MessageChan, unsubscribe func() error) *ExchangeApi.Subscription {
+	var subscription *ExchangeApi.Subscription
+	subscription = ExchangeApi.NewSubscription(topic, symbol, t, sub, func() error {
+		b.removeSubscription(url, subscription)
+		if t == ExchangeApi.MsgOrderBookDelta {
+			if conn, err := b.ConnectionMgr.GetConnection(url, nil); err == nil {
+				conn.UnSubscribeTarget(sub)
+			}
+		}
+		if unsubscribe != nil {
+			return unsubscribe()
+		}
+		return nil
+	})
+
+	b.subLock.Lock()
+	defer b.subLock.Unlock()
+	subs, ok := b.subscriptions[url]
+	if !ok {
+		subs = make(map[*ExchangeApi.Subscription]time.Time)
+		b.subscriptions[url] = subs
+	}
+	subs[subscription] = time.Now()
+	if t == ExchangeApi.MsgOrderBookDelta {
+		b.deltas[subscription] = &deltaState{pending: true}
+	}
+	if b.staleEnabled() {
+		b.watchdogOnce.Do(func() { go b.watchdog() })
+	}
+	return subscription
+}
+
+// EndSubscription mark the subscriptions of the topic and channel as closed, used by the legacy UnSubscribe
+func (b *BaseExchange) EndSubscription(url, topic string, sub ExchangeApi.MessageChan) {
+	b.subLock.Lock()
+	var ended []*ExchangeApi.Subscription
+	for s := range b.subscriptions[url] {
+		if s.Topic() == topic && s.Chan() == sub {
+			ended = append(ended, s)
+			delete(b.subscriptions[url], s)
+			delete(b.deltas, s)
+		}
+	}
+	b.subLock.Unlock()
+	for _, s := range ended {
+		s.End(nil)
+	}
+}
+
+func (b *BaseExchange) removeSubscription(url string, subscription *ExchangeApi.Subscription) {
+	b.subLock.Lock()
+	defer b.subLock.Unlock()
+	if subs, ok := b.subscriptions[url]; ok {
+		delete(subs, subscription)
+	}
+	delete(b.deltas, subscription)
+}
+
+// endSubscriptions finish all the subscriptions of the url with the reason
+func (b *BaseExchange) endSubscriptions(url string, err error) {
+	b.subLock.Lock()
+	subs := b.subscriptions[url]
+	delete(b.subscriptions, url)
+	for s := range subs {
+		delete(b.deltas, s)
+	}
+	b.dropFeeds(url)
+	b.subLock.Unlock()
+	b.recvLock.Lock()
+	delete(b.recvTimes, url)
+	b.recvLock.Unlock()
+	for s := range subs {
+		s.End(err)
+	}
+}
+
+func (b *BaseExchange) staleEnabled() bool {
+	if b.Option.StaleTimeout > 0 {
+		return true
+	}
+	for _, timeout := range b.Option.StaleTimeouts {
+		if timeout > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// staleTimeout the timeout of the message type, the user data is not watched unless its timeout is set
+func (b *BaseExchange) staleTimeout(t ExchangeApi.MessageType) time.Duration {
+	if timeout, ok := b.Option.StaleTimeouts[t]; ok {
+		return timeout
+	}
+	if t.IsUserData() {
+		return 0
+	}
+	return b.Option.StaleTimeout
+}
+
+// received record when the data is received, it is the publish hook of the connection manager
+func (b *BaseExchange) received(url string, message ExchangeApi.Message) {
+	if status, ok := message.Data.(ExchangeApi.OrderBookStatus); ok && status.State == ExchangeApi.BookResyncing {
+		// no snapshot on demand until the book is published again
+		b.subLock.Lock()
+		delete(b.feeds, feedKey{url: url, symbol: status.Symbol})
+		b.subLock.Unlock()
+	}
+	if !message.Type.IsData() || !b.staleEnabled() {
+		return
+	}
+	if _, ok := message.Data.(error); ok {
+		return
+	}
+	now := time.Now()
+	b.recvLock.Lock()
+	defer b.recvLock.Unlock()
+	times, ok := b.recvTimes[url]
+	if !ok {
+		times = make(map[staleKey]time.Time)
+		b.recvTimes[url] = times
+	}
+	// the symbols are matched regardless of case, as lastReceived looks them up
+	times[staleKey{message.Type, strings.ToUpper(message.Symbol())}] = now
+	times[staleKey{message.Type, anySymbol}] = now
+}
+
+// lastReceived when the data of the subscription was received last time, must be called with recvLock held.
+// a subscription without symbol receives the data of any symbol, the data without symbol belongs to any subscription
+func (b *BaseExchange) lastReceived(url string, s *ExchangeApi.Subscription) time.Time {
+	times := b.recvTimes[url]
+	if s.Symbol() == "" {
+		return times[staleKey{s.Type(), anySymbol}]
+	}
+	last := times[staleKey{s.Type(), strings.ToUpper(s.Symbol())}]
+	if t := times[staleKey{s.Type(), ""}]; t.After(last) {
+		last = t
+	}
+	return last
+}
+
+func (b *BaseExchange) watchdog() {
+	// check at least twice in the shortest timeout
+	interval := time.Second
+	timeouts := []time.Duration{b.Option.StaleTimeout}
+	for _, timeout := range b.Option.StaleTimeouts {
+		timeouts = append(timeouts, timeout)
+	}
+	for _, timeout := range timeouts {
+		if timeout > 0 && timeout/2 < interval {
+			interval = timeout / 2
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.watchdogStop:
+			return
+		case now := <-ticker.C:
+			b.checkStale(now)
+		}
+	}
+}
+
+// checkStale notify the subscriptions receiving no data for the timeout and take the action of the option
+func (b *BaseExchange) checkStale(now time.Time) {
+	type staleSubscription struct {
+		url     string
+		s       *ExchangeApi.Subscription
+		silence time.Duration
+	}
+	var stale []staleSubscription
+	b.subLock.Lock()
+	b.recvLock.Lock()
+	for url, subs := range b.subscriptions {
+		for s, since := range subs {
+			timeout := b.staleTimeout(s.Type())
+			if timeout <= 0 {
+				continue
+			}
+			last := b.lastReceived(url, s)
+			if since.After(last) {
+				last = since
+			}
+			if now.Sub(last) < timeout {
+				continue
+			}
+			// notify again if nothing comes in another timeout
+			subs[s] = now
+			stale = append(stale, staleSubscription{url: url, s: s, silence: now.Sub(last)})
+		}
+	}
+	b.recvLock.Unlock()
+	b.subLock.Unlock()
+
+	reconnects := make(map[string]struct{})
+	for _, item := range stale {
+		action := b.Option.StaleAction
+		if action == ExchangeApi.StaleActionResubscribe && b.Resubscribe == nil {
+			action = ExchangeApi.StaleActionReconnect
+		}
+		b.notify(item.s, ExchangeApi.Message{Type: ExchangeApi.MsgStale, Data: ExchangeApi.Stale{
+			Topic:   item.s.Topic(),
+			Symbol:  item.s.Symbol(),
+			Type:    item.s.Type(),
+			Silence: item.silence,
+			Action:  action,
+		}})
+		switch action {
+		case ExchangeApi.StaleActionResubscribe:
+			go func(url, topic string) {
+				if err := b.Resubscribe(url, topic); err != nil {
+					b.ErrorHandler(url, err, nil)
+				}
+			}(item.url, item.s.Topic())
+		case ExchangeApi.StaleActionReconnect:
+			reconnects[item.url] = struct{}{}
+		}
+	}
+	for url := range reconnects {
+		if conn, err := b.ConnectionMgr.GetConnection(url, nil); err == nil {
+			log.Printf("[BaseExchange] checkStale - reconnect the stale connection %s", url)
+			conn.Reconnect()
+		}
+	}
+}
+
+// notify send the message to the subscription only, it gives up when the subscription is finished
+func (b *BaseExchange) notify(s *ExchangeApi.Subscription, message ExchangeApi.Message) {
+	go func() {
+		select {
+		case s.Chan() <- message:
+		case <-s.Done():
+		case <-b.watchdogStop:
+		}
+	}()
+}
+
+// ConnectionStats the latency statistics of the websocket connections, key: the url of the connection
+func (b *BaseExchange) ConnectionStats() map[string]ExchangeApi.ConnStats {
+	return b.ConnectionMgr.Stats()
 }
 
 func (b *BaseExchange) GetMarketByID(symbolID string) (ExchangeApi.Market, error) {
@@ -65,6 +356,15 @@ func (b *BaseExchange) GetMarket(symbol string) (ExchangeApi.Market, error) {
 	return ExchangeApi.Market{}, errors.New(fmt.Sprintf("%v market not found", symbol))
 }
 
+// CheckClientOrderID a client order ID chosen by the caller must have the prefix of the options,
+// otherwise FetchOrder and CancelOrder would take it as an order ID
+func (b *BaseExchange) CheckClientOrderID(clientID string) error {
+	if clientID == "" || !utils.IsClientOrderID(clientID, b.Option.ClientOrderIDPrefix) {
+		return ExchangeApi.ExError{Code: ExchangeApi.ErrRequestParams, Message: fmt.Sprintf("the client order id %q lacks the prefix %q", clientID, b.Option.ClientOrderIDPrefix)}
+	}
+	return nil
+}
+
 func (b *BaseExchange) Fetch(callBack FetchCallBack, access, method, function string, param url.Values, header http.Header) ([]byte, error) {
 	request := callBack.Sign(access, method, function, param, header)
 	client := &http.Client{}
@@ -95,13 +395,36 @@ func (b *BaseExchange) Fetch(callBack FetchCallBack, access, method, function st
 	return body, nil
 }
 
+// Shutdown close all the subscriptions and websocket connections of the exchange,
+// it returns when the connections have exited or ctx is done.
+func (b *BaseExchange) Shutdown(ctx context.Context) error {
+	b.stopOnce.Do(func() { close(b.watchdogStop) })
+	b.subLock.Lock()
+	all := b.subscriptions
+	b.subscriptions = make(map[string]map[*ExchangeApi.Subscription]time.Time)
+	b.deltas = make(map[*ExchangeApi.Subscription]*deltaState)
+	b.feeds = make(map[feedKey]*ExchangeApi.Book)
+	b.subLock.Unlock()
+	for _, subs := range all {
+		for s := range subs {
+			s.End(nil)
+		}
+	}
+	return b.ConnectionMgr.Shutdown(ctx)
+}
+
 func (b *BaseExchange) ReConnectedHandler(url string, f func()) {
 	if f != nil {
 		f()
 	}
+	// the new connection has no topic subscribed
+	if conn, err := b.ConnectionMgr.GetConnection(url, nil); err == nil {
+		conn.ClearTopics()
+	}
 	//Notify subscribers of reconnection message, then clean up the channel
 	//because after receiving the reconnection notification, the subscribers will resubscribe and use the new channel
 	b.ConnectionMgr.PublishAfterClear(url, ExchangeApi.ReConnectedMessage)
+	b.endSubscriptions(url, ExchangeApi.ErrSubscriptionReset)
 }
 
 func (b *BaseExchange) DisConnectedHandler(url string, err error, f func()) {
@@ -111,6 +434,9 @@ func (b *BaseExchange) DisConnectedHandler(url string, err error, f func()) {
 	if f != nil {
 		f()
 	}
+	b.subLock.Lock()
+	b.dropFeeds(url)
+	b.subLock.Unlock()
 	b.ConnectionMgr.Publish(url, ExchangeApi.DisConnectedMessage)
 }
 
@@ -123,6 +449,7 @@ func (b *BaseExchange) CloseHandler(url string, f func()) {
 	}
 	b.ConnectionMgr.Publish(url, ExchangeApi.CloseMessage)
 	b.ConnectionMgr.RemoveConnection(url)
+	b.endSubscriptions(url, ExchangeApi.ErrConnectionClosed)
 }
 
 func (b *BaseExchange) ErrorHandler(url string, err error, f func()) {
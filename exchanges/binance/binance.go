@@ -1,6 +1,7 @@
 package binance
 
 import "github.com/xiaolo66/ExchangeApi"
+// Binance both BinanceRest and BinanceWs embed BaseExchange, the methods of the websockets are picked from BinanceWs
 type Binance struct {
 	BinanceRest
 	BinanceWs
@@ -17,3 +18,13 @@ func New(options ExchangeApi.Options) *Binance {
 
 	return instance
 }
+
+// ConnectionStats the latency statistics of the websocket connections, key: the url of the connection
+func (e *Binance) ConnectionStats() map[string]ExchangeApi.ConnStats {
+	return e.BinanceWs.ConnectionStats()
+}
+
+// RequestOrderBookSnapshot send the whole order book to the channel subscribing the deltas of the symbol
+func (e *Binance) RequestOrderBookSnapshot(symbol string, sub ExchangeApi.MessageChan) error {
+	return e.BinanceWs.RequestOrderBookSnapshot(symbol, sub)
+}
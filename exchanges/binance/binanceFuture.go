@@ -2,6 +2,7 @@ package binance
 
 import "github.com/xiaolo66/ExchangeApi"
 
+// BinanceFuture both BinanceFutureRest and BinanceFutureWs embed BaseExchange, the methods of the websockets are picked from BinanceFutureWs
 type BinanceFuture struct {
 	BinanceFutureRest
 	BinanceFutureWs
@@ -24,3 +25,13 @@ func NewFuture(options ExchangeApi.Options, futureOptions ExchangeApi.FutureOpti
 	}
 	return instance
 }
+
+// ConnectionStats the latency statistics of the websocket connections, key: the url of the connection
+func (e *BinanceFuture) ConnectionStats() map[string]ExchangeApi.ConnStats {
+	return e.BinanceFutureWs.ConnectionStats()
+}
+
+// RequestOrderBookSnapshot send the whole order book to the channel subscribing the deltas of the symbol
+func (e *BinanceFuture) RequestOrderBookSnapshot(symbol string, sub ExchangeApi.MessageChan) error {
+	return e.BinanceFutureWs.RequestOrderBookSnapshot(symbol, sub)
+}
@@ -28,7 +28,10 @@ type BinanceFutureRest struct {
 
 func (e *BinanceFutureRest) Init(option ExchangeApi.Options) {
 	e.Option = option
-	e.errors = make(map[int]RawError)
+	e.errors = map[int]RawError{
+		-2013: RawError{Code: ExchangeApi.ErrOrderNotFound, Message: ""},
+		-2011: RawError{Code: ExchangeApi.ErrOrderNotFound, Message: "Unknown order sent."},
+	}
 
 	if e.Option.RestHost == "" {
 		e.Option.RestHost = "https://fapi.binance.com"
@@ -233,6 +236,7 @@ func (e *BinanceFutureRest) FetchMarkets() (map[string]ExchangeApi.Market, error
 				PricePrecision:  pricePrecision,
 				AmountPrecision: amountPrecision,
 			}
+			parseFilters(&market, m.Filters)
 			e.Option.Markets[market.Symbol] = market
 		}
 		if e.contractType == ExchangeApi.Futures {
@@ -247,20 +251,41 @@ func (e *BinanceFutureRest) FetchMarkets() (map[string]ExchangeApi.Market, error
 				PricePrecision:  pricePrecision,
 				AmountPrecision: amountPrecision,
 			}
+			parseFilters(&market, m.Filters)
 			e.Option.Markets[market.Symbol] = market
 		}
 	}
 	return e.Option.Markets, nil
 }
 
-func (e *BinanceFutureRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (order ExchangeApi.Order, err error) {
+func (e *BinanceFutureRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	var clientID string
+	if useClientID {
+		clientID = utils.GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32)
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+// CreateOrderWithClientID FetchOrder and CancelOrder take the client order ID too
+func (e *BinanceFutureRest) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	if err := e.CheckClientOrderID(clientID); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+func (e *BinanceFutureRest) createOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (order ExchangeApi.Order, err error) {
 	market, err := e.GetMarket(symbol)
 	if err != nil {
 		return
 	}
+	price, amount, err = market.NormalizeOrder(price, amount, side, tradeType)
+	if err != nil {
+		return
+	}
 	params := url.Values{}
 	params.Set("symbol", market.SymbolID)
-	params.Set("quantity", utils.Round(amount, market.AmountPrecision, false))
+	params.Set("quantity", market.FormatAmount(amount))
 	switch side {
 	case ExchangeApi.OpenLong:
 		params.Set("side", "BUY")
@@ -278,13 +303,13 @@ func (e *BinanceFutureRest) CreateOrder(symbol string, price, amount float64, si
 	switch tradeType {
 	case ExchangeApi.LIMIT:
 		params.Set("type", "LIMIT")
-		params.Set("price", utils.Round(price, market.PricePrecision, false))
+		params.Set("price", market.FormatPrice(price))
 		params.Set("timeInForce", "GTC")
 	case ExchangeApi.MARKET:
 		params.Set("type", "MARKET")
 	}
-	if useClientID {
-		params.Set("newClientOrderId", utils.GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32))
+	if clientID != "" {
+		params.Set("newClientOrderId", clientID)
 	}
 	params.Set("newOrderRespType", "ACK")
 	res, err := e.Fetch(e, exchanges.Private, exchanges.POST, "/fapi/v1/order", params, http.Header{})
@@ -314,7 +339,7 @@ func (e *BinanceFutureRest) CancelOrder(symbol, orderID string) (err error) {
 	}
 	params := url.Values{}
 	if utils.IsClientOrderID(orderID, e.Option.ClientOrderIDPrefix) {
-		params.Set("clientOrderId", orderID)
+		params.Set("origClientOrderId", orderID)
 	} else {
 		params.Set("orderId", orderID)
 	}
@@ -335,6 +360,19 @@ func (e *BinanceFutureRest) CancelAllOrders(symbol string) (err error) {
 	return err
 }
 
+// CancelAllAfter the countdown of the symbol is kept by the exchange, it should be refreshed within timeout
+func (e *BinanceFutureRest) CancelAllAfter(symbol string, timeout time.Duration) (err error) {
+	market, err := e.GetMarket(symbol)
+	if err != nil {
+		return
+	}
+	params := url.Values{}
+	params.Set("symbol", market.SymbolID)
+	params.Set("countdownTime", strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+	_, err = e.Fetch(e, exchanges.Private, exchanges.POST, "/fapi/v1/countdownCancelAll", params, http.Header{})
+	return err
+}
+
 func (e *BinanceFutureRest) FetchOrder(symbol, orderID string) (order ExchangeApi.Order, err error) {
 	market, err := e.GetMarket(symbol)
 	if err != nil {
@@ -2,6 +2,7 @@ package binance
 
 import (
 	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges"
 	"testing"
 )
 
@@ -153,3 +154,14 @@ func TestBinanceFutureRest_Setting(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestBinanceFutureRest_HandleError(t *testing.T) {
+	err := baFuture.BinanceFutureRest.HandleError(exchanges.Request{}, []byte(`{"code":-2013,"msg":"Order does not exist."}`))
+	if exErr, ok := err.(ExchangeApi.ExError); !ok || exErr.Code != ExchangeApi.ErrOrderNotFound {
+		t.Errorf("expect ErrOrderNotFound, got %v", err)
+	}
+	err = baFuture.BinanceFutureRest.HandleError(exchanges.Request{}, []byte(`{"code":-2011,"msg":"Unknown order sent."}`))
+	if exErr, ok := err.(ExchangeApi.ExError); !ok || exErr.Code != ExchangeApi.ErrOrderNotFound {
+		t.Errorf("expect ErrOrderNotFound, got %v", err)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	jsoniter "github.com/json-iterator/go"
 	"github.com/xiaolo66/ExchangeApi"
 	"github.com/xiaolo66/ExchangeApi/exchanges"
-	"github.com/xiaolo66/ExchangeApi/utils"
 	. "github.com/xiaolo66/ExchangeApi/utils"
 	"net/http"
 	"net/url"
@@ -234,6 +233,7 @@ func (e *BinanceRest) FetchMarkets() (map[string]ExchangeApi.Market, error) {
 			PricePrecision:  pricePrecision,
 			AmountPrecision: amountPrecision,
 		}
+		parseFilters(&market, m.Filters)
 		e.Option.Markets[market.Symbol] = market
 	}
 	return e.Option.Markets, nil
@@ -264,14 +264,34 @@ func (e *BinanceRest) FetchBalance() (balances map[string]ExchangeApi.Balance, e
 	return
 }
 
-func (e *BinanceRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (order ExchangeApi.Order, err error) {
+func (e *BinanceRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	var clientID string
+	if useClientID {
+		clientID = GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32)
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+// CreateOrderWithClientID FetchOrder and CancelOrder take the client order ID too
+func (e *BinanceRest) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	if err := e.CheckClientOrderID(clientID); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+func (e *BinanceRest) createOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (order ExchangeApi.Order, err error) {
 	market, err := e.GetMarket(symbol)
 	if err != nil {
 		return
 	}
+	price, amount, err = market.NormalizeOrder(price, amount, side, tradeType)
+	if err != nil {
+		return
+	}
 	params := url.Values{}
 	params.Set("symbol", market.SymbolID)
-	params.Set("quantity", utils.Round(amount, market.AmountPrecision, false))
+	params.Set("quantity", market.FormatAmount(amount))
 	if side == ExchangeApi.Sell {
 		params.Set("side", "SELL")
 	} else if side == ExchangeApi.Buy {
@@ -281,12 +301,12 @@ func (e *BinanceRest) CreateOrder(symbol string, price, amount float64, side Exc
 	case ExchangeApi.MARKET:
 		params.Set("type", "MARKET")
 	default:
-		params.Set("price", utils.Round(price, market.PricePrecision, false))
+		params.Set("price", market.FormatPrice(price))
 		params.Set("type", "LIMIT")
 		params.Set("timeInForce", "GTC")
 	}
-	if useClientID {
-		params.Set("newClientOrderId", GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32))
+	if clientID != "" {
+		params.Set("newClientOrderId", clientID)
 	}
 	params.Set("newOrderRespType", "ACK")
 	res, err := e.Fetch(e, exchanges.Private, exchanges.POST, "/api/v3/order", params, http.Header{})
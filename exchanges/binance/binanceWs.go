@@ -1,6 +1,7 @@
 package binance
 
 import (
+	"context"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"github.com/xiaolo66/ExchangeApi/exchanges/websocket"
 	"log"
 	"strings"
+	"sync"
 	"time"
 	"github.com/xiaolo66/ExchangeApi"
 	."github.com/xiaolo66/ExchangeApi/utils"
@@ -26,7 +28,7 @@ func SubscribeStream(event ...string) Stream {
 	return Stream{
 		Method: "SUBSCRIBE",
 		Params: append([]string{}, event...),
-		Id:     time.Now().Nanosecond(),
+		Id:     nextStreamID(),
 	}
 }
 
@@ -34,7 +36,7 @@ func UnSubscribeStream(event ...string) Stream {
 	return Stream{
 		Method: "UNSUBSCRIBE",
 		Params: append([]string{}, event...),
-		Id:     time.Now().Nanosecond(),
+		Id:     nextStreamID(),
 	}
 }
 
@@ -50,20 +52,27 @@ func (u UserDataStreamError) Error() string {
 //The websocket function of binance is not well designed.
 //first, there's no topic field passed by subscribe action in the async return data. so, it is impossible to directly distinguish whose data is
 //Second, some return data don't even have event field, only determine what kind of data it is by parsing the string
+//Both are solved by the combined stream endpoint(/stream), which wraps the data with the stream name, so it is the default host.
 
 type BinanceWs struct {
 	exchanges.BaseExchange
 	orderBooks       map[string]*SymbolOrderBook // orderbook's local cache of one symbol
-	partialOrderBook OrderBook                   // Partial Book Depth
+	partialOrderBook OrderBook                   // Partial Book Depth of the /ws endpoint
+	partialBooks     map[string]*OrderBook       // Partial Book Depth of the combined streams, key: symbol
+	combined         bool                        // whether the host is the combined stream endpoint
+	requests         streamRequests
 	errors           map[int]ExchangeApi.ExError
 	listenKey        string // listenKey for User Data Streams, including account update,balance update,order update
 	listenKeyStop    chan struct{}
+	listenKeyLoop    sync.WaitGroup // the goroutine keeping the listen key alive
 }
 
 func (e *BinanceWs) Init(option ExchangeApi.Options) {
 	e.BaseExchange.Init()
 	e.Option = option
+	e.Resubscribe = e.resubscribe
 	e.orderBooks = make(map[string]*SymbolOrderBook)
+	e.partialBooks = make(map[string]*OrderBook)
 	e.errors = map[int]ExchangeApi.ExError{
 		30040: ExchangeApi.ExError{Code: ExchangeApi.ErrChannelNotExist},
 		30008: ExchangeApi.ExError{Code: ExchangeApi.ErrAuthFailed},
@@ -72,21 +81,25 @@ func (e *BinanceWs) Init(option ExchangeApi.Options) {
 		30041: ExchangeApi.ExError{Code: ExchangeApi.ErrAuthFailed},
 	}
 	if e.Option.WsHost == "" {
-		e.Option.WsHost = "wss://stream.binance.com:9443/ws"
+		e.Option.WsHost = "wss://stream.binance.com:9443/stream"
 	}
+	e.combined = isCombinedHost(e.Option.WsHost)
 	if e.Option.RestHost == "" {
 		e.Option.RestHost = "https://api.binance.com/api/v3"
 	}
+	// 1024 streams per connection, 5 incoming messages per second including ping/pong
+	e.InitShardLimit(1024, 4)
 	e.listenKeyStop = make(chan struct{})
 }
 
-func (e *BinanceWs) SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub ExchangeApi.MessageChan) (string, error) {
+func (e *BinanceWs) SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	e.RwLock.Lock()
-	if !isIncremental {
+	if !isIncremental && !e.combined {
 		if e.partialOrderBook.Symbol != "" {
+			e.RwLock.Unlock()
 			//It's a poor design of binance, because there's no event field for this kind of return, it is impossible to distinguish whose data it is.
 			//so only support one symbol data subscribe
-			return "", errors.New("binance instance can only obtain one symbol partial order book at the same time")
+			return nil, errors.New("binance instance can only obtain one symbol partial order book at the same time")
 		}
 		e.partialOrderBook.Symbol = symbol
 	}
@@ -103,61 +116,115 @@ func (e *BinanceWs) SubscribeOrderBook(symbol string, level, speed int, isIncrem
 	}
 	topic, err := e.getTopicBySymbol(symbol, suffix)
 	if topic == "" {
-		return topic, err
+		return nil, err
 	}
-	return e.subscribe(e.Option.WsHost, topic, sub)
+	return e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgOrderBook, sub)
 }
 
-func (e *BinanceWs) SubscribeTrades(symbol string, sub ExchangeApi.MessageChan) (string, error) {
+// SubscribeOrderBookDelta subscribe the diff depth stream of 100ms, the local book is published as MsgOrderBookDelta
+func (e *BinanceWs) SubscribeOrderBookDelta(symbol string, snapshotInterval time.Duration, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	topic, err := e.getTopicBySymbol(symbol, "depth@100ms")
+	if topic == "" {
+		return nil, err
+	}
+	s, err := e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgOrderBookDelta, sub)
+	if err != nil {
+		return nil, err
+	}
+	e.SetSnapshotInterval(s, snapshotInterval)
+	return s, nil
+}
+
+func (e *BinanceWs) SubscribeTrades(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	topic, err := e.getTopicBySymbol(symbol, "trade")
 	if topic == "" {
-		return topic, err
+		return nil, err
 	}
-	return e.subscribe(e.Option.WsHost, topic, sub)
+	return e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgTrade, sub)
 }
 
-func (e *BinanceWs) SubscribeTicker(symbol string, sub ExchangeApi.MessageChan) (string, error) {
+func (e *BinanceWs) SubscribeTicker(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	topic, err := e.getTopicBySymbol(symbol, "ticker")
 	if topic == "" {
-		return topic, err
+		return nil, err
 	}
-	return e.subscribe(e.Option.WsHost, topic, sub)
+	return e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgTicker, sub)
 }
 
-func (e *BinanceWs) SubscribeAllTicker(sub ExchangeApi.MessageChan) (string, error) {
+func (e *BinanceWs) SubscribeAllTicker(sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	topic := "!ticker@arr"
 	topic = strings.ToLower(topic)
-	return e.subscribe(e.Option.WsHost, topic, sub)
+	return e.subscribe(e.Option.WsHost, topic, "", ExchangeApi.MsgAllTicker, sub)
 }
 
-func (e *BinanceWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub ExchangeApi.MessageChan) (string, error) {
+func (e *BinanceWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	kt := parseKLienType(t)
 	topic, err := e.getTopicBySymbol(symbol, fmt.Sprintf("kline_%s", kt))
 	if topic == "" {
-		return topic, err
+		return nil, err
 	}
-	return e.subscribe(e.Option.WsHost, topic, sub)
+	return e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgKLine, sub)
 }
 
-func (e *BinanceWs) SubscribeBalance(symbol string, sub ExchangeApi.MessageChan) (string, error) {
-	return e.subscribeUserData(sub)
+func (e *BinanceWs) SubscribeBalance(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribeUserData(ExchangeApi.MsgBalance, sub)
 }
 
-func (e *BinanceWs) SubscribeOrder(symbol string, sub ExchangeApi.MessageChan) (string, error) {
-	return e.subscribeUserData(sub)
+func (e *BinanceWs) SubscribeOrder(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribeUserData(ExchangeApi.MsgOrder, sub)
 }
 
 func (e *BinanceWs) UnSubscribe(event string, sub ExchangeApi.MessageChan) error {
-	conn, err := e.ConnectionMgr.GetConnection(e.Option.WsHost, nil)
+	e.RwLock.RLock()
+	listenKey := e.listenKey
+	e.RwLock.RUnlock()
+	if listenKey != "" && event == listenKey {
+		url := userDataUrl(e.Option.WsHost, listenKey)
+		e.EndSubscription(url, event, sub)
+		return e.unSubscribeUserData(url, sub)
+	}
+	conn, err := e.ConnectionMgr.FindConnection(e.Option.WsHost, event)
 	if err != nil {
 		return err
 	}
-	if err := e.send(conn, UnSubscribeStream(event)); err != nil {
-		return err
+	e.EndSubscription(conn.Url(), event, sub)
+	return e.unSubscribe(conn.Url(), event, sub)
+}
+
+// Shutdown delete the listen key, close all the subscriptions and connections,
+// it returns after the keepalive goroutine and the websocket loops exited or ctx is done
+func (e *BinanceWs) Shutdown(ctx context.Context) error {
+	e.RwLock.RLock()
+	listenKey := e.listenKey
+	e.RwLock.RUnlock()
+	if listenKey != "" {
+		if err := e.deleteListenKey(listenKey); err != nil {
+			log.Printf("[BinanceWs] Shutdown - delete listen key error:%v", err)
+		}
 	}
 
-	conn.UnSubscribe(sub)
-	return nil
+	err := e.BaseExchange.Shutdown(ctx)
+
+	// the keepalive goroutine is stopped by the close handler of the user data connection
+	e.RwLock.Lock()
+	if e.listenKey != "" {
+		e.listenKey = ""
+		close(e.listenKeyStop)
+	}
+	e.RwLock.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		e.listenKeyLoop.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
 }
 
 func (e *BinanceWs) Connect(url string) (*exchanges.Connection, error) {
@@ -168,6 +235,7 @@ func (e *BinanceWs) Connect(url string) (*exchanges.Connection, error) {
 		websocket.SetProxyUrl(e.Option.ProxyUrl),
 		websocket.SetIsAutoReconnect(e.Option.AutoReconnect),
 		websocket.SetHeartbeatIntervalTime(time.Second*10),
+		websocket.SetPingIntervalTime(time.Second*10),
 		websocket.SetReadDeadLineTime(time.Minute*3*2), // binance's heartbeat interval is 3 minutes
 		websocket.SetMessageHandler(e.messageHandler),
 		websocket.SetErrorHandler(e.errorHandler),
@@ -179,38 +247,78 @@ func (e *BinanceWs) Connect(url string) (*exchanges.Connection, error) {
 	return conn, err
 }
 
-func (e *BinanceWs) subscribe(url, topic string, sub ExchangeApi.MessageChan) (string, error) {
-	conn, err := e.ConnectionMgr.GetConnection(url, e.Connect)
+func (e *BinanceWs) subscribe(url, topic, symbol string, t ExchangeApi.MessageType, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	conn, err := e.ConnectionMgr.GetShardConnection(url, topic, e.Connect)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	if err := e.send(conn, SubscribeStream(topic)); err != nil {
-		return "", err
+	// the data may come right after the response, so listen to it before subscribing
+	undo := conn.Listen(t, sub)
+	conn.Throttle()
+	if _, err := e.requests.request(conn, SubscribeStream(topic)); err != nil {
+		conn.RemoveTopic(topic)
+		undo()
+		return nil, err
 	}
-	conn.Subscribe(sub)
-	return topic, nil
+	url = conn.Url()
+	return e.NewSubscription(url, topic, symbol, t, sub, func() error {
+		return e.unSubscribe(url, topic, sub)
+	}), nil
+}
+
+// resubscribe renew the stream on the connection of url, used by the stale watchdog
+func (e *BinanceWs) resubscribe(url, topic string) error {
+	conn, err := e.ConnectionMgr.GetConnection(url, nil)
+	if err != nil {
+		return err
+	}
+	conn.Throttle()
+	if _, err := e.requests.request(conn, UnSubscribeStream(topic)); err != nil {
+		return err
+	}
+	conn.Throttle()
+	_, err = e.requests.request(conn, SubscribeStream(topic))
+	return err
+}
+
+func (e *BinanceWs) unSubscribe(url, topic string, sub ExchangeApi.MessageChan) error {
+	conn, err := e.ConnectionMgr.GetConnection(url, nil)
+	if err != nil {
+		return err
+	}
+	conn.Throttle()
+	if _, err := e.requests.request(conn, UnSubscribeStream(topic)); err != nil {
+		return err
+	}
+
+	conn.UnSubscribe(sub)
+	conn.RemoveTopic(topic)
+	return nil
 }
 
-func (e *BinanceWs) subscribeUserData(sub ExchangeApi.MessageChan) (string, error) {
+func (e *BinanceWs) subscribeUserData(t ExchangeApi.MessageType, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	e.RwLock.Lock()
 	defer e.RwLock.Unlock()
 	if e.listenKey != "" {
 		// Because balance and order share the same stream, so just subscribe once.
-		url := fmt.Sprintf("%s/%s", e.Option.WsHost, e.listenKey)
+		url := userDataUrl(e.Option.WsHost, e.listenKey)
 		conn, err := e.ConnectionMgr.GetConnection(url, nil)
-		if err == nil {
-			conn.Subscribe(sub)
+		if err != nil {
+			return nil, err
 		}
-		return e.listenKey, err
+		conn.Subscribe(sub)
+		return e.newUserDataSubscription(url, e.listenKey, t, sub), nil
 	}
 	var err error
 	e.listenKey, err = e.createListenKey()
 	if err != nil {
-		return e.listenKey, err
+		return nil, err
 	}
 	e.listenKeyStop = make(chan struct{})
+	e.listenKeyLoop.Add(1)
 	go func() {
+		defer e.listenKeyLoop.Done()
 		ticker := time.NewTicker(time.Minute * 30)
 		defer ticker.Stop()
 		for {
@@ -223,13 +331,56 @@ func (e *BinanceWs) subscribeUserData(sub ExchangeApi.MessageChan) (string, erro
 		}
 	}()
 
-	url := fmt.Sprintf("%s/%s", e.Option.WsHost, e.listenKey)
+	url := userDataUrl(e.Option.WsHost, e.listenKey)
 	conn, err := e.ConnectionMgr.GetConnection(url, e.Connect)
 	if err != nil {
-		return e.listenKey, err
+		return nil, err
 	}
 	conn.Subscribe(sub)
-	return e.listenKey, nil
+	return e.newUserDataSubscription(url, e.listenKey, t, sub), nil
+}
+
+func (e *BinanceWs) newUserDataSubscription(url, listenKey string, t ExchangeApi.MessageType, sub ExchangeApi.MessageChan) *ExchangeApi.Subscription {
+	return e.NewSubscription(url, listenKey, "", t, sub, func() error {
+		return e.unSubscribeUserData(url, sub)
+	})
+}
+
+// unSubscribeUserData the user data stream has no topic to unsubscribe, just stop pushing to the channel,
+// the listen key is deleted and the stream is closed when nobody is listening.
+func (e *BinanceWs) unSubscribeUserData(url string, sub ExchangeApi.MessageChan) error {
+	conn, err := e.ConnectionMgr.GetConnection(url, nil)
+	if err != nil {
+		return err
+	}
+	conn.UnSubscribe(sub)
+	if conn.MsgChannels.Cardinality() > 0 {
+		return nil
+	}
+	e.RwLock.RLock()
+	listenKey := e.listenKey
+	e.RwLock.RUnlock()
+	err = e.deleteListenKey(listenKey)
+	conn.Close()
+	return err
+}
+
+// ListSubscriptions query the streams subscribed on all of the public connections
+func (e *BinanceWs) ListSubscriptions() ([]string, error) {
+	var streams []string
+	for _, conn := range e.ConnectionMgr.GetShardConnections(e.Option.WsHost) {
+		conn.Throttle()
+		result, err := e.requests.request(conn, ListSubscriptionsStream())
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		if err := json.Unmarshal(result, &list); err != nil {
+			return nil, ExchangeApi.ExError{Code: ExchangeApi.ErrDataParse, Message: err.Error()}
+		}
+		streams = append(streams, list...)
+	}
+	return streams, nil
 }
 
 func (e *BinanceWs) getTopicBySymbol(symbol, suffix string) (string, error) {
@@ -250,11 +401,29 @@ func (e *BinanceWs) send(conn *exchanges.Connection, data Stream) (err error) {
 }
 
 func (e *BinanceWs) messageHandler(url string, message []byte) {
+	stream := StreamMessage{}
+	if err := json.Unmarshal(message, &stream); err != nil {
+		e.errorHandler(url, fmt.Errorf("[BinanceWs] messageHandler unmarshal error:%v", err))
+		return
+	}
+	if stream.isResponse() {
+		if !e.requests.done(stream) && stream.Error != nil {
+			e.errorHandler(url, fmt.Errorf("[BinanceWs] messageHandler - %v", stream.err()))
+		}
+		return
+	}
+	if stream.Stream != "" {
+		message = stream.Data
+	}
+
 	res := ResponseEvent{}
 	if err := json.Unmarshal(message, &res); err != nil {
 		e.errorHandler(url, fmt.Errorf("[BinanceWs] messageHandler unmarshal error:%v", err))
 		return
 	}
+	if res.EventTime > 0 {
+		e.ConnectionMgr.ObserveEventTime(url, time.Unix(0, res.EventTime*int64(time.Millisecond)))
+	}
 
 	switch res.Event {
 	case "depthUpdate":
@@ -291,7 +460,7 @@ func (e *BinanceWs) messageHandler(url string, message []byte) {
 		//So the data will be published to all subscribers who have the same event type, and the user need distinguish the right market data by himself
 		if bytes.Contains(message, []byte("lastUpdateId")) && bytes.Contains(message, []byte("bids")) {
 			//Partial Book Depth Streams(Top bids and asks of specified level)
-			e.handleDepth(url, message)
+			e.handleDepth(url, stream.Stream, message)
 		}
 	}
 }
@@ -305,6 +474,7 @@ func (e *BinanceWs) disConnectedHandler(url string, err error) {
 	e.BaseExchange.DisConnectedHandler(url, err, func() {
 		delete(e.orderBooks, url)
 		e.partialOrderBook = OrderBook{}
+		e.partialBooks = make(map[string]*OrderBook)
 	})
 }
 
@@ -312,7 +482,7 @@ func (e *BinanceWs) closeHandler(url string) {
 	// clear cache data and the connection
 	e.BaseExchange.CloseHandler(url, func() {
 		delete(e.orderBooks, url)
-		if strings.Contains(url, e.listenKey) {
+		if e.listenKey != "" && strings.Contains(url, e.listenKey) {
 			e.listenKey = ""
 			close(e.listenKeyStop)
 		}
@@ -331,24 +501,45 @@ func (e *BinanceWs) heartbeatHandler(url string) {
 	conn.SendPongMessage([]byte("pong"))
 }
 
-func (e *BinanceWs) handleDepth(url string, message []byte) {
+// handleDepth stream is the name of the combined stream, which tells whose data it is. it is empty for the /ws endpoint
+func (e *BinanceWs) handleDepth(url, stream string, message []byte) {
+	// the order book cache is shared by the connections of all shards
+	e.RwLock.Lock()
+	defer e.RwLock.Unlock()
 	data := RawOrderBook{}
 	restJson := jsoniter.Config{TagKey: "rest"}.Froze()
 	if err := restJson.Unmarshal(message, &data); err != nil {
 		e.errorHandler(url, fmt.Errorf("[BinanceWs] handleDepth - message Unmarshal to RawOrderBook error:%v", err))
 		return
 	}
-	if data.LastUpdateID < e.partialOrderBook.LastUpdateID {
+	orderBook := &e.partialOrderBook
+	if stream != "" {
+		market, err := e.GetMarketByID(symbolIDOfStream(stream))
+		if err != nil {
+			e.errorHandler(url, err)
+			return
+		}
+		var ok bool
+		if orderBook, ok = e.partialBooks[market.Symbol]; !ok {
+			orderBook = &OrderBook{}
+			orderBook.Symbol = market.Symbol
+			e.partialBooks[market.Symbol] = orderBook
+		}
+	}
+	if data.LastUpdateID < orderBook.LastUpdateID {
 		return
 	}
 
-	e.partialOrderBook.Bids = ExchangeApi.Depth{}
-	e.partialOrderBook.Asks = ExchangeApi.Depth{}
-	e.partialOrderBook.update(data)
-	e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: e.partialOrderBook.OrderBook})
+	orderBook.Bids = ExchangeApi.Depth{}
+	orderBook.Asks = ExchangeApi.Depth{}
+	orderBook.update(data)
+	e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: orderBook.OrderBook})
 }
 
 func (e *BinanceWs) handleIncrementalDepth(url string, message []byte) {
+	// the order book cache is shared by the connections of all shards
+	e.RwLock.Lock()
+	defer e.RwLock.Unlock()
 	rawOB := RawOrderBook{}
 	if err := json.Unmarshal(message, &rawOB); err != nil {
 		e.errorHandler(url, fmt.Errorf("[BinanceWs] handleIncrementalDepth - message Unmarshal to RawOrderBook error:%v", err))
@@ -375,28 +566,60 @@ func (e *BinanceWs) handleIncrementalDepth(url string, message []byte) {
 
 	symbolOrderBook, ok := e.orderBooks[url]
 	if !ok || symbolOrderBook == nil {
-		_ = e.getSnapshotOrderBook(url, market, &SymbolOrderBook{})
-		return
+		symbolOrderBook = &SymbolOrderBook{}
+		e.orderBooks[url] = symbolOrderBook
 	}
-	fullOrderBook, ok := (*symbolOrderBook)[market.Symbol]
+	state, ok := (*symbolOrderBook)[market.Symbol]
 	if !ok {
-		_ = e.getSnapshotOrderBook(url, market, symbolOrderBook)
+		state = &depthSync{market: market}
+		(*symbolOrderBook)[market.Symbol] = state
+		state.wait(rawOB)
+		go e.resyncDepth(url, state)
+		return
+	}
+	if state.book == nil {
+		state.wait(rawOB)
+		return
+	}
+	applied, err := state.apply(rawOB)
+	if err != nil {
+		// rebuild the book in the background, the subscribers are told instead of getting an error
+		state.resyncs++
+		state.reset(rawOB)
+		e.ConnectionMgr.Publish(url, state.status(ExchangeApi.BookResyncing, err.Error()))
+		go e.resyncDepth(url, state)
 		return
 	}
-	if fullOrderBook != nil {
-		first := rawOB.FirstUpdateID <= fullOrderBook.LastUpdateID+1 && rawOB.LastUpdateID >= fullOrderBook.LastUpdateID
-		if first || rawOB.FirstUpdateID == fullOrderBook.LastUpdateID+1 {
-			fullOrderBook.update(rawOB)
-			e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: fullOrderBook.OrderBook})
-		} else if rawOB.LastUpdateID < fullOrderBook.LastUpdateID {
-			log.Printf("[BinanceWs] handleIncrementalDepth - recv old update data\n")
-		} else {
-			delete(*symbolOrderBook, market.Symbol)
-			err := ExchangeApi.ExError{Code: ExchangeApi.ErrInvalidDepth,
-				Message: fmt.Sprintf("[BinanceWs] handleIncrementalDepth - recv dirty data, new.FirstUpdateID: %v != old.LastUpdateID: %v ", rawOB.FirstUpdateID, fullOrderBook.LastUpdateID+1),
-				Data:    map[string]interface{}{"symbol": fullOrderBook.Symbol}}
-			e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: err})
+	if applied {
+		e.PublishOrderBook(url, state.book.Book)
+	} else {
+		log.Printf("[BinanceWs] handleIncrementalDepth - recv old update data\n")
+	}
+}
+
+// resyncDepth fetch the snapshot and replay the buffered events on it, it is retried until the book is in sync,
+// or the state is dropped by unsubscribing or reconnecting
+func (e *BinanceWs) resyncDepth(url string, state *depthSync) {
+	for {
+		snapshot, err := e.getSnapshotOrderBook(state.market)
+		if err != nil {
+			e.errorHandler(url, err)
+		}
+		e.RwLock.Lock()
+		if symbolOrderBook, ok := e.orderBooks[url]; !ok || (*symbolOrderBook)[state.market.Symbol] != state {
+			e.RwLock.Unlock()
+			return
 		}
+		if err == nil && state.replay(snapshot) {
+			e.PublishOrderBook(url, state.book.Book)
+			if state.resyncs > 0 {
+				e.ConnectionMgr.Publish(url, state.status(ExchangeApi.BookResynced, ""))
+			}
+			e.RwLock.Unlock()
+			return
+		}
+		e.RwLock.Unlock()
+		time.Sleep(depthResyncInterval)
 	}
 }
 
@@ -456,16 +679,23 @@ func (e *BinanceWs) handleKLine(url string, message []byte) {
 }
 
 func (e *BinanceWs) handleBalance(url string, balanceUpdate bool, message []byte) {
-	data := Balances{}
-	if err := json.Unmarshal(message, &data); err != nil {
-		e.errorHandler(url, fmt.Errorf("[BinanceWs] handleBalance - message Unmarshal to balance error:%v", err))
-		return
-	}
-
 	balances := ExchangeApi.BalanceUpdate{Balances: make(map[string]ExchangeApi.Balance)}
 	if balanceUpdate {
-
+		data := BalanceDelta{}
+		if err := json.Unmarshal(message, &data); err != nil {
+			e.errorHandler(url, fmt.Errorf("[BinanceWs] handleBalance - message Unmarshal to balance delta error:%v", err))
+			return
+		}
+		asset := strings.ToUpper(data.Currency)
+		balances.UpdateTime = time.Duration(data.Timestamp)
+		balances.Delta = true
+		balances.Balances[asset] = ExchangeApi.Balance{Asset: asset, Available: SafeParseFloat(data.Delta)}
 	} else {
+		data := Balances{}
+		if err := json.Unmarshal(message, &data); err != nil {
+			e.errorHandler(url, fmt.Errorf("[BinanceWs] handleBalance - message Unmarshal to balance error:%v", err))
+			return
+		}
 		balances.UpdateTime = time.Duration(data.Timestamp)
 		for _, b := range data.Balances {
 			balances.Balances[b.Currency] = b.parseBalance()
@@ -486,9 +716,7 @@ func (e *BinanceWs) handleOrder(url string, message []byte) {
 	e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrder, Data: order})
 }
 
-func (e *BinanceWs) getSnapshotOrderBook(url string, market ExchangeApi.Market, symbolOrderBook *SymbolOrderBook) (err error) {
-	time.Sleep(time.Second)
-
+func (e *BinanceWs) getSnapshotOrderBook(market ExchangeApi.Market) (*LocalOrderBook, error) {
 	var response struct {
 		LastUpdateID int64         `json:"lastUpdateId"` // Last update ID
 		Bids         ExchangeApi.RawDepth `json:"bids"`
@@ -496,21 +724,16 @@ func (e *BinanceWs) getSnapshotOrderBook(url string, market ExchangeApi.Market,
 	}
 	client := resty.New()
 	reqUrl := fmt.Sprintf("%s/depth?symbol=%s&limit=1000", e.Option.RestHost, market.SymbolID)
-	_, err = client.R().SetResult(&response).Get(reqUrl)
+	_, err := client.R().SetResult(&response).Get(reqUrl)
 	if err != nil {
-		return fmt.Errorf("[BinanceWs] getSnapshotOrderBook - request url %s error:%v", reqUrl, err)
+		return nil, fmt.Errorf("[BinanceWs] getSnapshotOrderBook - request url %s error:%v", reqUrl, err)
 	}
 	if response.LastUpdateID == 0 {
-		return fmt.Errorf("[BinanceWs] getSnapshotOrderBook - request url %s no data", reqUrl)
-	}
-	var orderBook OrderBook
-	orderBook.Bids = orderBook.Bids.Update(response.Bids, true)
-	orderBook.Asks = orderBook.Asks.Update(response.Asks, false)
-	orderBook.LastUpdateID = response.LastUpdateID
-	orderBook.Symbol = market.Symbol
-	(*symbolOrderBook)[market.Symbol] = &orderBook
-	e.orderBooks[url] = symbolOrderBook
-	return
+		return nil, fmt.Errorf("[BinanceWs] getSnapshotOrderBook - request url %s no data", reqUrl)
+	}
+	orderBook := LocalOrderBook{LastUpdateID: response.LastUpdateID, Book: ExchangeApi.NewBook(market.Symbol, e.Option.OrderBookDepth)}
+	orderBook.Book.Update(response.Bids, response.Asks)
+	return &orderBook, nil
 }
 
 func (e *BinanceWs) createListenKey() (string, error) {
@@ -0,0 +1,109 @@
+package binance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+const (
+	// the events kept while the snapshot is being fetched, the oldest are dropped beyond it
+	maxDepthBuffer = 1000
+	// the interval of retrying the snapshot if it can't be fetched or it is older than the buffered events
+	depthResyncInterval = time.Second
+)
+
+// depthSync keep the local order book of a symbol in sync with the Diff. Depth Stream
+//  1. Buffer the events you receive from the stream.
+//  2. Get a depth snapshot from https://api.binance.com/api/v3/depth?symbol=BNBBTC&limit=1000 .
+//  3. Drop any event where u is <= lastUpdateId in the snapshot. (futures: u is < lastUpdateId)
+//  4. The first processed event should have U <= lastUpdateId+1 AND u >= lastUpdateId+1. (futures: U <= lastUpdateId AND u >= lastUpdateId)
+//  5. While listening to the stream, each new event's U should be equal to the previous event's u+1. (futures: pu should be equal to the previous event's u)
+//     otherwise restart from step 1
+type depthSync struct {
+	market  ExchangeApi.Market
+	future  bool
+	book    *LocalOrderBook // nil while the snapshot is being fetched
+	synced  bool            // whether the first event after the snapshot has been applied
+	buffer  []RawOrderBook
+	resyncs int
+}
+
+// depthGapError the event doesn't follow the last one applied
+type depthGapError struct {
+	event RawOrderBook
+	last  int64
+}
+
+func (e depthGapError) Error() string {
+	return fmt.Sprintf("recv dirty data, new.FirstUpdateID: %v != old.LastUpdateID: %v", e.event.FirstUpdateID, e.last+1)
+}
+
+// wait keep the event until the book is rebuilt
+func (s *depthSync) wait(event RawOrderBook) {
+	if len(s.buffer) >= maxDepthBuffer {
+		s.buffer = s.buffer[1:]
+	}
+	s.buffer = append(s.buffer, event)
+}
+
+// reset drop the book, the event found the gap is the first one buffered
+func (s *depthSync) reset(event RawOrderBook) {
+	s.book = nil
+	s.synced = false
+	s.buffer = []RawOrderBook{event}
+}
+
+// apply the event to the book, false if it is older than the book
+func (s *depthSync) apply(event RawOrderBook) (bool, error) {
+	last := s.book.LastUpdateID
+	if !s.synced {
+		if event.LastUpdateID < last || (!s.future && event.LastUpdateID == last) {
+			return false, nil
+		}
+		first := event.FirstUpdateID <= last+1 && event.LastUpdateID >= last+1
+		if s.future {
+			first = event.FirstUpdateID <= last && event.LastUpdateID >= last
+		}
+		if !first {
+			return false, depthGapError{event: event, last: last}
+		}
+		s.synced = true
+	} else {
+		next := event.FirstUpdateID == last+1
+		if s.future {
+			next = event.PreUpdateID == last
+		}
+		if !next {
+			if event.LastUpdateID <= last {
+				return false, nil
+			}
+			return false, depthGapError{event: event, last: last}
+		}
+	}
+	s.book.update(event)
+	return true, nil
+}
+
+// replay apply the buffered events on the snapshot, false if the snapshot can't be linked with them
+func (s *depthSync) replay(snapshot *LocalOrderBook) bool {
+	s.book, s.synced = snapshot, false
+	for _, event := range s.buffer {
+		if _, err := s.apply(event); err != nil {
+			s.book = nil
+			return false
+		}
+	}
+	s.buffer = nil
+	return true
+}
+
+func (s *depthSync) status(state ExchangeApi.BookSyncState, reason string) ExchangeApi.Message {
+	return ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookStatus, Data: ExchangeApi.OrderBookStatus{
+		Symbol:  s.market.Symbol,
+		State:   state,
+		Resyncs: s.resyncs,
+		Reason:  reason,
+	}}
+}
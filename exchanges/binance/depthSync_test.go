@@ -0,0 +1,101 @@
+package binance
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+func TestBinanceWs_ResyncDepth(t *testing.T) {
+	// the first snapshot is taken at 10, the one after the gap at 22
+	var snapshots int32
+	rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&snapshots, 1) == 1 {
+			fmt.Fprint(w, `{"lastUpdateId":10,"bids":[["100","1"]],"asks":[["102","1"]]}`)
+		} else {
+			fmt.Fprint(w, `{"lastUpdateId":22,"bids":[["101","3"]],"asks":[["102","1"]]}`)
+		}
+	}))
+	defer rest.Close()
+	server := fakeStreamServer(t)
+	defer server.Close()
+
+	ws := BinanceWs{}
+	ws.Init(ExchangeApi.Options{
+		WsHost:   "ws" + strings.TrimPrefix(server.URL, "http") + "/stream",
+		RestHost: rest.URL,
+		Markets: map[string]ExchangeApi.Market{
+			"BTC/USDT": {SymbolID: "BTCUSDT", Symbol: "BTC/USDT", BaseID: "BTC", QuoteID: "USDT"},
+		},
+	})
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+	}()
+	url := ws.Option.WsHost
+	conn, err := ws.ConnectionMgr.GetConnection(url, ws.Connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgChan := make(ExchangeApi.MessageChan, 10)
+	conn.Subscribe(msgChan)
+
+	event := func(first, last int64, bid string) {
+		ws.handleIncrementalDepth(url, []byte(fmt.Sprintf(`{"e":"depthUpdate","s":"BTCUSDT","U":%d,"u":%d,"b":[%s],"a":[]}`, first, last, bid)))
+	}
+	// wait until each of the conditions is matched by a message, the order of the messages is not guaranteed
+	expect := func(what string, matches ...func(ExchangeApi.Message) bool) {
+		deadline := time.After(time.Second * 3)
+		for len(matches) > 0 {
+			select {
+			case msg := <-msgChan:
+				for i, match := range matches {
+					if match(msg) {
+						matches = append(matches[:i], matches[i+1:]...)
+						break
+					}
+				}
+			case <-deadline:
+				t.Fatalf("%s not received", what)
+			}
+		}
+	}
+	bestBid := func(price, amount string) func(ExchangeApi.Message) bool {
+		return func(msg ExchangeApi.Message) bool {
+			ob, ok := msg.Data.(ExchangeApi.OrderBook)
+			return ok && len(ob.Bids) > 0 && ob.Bids[0].Price == price && ob.Bids[0].Amount == amount
+		}
+	}
+	status := func(state ExchangeApi.BookSyncState) func(ExchangeApi.Message) bool {
+		return func(msg ExchangeApi.Message) bool {
+			s, ok := msg.Data.(ExchangeApi.OrderBookStatus)
+			if ok && (s.Symbol != "BTC/USDT" || s.Resyncs != 1) {
+				t.Errorf("unexpected status %+v", s)
+			}
+			return ok && s.State == state
+		}
+	}
+
+	// the first event is buffered and replayed on the snapshot
+	event(8, 12, `["100","2"]`)
+	expect("the order book built from the snapshot", bestBid("100", "2"))
+	event(13, 14, `["100","5"]`)
+	expect("the order book updated", bestBid("100", "5"))
+
+	// a gap is found, the subscribers get the status instead of the error
+	event(20, 21, `["100","0"]`)
+	expect("the resyncing status", status(ExchangeApi.BookResyncing))
+	expect("the order book rebuilt", bestBid("101", "3"), status(ExchangeApi.BookResynced))
+	if n := atomic.LoadInt32(&snapshots); n != 2 {
+		t.Errorf("expect 2 snapshots fetched, got %d", n)
+	}
+}
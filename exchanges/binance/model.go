@@ -13,13 +13,40 @@ import (
 // ResponseEvent 解析回包中的事件
 type ResponseEvent struct {
 	Event  string `json:"e"`
-	Ignore int    `json:"E"` // unmarshal不区分大小写，会把E值解析到e上
+	EventTime int64 `json:"E"` // event time, also prevents E being parsed into e since unmarshal is case insensitive
 }
 
 type Filter struct {
-	FilterType string `json:"filterType"`
-	TickSize   string `json:"tickSize"`
-	StepSize   string `json:"stepSize"`
+	FilterType  string `json:"filterType"`
+	TickSize    string `json:"tickSize"`
+	StepSize    string `json:"stepSize"`
+	MinQty      string `json:"minQty"`
+	MaxQty      string `json:"maxQty"`
+	MinNotional string `json:"minNotional"`
+	MaxNotional string `json:"maxNotional"`
+	Notional    string `json:"notional"` // the min notional of the futures
+}
+
+// parseFilters set the order rules of the market from the filters of the exchange info
+func parseFilters(market *ExchangeApi.Market, filters []Filter) {
+	for _, filter := range filters {
+		switch filter.FilterType {
+		case "PRICE_FILTER":
+			market.TickSize = SafeParseFloat(filter.TickSize)
+		case "LOT_SIZE":
+			market.StepSize = SafeParseFloat(filter.StepSize)
+			market.MinQty = SafeParseFloat(filter.MinQty)
+			market.MaxQty = SafeParseFloat(filter.MaxQty)
+		case "MIN_NOTIONAL":
+			market.MinNotional = SafeParseFloat(filter.MinNotional)
+			if market.MinNotional == 0 {
+				market.MinNotional = SafeParseFloat(filter.Notional)
+			}
+		case "NOTIONAL":
+			market.MinNotional = SafeParseFloat(filter.MinNotional)
+			market.MaxNotional = SafeParseFloat(filter.MaxNotional)
+		}
+	}
 }
 type Market struct {
 	Symbol             string   `json:"symbol"`
@@ -60,8 +87,19 @@ func (o *OrderBook) update(bookData RawOrderBook) {
 	o.LastUpdateID = bookData.LastUpdateID
 }
 
+// LocalOrderBook the order book maintained by the Diff. Depth Stream
+type LocalOrderBook struct {
+	LastUpdateID int64
+	*ExchangeApi.Book
+}
+
+func (o *LocalOrderBook) update(bookData RawOrderBook) {
+	o.Book.Update(bookData.Bids, bookData.Asks)
+	o.LastUpdateID = bookData.LastUpdateID
+}
+
 // OrderBook of one symbol
-type SymbolOrderBook map[string]*OrderBook
+type SymbolOrderBook map[string]*depthSync
 
 type Ticker struct {
 	Timestamp   float64 `json:"E" rest:"openTime"`
@@ -273,6 +311,13 @@ type Balances struct {
 	Balances  []Balance `json:"B" rest:"balances"`
 }
 
+// BalanceDelta the balanceUpdate event, the change of an asset by a deposit, withdrawal or transfer
+type BalanceDelta struct {
+	Timestamp float64 `json:"E"`
+	Currency  string  `json:"a"`
+	Delta     string  `json:"d"`
+}
+
 type FuturePosition struct {
 	Symbol                 string `json:"symbol"`
 	AvgPrice               string `json:"entryPrice"`             //开仓均价
@@ -0,0 +1,136 @@
+package binance
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges"
+)
+
+// the max time to wait for the response of a SUBSCRIBE/UNSUBSCRIBE/LIST_SUBSCRIPTIONS request
+const streamRequestTimeout = time.Second * 10
+
+var lastStreamID int64
+
+// nextStreamID the id of a live subscribing request, it is unique in the process
+func nextStreamID() int {
+	return int(atomic.AddInt64(&lastStreamID, 1))
+}
+
+func ListSubscriptionsStream() Stream {
+	return Stream{
+		Method: "LIST_SUBSCRIPTIONS",
+		Id:     nextStreamID(),
+	}
+}
+
+// StreamMessage is used to tell what a message is, it is one of the following:
+// the envelope of combined streams: {"stream":"<streamName>","data":<rawPayload>}
+// the response of a request: {"result":null,"id":1} or {"error":{"code":2,"msg":"Invalid request"},"id":1}
+// otherwise the raw payload of the /ws endpoint
+type StreamMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+
+	Result json.RawMessage `json:"result"`
+	Id     *int            `json:"id"`
+	Error  *StreamError    `json:"error"`
+}
+
+type StreamError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func (s StreamMessage) isResponse() bool {
+	return s.Stream == "" && (s.Id != nil || s.Error != nil)
+}
+
+func (s StreamMessage) err() error {
+	if s.Error == nil {
+		return nil
+	}
+	code := ExchangeApi.ErrRequestParams
+	if strings.Contains(strings.ToLower(s.Error.Msg), "stream") {
+		code = ExchangeApi.ErrChannelNotExist
+	}
+	return ExchangeApi.ExError{Code: code, Message: fmt.Sprintf("code:%v msg:%v", s.Error.Code, s.Error.Msg)}
+}
+
+// streamRequests keep track of the requests waiting for their response
+type streamRequests struct {
+	lock    sync.Mutex
+	pending map[int]chan StreamMessage
+}
+
+// request send the request and wait for its response, the result is returned
+func (r *streamRequests) request(conn *exchanges.Connection, data Stream) (json.RawMessage, error) {
+	response := make(chan StreamMessage, 1)
+	r.lock.Lock()
+	if r.pending == nil {
+		r.pending = make(map[int]chan StreamMessage)
+	}
+	r.pending[data.Id] = response
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.pending, data.Id)
+		r.lock.Unlock()
+	}()
+
+	if err := conn.SendJsonMessage(data); err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-response:
+		if err := res.err(); err != nil {
+			return nil, err
+		}
+		return res.Result, nil
+	case <-time.After(streamRequestTimeout):
+		return nil, ExchangeApi.ExError{Code: ExchangeApi.ErrTimeout, Message: fmt.Sprintf("no response of %s %v", data.Method, data.Params)}
+	}
+}
+
+// done deliver the response to the waiting request, false if nobody is waiting for it
+func (r *streamRequests) done(res StreamMessage) bool {
+	if res.Id == nil {
+		return false
+	}
+	r.lock.Lock()
+	response, ok := r.pending[*res.Id]
+	r.lock.Unlock()
+	if ok {
+		response <- res
+	}
+	return ok
+}
+
+// isCombinedHost whether the host is the combined stream endpoint, whose payloads are wrapped by the stream name
+func isCombinedHost(host string) bool {
+	return strings.HasSuffix(strings.Split(host, "?")[0], "/stream")
+}
+
+// userDataUrl the url of the user data stream of the listen key
+func userDataUrl(host, listenKey string) string {
+	if isCombinedHost(host) {
+		return fmt.Sprintf("%s?streams=%s", host, listenKey)
+	}
+	return fmt.Sprintf("%s/%s", host, listenKey)
+}
+
+// symbolIDOfStream the market id of a stream name, eg. btcusdt@depth20@100ms => BTCUSDT
+func symbolIDOfStream(stream string) string {
+	return strings.ToUpper(strings.Split(stream, "@")[0])
+}
+
+// isPartialDepthStream whether the stream is a partial book depth stream, eg. btcusdt@depth20@100ms
+func isPartialDepthStream(stream string) bool {
+	parts := strings.Split(stream, "@")
+	return len(parts) > 1 && strings.HasPrefix(parts[1], "depth") && len(parts[1]) > len("depth")
+}
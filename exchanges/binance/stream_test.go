@@ -0,0 +1,117 @@
+package binance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// fakeStreamServer answer the live subscribing requests like the combined stream endpoint,
+// a partial depth is pushed after the depth stream subscribed, the streams containing "eth" are invalid
+func fakeStreamServer(t *testing.T) *httptest.Server {
+	upgrader := gorilla.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		var streams []string
+		for {
+			var req Stream
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			var res string
+			switch {
+			case req.Method == "LIST_SUBSCRIPTIONS":
+				data, _ := json.Marshal(streams)
+				res = fmt.Sprintf(`{"result":%s,"id":%d}`, data, req.Id)
+			case strings.Contains(req.Params[0], "eth"):
+				res = fmt.Sprintf(`{"error":{"code":2,"msg":"Invalid request: invalid stream"},"id":%d}`, req.Id)
+			default:
+				res = fmt.Sprintf(`{"result":null,"id":%d}`, req.Id)
+				if req.Method == "SUBSCRIBE" {
+					streams = append(streams, req.Params...)
+				}
+			}
+			if err := conn.WriteMessage(gorilla.TextMessage, []byte(res)); err != nil {
+				return
+			}
+			if req.Method == "SUBSCRIBE" && strings.Contains(req.Params[0], "@depth") {
+				depth := fmt.Sprintf(`{"stream":"%s","data":{"lastUpdateId":1,"bids":[["100","1"]],"asks":[["101","2"]]}}`, req.Params[0])
+				if err := conn.WriteMessage(gorilla.TextMessage, []byte(depth)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+}
+
+func TestBinanceWs_CombinedStream(t *testing.T) {
+	server := fakeStreamServer(t)
+	defer server.Close()
+
+	ws := BinanceWs{}
+	ws.Init(ExchangeApi.Options{
+		WsHost:          "ws" + strings.TrimPrefix(server.URL, "http") + "/stream",
+		WsSubscribeRate: -1,
+		RawMessage:      true,
+		Markets: map[string]ExchangeApi.Market{
+			"BTC/USDT": {SymbolID: "BTCUSDT", Symbol: "BTC/USDT", BaseID: "BTC", QuoteID: "USDT"},
+			"BNB/USDT": {SymbolID: "BNBUSDT", Symbol: "BNB/USDT", BaseID: "BNB", QuoteID: "USDT"},
+			"ETH/USDT": {SymbolID: "ETHUSDT", Symbol: "ETH/USDT", BaseID: "ETH", QuoteID: "USDT"},
+		},
+	})
+	defer func() {
+		// nobody reads the channels any more, the pending messages are dropped
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+	}()
+
+	// the partial depth of more than one symbol can be subscribed at the same time
+	for _, symbol := range []string{"BTC/USDT", "BNB/USDT"} {
+		msgChan := make(ExchangeApi.MessageChan, 1)
+		if _, err := ws.SubscribeOrderBook(symbol, 20, 0, false, msgChan); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-msgChan:
+			orderBook, ok := msg.Data.(ExchangeApi.OrderBook)
+			if !ok || orderBook.Symbol != symbol {
+				t.Errorf("expect order book of %s, got %+v", symbol, msg)
+			}
+			if msg.RecvTime.IsZero() || msg.RecvMono <= 0 || msg.ConnID == 0 || msg.Seq == 0 {
+				t.Errorf("expect receive info stamped, got %+v", msg)
+			}
+			if !strings.Contains(string(msg.Raw), `"stream":"`+strings.ToLower(orderBook.Symbol[:3])) {
+				t.Errorf("expect raw payload of %s, got %s", symbol, msg.Raw)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("order book of %s not received", symbol)
+		}
+	}
+
+	_, err := ws.SubscribeTrades("ETH/USDT", make(ExchangeApi.MessageChan))
+	if exErr, ok := err.(ExchangeApi.ExError); !ok || exErr.Code != ExchangeApi.ErrChannelNotExist {
+		t.Errorf("expect invalid stream error, got %v", err)
+	}
+
+	streams, err := ws.ListSubscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(streams, ",") != "btcusdt@depth20,bnbusdt@depth20" {
+		t.Errorf("unexpected subscriptions %v", streams)
+	}
+}
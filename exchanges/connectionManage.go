@@ -1,23 +1,145 @@
 package exchanges
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/xiaolo66/ExchangeApi/exchanges/websocket"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"github.com/xiaolo66/ExchangeApi"
 	set "github.com/deckarep/golang-set"
 )
 
+var ErrManagerShutdown = errors.New("connection manager has been shut down")
+
 type ConnectFunc func(url string) (*Connection, error)
 type Connection struct {
 	websocket.WsConn
 	MsgChannels set.Set
+
+	publishing sync.WaitGroup // messages not yet received by the subscribers
+	quit       chan struct{}  // closed when the connection is shut down, the pending messages are dropped
+	quitOnce   sync.Once
+
+	topicLock sync.Mutex
+	topics    map[string]int // subscribed topics and their reference count
+	limiter   *rateLimiter   // throttle the subscribe/unsubscribe requests
+
+	eventLag    int64        // receive time - event time of the last event
+	avgEventLag int64        // smoothed event lag
+	lastEvent   atomic.Value // frameEvent, the event time of the last frame
+	raw         bool         // attach the raw payload to the messages
+
+	targetLock sync.Mutex
+	targets    map[ExchangeApi.MessageChan]*outbox // the channels getting the data published to them only, see SubscribeTarget
+}
+
+// outbox deliver the messages to a target channel one by one, so they arrive in the order published
+type outbox struct {
+	msgChan ExchangeApi.MessageChan
+	refs    int // the subscriptions of the channel on the connection
+
+	lock    sync.Mutex
+	queue   []ExchangeApi.Message
+	running bool // whether a goroutine is delivering the queue
+}
+
+// frameEvent the event time of a frame
+type frameEvent struct {
+	connID uint64
+	seq    uint64
+	time   time.Time
 }
 
 func NewConnection() *Connection {
 	return &Connection{
 		MsgChannels: set.NewSet(),
+		quit:        make(chan struct{}),
+		topics:      make(map[string]int),
+		targets:     make(map[ExchangeApi.MessageChan]*outbox),
+	}
+}
+
+// AddTopic count a topic subscribed on this connection
+func (c *Connection) AddTopic(topic string) {
+	c.topicLock.Lock()
+	defer c.topicLock.Unlock()
+	c.topics[topic]++
+}
+
+// RemoveTopic the topic is removed when it is unsubscribed as many times as subscribed
+func (c *Connection) RemoveTopic(topic string) {
+	c.topicLock.Lock()
+	defer c.topicLock.Unlock()
+	if c.topics[topic] > 1 {
+		c.topics[topic]--
+	} else {
+		delete(c.topics, topic)
+	}
+}
+
+func (c *Connection) HasTopic(topic string) bool {
+	c.topicLock.Lock()
+	defer c.topicLock.Unlock()
+	_, ok := c.topics[topic]
+	return ok
+}
+
+// TopicCount the number of different topics subscribed on this connection
+func (c *Connection) TopicCount() int {
+	c.topicLock.Lock()
+	defer c.topicLock.Unlock()
+	return len(c.topics)
+}
+
+// ClearTopics forget all the topics, used after reconnected since the server forgot them too
+func (c *Connection) ClearTopics() {
+	c.topicLock.Lock()
+	defer c.topicLock.Unlock()
+	c.topics = make(map[string]int)
+}
+
+// ObserveEventTime measure the lag between the event time of the exchange and the receive time of the last frame
+func (c *Connection) ObserveEventTime(eventTime time.Time) {
+	frame := c.LastFrame()
+	c.lastEvent.Store(frameEvent{connID: frame.ConnID, seq: frame.Seq, time: eventTime})
+	recvTime := frame.RecvTime
+	if recvTime.IsZero() {
+		recvTime = time.Now()
+	}
+	lag := int64(recvTime.Sub(eventTime))
+	atomic.StoreInt64(&c.eventLag, lag)
+	avg := atomic.LoadInt64(&c.avgEventLag)
+	if avg == 0 {
+		avg = lag
+	} else {
+		avg += (lag - avg) / 8
 	}
+	atomic.StoreInt64(&c.avgEventLag, avg)
+}
+
+func (c *Connection) Stats() ExchangeApi.ConnStats {
+	rtt, avgRtt := c.PingRTT()
+	return ExchangeApi.ConnStats{
+		Url:         c.Url(),
+		ConnID:      c.ConnID(),
+		Topics:      c.TopicCount(),
+		LastRecv:    c.LastReceived(),
+		PingRTT:     rtt,
+		AvgPingRTT:  avgRtt,
+		EventLag:    time.Duration(atomic.LoadInt64(&c.eventLag)),
+		AvgEventLag: time.Duration(atomic.LoadInt64(&c.avgEventLag)),
+	}
+}
+
+// Throttle block until a subscribe/unsubscribe request can be sent without exceeding the rate limit
+func (c *Connection) Throttle() {
+	c.limiter.Wait()
 }
 
 func (c *Connection) Subscribe(msgChan ExchangeApi.MessageChan) {
@@ -28,35 +150,257 @@ func (c *Connection) UnSubscribe(msgChan ExchangeApi.MessageChan) {
 	c.MsgChannels.Remove(msgChan)
 }
 
+// SubscribeTarget add a channel which gets the data published to it by PublishTo only, besides the notifications of the connection.
+// The messages of a target arrive in the order published, it's used by the subscriptions of MsgOrderBookDelta.
+// The channel is counted, it stops being a target when it is unsubscribed as many times as subscribed.
+func (c *Connection) SubscribeTarget(msgChan ExchangeApi.MessageChan) {
+	c.targetLock.Lock()
+	defer c.targetLock.Unlock()
+	o, ok := c.targets[msgChan]
+	if !ok {
+		o = &outbox{msgChan: msgChan}
+		c.targets[msgChan] = o
+	}
+	o.refs++
+}
+
+func (c *Connection) UnSubscribeTarget(msgChan ExchangeApi.MessageChan) {
+	c.targetLock.Lock()
+	defer c.targetLock.Unlock()
+	if o, ok := c.targets[msgChan]; ok {
+		if o.refs--; o.refs <= 0 {
+			delete(c.targets, msgChan)
+		}
+	}
+}
+
+// Listen subscribe the channel for the messages of type t, the channel of MsgOrderBookDelta becomes a target, see SubscribeTarget.
+// It returns the function undoing it if subscribing the topic fails, a channel listening already keeps listening then.
+func (c *Connection) Listen(t ExchangeApi.MessageType, msgChan ExchangeApi.MessageChan) (undo func()) {
+	if t == ExchangeApi.MsgOrderBookDelta {
+		c.SubscribeTarget(msgChan)
+		return func() { c.UnSubscribeTarget(msgChan) }
+	}
+	if c.MsgChannels.Contains(msgChan) {
+		return func() {}
+	}
+	c.Subscribe(msgChan)
+	return func() { c.UnSubscribe(msgChan) }
+}
+
+// HasSubscribers whether any channel gets the data published to all, the data nobody gets needn't be built
+func (c *Connection) HasSubscribers() bool {
+	return c.MsgChannels.Cardinality() > 0
+}
+
 func (c *Connection) Close() {
 	c.WsConn.Close()
 }
 
+// Shutdown close the websocket gracefully and wait for the subscribers to receive the pending messages,
+// the messages still pending when ctx is done are dropped.
+func (c *Connection) Shutdown(ctx context.Context) error {
+	err := c.WsConn.Shutdown(ctx)
+
+	drained := make(chan struct{})
+	go func() {
+		c.publishing.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		c.quitOnce.Do(func() { close(c.quit) })
+		<-drained
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// stamp attach the info of the frame being handled to the data message
+func (c *Connection) stamp(msg *ExchangeApi.Message) {
+	frame := c.LastFrame()
+	if !msg.Type.IsData() || frame.RecvTime.IsZero() {
+		return
+	}
+	msg.RecvTime = frame.RecvTime
+	msg.RecvMono = ExchangeApi.MonoTime(frame.RecvTime)
+	msg.ConnID = frame.ConnID
+	msg.Seq = frame.Seq
+	if event, ok := c.lastEvent.Load().(frameEvent); ok && event.connID == frame.ConnID && event.seq == frame.Seq {
+		msg.EventTime = event.time
+	}
+	if c.raw {
+		msg.Raw = frame.Payload
+	}
+}
+
 func (c *Connection) Publish(msg ExchangeApi.Message, clear bool) {
+	c.stamp(&msg)
 	tmp := c.MsgChannels
 	if clear {
 		c.MsgChannels = set.NewSet()
 	}
+	// the targets get the notifications of the connection too
+	if !msg.Type.IsData() {
+		c.targetLock.Lock()
+		for msgChan, o := range c.targets {
+			if !tmp.Contains(msgChan) {
+				c.enqueue(o, msg)
+			}
+		}
+		if clear {
+			c.targets = make(map[ExchangeApi.MessageChan]*outbox)
+		}
+		c.targetLock.Unlock()
+	}
 	tmp.Each(func(item interface{}) bool {
 		msgChan, ok := item.(ExchangeApi.MessageChan)
 		if ok && msgChan != nil {
 			//must use go routine here, otherwise the "Each" method may be blocked, caused dead lock if someone call Subscribe/UnSubscribe at same time.
-			go func() { msgChan <- msg }()
+			c.publishing.Add(1)
+			go func() {
+				defer c.publishing.Done()
+				select {
+				case msgChan <- msg:
+				case <-c.quit:
+				}
+			}()
 		}
 		return false
 	})
 }
 
+// PublishTo send the message to the target channels, the channels not subscribed as targets on the connection are skipped
+func (c *Connection) PublishTo(msg ExchangeApi.Message, msgChans ...ExchangeApi.MessageChan) {
+	c.stamp(&msg)
+	c.targetLock.Lock()
+	defer c.targetLock.Unlock()
+	for _, msgChan := range msgChans {
+		if o, ok := c.targets[msgChan]; ok {
+			c.enqueue(o, msg)
+		}
+	}
+}
+
+// enqueue add the message to the outbox, a goroutine delivers the queue until it's empty
+func (c *Connection) enqueue(o *outbox, msg ExchangeApi.Message) {
+	c.publishing.Add(1)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.queue = append(o.queue, msg)
+	if !o.running {
+		o.running = true
+		go c.deliver(o)
+	}
+}
+
+func (c *Connection) deliver(o *outbox) {
+	for {
+		o.lock.Lock()
+		if len(o.queue) == 0 {
+			o.running = false
+			o.lock.Unlock()
+			return
+		}
+		msg := o.queue[0]
+		o.queue[0] = ExchangeApi.Message{}
+		o.queue = o.queue[1:]
+		o.lock.Unlock()
+		select {
+		case o.msgChan <- msg:
+		case <-c.quit:
+		}
+		c.publishing.Done()
+	}
+}
+
+// ShardLimit the limits of one physical connection, the topics of a url are spread over
+// several connections when one connection is full. A value <= 0 means no limit.
+type ShardLimit struct {
+	MaxTopics     int // max topics subscribed on one connection
+	SubscribeRate int // max subscribe/unsubscribe requests per second on one connection
+}
+
+// ShardUrl the key of the nth connection of url, the first connection keeps the url itself.
+// The key is used as the url of the connection, it's fine to dial it because the fragment is not sent to the server.
+func ShardUrl(url string, n int) string {
+	if n == 0 {
+		return url
+	}
+	return fmt.Sprintf("%s#%d", url, n)
+}
+
+// parseShardUrl split the connection key into the url and the index of the shard
+func parseShardUrl(key string) (string, int) {
+	i := strings.LastIndex(key, "#")
+	if i < 0 {
+		return key, 0
+	}
+	n, err := strconv.Atoi(key[i+1:])
+	if err != nil {
+		return key, 0
+	}
+	return key[:i], n
+}
+
 type ConnectionManager struct {
 	sync.RWMutex
-	once  sync.Once
-	conns map[string]*Connection // key: ws url
+	once     sync.Once
+	conns    map[string]*Connection // key: ws url, see ShardUrl
+	shutdown bool
+	limit    ShardLimit
+	raw      bool // see Options.RawMessage
+
+	publishHook func(url string, message ExchangeApi.Message) // invoked before a message is published by Publish
 }
 
 func NewConnectionManager() *ConnectionManager {
 	return &ConnectionManager{conns: make(map[string]*Connection)}
 }
 
+func (c *ConnectionManager) SetShardLimit(limit ShardLimit) {
+	c.Lock()
+	defer c.Unlock()
+	c.limit = limit
+}
+
+// SetRawMessage whether the messages of the connections created later carry the raw payload
+func (c *ConnectionManager) SetRawMessage(raw bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.raw = raw
+}
+
+func (c *ConnectionManager) SetPublishHook(hook func(url string, message ExchangeApi.Message)) {
+	c.Lock()
+	defer c.Unlock()
+	c.publishHook = hook
+}
+
+// ObserveEventTime measure the event lag of the connection of url, see Connection.ObserveEventTime
+func (c *ConnectionManager) ObserveEventTime(url string, eventTime time.Time) {
+	c.RLock()
+	conn := c.conns[url]
+	c.RUnlock()
+	if conn != nil {
+		conn.ObserveEventTime(eventTime)
+	}
+}
+
+// Stats the statistics of all the connections, key: the url of the connection
+func (c *ConnectionManager) Stats() map[string]ExchangeApi.ConnStats {
+	c.RLock()
+	defer c.RUnlock()
+	stats := make(map[string]ExchangeApi.ConnStats, len(c.conns))
+	for url, conn := range c.conns {
+		stats[url] = conn.Stats()
+	}
+	return stats
+}
+
 func (c *ConnectionManager) SetConnection(url string, connection *Connection) {
 	c.Lock()
 	defer c.Unlock()
@@ -82,32 +426,169 @@ func (c *ConnectionManager) Close() {
 	c.conns = make(map[string]*Connection)
 }
 
+// Shutdown close all the connections gracefully, no more connection can be created after that
+func (c *ConnectionManager) Shutdown(ctx context.Context) error {
+	c.Lock()
+	c.shutdown = true
+	conns := make([]*Connection, 0, len(c.conns))
+	for _, conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	// keep the connections in the map, so the close message can be published to their subscribers
+	c.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(conns))
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn *Connection) {
+			defer wg.Done()
+			errs[i] = conn.Shutdown(ctx)
+		}(i, conn)
+	}
+	wg.Wait()
+
+	c.Lock()
+	c.conns = make(map[string]*Connection)
+	c.Unlock()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *ConnectionManager) GetConnection(url string, connectFunc ConnectFunc) (*Connection, error) {
 	c.Lock()
 	defer c.Unlock()
 	conn, ok := c.conns[url]
 	if !ok {
 		if connectFunc != nil {
-			var err error
-			conn, err = connectFunc(url)
-			if err != nil {
-				return nil, err
+			if c.shutdown {
+				return nil, ErrManagerShutdown
 			}
-			c.conns[url] = conn
-			return conn, nil
+			return c.connect(url, connectFunc)
 		}
 		return nil, fmt.Errorf("not found websocket session, url:%s", url)
 	}
 	return conn, nil
 }
 
+// connect create the connection of the key, must be called with the lock held
+func (c *ConnectionManager) connect(key string, connectFunc ConnectFunc) (*Connection, error) {
+	conn, err := connectFunc(key)
+	if err != nil {
+		return nil, err
+	}
+	conn.limiter = newRateLimiter(c.limit.SubscribeRate)
+	conn.raw = c.raw
+	c.conns[key] = conn
+	return conn, nil
+}
+
+// shards the connections of url ordered by index, must be called with the lock held
+func (c *ConnectionManager) shards(url string) ([]*Connection, []int) {
+	var indexes []int
+	for key := range c.conns {
+		if base, n := parseShardUrl(key); base == url {
+			indexes = append(indexes, n)
+		}
+	}
+	sort.Ints(indexes)
+	conns := make([]*Connection, len(indexes))
+	for i, n := range indexes {
+		conns[i] = c.conns[ShardUrl(url, n)]
+	}
+	return conns, indexes
+}
+
+// GetShardConnection pick a connection of url to subscribe the topic and count the topic on it.
+// The connection already having the topic is preferred, otherwise the first one which is not full,
+// a new connection is created when all of them are full.
+func (c *ConnectionManager) GetShardConnection(url, topic string, connectFunc ConnectFunc) (*Connection, error) {
+	c.Lock()
+	defer c.Unlock()
+	conns, indexes := c.shards(url)
+	var conn *Connection
+	for _, shard := range conns {
+		if shard.HasTopic(topic) {
+			conn = shard
+			break
+		}
+		if conn == nil && (c.limit.MaxTopics <= 0 || shard.TopicCount() < c.limit.MaxTopics) {
+			conn = shard
+		}
+	}
+	if conn == nil {
+		if c.shutdown {
+			return nil, ErrManagerShutdown
+		}
+		// reuse the smallest free index
+		n := 0
+		for _, index := range indexes {
+			if index != n {
+				break
+			}
+			n++
+		}
+		var err error
+		if conn, err = c.connect(ShardUrl(url, n), connectFunc); err != nil {
+			return nil, err
+		}
+	}
+	conn.AddTopic(topic)
+	return conn, nil
+}
+
+// GetShardConnections all the connections of url ordered by index
+func (c *ConnectionManager) GetShardConnections(url string) []*Connection {
+	c.RLock()
+	defer c.RUnlock()
+	conns, _ := c.shards(url)
+	return conns
+}
+
+// FindConnection find the connection of url where the topic is subscribed
+func (c *ConnectionManager) FindConnection(url, topic string) (*Connection, error) {
+	c.RLock()
+	conns, _ := c.shards(url)
+	c.RUnlock()
+	for _, conn := range conns {
+		if conn.HasTopic(topic) {
+			return conn, nil
+		}
+	}
+	return c.GetConnection(url, nil)
+}
+
 func (c *ConnectionManager) Publish(url string, message ExchangeApi.Message) {
+	c.RLock()
+	hook := c.publishHook
+	c.RUnlock()
+	if hook != nil {
+		hook(url, message)
+	}
 	conn, _ := c.GetConnection(url, nil)
 	if conn != nil {
 		conn.Publish(message, false)
 	}
 }
 
+// PublishTo send the message to the target channels of the connection of url, see Connection.PublishTo
+func (c *ConnectionManager) PublishTo(url string, message ExchangeApi.Message, msgChans ...ExchangeApi.MessageChan) {
+	c.RLock()
+	hook := c.publishHook
+	c.RUnlock()
+	if hook != nil {
+		hook(url, message)
+	}
+	conn, _ := c.GetConnection(url, nil)
+	if conn != nil {
+		conn.PublishTo(message, msgChans...)
+	}
+}
+
 // PublishAfterClear clear the subscribers and notify them
 func (c *ConnectionManager) PublishAfterClear(url string, message ExchangeApi.Message) {
 	conn, _ := c.GetConnection(url, nil)
@@ -116,3 +597,33 @@ func (c *ConnectionManager) PublishAfterClear(url string, message ExchangeApi.Me
 	}
 }
 
+
+// rateLimiter spread the requests evenly, at most rate requests per second
+type rateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(rate)}
+}
+
+// Wait block until the next request is allowed, a nil limiter never blocks
+func (r *rateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	wait := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.lock.Unlock()
+	time.Sleep(wait)
+}
@@ -0,0 +1,324 @@
+package exchanges
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/websocket"
+)
+
+// echoServer a websocket server which replies every text message
+func echoServer(t *testing.T) *httptest.Server {
+	upgrader := gorilla.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestConnectionManager_Shutdown(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	base := BaseExchange{}
+	base.Init()
+	recv := make(chan []byte, 1)
+	connect := func(url string) (*Connection, error) {
+		conn := NewConnection()
+		err := conn.Connect(
+			websocket.SetWsUrl(url),
+			websocket.SetIsAutoReconnect(true),
+			websocket.SetMessageHandler(func(url string, message []byte) { recv <- message }),
+			websocket.SetCloseHandler(func(url string) { base.CloseHandler(url, nil) }),
+		)
+		return conn, err
+	}
+	conn, err := base.ConnectionMgr.GetConnection(url, connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgChan := make(ExchangeApi.MessageChan)
+	conn.Subscribe(msgChan)
+	subscription := base.NewSubscription(url, "topic", "BTC/USDT", ExchangeApi.MsgTrade, msgChan, nil)
+
+	conn.SendMessage([]byte("hello"))
+	select {
+	case <-recv:
+	case <-time.After(time.Second * 3):
+		t.Fatal("echo message not received")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	var msg ExchangeApi.Message
+	received := make(chan struct{})
+	go func() {
+		msg = <-msgChan
+		close(received)
+	}()
+	if err := base.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// shutdown returns after the message is received, but the receiver may not have been scheduled yet
+	select {
+	case <-received:
+		if msg.Type != ExchangeApi.MsgClosed {
+			t.Errorf("expect close message, got %v", msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("close message not delivered before shutdown returned")
+	}
+	if subscription.State() != ExchangeApi.SubscriptionClosed {
+		t.Errorf("expect subscription closed, got %v", subscription.State())
+	}
+	if _, err := base.ConnectionMgr.GetConnection(url, connect); err != ErrManagerShutdown {
+		t.Errorf("expect no more connection after shutdown, got %v", err)
+	}
+}
+
+func TestConnectionManager_GetShardConnection(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	mgr := NewConnectionManager()
+	mgr.SetShardLimit(ShardLimit{MaxTopics: 2, SubscribeRate: 20})
+	connect := func(url string) (*Connection, error) {
+		conn := NewConnection()
+		err := conn.Connect(websocket.SetWsUrl(url))
+		return conn, err
+	}
+	defer mgr.Shutdown(context.Background())
+
+	topics := []string{"a", "b", "c", "a", "d", "e"}
+	expects := []string{url, url, ShardUrl(url, 1), url, ShardUrl(url, 1), ShardUrl(url, 2)}
+	for i, topic := range topics {
+		conn, err := mgr.GetShardConnection(url, topic, connect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.Url() != expects[i] {
+			t.Errorf("topic %s expect connection %s, got %s", topic, expects[i], conn.Url())
+		}
+	}
+
+	conn, err := mgr.FindConnection(url, "d")
+	if err != nil || conn.Url() != ShardUrl(url, 1) {
+		t.Errorf("topic d expect connection %s, got %v", ShardUrl(url, 1), err)
+	}
+	conn.RemoveTopic("d")
+	conn, _ = mgr.GetShardConnection(url, "f", connect)
+	if conn.Url() != ShardUrl(url, 1) {
+		t.Errorf("expect the free room of %s reused, got %s", ShardUrl(url, 1), conn.Url())
+	}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		conn.Throttle()
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*200 {
+		t.Errorf("expect throttled 5 requests at 20/s, took %v", elapsed)
+	}
+}
+
+func TestBaseExchange_LastReceived(t *testing.T) {
+	base := BaseExchange{}
+	base.Init()
+	base.Option.StaleTimeout = time.Second
+	// the adapter emits the symbol in another case than the subscription
+	base.received("url", ExchangeApi.Message{Type: ExchangeApi.MsgTrade, Data: ExchangeApi.Trade{Symbol: "btc/usdt"}})
+	sub := ExchangeApi.NewSubscription("btc trade", "BTC/USDT", ExchangeApi.MsgTrade, nil, nil)
+	base.recvLock.Lock()
+	defer base.recvLock.Unlock()
+	if base.lastReceived("url", sub).IsZero() {
+		t.Error("the data of the subscription is not found")
+	}
+}
+
+func TestBaseExchange_StaleWatchdog(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	base := BaseExchange{}
+	base.Init()
+	base.Option.StaleTimeout = time.Millisecond * 200
+	base.Option.StaleAction = ExchangeApi.StaleActionResubscribe
+	resubscribed := make(chan string, 10)
+	base.Resubscribe = func(url, topic string) error {
+		resubscribed <- topic
+		return nil
+	}
+	conn, err := base.ConnectionMgr.GetConnection(url, func(url string) (*Connection, error) {
+		conn := NewConnection()
+		err := conn.Connect(websocket.SetWsUrl(url), websocket.SetPingIntervalTime(time.Millisecond*50))
+		return conn, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// nobody reads the channels any more, the pending messages are dropped
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = base.Shutdown(ctx)
+	}()
+
+	trades := make(ExchangeApi.MessageChan, 10)
+	conn.Subscribe(trades)
+	base.NewSubscription(url, "btc trade", "BTC/USDT", ExchangeApi.MsgTrade, trades, nil)
+	orders := make(ExchangeApi.MessageChan, 10)
+	base.NewSubscription(url, "orders", "", ExchangeApi.MsgOrder, orders, nil)
+
+	// the trades of another market don't keep the subscription alive
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond * 20):
+				base.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgTrade, Data: ExchangeApi.Trade{Symbol: "ETH/USDT"}})
+			}
+		}
+	}()
+
+	deadline := time.After(time.Second * 2)
+	for stale := false; !stale; {
+		select {
+		case msg := <-trades:
+			if msg.Type != ExchangeApi.MsgStale {
+				continue
+			}
+			data := msg.Data.(ExchangeApi.Stale)
+			if data.Symbol != "BTC/USDT" || data.Action != ExchangeApi.StaleActionResubscribe || data.Silence < base.Option.StaleTimeout {
+				t.Errorf("unexpected stale data %+v", data)
+			}
+			stale = true
+		case <-deadline:
+			t.Fatal("stale message not received")
+		}
+	}
+	select {
+	case topic := <-resubscribed:
+		if topic != "btc trade" {
+			t.Errorf("unexpected topic resubscribed %s", topic)
+		}
+	case <-time.After(time.Second):
+		t.Error("the stale topic not resubscribed")
+	}
+	select {
+	case msg := <-orders:
+		t.Errorf("the orders are not watched by default, got %+v", msg)
+	default:
+	}
+
+	stats := base.ConnectionStats()[url]
+	if stats.PingRTT <= 0 || stats.LastRecv.IsZero() {
+		t.Errorf("expect ping round trip time measured, got %+v", stats)
+	}
+}
+
+func TestBaseExchange_PublishOrderBook(t *testing.T) {
+	server := echoServer(t)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	base := BaseExchange{}
+	base.Init()
+	conn, err := base.ConnectionMgr.GetConnection(url, func(url string) (*Connection, error) {
+		conn := NewConnection()
+		err := conn.Connect(websocket.SetWsUrl(url))
+		return conn, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = base.Shutdown(ctx)
+	}()
+
+	deltas := make(ExchangeApi.MessageChan)
+	conn.Listen(ExchangeApi.MsgOrderBookDelta, deltas)
+	subscription := base.NewSubscription(url, "depth", "BTC/USDT", ExchangeApi.MsgOrderBookDelta, deltas, nil)
+	book := ExchangeApi.NewBook("BTC/USDT", 0)
+	publish := func(price, amount string) {
+		base.RwLock.Lock()
+		defer base.RwLock.Unlock()
+		_ = book.UpdateBid(ExchangeApi.DepthItem{Price: price, Amount: amount})
+		base.PublishOrderBook(url, book)
+	}
+	// the deltas are published faster than received, they must arrive in order
+	go func() {
+		publish("100", "1")
+		for i := 1; i <= 100; i++ {
+			publish(fmt.Sprint(100+i), "1")
+		}
+	}()
+	local := ExchangeApi.NewBook("BTC/USDT", 0)
+	for local.Seq() < 101 {
+		select {
+		case msg := <-deltas:
+			delta := msg.Data.(ExchangeApi.OrderBookDelta)
+			if msg.Type != ExchangeApi.MsgOrderBookDelta {
+				t.Fatalf("unexpected message %+v", msg)
+			}
+			if err := local.ApplyDelta(delta); err != nil {
+				t.Fatalf("delta %d after %d: %v", delta.Seq, local.Seq(), err)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("deltas not received, seq %d", local.Seq())
+		}
+	}
+	if bids, _ := local.Len(); bids != 101 {
+		t.Errorf("expect 101 bids, got %d", bids)
+	}
+
+	if err := base.RequestOrderBookSnapshot("BTC/USDT", deltas); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-deltas:
+		if delta := msg.Data.(ExchangeApi.OrderBookDelta); !delta.Snapshot || delta.Seq != 101 || len(delta.Bids) != 101 {
+			t.Errorf("unexpected snapshot %d %v", delta.Seq, delta.Snapshot)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("snapshot not received")
+	}
+
+	// no one gets the changes after the subscription is closed
+	if err := subscription.Close(); err != nil {
+		t.Fatal(err)
+	}
+	publish("99", "1")
+	if _, ok := base.feeds[feedKey{url: url, symbol: "BTC/USDT"}]; ok {
+		t.Error("expect the book forgotten")
+	}
+	if err := base.RequestOrderBookSnapshot("BTC/USDT", deltas); err == nil {
+		t.Error("expect error of the closed subscription")
+	}
+}
@@ -1,7 +1,12 @@
 package huobi
 
-import "github.com/xiaolo66/ExchangeApi"
+import (
+	"context"
 
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// Huobi both HuobiRest and HuobiWs embed BaseExchange, the methods of the websockets are picked from HuobiWs
 type Huobi struct {
 	HuobiRest
 	HuobiWs
@@ -17,3 +22,18 @@ func New(options ExchangeApi.Options) *Huobi {
 	}
 	return instance
 }
+
+// ConnectionStats the latency statistics of the websocket connections, key: the url of the connection
+func (e *Huobi) ConnectionStats() map[string]ExchangeApi.ConnStats {
+	return e.HuobiWs.ConnectionStats()
+}
+
+// Shutdown close all the subscriptions and connections
+func (e *Huobi) Shutdown(ctx context.Context) error {
+	return e.HuobiWs.Shutdown(ctx)
+}
+
+// RequestOrderBookSnapshot send the whole order book to the channel subscribing the deltas of the symbol
+func (e *Huobi) RequestOrderBookSnapshot(symbol string, sub ExchangeApi.MessageChan) error {
+	return e.HuobiWs.RequestOrderBookSnapshot(symbol, sub)
+}
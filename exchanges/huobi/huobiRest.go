@@ -195,6 +195,9 @@ func (e *HuobiRest) FetchMarkets() (map[string]ExchangeApi.Market, error) {
 			PricePrecision:  value.PricePrecision,
 			AmountPrecision: value.AmountPrecision,
 			Lot:             value.MinAmount,
+			MinQty:          value.MinAmount,
+			MaxQty:          value.MaxAmount,
+			MinNotional:     value.MinValue,
 		}
 		e.Option.Markets[market.Symbol] = market
 		e.SymbolMap[value.Symbol] = market.Symbol
@@ -241,7 +244,23 @@ func (e *HuobiRest) FetchBalance() (balances map[string]ExchangeApi.Balance, err
 	return
 }
 
-func (e *HuobiRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (order ExchangeApi.Order, err error) {
+func (e *HuobiRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	var clientID string
+	if useClientID {
+		clientID = GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32)
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+// CreateOrderWithClientID FetchOrder and CancelOrder take the client order ID too
+func (e *HuobiRest) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	if err := e.CheckClientOrderID(clientID); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+func (e *HuobiRest) createOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (order ExchangeApi.Order, err error) {
 	accountId, err := e.GetAccount()
 	if err != nil {
 		return
@@ -250,17 +269,21 @@ func (e *HuobiRest) CreateOrder(symbol string, price, amount float64, side Excha
 	if err != nil {
 		return
 	}
+	price, amount, err = market.NormalizeOrder(price, amount, side, tradeType)
+	if err != nil {
+		return
+	}
 	params := url.Values{}
 	params.Add("account-id", strconv.Itoa(int(accountId)))
 	params.Set("symbol", market.SymbolID)
-	params.Set("amount", utils.Round(amount, market.AmountPrecision, false))
+	params.Set("amount", market.FormatAmount(amount))
 	if side == ExchangeApi.Sell {
 		switch tradeType {
 		case ExchangeApi.MARKET:
 			params.Set("type", "sell-market")
 			params.Set("amount", utils.Round(amount*price, market.AmountPrecision, false))
 		default:
-			params.Set("price", utils.Round(price, market.PricePrecision, false))
+			params.Set("price", market.FormatPrice(price))
 			params.Set("type", "sell-limit")
 		}
 	} else if side == ExchangeApi.Buy {
@@ -269,12 +292,12 @@ func (e *HuobiRest) CreateOrder(symbol string, price, amount float64, side Excha
 			params.Set("type", "buy-market")
 			params.Set("amount", utils.Round(amount*price, market.AmountPrecision, false))
 		default:
-			params.Set("price", utils.Round(price, market.PricePrecision, false))
+			params.Set("price", market.FormatPrice(price))
 			params.Set("type", "buy-limit")
 		}
 	}
-	if useClientID {
-		params.Set("client-order-id", GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32))
+	if clientID != "" {
+		params.Set("client-order-id", clientID)
 	}
 	res, err := e.Fetch(e, exchanges.Private, exchanges.POST, "/v1/order/orders/place", params, http.Header{})
 	if err != nil {
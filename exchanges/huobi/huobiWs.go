@@ -31,6 +31,7 @@ type HuobiWs struct {
 	exchanges.BaseExchange
 	orderBooks   map[string]*SymbolOrderBook
 	subTopicInfo map[string]SubTopic
+	topicLock    sync.RWMutex // subTopicInfo is read by the connections of all shards
 	errors       map[int]ExchangeApi.ExError
 	loginLock    sync.Mutex
 	loginChan    chan struct{}
@@ -40,6 +41,7 @@ type HuobiWs struct {
 func (e *HuobiWs) Init(option ExchangeApi.Options) {
 	e.BaseExchange.Init()
 	e.Option = option
+	e.Resubscribe = e.resubscribe
 	e.orderBooks = make(map[string]*SymbolOrderBook)
 	e.subTopicInfo = make(map[string]SubTopic)
 	e.errors = map[int]ExchangeApi.ExError{}
@@ -52,9 +54,11 @@ func (e *HuobiWs) Init(option ExchangeApi.Options) {
 	if e.Option.RestHost == "" {
 		e.Option.RestHost = "https://api.huobi.pro"
 	}
+	// conservative defaults, huobi drops the connection which subscribes too fast
+	e.InitShardLimit(100, 10)
 }
 
-func (e *HuobiWs) SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub ExchangeApi.MessageChan) (string, error) {
+func (e *HuobiWs) SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	suffix := ""
 	url := e.Option.WsHost
 	if !isIncremental {
@@ -71,34 +75,47 @@ func (e *HuobiWs) SubscribeOrderBook(symbol string, level, speed int, isIncremen
 	}
 
 	topic, err := e.getTopicBySymbol("market.", symbol, suffix)
-	fmt.Println(topic)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return e.subscribe(url, topic, symbol, ExchangeApi.MsgOrderBook, false, sub)
 }
 
-func (e *HuobiWs) SubscribeTicker(symbol string, sub ExchangeApi.MessageChan) (string, error) {
+// SubscribeOrderBookDelta subscribe the incremental depth of 150 levels, the local book is published as MsgOrderBookDelta
+func (e *HuobiWs) SubscribeOrderBookDelta(symbol string, snapshotInterval time.Duration, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	topic, err := e.getTopicBySymbol("market.", symbol, ".mbp.150")
+	if err != nil {
+		return nil, err
+	}
+	s, err := e.subscribe("wss://api.huobi.pro/feed", topic, symbol, ExchangeApi.MsgOrderBookDelta, false, sub)
+	if err != nil {
+		return nil, err
+	}
+	e.SetSnapshotInterval(s, snapshotInterval)
+	return s, nil
+}
+
+func (e *HuobiWs) SubscribeTicker(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	topic, err := e.getTopicBySymbol("market.", symbol, ".detail")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgTicker, false, sub)
 }
 
-func (e *HuobiWs) SubscribeTrades(symbol string, sub ExchangeApi.MessageChan) (string, error) {
+func (e *HuobiWs) SubscribeTrades(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	topic, err := e.getTopicBySymbol("market.", symbol, ".trade.detail")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgTrade, false, sub)
 }
 
-func (e *HuobiWs) SubscribeAllTicker(sub ExchangeApi.MessageChan) (string, error) {
-	return "", ExchangeApi.ExError{Code: ExchangeApi.NotImplement}
+func (e *HuobiWs) SubscribeAllTicker(sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return nil, ExchangeApi.ExError{Code: ExchangeApi.NotImplement}
 }
 
-func (e *HuobiWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub ExchangeApi.MessageChan) (string, error) {
+func (e *HuobiWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	table := ""
 	switch t {
 	case ExchangeApi.KLine1Minute:
@@ -120,40 +137,80 @@ func (e *HuobiWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub Exc
 	default:
 		{
 			err := errors.New("kline does not support this interval")
-			return "", err
+			return nil, err
 		}
 	}
 	topic, err := e.getTopicBySymbol("market.", symbol, ".kline."+table)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return e.subscribe(e.Option.WsHost, topic, symbol, ExchangeApi.MsgKLine, false, sub)
 }
 
 func (e *HuobiWs) UnSubscribe(event string, sub ExchangeApi.MessageChan) error {
-	delete(e.subTopicInfo, event)
-	conn, err := e.ConnectionMgr.GetConnection(e.Option.WsHost, nil)
+	conn, err := e.ConnectionMgr.FindConnection(e.Option.WsHost, event)
+	if err != nil {
+		return err
+	}
+	e.EndSubscription(conn.Url(), event, sub)
+	return e.unSubscribe(conn.Url(), event, false, sub)
+}
+
+// resubscribe renew the topic on the connection of url, used by the stale watchdog
+func (e *HuobiWs) resubscribe(url, topic string) error {
+	conn, err := e.ConnectionMgr.GetConnection(url, nil)
+	if err != nil {
+		return err
+	}
+	unsub, sub := map[string]string{"unsub": topic}, map[string]string{"sub": topic}
+	if strings.HasSuffix(url, "/v2") {
+		unsub = map[string]string{"action": "unsub", "ch": topic}
+		sub = map[string]string{"action": "sub", "ch": topic}
+	}
+	conn.Throttle()
+	if err := conn.SendJsonMessage(unsub); err != nil {
+		return err
+	}
+	conn.Throttle()
+	return conn.SendJsonMessage(sub)
+}
+
+func (e *HuobiWs) unSubscribe(url, topic string, needLogin bool, sub ExchangeApi.MessageChan) error {
+	conn, err := e.ConnectionMgr.GetConnection(url, nil)
 	if err != nil {
 		return err
 	}
 	data := map[string]string{
-		"unsub": event,
+		"unsub": topic,
+	}
+	if needLogin {
+		data = map[string]string{
+			"action": "unsub",
+			"ch":     topic,
+		}
 	}
+	conn.Throttle()
 	if err := conn.SendJsonMessage(data); err != nil {
 		return err
 	}
 	conn.UnSubscribe(sub)
+	conn.RemoveTopic(topic)
+	if !conn.HasTopic(topic) {
+		e.topicLock.Lock()
+		delete(e.subTopicInfo, topic)
+		e.topicLock.Unlock()
+	}
 	return nil
 }
 
-func (e *HuobiWs) SubscribeBalance(symbol string, sub ExchangeApi.MessageChan) (string, error) {
+func (e *HuobiWs) SubscribeBalance(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	return e.subscribe(fmt.Sprintf("%s/v2", e.Option.WsHost), "accounts.update#2", symbol, ExchangeApi.MsgBalance, true, sub)
 }
 
-func (e *HuobiWs) SubscribeOrder(symbol string, sub ExchangeApi.MessageChan) (string, error) {
+func (e *HuobiWs) SubscribeOrder(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	topic, err := e.getTopicBySymbol("orders#", symbol, "")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return e.subscribe(fmt.Sprintf("%s/v2", e.Option.WsHost), topic, symbol, ExchangeApi.MsgOrder, true, sub)
 }
@@ -175,6 +232,7 @@ func (e *HuobiWs) Connect(url string) (*exchanges.Connection, error) {
 		websocket.SetIsAutoReconnect(e.Option.AutoReconnect),
 		websocket.SetEnableCompression(false),
 		websocket.SetReadDeadLineTime(time.Minute),
+		websocket.SetPingIntervalTime(time.Second*10),
 		websocket.SetMessageHandler(e.messageHandler),
 		websocket.SetErrorHandler(e.errorHandler),
 		websocket.SetCloseHandler(e.closeHandler),
@@ -185,29 +243,44 @@ func (e *HuobiWs) Connect(url string) (*exchanges.Connection, error) {
 	return conn, err
 }
 
-func (e *HuobiWs) subscribe(url, topic, symbol string, t ExchangeApi.MessageType, needLogin bool, sub ExchangeApi.MessageChan) (string, error) {
+func (e *HuobiWs) subscribe(url, topic, symbol string, t ExchangeApi.MessageType, needLogin bool, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	e.topicLock.Lock()
 	_, ok := e.subTopicInfo[topic] //ok是看当前key是否存在返回布尔，value返回对应key的值
 	if !ok {
 		e.subTopicInfo[topic] = SubTopic{Topic: topic, Symbol: symbol, MessageType: t}
 	}
+	e.topicLock.Unlock()
 
-	conn, err := e.ConnectionMgr.GetConnection(url, e.Connect)
+	var conn *exchanges.Connection
+	var err error
+	if needLogin {
+		// the login state is kept by the instance, so the private topics always go over the first connection
+		conn, err = e.ConnectionMgr.GetConnection(url, e.Connect)
+		if err == nil {
+			conn.AddTopic(topic)
+		}
+	} else {
+		conn, err = e.ConnectionMgr.GetShardConnection(url, topic, e.Connect)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	var data map[string]string
 	if needLogin {
 		e.loginLock.Lock()
 		defer e.loginLock.Unlock()
 		if !e.isLogin {
+			conn.Throttle()
 			if err := e.login(conn); err != nil {
-				return "", err
+				conn.RemoveTopic(topic)
+				return nil, err
 			}
 			select {
 			case <-e.loginChan:
 				break
 			case <-time.After(time.Second * 5):
-				return "", errors.New("login failed")
+				conn.RemoveTopic(topic)
+				return nil, errors.New("login failed")
 			}
 		}
 		data = map[string]string{
@@ -220,12 +293,19 @@ func (e *HuobiWs) subscribe(url, topic, symbol string, t ExchangeApi.MessageType
 		}
 	}
 
+	// the data may come right after the response, so listen to it before subscribing
+	undo := conn.Listen(t, sub)
+	conn.Throttle()
 	if err := conn.SendJsonMessage(data); err != nil {
-		return "", err
+		conn.RemoveTopic(topic)
+		undo()
+		return nil, err
 	}
-	conn.Subscribe(sub)
+	url = conn.Url()
 
-	return topic, nil
+	return e.NewSubscription(url, topic, symbol, t, sub, func() error {
+		return e.unSubscribe(url, topic, needLogin, sub)
+	}), nil
 }
 
 func (e *HuobiWs) send(url string, data interface{}) (err error) {
@@ -255,6 +335,9 @@ func (e *HuobiWs) messageHandler(url string, message []byte) {
 		e.errorHandler(url, fmt.Errorf("[huobiWs] messageHandler unmarshal error:%v", err))
 		return
 	}
+	if res.Ts > 0 && res.Topic != "" {
+		e.ConnectionMgr.ObserveEventTime(url, time.Unix(0, res.Ts*int64(time.Millisecond)))
+	}
 	if res.Ping != 0 {
 		data := map[string]interface{}{"pong": res.Ping}
 		if err := e.send(url, data); err != nil {
@@ -269,7 +352,9 @@ func (e *HuobiWs) messageHandler(url string, message []byte) {
 	}
 
 	if res.Topic != "" && res.Code == 0 {
+		e.topicLock.RLock()
 		topicInfo := e.subTopicInfo[res.Topic]
+		e.topicLock.RUnlock()
 		switch topicInfo.MessageType {
 		case ExchangeApi.MsgTrade:
 			e.handleTrade(url, message, topicInfo)
@@ -279,6 +364,8 @@ func (e *HuobiWs) messageHandler(url string, message []byte) {
 			} else {
 				e.handleIncrementalDepth(url, message, topicInfo)
 			}
+		case ExchangeApi.MsgOrderBookDelta:
+			e.handleIncrementalDepth(url, message, topicInfo)
 		case ExchangeApi.MsgKLine:
 			e.handleKLine(url, message, topicInfo)
 		case ExchangeApi.MsgTicker:
@@ -294,7 +381,9 @@ func (e *HuobiWs) messageHandler(url string, message []byte) {
 	if res.Rep != "" {
 		if strings.Contains(res.Rep, "mbp") {
 			//get full order book
+			e.topicLock.RLock()
 			topicInfo := e.subTopicInfo[res.Rep]
+			e.topicLock.RUnlock()
 			e.handleFullDepth(url, message, topicInfo)
 		}
 	}
@@ -342,7 +431,9 @@ func (e *HuobiWs) handleDepth(url string, message []byte, topicInfo SubTopic) {
 	if data.Depth.SeqNum > topicInfo.LastUpdateID {
 		res := data.parseOrderBook(topicInfo.Symbol)
 		topicInfo.LastUpdateID = data.Depth.SeqNum
+		e.topicLock.Lock()
 		e.subTopicInfo[topicInfo.Topic] = topicInfo
+		e.topicLock.Unlock()
 		e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: res})
 	} else {
 		err := ExchangeApi.ExError{Code: ExchangeApi.ErrInvalidDepth,
@@ -353,6 +444,9 @@ func (e *HuobiWs) handleDepth(url string, message []byte, topicInfo SubTopic) {
 }
 
 func (e *HuobiWs) handleIncrementalDepth(url string, message []byte, topicInfo SubTopic) {
+	// the order book cache is shared by the connections of all shards
+	e.RwLock.Lock()
+	defer e.RwLock.Unlock()
 	var data OrderBookRes
 	if err := json.Unmarshal(message, &data); err != nil {
 		e.errorHandler(url, fmt.Errorf("[huobiWs] handleTicker - message Unmarshal to ticker error:%v", err))
@@ -366,39 +460,59 @@ func (e *HuobiWs) handleIncrementalDepth(url string, message []byte, topicInfo S
 	}
 	fullOrderBook, ok := (*symbolOrderBook)[topicInfo.Symbol]
 	if !ok {
+		fullOrderBook = &OrderBook{Book: ExchangeApi.NewBook(topicInfo.Symbol, e.Option.OrderBookDepth)}
+		(*symbolOrderBook)[topicInfo.Symbol] = fullOrderBook
+		fullOrderBook.wait(data)
 		e.send(url, map[string]string{"req": topicInfo.Topic})
-		(*symbolOrderBook)[topicInfo.Symbol] = &OrderBook{}
 		return
 	}
-	if fullOrderBook != nil {
-		if fullOrderBook.SeqNum == data.Depth.PrevSeqNum {
-			fullOrderBook.update(data)
-			e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: fullOrderBook.OrderBook})
-		} else if fullOrderBook.SeqNum != 0 {
-			delete(*symbolOrderBook, topicInfo.Symbol)
-			err := ExchangeApi.ExError{Code: ExchangeApi.ErrInvalidDepth,
-				Message: fmt.Sprintf("[HuobiWs] handleIncrementalDepth - recv dirty data, new.PrevSeqNum: %v != old.SeqNum: %v ", data.Depth.PrevSeqNum, fullOrderBook.SeqNum),
-				Data:    map[string]interface{}{"symbol": fullOrderBook.Symbol}}
-			e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: err})
-		}
+	if fullOrderBook.waiting {
+		fullOrderBook.wait(data)
+		return
+	}
+	if fullOrderBook.SeqNum == data.Depth.PrevSeqNum {
+		fullOrderBook.update(data)
+		e.PublishOrderBook(url, fullOrderBook.Book)
+	} else if data.Depth.SeqNum > fullOrderBook.SeqNum {
+		// request the snapshot again, the subscribers are told instead of getting an error
+		fullOrderBook.resyncs++
+		fullOrderBook.buffer = nil
+		fullOrderBook.wait(data)
+		reason := fmt.Sprintf("[HuobiWs] handleIncrementalDepth - recv dirty data, new.PrevSeqNum: %v != old.SeqNum: %v ", data.Depth.PrevSeqNum, fullOrderBook.SeqNum)
+		e.ConnectionMgr.Publish(url, fullOrderBook.status(ExchangeApi.BookResyncing, reason))
+		e.send(url, map[string]string{"req": topicInfo.Topic})
 	}
 }
 
 func (e *HuobiWs) handleFullDepth(url string, message []byte, topicInfo SubTopic) {
+	// the order book cache is shared by the connections of all shards
+	e.RwLock.Lock()
+	defer e.RwLock.Unlock()
 	var data OrderBookRes
 	restJson := jsoniter.Config{TagKey: "rep"}.Froze()
 	if err := restJson.Unmarshal(message, &data); err != nil {
 		e.errorHandler(url, fmt.Errorf("[huobiWs] handleTicker - message Unmarshal to ticker error:%v", err))
 		return
 	}
-	ob := OrderBook{}
-	ob.update(data)
 	symbolOrderBook, ok := e.orderBooks[url]
 	if !ok {
 		symbolOrderBook = &SymbolOrderBook{}
 		e.orderBooks[url] = symbolOrderBook
 	}
-	(*symbolOrderBook)[topicInfo.Symbol] = &ob
+	fullOrderBook, ok := (*symbolOrderBook)[topicInfo.Symbol]
+	if !ok {
+		fullOrderBook = &OrderBook{Book: ExchangeApi.NewBook(topicInfo.Symbol, e.Option.OrderBookDepth)}
+		(*symbolOrderBook)[topicInfo.Symbol] = fullOrderBook
+	}
+	if !fullOrderBook.replay(data) {
+		// the snapshot is older than the updates buffered
+		e.send(url, map[string]string{"req": topicInfo.Topic})
+		return
+	}
+	e.PublishOrderBook(url, fullOrderBook.Book)
+	if fullOrderBook.resyncs > 0 {
+		e.ConnectionMgr.Publish(url, fullOrderBook.status(ExchangeApi.BookResynced, ""))
+	}
 }
 
 func (e *HuobiWs) handleTrade(url string, message []byte, topicInfo SubTopic) {
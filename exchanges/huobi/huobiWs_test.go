@@ -1,10 +1,16 @@
 package huobi
 
 import (
+	"context"
 	"fmt"
 	"github.com/xiaolo66/ExchangeApi"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	gorilla "github.com/gorilla/websocket"
 )
 
 var (
@@ -102,12 +108,12 @@ func TestHuobiWs_SubscribeKline(t *testing.T) {
 }
 
 func TestHuobiWs_UnSubscribe(t *testing.T) {
-	topic, err := e.SubscribeKLine(symbol, ExchangeApi.KLine1Minute, msgChan)
+	subscription, err := e.SubscribeKLine(symbol, ExchangeApi.KLine1Minute, msgChan)
 	if err == nil {
 		go handleMsg(msgChan)
 	}
 	time.Sleep(time.Second * 10)
-	e.UnSubscribe(topic, msgChan)
+	ExchangeApi.UnSubscribeAll(subscription)
 	time.Sleep(time.Second * 10)
 }
 
@@ -123,4 +129,71 @@ func TestHuobiWs_SubscribeOrders(t *testing.T) {
 	if err == nil {
 		handleMsg(msgChan)
 	}
-}
\ No newline at end of file
+}
+
+// silentServer a websocket server which reads the requests and never replies
+func silentServer(t *testing.T) *httptest.Server {
+	upgrader := gorilla.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestHuobiWs_OrderBookDelta(t *testing.T) {
+	server := silentServer(t)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ws := HuobiWs{}
+	ws.Init(ExchangeApi.Options{Markets: map[string]ExchangeApi.Market{
+		"BTC/USDT": {SymbolID: "btcusdt", Symbol: "BTC/USDT", BaseID: "btc", QuoteID: "usdt"},
+	}})
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = ws.Shutdown(ctx)
+	}()
+	conn, err := ws.ConnectionMgr.GetConnection(url, ws.Connect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := "market.btcusdt.mbp.150"
+	ws.subTopicInfo[topic] = SubTopic{Topic: topic, Symbol: "BTC/USDT", MessageType: ExchangeApi.MsgOrderBookDelta}
+	deltas := make(ExchangeApi.MessageChan, 10)
+	conn.Listen(ExchangeApi.MsgOrderBookDelta, deltas)
+	ws.NewSubscription(url, topic, "BTC/USDT", ExchangeApi.MsgOrderBookDelta, deltas, nil)
+
+	next := func() ExchangeApi.OrderBookDelta {
+		t.Helper()
+		select {
+		case msg := <-deltas:
+			if msg.Type != ExchangeApi.MsgOrderBookDelta {
+				t.Fatalf("unexpected message %+v", msg)
+			}
+			return msg.Data.(ExchangeApi.OrderBookDelta)
+		case <-time.After(time.Second * 3):
+			t.Fatal("delta not received")
+		}
+		return ExchangeApi.OrderBookDelta{}
+	}
+	// the update is buffered until the snapshot is replied
+	ws.messageHandler(url, []byte(`{"ch":"market.btcusdt.mbp.150","ts":1,"tick":{"seqNum":11,"prevSeqNum":10,"bids":[[100,2]],"asks":[]}}`))
+	ws.messageHandler(url, []byte(`{"rep":"market.btcusdt.mbp.150","data":{"seqNum":10,"bids":[[100,1]],"asks":[[102,1]]}}`))
+	if delta := next(); !delta.Snapshot || len(delta.Bids) != 1 || delta.Bids[0].Amount != "2" || len(delta.Asks) != 1 {
+		t.Fatalf("unexpected snapshot %+v", delta)
+	}
+	ws.messageHandler(url, []byte(`{"ch":"market.btcusdt.mbp.150","ts":2,"tick":{"seqNum":12,"prevSeqNum":11,"bids":[[101,1]],"asks":[]}}`))
+	if delta := next(); delta.Snapshot || len(delta.Bids) != 1 || delta.Bids[0].Price != "101" || len(delta.Asks) != 0 {
+		t.Fatalf("unexpected delta %+v", delta)
+	}
+}
@@ -16,6 +16,7 @@ type Response struct {
 	Rep    string  `json:"rep"`
 	Ping   float64 `json:"ping"`
 	Code   int     `json:"code"`
+	Ts     int64   `json:"ts"`
 }
 
 type OrderBookRes struct {
@@ -181,6 +182,8 @@ type Market struct {
 	Base            string  `json:"base-currency"`
 	Quote           string  `json:"quote-currency"`
 	MinAmount       float64 `json:"min-order-amt"`
+	MaxAmount       float64 `json:"max-order-amt"`
+	MinValue        float64 `json:"min-order-value"`
 	AmountPrecision int     `json:"amount-precision"`
 	PricePrecision  int     `json:"price-precision"`
 }
@@ -312,15 +315,58 @@ type ResponseEvent struct {
 	Message string `json:"message"`
 }
 
+// OrderBook the order book maintained by the incremental depth channel
 type OrderBook struct {
-	ExchangeApi.OrderBook
+	*ExchangeApi.Book
 	SeqNum     float64
 	PrevSeqNum float64
+
+	waiting bool           // the snapshot has been requested, the updates are buffered until it comes
+	buffer  []OrderBookRes // the updates received while waiting
+	resyncs int
+}
+
+// the updates kept while waiting for the snapshot, the oldest are dropped beyond it
+const maxDepthBuffer = 1000
+
+// wait keep the update until the snapshot comes
+func (o *OrderBook) wait(bookData OrderBookRes) {
+	o.waiting = true
+	if len(o.buffer) >= maxDepthBuffer {
+		o.buffer = o.buffer[1:]
+	}
+	o.buffer = append(o.buffer, bookData)
+}
+
+// replay rebuild the book by the snapshot and the buffered updates, false if the snapshot can't be linked with them
+func (o *OrderBook) replay(snapshot OrderBookRes) bool {
+	o.Book.Reset()
+	o.update(snapshot)
+	for _, bookData := range o.buffer {
+		if bookData.Depth.SeqNum <= o.SeqNum {
+			continue
+		}
+		if bookData.Depth.PrevSeqNum != o.SeqNum {
+			return false
+		}
+		o.update(bookData)
+	}
+	o.waiting = false
+	o.buffer = nil
+	return true
+}
+
+func (o *OrderBook) status(state ExchangeApi.BookSyncState, reason string) ExchangeApi.Message {
+	return ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookStatus, Data: ExchangeApi.OrderBookStatus{
+		Symbol:  o.Symbol,
+		State:   state,
+		Resyncs: o.resyncs,
+		Reason:  reason,
+	}}
 }
 
 func (o *OrderBook) update(bookData OrderBookRes) {
-	o.Bids = o.Bids.Update(bookData.Depth.Bids, true)
-	o.Asks = o.Asks.Update(bookData.Depth.Asks, false)
+	o.Book.Update(bookData.Depth.Bids, bookData.Depth.Asks)
 	o.SeqNum = bookData.Depth.SeqNum
 	o.PrevSeqNum = bookData.Depth.PrevSeqNum
 }
@@ -0,0 +1,156 @@
+package mock
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// futureState the positions, mark prices and funding rates set by the test, and the countdowns of CancelAllAfter
+type futureState struct {
+	positions  map[string][]ExchangeApi.FuturePositons
+	marks      map[string]float64
+	fundings   map[string]ExchangeApi.FundingRate
+	countdowns map[string]*time.Timer // the CancelAllAfter of the symbols
+}
+
+func newFutureState() futureState {
+	return futureState{
+		positions:  make(map[string][]ExchangeApi.FuturePositons),
+		marks:      make(map[string]float64),
+		fundings:   make(map[string]ExchangeApi.FundingRate),
+		countdowns: make(map[string]*time.Timer),
+	}
+}
+
+var _ ExchangeApi.IFutureExchange = (*Exchange)(nil)
+var _ ExchangeApi.ICancelAllAfterExchange = (*Exchange)(nil)
+
+// SetPositions replace the positions of the symbol, they are published to the position subscribers
+func (e *Exchange) SetPositions(symbol string, positions []ExchangeApi.FuturePositons) {
+	e.lock.Lock()
+	e.future.positions[symbol] = append([]ExchangeApi.FuturePositons(nil), positions...)
+	e.lock.Unlock()
+	e.Publish(symbol, ExchangeApi.Message{
+		Type: ExchangeApi.MsgPositions,
+		Data: ExchangeApi.FuturePositonsUpdate{Symbol: symbol, Positons: positions},
+	})
+}
+
+// SetMarkPrice set the mark price of the symbol, it is published to the mark price subscribers
+func (e *Exchange) SetMarkPrice(symbol string, price float64) {
+	e.lock.Lock()
+	e.future.marks[symbol] = price
+	e.lock.Unlock()
+	e.Publish(symbol, ExchangeApi.Message{
+		Type: ExchangeApi.MsgMarkPrice,
+		Data: ExchangeApi.MarkPrice{Symbol: symbol, Price: strconv.FormatFloat(price, 'f', -1, 64)},
+	})
+}
+
+// SetFundingRate set the funding rate of the symbol returned by FetchFundingRate
+func (e *Exchange) SetFundingRate(symbol string, rate ExchangeApi.FundingRate) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.future.fundings[symbol] = rate
+}
+
+func (e *Exchange) Setting(symbol string, leverage int, marginMode ExchangeApi.FutureMarginMode, positionMode ExchangeApi.FuturePositionsMode) error {
+	return e.err("Setting")
+}
+
+func (e *Exchange) FetchMarkPrice(symbol string) (ExchangeApi.MarkPrice, error) {
+	if err := e.err("FetchMarkPrice"); err != nil {
+		return ExchangeApi.MarkPrice{}, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	price, ok := e.future.marks[symbol]
+	if !ok {
+		return ExchangeApi.MarkPrice{}, ExchangeApi.ExError{Code: ExchangeApi.ErrNotFoundMarket, Message: symbol}
+	}
+	return ExchangeApi.MarkPrice{Symbol: symbol, Price: strconv.FormatFloat(price, 'f', -1, 64)}, nil
+}
+
+func (e *Exchange) FetchFundingRate(symbol string) (ExchangeApi.FundingRate, error) {
+	if err := e.err("FetchFundingRate"); err != nil {
+		return ExchangeApi.FundingRate{}, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	rate, ok := e.future.fundings[symbol]
+	if !ok {
+		return rate, ExchangeApi.ExError{Code: ExchangeApi.ErrNotFoundMarket, Message: symbol}
+	}
+	return rate, nil
+}
+
+// FetchAccountInfo the positions only, the assets are not simulated
+func (e *Exchange) FetchAccountInfo() (ExchangeApi.FutureAccountInfo, error) {
+	if err := e.err("FetchAccountInfo"); err != nil {
+		return ExchangeApi.FutureAccountInfo{}, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	info := ExchangeApi.FutureAccountInfo{
+		Assets:    make(map[string]ExchangeApi.FutureAsset),
+		Positions: make(map[string]map[ExchangeApi.PositionType]ExchangeApi.FuturePositons),
+	}
+	for _, positions := range e.future.positions {
+		for _, p := range positions {
+			if info.Positions[p.Coin] == nil {
+				info.Positions[p.Coin] = make(map[ExchangeApi.PositionType]ExchangeApi.FuturePositons)
+			}
+			info.Positions[p.Coin][p.PositionType] = p
+		}
+	}
+	return info, nil
+}
+
+func (e *Exchange) FetchPositions(symbol string) ([]ExchangeApi.FuturePositons, error) {
+	if err := e.err("FetchPositions"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]ExchangeApi.FuturePositons(nil), e.future.positions[symbol]...), nil
+}
+
+func (e *Exchange) FetchAllPositions() ([]ExchangeApi.FuturePositons, error) {
+	if err := e.err("FetchAllPositions"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var all []ExchangeApi.FuturePositons
+	for _, positions := range e.future.positions {
+		all = append(all, positions...)
+	}
+	return all, nil
+}
+
+func (e *Exchange) SubscribePositions(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribePositions", ExchangeApi.MsgPositions, symbol, sub)
+}
+
+func (e *Exchange) SubscribeMarkPrice(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeMarkPrice", ExchangeApi.MsgMarkPrice, symbol, sub)
+}
+
+// CancelAllAfter the open orders of the symbol are canceled when the countdown runs out
+func (e *Exchange) CancelAllAfter(symbol string, timeout time.Duration) error {
+	if err := e.err("CancelAllAfter"); err != nil {
+		return err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if timer, ok := e.future.countdowns[symbol]; ok {
+		timer.Stop()
+		delete(e.future.countdowns, symbol)
+	}
+	if timeout > 0 {
+		e.future.countdowns[symbol] = time.AfterFunc(timeout, func() { e.CancelAllOrders(symbol) })
+	}
+	return nil
+}
@@ -0,0 +1,588 @@
+// Package mock is an in-memory IExchange and IFutureExchange for testing the components built on them without the network.
+// The market data is pushed by the test, the orders are kept in memory and filled by the test.
+package mock
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+type subKey struct {
+	t      ExchangeApi.MessageType
+	symbol string
+}
+
+type klineKey struct {
+	symbol string
+	t      ExchangeApi.KLineType
+}
+
+type Exchange struct {
+	Name string
+
+	lock      sync.Mutex
+	markets   map[string]ExchangeApi.Market
+	books     map[string]ExchangeApi.OrderBook
+	seqs      map[string]uint64 // the Seq of the last order book delta of the symbol
+	tickers   map[string]ExchangeApi.Ticker
+	klines    map[klineKey][]ExchangeApi.KLine
+	balances  map[string]ExchangeApi.Balance
+	orders    map[string]ExchangeApi.Order
+	future    futureState
+	lastID    int
+	subs      map[subKey]map[*ExchangeApi.Subscription]struct{}
+	errs      map[string]error
+	errsAfter map[string]error // the errors returned after the method takes effect
+	shutdown  bool
+}
+
+var _ ExchangeApi.IExchange = (*Exchange)(nil)
+var _ ExchangeApi.IClientOrderExchange = (*Exchange)(nil)
+
+// New create an empty exchange, the markets, books and balances are set by the test
+func New(name string) *Exchange {
+	return &Exchange{
+		Name:      name,
+		markets:   make(map[string]ExchangeApi.Market),
+		books:     make(map[string]ExchangeApi.OrderBook),
+		seqs:      make(map[string]uint64),
+		tickers:   make(map[string]ExchangeApi.Ticker),
+		klines:    make(map[klineKey][]ExchangeApi.KLine),
+		balances:  make(map[string]ExchangeApi.Balance),
+		orders:    make(map[string]ExchangeApi.Order),
+		future:    newFutureState(),
+		subs:      make(map[subKey]map[*ExchangeApi.Subscription]struct{}),
+		errs:      make(map[string]error),
+		errsAfter: make(map[string]error),
+	}
+}
+
+// SetError make the method of the name (eg. "CreateOrder") fail with err, a nil err restores it
+func (e *Exchange) SetError(method string, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err == nil {
+		delete(e.errs, method)
+	} else {
+		e.errs[method] = err
+	}
+}
+
+func (e *Exchange) err(method string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.errs[method]
+}
+
+// SetErrorAfter make the method of the name fail with err after it takes effect, as a request whose response is lost,
+// it's supported by CreateOrder, CreateOrderWithClientID and CancelOrder. A nil err restores it
+func (e *Exchange) SetErrorAfter(method string, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err == nil {
+		delete(e.errsAfter, method)
+	} else {
+		e.errsAfter[method] = err
+	}
+}
+
+func (e *Exchange) errAfter(method string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.errsAfter[method]
+}
+
+// SetMarket add or replace a market
+func (e *Exchange) SetMarket(market ExchangeApi.Market) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.markets[market.Symbol] = market
+}
+
+// SetBalance set the balance of an asset, it is published to the balance subscribers
+func (e *Exchange) SetBalance(balance ExchangeApi.Balance) {
+	e.lock.Lock()
+	e.balances[balance.Asset] = balance
+	e.lock.Unlock()
+	update := ExchangeApi.BalanceUpdate{
+		UpdateTime: time.Duration(time.Now().UnixNano() / 1e6),
+		Balances:   map[string]ExchangeApi.Balance{balance.Asset: balance},
+	}
+	e.Publish("", ExchangeApi.Message{Type: ExchangeApi.MsgBalance, Data: update})
+}
+
+// AddBalance change the available amount of an asset by delta, as a deposit or withdrawal.
+// It is published to the balance subscribers as a delta.
+func (e *Exchange) AddBalance(asset string, delta float64) {
+	e.lock.Lock()
+	balance := e.balances[asset]
+	balance.Asset = asset
+	balance.Available += delta
+	e.balances[asset] = balance
+	e.lock.Unlock()
+	update := ExchangeApi.BalanceUpdate{
+		UpdateTime: time.Duration(time.Now().UnixNano() / 1e6),
+		Balances:   map[string]ExchangeApi.Balance{asset: {Asset: asset, Available: delta}},
+		Delta:      true,
+	}
+	e.Publish("", ExchangeApi.Message{Type: ExchangeApi.MsgBalance, Data: update})
+}
+
+// SetOrderBook replace the order book of the symbol, it is published to the order book subscribers,
+// and to the delta subscribers as a snapshot
+func (e *Exchange) SetOrderBook(orderBook ExchangeApi.OrderBook) {
+	e.lock.Lock()
+	e.books[orderBook.Symbol] = orderBook
+	e.seqs[orderBook.Symbol]++
+	snapshot := snapshotDelta(orderBook, e.seqs[orderBook.Symbol])
+	e.lock.Unlock()
+	e.Publish(orderBook.Symbol, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: orderBook})
+	e.Publish(orderBook.Symbol, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookDelta, Data: snapshot})
+}
+
+// snapshotDelta the order book as a snapshot delta with the normalized levels
+func snapshotDelta(orderBook ExchangeApi.OrderBook, seq uint64) ExchangeApi.OrderBookDelta {
+	book := ExchangeApi.NewBook(orderBook.Symbol, -1)
+	for _, item := range orderBook.Bids {
+		_ = book.UpdateBid(item)
+	}
+	for _, item := range orderBook.Asks {
+		_ = book.UpdateAsk(item)
+	}
+	snapshot := book.SnapshotDelta()
+	snapshot.Seq = seq
+	return snapshot
+}
+
+// SetKLines replace the klines of the symbol and type returned by FetchKLine
+func (e *Exchange) SetKLines(symbol string, t ExchangeApi.KLineType, klines []ExchangeApi.KLine) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.klines[klineKey{symbol: symbol, t: t}] = klines
+}
+
+// SetTicker replace the ticker of the symbol, it is published to the ticker subscribers
+func (e *Exchange) SetTicker(ticker ExchangeApi.Ticker) {
+	e.lock.Lock()
+	e.tickers[ticker.Symbol] = ticker
+	e.lock.Unlock()
+	e.Publish(ticker.Symbol, ExchangeApi.Message{Type: ExchangeApi.MsgTicker, Data: ticker})
+}
+
+// Publish send the message to the subscribers of its type, the symbol is empty for the account wide messages.
+// As the data of an exchange connection goes to all of its channels, the subscribers of the other symbols get it too,
+// only MsgOrderBookDelta is sent to the subscribers of the symbol. A channel gets the message once however many
+// subscriptions it has, and MsgOrderBookStatus goes to the order book subscribers, as the exchanges do.
+// It blocks until all of the subscribers have received it.
+func (e *Exchange) Publish(symbol string, msg ExchangeApi.Message) {
+	t := msg.Type
+	if t == ExchangeApi.MsgOrderBookStatus {
+		t = ExchangeApi.MsgOrderBook
+	}
+	e.lock.Lock()
+	var chans []ExchangeApi.MessageChan
+	seen := make(map[ExchangeApi.MessageChan]bool)
+	for key, subs := range e.subs {
+		if key.t != t || t == ExchangeApi.MsgOrderBookDelta && key.symbol != symbol {
+			continue
+		}
+		for sub := range subs {
+			if c := sub.Chan(); !seen[c] {
+				seen[c] = true
+				chans = append(chans, c)
+			}
+		}
+	}
+	e.lock.Unlock()
+	for _, c := range chans {
+		c <- msg
+	}
+}
+
+// Disconnect send MsgDisConnected to every subscription, as the websocket is lost
+func (e *Exchange) Disconnect() {
+	e.notifyAll(ExchangeApi.DisConnectedMessage)
+}
+
+// Reconnect send MsgReConnected to every subscription, they are renewed by the subscribers
+func (e *Exchange) Reconnect() {
+	e.notifyAll(ExchangeApi.ReConnectedMessage)
+}
+
+func (e *Exchange) notifyAll(msg ExchangeApi.Message) {
+	e.lock.Lock()
+	var chans []ExchangeApi.MessageChan
+	for _, subs := range e.subs {
+		for sub := range subs {
+			chans = append(chans, sub.Chan())
+		}
+	}
+	e.lock.Unlock()
+	for _, c := range chans {
+		c <- msg
+	}
+}
+
+// Subscribers the number of the active subscriptions of the type and symbol
+func (e *Exchange) Subscribers(t ExchangeApi.MessageType, symbol string) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return len(e.subs[subKey{t: t, symbol: symbol}])
+}
+
+func (e *Exchange) subscribe(method string, t ExchangeApi.MessageType, symbol string, msgChan ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	if err := e.err(method); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.shutdown {
+		return nil, ExchangeApi.ErrConnectionClosed
+	}
+	key := subKey{t: t, symbol: symbol}
+	var sub *ExchangeApi.Subscription
+	sub = ExchangeApi.NewSubscription(fmt.Sprintf("%d:%s", t, symbol), symbol, t, msgChan, func() error {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		delete(e.subs[key], sub)
+		return nil
+	})
+	if e.subs[key] == nil {
+		e.subs[key] = make(map[*ExchangeApi.Subscription]struct{})
+	}
+	e.subs[key][sub] = struct{}{}
+	return sub, nil
+}
+
+func (e *Exchange) SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeOrderBook", ExchangeApi.MsgOrderBook, symbol, sub)
+}
+
+// SubscribeOrderBookDelta the snapshots are sent by SetOrderBook and RequestOrderBookSnapshot only, snapshotInterval is ignored
+func (e *Exchange) SubscribeOrderBookDelta(symbol string, snapshotInterval time.Duration, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeOrderBookDelta", ExchangeApi.MsgOrderBookDelta, symbol, sub)
+}
+
+// RequestOrderBookSnapshot the snapshot is sent by another goroutine, so the caller may be the receiver of the channel
+func (e *Exchange) RequestOrderBookSnapshot(symbol string, sub ExchangeApi.MessageChan) error {
+	if err := e.err("RequestOrderBookSnapshot"); err != nil {
+		return err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for s := range e.subs[subKey{t: ExchangeApi.MsgOrderBookDelta, symbol: symbol}] {
+		if s.Chan() == sub {
+			snapshot := snapshotDelta(e.books[symbol], e.seqs[symbol])
+			snapshot.Symbol = symbol
+			go func() { sub <- ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookDelta, Data: snapshot} }()
+			return nil
+		}
+	}
+	return ExchangeApi.ExError{Code: ExchangeApi.ErrChannelNotExist, Message: "order book delta of " + symbol + " not subscribed"}
+}
+
+func (e *Exchange) SubscribeTrades(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeTrades", ExchangeApi.MsgTrade, symbol, sub)
+}
+
+func (e *Exchange) SubscribeTicker(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeTicker", ExchangeApi.MsgTicker, symbol, sub)
+}
+
+func (e *Exchange) SubscribeAllTicker(sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeAllTicker", ExchangeApi.MsgAllTicker, "", sub)
+}
+
+func (e *Exchange) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeKLine", ExchangeApi.MsgKLine, symbol, sub)
+}
+
+// SubscribeBalance the balances of all assets are account wide, symbol is ignored
+func (e *Exchange) SubscribeBalance(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeBalance", ExchangeApi.MsgBalance, "", sub)
+}
+
+func (e *Exchange) SubscribeOrder(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe("SubscribeOrder", ExchangeApi.MsgOrder, symbol, sub)
+}
+
+func (e *Exchange) UnSubscribe(topic string, sub ExchangeApi.MessageChan) error {
+	e.lock.Lock()
+	var found []*ExchangeApi.Subscription
+	for _, subs := range e.subs {
+		for s := range subs {
+			if s.Topic() == topic && s.Chan() == sub {
+				found = append(found, s)
+			}
+		}
+	}
+	e.lock.Unlock()
+	return ExchangeApi.UnSubscribeAll(found...)
+}
+
+func (e *Exchange) FetchOrderBook(symbol string, size int) (ExchangeApi.OrderBook, error) {
+	if err := e.err("FetchOrderBook"); err != nil {
+		return ExchangeApi.OrderBook{}, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ob, ok := e.books[symbol]
+	if !ok {
+		return ob, ExchangeApi.ExError{Code: ExchangeApi.ErrNotFoundMarket, Message: symbol}
+	}
+	if size > 0 && size < len(ob.Bids) {
+		ob.Bids = ob.Bids[:size]
+	}
+	if size > 0 && size < len(ob.Asks) {
+		ob.Asks = ob.Asks[:size]
+	}
+	return ob, nil
+}
+
+func (e *Exchange) FetchTicker(symbol string) (ExchangeApi.Ticker, error) {
+	if err := e.err("FetchTicker"); err != nil {
+		return ExchangeApi.Ticker{}, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	ticker, ok := e.tickers[symbol]
+	if !ok {
+		return ticker, ExchangeApi.ExError{Code: ExchangeApi.ErrNotFoundMarket, Message: symbol}
+	}
+	return ticker, nil
+}
+
+func (e *Exchange) FetchAllTicker() (map[string]ExchangeApi.Ticker, error) {
+	if err := e.err("FetchAllTicker"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	tickers := make(map[string]ExchangeApi.Ticker, len(e.tickers))
+	for symbol, ticker := range e.tickers {
+		tickers[symbol] = ticker
+	}
+	return tickers, nil
+}
+
+func (e *Exchange) FetchTrade(symbol string) ([]ExchangeApi.Trade, error) {
+	return nil, e.err("FetchTrade")
+}
+
+func (e *Exchange) FetchKLine(symbol string, t ExchangeApi.KLineType) ([]ExchangeApi.KLine, error) {
+	if err := e.err("FetchKLine"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]ExchangeApi.KLine(nil), e.klines[klineKey{symbol: symbol, t: t}]...), nil
+}
+
+func (e *Exchange) FetchMarkets() (map[string]ExchangeApi.Market, error) {
+	if err := e.err("FetchMarkets"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	markets := make(map[string]ExchangeApi.Market, len(e.markets))
+	for symbol, market := range e.markets {
+		markets[symbol] = market
+	}
+	return markets, nil
+}
+
+func (e *Exchange) FetchBalance() (map[string]ExchangeApi.Balance, error) {
+	if err := e.err("FetchBalance"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	balances := make(map[string]ExchangeApi.Balance, len(e.balances))
+	for asset, balance := range e.balances {
+		balances[asset] = balance
+	}
+	return balances, nil
+}
+
+// CreateOrder the order is kept open until it is filled by Fill or canceled
+func (e *Exchange) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	if err := e.err("CreateOrder"); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	order := e.createOrder(symbol, price, amount, side, tradeType, orderType, func(id string) string {
+		if useClientID {
+			return "mock" + id
+		}
+		return ""
+	})
+	return order, e.errAfter("CreateOrder")
+}
+
+// CreateOrderWithClientID any non-empty client order ID is taken, a client order ID already used fails with ErrInvalidOrder
+func (e *Exchange) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	if err := e.err("CreateOrderWithClientID"); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	if clientID == "" {
+		return ExchangeApi.Order{}, ExchangeApi.ExError{Code: ExchangeApi.ErrRequestParams, Message: "empty client order id"}
+	}
+	e.lock.Lock()
+	_, used := e.findOrder(clientID)
+	e.lock.Unlock()
+	if used {
+		return ExchangeApi.Order{}, ExchangeApi.ExError{Code: ExchangeApi.ErrInvalidOrder, Message: "duplicate client order id " + clientID}
+	}
+	order := e.createOrder(symbol, price, amount, side, tradeType, orderType, func(string) string { return clientID })
+	return order, e.errAfter("CreateOrderWithClientID")
+}
+
+func (e *Exchange) createOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID func(id string) string) ExchangeApi.Order {
+	e.lock.Lock()
+	e.lastID++
+	order := ExchangeApi.Order{
+		ID:         strconv.Itoa(e.lastID),
+		Symbol:     symbol,
+		Price:      strconv.FormatFloat(price, 'f', -1, 64),
+		Amount:     strconv.FormatFloat(amount, 'f', -1, 64),
+		Filled:     "0",
+		Cost:       "0",
+		Status:     ExchangeApi.Open,
+		Side:       side,
+		Type:       tradeType,
+		OrderType:  orderType,
+		CreateTime: time.Duration(time.Now().UnixNano() / 1e6),
+	}
+	order.ClientID = clientID(order.ID)
+	e.orders[order.ID] = order
+	e.lock.Unlock()
+	e.Publish(symbol, ExchangeApi.Message{Type: ExchangeApi.MsgOrder, Data: order})
+	return order
+}
+
+// findOrder the order by its ID or client order ID, the lock is held by the caller
+func (e *Exchange) findOrder(id string) (ExchangeApi.Order, bool) {
+	if order, ok := e.orders[id]; ok {
+		return order, true
+	}
+	for _, order := range e.orders {
+		if order.ClientID != "" && order.ClientID == id {
+			return order, true
+		}
+	}
+	return ExchangeApi.Order{}, false
+}
+
+// Fill fill the open order by amount at price, it is published to the order subscribers
+func (e *Exchange) Fill(orderID string, price, amount float64) (ExchangeApi.Order, error) {
+	e.lock.Lock()
+	order, ok := e.orders[orderID]
+	if !ok || (order.Status != ExchangeApi.Open && order.Status != ExchangeApi.Partial) {
+		e.lock.Unlock()
+		return order, ExchangeApi.ExError{Code: ExchangeApi.ErrOrderNotFound, Message: orderID}
+	}
+	filled, _ := strconv.ParseFloat(order.Filled, 64)
+	cost, _ := strconv.ParseFloat(order.Cost, 64)
+	total, _ := strconv.ParseFloat(order.Amount, 64)
+	filled += amount
+	cost += price * amount
+	order.Filled = strconv.FormatFloat(filled, 'f', -1, 64)
+	order.Cost = strconv.FormatFloat(cost, 'f', -1, 64)
+	order.Status = ExchangeApi.Partial
+	if filled >= total {
+		order.Status = ExchangeApi.Close
+	}
+	order.TransactionTime = time.Duration(time.Now().UnixNano() / 1e6)
+	e.orders[orderID] = order
+	e.lock.Unlock()
+	e.Publish(order.Symbol, ExchangeApi.Message{Type: ExchangeApi.MsgOrder, Data: order})
+	return order, nil
+}
+
+func (e *Exchange) CancelOrder(symbol, orderID string) error {
+	if err := e.err("CancelOrder"); err != nil {
+		return err
+	}
+	e.lock.Lock()
+	order, ok := e.findOrder(orderID)
+	if !ok || order.Symbol != symbol || (order.Status != ExchangeApi.Open && order.Status != ExchangeApi.Partial) {
+		e.lock.Unlock()
+		return ExchangeApi.ExError{Code: ExchangeApi.ErrOrderNotFound, Message: orderID}
+	}
+	order.Status = ExchangeApi.Canceled
+	e.orders[order.ID] = order
+	e.lock.Unlock()
+	e.Publish(symbol, ExchangeApi.Message{Type: ExchangeApi.MsgOrder, Data: order})
+	return e.errAfter("CancelOrder")
+}
+
+func (e *Exchange) CancelAllOrders(symbol string) error {
+	orders, err := e.FetchOpenOrders(symbol, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if err := e.CancelOrder(symbol, order.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Exchange) FetchOrder(symbol, orderID string) (ExchangeApi.Order, error) {
+	if err := e.err("FetchOrder"); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	order, ok := e.findOrder(orderID)
+	if !ok || order.Symbol != symbol {
+		return order, ExchangeApi.ExError{Code: ExchangeApi.ErrOrderNotFound, Message: orderID}
+	}
+	return order, nil
+}
+
+// FetchOpenOrders all of the open orders are returned in one page
+func (e *Exchange) FetchOpenOrders(symbol string, pageIndex, pageSize int) ([]ExchangeApi.Order, error) {
+	if err := e.err("FetchOpenOrders"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var orders []ExchangeApi.Order
+	for _, order := range e.orders {
+		if order.Symbol == symbol && (order.Status == ExchangeApi.Open || order.Status == ExchangeApi.Partial) {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		id1, _ := strconv.Atoi(orders[i].ID)
+		id2, _ := strconv.Atoi(orders[j].ID)
+		return id1 < id2
+	})
+	return orders, nil
+}
+
+func (e *Exchange) ConnectionStats() map[string]ExchangeApi.ConnStats {
+	return map[string]ExchangeApi.ConnStats{}
+}
+
+// Shutdown end all of the subscriptions
+func (e *Exchange) Shutdown(ctx context.Context) error {
+	e.lock.Lock()
+	e.shutdown = true
+	subs := e.subs
+	e.subs = make(map[subKey]map[*ExchangeApi.Subscription]struct{})
+	e.lock.Unlock()
+	for _, s := range subs {
+		for sub := range s {
+			sub.End(ExchangeApi.ErrConnectionClosed)
+		}
+	}
+	return nil
+}
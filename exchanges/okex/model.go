@@ -42,6 +42,9 @@ type ResponseEvent struct {
 	Table     string `json:"table"`
 	ErrorCode int    `json:"errorCode"`
 	Message   string `json:"message"`
+	Data      []struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"data"`
 }
 
 // OrderBook
@@ -55,8 +58,30 @@ func (o *OrderBook) update(bookData DepthData) {
 	o.Asks = o.Asks.Update(bookData.Asks, false)
 }
 
+// LocalOrderBook the order book maintained by the tick-by-tick depth channel
+type LocalOrderBook struct {
+	*ExchangeApi.Book
+	Timestamp time.Time
+	resyncing bool // the checksum is not correct, waiting for the partial after resubscribed
+	resyncs   int
+}
+
+func (o *LocalOrderBook) update(bookData DepthData) {
+	o.Book.Update(bookData.Bids, bookData.Asks)
+	o.Timestamp = bookData.Timestamp
+}
+
+func (o *LocalOrderBook) status(state ExchangeApi.BookSyncState, reason string) ExchangeApi.Message {
+	return ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookStatus, Data: ExchangeApi.OrderBookStatus{
+		Symbol:  o.Symbol,
+		State:   state,
+		Resyncs: o.resyncs,
+		Reason:  reason,
+	}}
+}
+
 // OrderBook of one symbol
-type SymbolOrderBook map[string]*OrderBook
+type SymbolOrderBook map[string]*LocalOrderBook
 
 type DepthData struct {
 	Checksum  int32         `json:"checksum"`
@@ -67,6 +92,7 @@ type DepthData struct {
 }
 
 type OrderBookRes struct {
+	Table  string      `json:"table"`
 	Action string      `json:"action"`
 	Data   []DepthData `json:"data"`
 }
@@ -1,7 +1,10 @@
 package okex
 import (
+	"context"
+
 	"github.com/xiaolo66/ExchangeApi"
 )
+// Okex both OkexRest and OkexWs embed BaseExchange, the methods of the websockets are picked from OkexWs
 type Okex struct {
 	OkexRest
 	OkexWs
@@ -18,3 +21,18 @@ func New(options ExchangeApi.Options) *Okex {
 
 	return instance
 }
+
+// ConnectionStats the latency statistics of the websocket connections, key: the url of the connection
+func (e *Okex) ConnectionStats() map[string]ExchangeApi.ConnStats {
+	return e.OkexWs.ConnectionStats()
+}
+
+// Shutdown close all the subscriptions and connections
+func (e *Okex) Shutdown(ctx context.Context) error {
+	return e.OkexWs.Shutdown(ctx)
+}
+
+// RequestOrderBookSnapshot send the whole order book to the channel subscribing the deltas of the symbol
+func (e *Okex) RequestOrderBookSnapshot(symbol string, sub ExchangeApi.MessageChan) error {
+	return e.OkexWs.RequestOrderBookSnapshot(symbol, sub)
+}
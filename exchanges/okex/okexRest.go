@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/xiaolo66/ExchangeApi"
 	"github.com/xiaolo66/ExchangeApi/exchanges"
-	"github.com/xiaolo66/ExchangeApi/utils"
 	. "github.com/xiaolo66/ExchangeApi/utils"
 	"net/http"
 	"net/url"
@@ -209,6 +208,9 @@ func (e *OkexRest) FetchMarkets() (map[string]ExchangeApi.Market, error) {
 			BaseID:   strings.ToUpper(v.BaseCurrency),
 			QuoteID:  strings.ToUpper(v.QuoteCurrency),
 			Lot:      v.MinSize,
+			TickSize: SafeParseFloat(v.TickSize),
+			StepSize: SafeParseFloat(v.SizeIncrement),
+			MinQty:   v.MinSize,
 		}
 		pres := strings.Split(v.TickSize, ".")
 		if len(pres) == 1 {
@@ -248,15 +250,35 @@ func (e *OkexRest) FetchBalance() (balances map[string]ExchangeApi.Balance, err
 	return
 }
 
-func (e *OkexRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (order ExchangeApi.Order, err error) {
+func (e *OkexRest) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	var clientID string
+	if useClientID {
+		clientID = GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32)
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+// CreateOrderWithClientID FetchOrder and CancelOrder take the client order ID too
+func (e *OkexRest) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	if err := e.CheckClientOrderID(clientID); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	return e.createOrder(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+func (e *OkexRest) createOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (order ExchangeApi.Order, err error) {
 	market, err := e.GetMarket(symbol)
 	if err != nil {
 		return
 	}
+	price, amount, err = market.NormalizeOrder(price, amount, side, tradeType)
+	if err != nil {
+		return
+	}
 	params := url.Values{}
 	params.Set("instrument_id", market.SymbolID)
-	params.Set("price", utils.Round(price, market.PricePrecision, false))
-	params.Set("size", utils.Round(amount, market.AmountPrecision, false))
+	params.Set("price", market.FormatPrice(price))
+	params.Set("size", market.FormatAmount(amount))
 	if side == ExchangeApi.Sell {
 		params.Set("side", "sell")
 	} else if side == ExchangeApi.Buy {
@@ -277,8 +299,8 @@ func (e *OkexRest) CreateOrder(symbol string, price, amount float64, side Exchan
 	default:
 		params.Set("type", "limit")
 	}
-	if useClientID {
-		params.Set("client_oid", GenerateOrderClientId(e.Option.ClientOrderIDPrefix, 32))
+	if clientID != "" {
+		params.Set("client_oid", clientID)
 	}
 	res, err := e.Fetch(e, exchanges.Private, exchanges.POST, "/api/spot/v3/orders", params, http.Header{})
 	if err != nil {
@@ -299,6 +321,7 @@ func (e *OkexRest) CreateOrder(symbol string, price, amount float64, side Exchan
 	return
 }
 
+// CancelOrder the path takes the order ID or the client order ID
 func (e *OkexRest) CancelOrder(symbol, orderID string) (err error) {
 	market, err := e.GetMarket(symbol)
 	if err != nil {
@@ -327,6 +350,7 @@ func (e *OkexRest) CancelAllOrders(symbol string) (err error) {
 }
 
 //FetchOrder : 获取订单详情
+// the path takes the order ID or the client order ID
 func (e *OkexRest) FetchOrder(symbol, orderID string) (order ExchangeApi.Order, err error) {
 	market, err := e.GetMarket(symbol)
 	if err != nil {
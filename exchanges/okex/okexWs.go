@@ -30,6 +30,7 @@ type OkexWs struct {
 func (e *OkexWs) Init(option ExchangeApi.Options) {
 	e.BaseExchange.Init()
 	e.Option = option
+	e.Resubscribe = e.resubscribe
 	e.orderBooks = make(map[string]*SymbolOrderBook)
 	e.errors = map[int]ExchangeApi.ExError{
 		30040: ExchangeApi.ExError{Code: ExchangeApi.ErrChannelNotExist},
@@ -47,25 +48,37 @@ func (e *OkexWs) Init(option ExchangeApi.Options) {
 	if e.Option.RestHost == "" {
 		e.Option.RestHost = "https://www.okex.com"
 	}
+	// conservative defaults, okex drops the connection which subscribes too fast
+	e.InitShardLimit(200, 10)
 }
 
-func (e *OkexWs) SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub ExchangeApi.MessageChan) (string, error) {
-	return e.subscribe(e.Option.WsHost, "spot/depth_l2_tbt", symbol, false, sub)
+func (e *OkexWs) SubscribeOrderBook(symbol string, level, speed int, isIncremental bool, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe(e.Option.WsHost, "spot/depth_l2_tbt", symbol, ExchangeApi.MsgOrderBook, false, sub)
 }
 
-func (e *OkexWs) SubscribeTrades(symbol string, sub ExchangeApi.MessageChan) (string, error) {
-	return e.subscribe(e.Option.WsHost, "spot/trade", symbol, false, sub)
+// SubscribeOrderBookDelta subscribe the tick-by-tick depth channel, the local book is published as MsgOrderBookDelta
+func (e *OkexWs) SubscribeOrderBookDelta(symbol string, snapshotInterval time.Duration, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	s, err := e.subscribe(e.Option.WsHost, "spot/depth_l2_tbt", symbol, ExchangeApi.MsgOrderBookDelta, false, sub)
+	if err != nil {
+		return nil, err
+	}
+	e.SetSnapshotInterval(s, snapshotInterval)
+	return s, nil
+}
+
+func (e *OkexWs) SubscribeTrades(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe(e.Option.WsHost, "spot/trade", symbol, ExchangeApi.MsgTrade, false, sub)
 }
 
-func (e *OkexWs) SubscribeTicker(symbol string, sub ExchangeApi.MessageChan) (string, error) {
-	return e.subscribe(e.Option.WsHost, "spot/ticker", symbol, false, sub)
+func (e *OkexWs) SubscribeTicker(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe(e.Option.WsHost, "spot/ticker", symbol, ExchangeApi.MsgTicker, false, sub)
 }
 
-func (e *OkexWs) SubscribeAllTicker(sub ExchangeApi.MessageChan) (string, error) {
-	return "", ExchangeApi.ExError{Code: ExchangeApi.NotImplement}
+func (e *OkexWs) SubscribeAllTicker(sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return nil, ExchangeApi.ExError{Code: ExchangeApi.NotImplement}
 }
 
-func (e *OkexWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub ExchangeApi.MessageChan) (string, error) {
+func (e *OkexWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	table := ""
 	switch t {
 	case ExchangeApi.KLine1Minute:
@@ -93,29 +106,24 @@ func (e *OkexWs) SubscribeKLine(symbol string, t ExchangeApi.KLineType, sub Exch
 	case ExchangeApi.KLine1Week:
 		table = "candle604800s"
 	}
-	return e.subscribe(e.Option.WsHost, fmt.Sprintf("spot/%s", table), symbol, false, sub)
+	return e.subscribe(e.Option.WsHost, fmt.Sprintf("spot/%s", table), symbol, ExchangeApi.MsgKLine, false, sub)
 }
 
-func (e *OkexWs) SubscribeBalance(symbol string, sub ExchangeApi.MessageChan) (string, error) {
-	return e.subscribe(e.Option.WsHost, "spot/account", symbol, true, sub)
+func (e *OkexWs) SubscribeBalance(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe(e.Option.WsHost, "spot/account", symbol, ExchangeApi.MsgBalance, true, sub)
 }
 
-func (e *OkexWs) SubscribeOrder(symbol string, sub ExchangeApi.MessageChan) (string, error) {
-	return e.subscribe(e.Option.WsHost, "spot/order", symbol, true, sub)
+func (e *OkexWs) SubscribeOrder(symbol string, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
+	return e.subscribe(e.Option.WsHost, "spot/order", symbol, ExchangeApi.MsgOrder, true, sub)
 }
 
 func (e *OkexWs) UnSubscribe(event string, sub ExchangeApi.MessageChan) error {
-	conn, err := e.ConnectionMgr.GetConnection(e.Option.WsHost, nil)
+	conn, err := e.ConnectionMgr.FindConnection(e.Option.WsHost, event)
 	if err != nil {
 		return err
 	}
-	if err := e.send(conn, UnSubscribeStream(event)); err != nil {
-		return err
-	}
-
-	conn.UnSubscribe(sub)
-
-	return nil
+	e.EndSubscription(conn.Url(), event, sub)
+	return e.unSubscribe(conn.Url(), event, []string{event}, sub)
 }
 
 func (e *OkexWs) Connect(url string) (*exchanges.Connection, error) {
@@ -127,6 +135,7 @@ func (e *OkexWs) Connect(url string) (*exchanges.Connection, error) {
 		websocket.SetIsAutoReconnect(e.Option.AutoReconnect),
 		websocket.SetEnableCompression(false),
 		websocket.SetHeartbeatIntervalTime(time.Second),
+		websocket.SetPingIntervalTime(time.Second*10),
 		websocket.SetReadDeadLineTime(time.Second*30),
 		websocket.SetMessageHandler(e.messageHandler),
 		websocket.SetErrorHandler(e.errorHandler),
@@ -140,43 +149,92 @@ func (e *OkexWs) Connect(url string) (*exchanges.Connection, error) {
 	return conn, err
 }
 
-func (e *OkexWs) subscribe(url, table, symbol string, needLogin bool, sub ExchangeApi.MessageChan) (string, error) {
+func (e *OkexWs) subscribe(url, table, symbol string, t ExchangeApi.MessageType, needLogin bool, sub ExchangeApi.MessageChan) (*ExchangeApi.Subscription, error) {
 	market, err := e.GetMarket(symbol)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	topic := fmt.Sprintf("%s:%s", table, market.SymbolID)
-	conn, err := e.ConnectionMgr.GetConnection(url, e.Connect)
+	var conn *exchanges.Connection
+	if needLogin {
+		// the login state is kept by the instance, so the private topics always go over the first connection
+		conn, err = e.ConnectionMgr.GetConnection(url, e.Connect)
+		if err == nil {
+			conn.AddTopic(topic)
+		}
+	} else {
+		conn, err = e.ConnectionMgr.GetShardConnection(url, topic, e.Connect)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if needLogin {
 		e.loginLock.Lock()
 		defer e.loginLock.Unlock()
 		if !e.isLogin {
+			conn.Throttle()
 			if err := e.login(conn); err != nil {
-				return "", err
+				conn.RemoveTopic(topic)
+				return nil, err
 			}
 			select {
 			case <-e.loginChan:
 				break
 			case <-time.After(time.Second * 5):
-				return "", errors.New("login failed")
+				conn.RemoveTopic(topic)
+				return nil, errors.New("login failed")
 			}
 		}
 	}
 
+	topics := []string{topic}
 	if table == "spot/account" {
-		e.send(conn, SubscribeStream(fmt.Sprintf("%s:%s", table, market.BaseID)))
-		e.send(conn, SubscribeStream(fmt.Sprintf("%s:%s", table, market.QuoteID)))
-	} else {
-		if err := e.send(conn, SubscribeStream(topic)); err != nil {
-			return "", err
-		}
+		topics = []string{fmt.Sprintf("%s:%s", table, market.BaseID), fmt.Sprintf("%s:%s", table, market.QuoteID)}
+	}
+	// the data may come right after the response, so listen to it before subscribing
+	undo := conn.Listen(t, sub)
+	conn.Throttle()
+	if err := e.send(conn, SubscribeStream(topics...)); err != nil {
+		conn.RemoveTopic(topic)
+		undo()
+		return nil, err
+	}
+	url = conn.Url()
+	return e.NewSubscription(url, topic, market.Symbol, t, sub, func() error {
+		return e.unSubscribe(url, topic, topics, sub)
+	}), nil
+}
+
+// resubscribe renew the topic on the connection of url, used by the stale watchdog
+func (e *OkexWs) resubscribe(url, topic string) error {
+	conn, err := e.ConnectionMgr.GetConnection(url, nil)
+	if err != nil {
+		return err
+	}
+	conn.Throttle()
+	if err := e.send(conn, UnSubscribeStream(topic)); err != nil {
+		return err
+	}
+	conn.Throttle()
+	return e.send(conn, SubscribeStream(topic))
+}
+
+// unSubscribe topic is the one counted on the connection, topics are the streams sent to the server
+func (e *OkexWs) unSubscribe(url, topic string, topics []string, sub ExchangeApi.MessageChan) error {
+	conn, err := e.ConnectionMgr.GetConnection(url, nil)
+	if err != nil {
+		return err
+	}
+	conn.Throttle()
+	if err := e.send(conn, UnSubscribeStream(topics...)); err != nil {
+		return err
 	}
-	conn.Subscribe(sub)
-	return topic, nil
+
+	conn.UnSubscribe(sub)
+	conn.RemoveTopic(topic)
+
+	return nil
 }
 
 func (e *OkexWs) send(conn *exchanges.Connection, data Stream) (err error) {
@@ -200,6 +258,11 @@ func (e *OkexWs) messageHandler(url string, message []byte) {
 		e.errorHandler(url, fmt.Errorf("[Okex] messageHandler unmarshal error:%v", err))
 		return
 	}
+	if len(res.Data) > 0 && res.Data[0].Timestamp != "" {
+		if ms := ParseIsoTime(res.Data[0].Timestamp, nil); ms > 0 {
+			e.ConnectionMgr.ObserveEventTime(url, time.Unix(0, int64(ms)*int64(time.Millisecond)))
+		}
+	}
 
 	if res.Event == "error" {
 		e.errorHandler(url, fmt.Errorf("[OkexWs] messageHandler - business errcode:%v errmsg:%v", res.ErrorCode, res.Message))
@@ -271,6 +334,9 @@ func (e *OkexWs) decompressHandler(data []byte) ([]byte, error) {
 }
 
 func (e *OkexWs) handleDepth(url string, message []byte) {
+	// the order book cache is shared by the connections of all shards
+	e.RwLock.Lock()
+	defer e.RwLock.Unlock()
 	rawOB := OrderBookRes{}
 	if err := json.Unmarshal(message, &rawOB); err != nil {
 		e.errorHandler(url, fmt.Errorf("[OkexWs] handleDepth - message Unmarshal to UpdateOrderBook error:%v", err))
@@ -288,36 +354,50 @@ func (e *OkexWs) handleDepth(url string, message []byte) {
 	}
 
 	symbolOrderBook, exit := e.orderBooks[url]
-	newOrderBook := &OrderBook{}
-	newOrderBook.Symbol = market.Symbol
+	if !exit || symbolOrderBook == nil {
+		symbolOrderBook = &SymbolOrderBook{}
+		e.orderBooks[url] = symbolOrderBook
+	}
 
 	//The 400 entries of market depth data of the order book that return for the first time after subscription will be pushed;
 	//subsequently as long as there's any change of market depth data of the order book, the changes will be pushed tick by tick.
+	cacheOrderBook, ok := (*symbolOrderBook)[market.Symbol]
+	resynced := false
 	if rawOB.Action == "partial" {
-		symbolOrderBook = &SymbolOrderBook{}
-	} else if rawOB.Action == "update" {
-		if !exit || symbolOrderBook == nil {
-			return
-		}
-		cacheOrderBook, ok := (*symbolOrderBook)[market.Symbol]
+		newOrderBook := &LocalOrderBook{Book: ExchangeApi.NewBook(market.Symbol, e.Option.OrderBookDepth)}
 		if ok {
-			newOrderBook = cacheOrderBook
+			resynced = cacheOrderBook.resyncing
+			newOrderBook.resyncs = cacheOrderBook.resyncs
 		}
+		cacheOrderBook = newOrderBook
+		(*symbolOrderBook)[market.Symbol] = cacheOrderBook
+	} else if !ok || cacheOrderBook.resyncing {
+		return
 	}
-	newOrderBook.update(data)
+	cacheOrderBook.update(data)
 
-	crc32BaseBuffer, expectCrc32 := e.calCrc32(&newOrderBook.Asks, &newOrderBook.Bids)
+	// the checksum covers the best 25 levels
+	orderBook := cacheOrderBook.Snapshot(25)
+	crc32BaseBuffer, expectCrc32 := e.calCrc32(&orderBook.Asks, &orderBook.Bids)
 	if expectCrc32 == data.Checksum {
-		(*symbolOrderBook)[market.Symbol] = newOrderBook
-		e.orderBooks[url] = symbolOrderBook
-		e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: newOrderBook.OrderBook})
-	} else {
-		err := ExchangeApi.ExError{Code: ExchangeApi.ErrInvalidDepth,
-			Message: fmt.Sprintf("[OkexWs] handleDepth - recv dirty data, Checksum's not correct. LocalString: %s, LocalCrc32: %d, RemoteCrc32: %d",
-				crc32BaseBuffer.String(), expectCrc32, data.Checksum),
-			Data: map[string]interface{}{"symbol": newOrderBook.Symbol}}
-		e.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: err})
+		e.PublishOrderBook(url, cacheOrderBook.Book)
+		if resynced {
+			e.ConnectionMgr.Publish(url, cacheOrderBook.status(ExchangeApi.BookResynced, ""))
+		}
+		return
 	}
+	// the local book is broken, resubscribe to get a new partial, the updates are dropped until it comes
+	cacheOrderBook.resyncing = true
+	cacheOrderBook.resyncs++
+	reason := fmt.Sprintf("[OkexWs] handleDepth - recv dirty data, Checksum's not correct. LocalString: %s, LocalCrc32: %d, RemoteCrc32: %d",
+		crc32BaseBuffer.String(), expectCrc32, data.Checksum)
+	e.ConnectionMgr.Publish(url, cacheOrderBook.status(ExchangeApi.BookResyncing, reason))
+	topic := fmt.Sprintf("%s:%s", rawOB.Table, data.Symbol)
+	go func() {
+		if err := e.resubscribe(url, topic); err != nil {
+			e.errorHandler(url, fmt.Errorf("[OkexWs] handleDepth - resubscribe %s error:%v", topic, err))
+		}
+	}()
 }
 
 func (e *OkexWs) handleTicker(url string, message []byte) {
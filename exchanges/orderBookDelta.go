@@ -0,0 +1,140 @@
+package exchanges
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// deltaState the snapshots of a subscription of MsgOrderBookDelta
+type deltaState struct {
+	interval time.Duration // the interval of the periodic snapshots, no periodic snapshot if 0
+	next     time.Time     // when the next periodic snapshot is due
+	pending  bool          // a snapshot is due at the next update, the subscription is new or it is requested
+}
+
+func (d *deltaState) due(now time.Time) bool {
+	return d.pending || (d.interval > 0 && !now.Before(d.next))
+}
+
+func (d *deltaState) sent(now time.Time) {
+	d.pending = false
+	d.next = now.Add(d.interval)
+}
+
+type feedKey struct {
+	url    string
+	symbol string
+}
+
+// SetSnapshotInterval set the interval of the periodic snapshots of a subscription of MsgOrderBookDelta
+func (b *BaseExchange) SetSnapshotInterval(s *ExchangeApi.Subscription, interval time.Duration) {
+	b.subLock.Lock()
+	defer b.subLock.Unlock()
+	if state, ok := b.deltas[s]; ok {
+		state.interval = interval
+		state.next = time.Now().Add(interval)
+	}
+}
+
+// PublishOrderBook publish the local order book of the connection of url after it's updated, must be called with RwLock held.
+// The whole book is published as MsgOrderBook if anyone of the connection gets it, and the changes are published as
+// MsgOrderBookDelta to the delta subscriptions of the symbol, the book tracks its changes only while they exist.
+func (b *BaseExchange) PublishOrderBook(url string, book *ExchangeApi.Book) {
+	if conn, err := b.ConnectionMgr.GetConnection(url, nil); err == nil && conn.HasSubscribers() {
+		b.ConnectionMgr.Publish(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBook, Data: book.Snapshot(0)})
+	}
+
+	now := time.Now()
+	key := feedKey{url: url, symbol: book.Symbol}
+	var snapshots, deltas []ExchangeApi.MessageChan
+	b.subLock.Lock()
+	var states []*deltaState
+	var chans []ExchangeApi.MessageChan
+	for s := range b.subscriptions[url] {
+		if state, ok := b.deltas[s]; ok && strings.ToUpper(s.Symbol()) == book.Symbol {
+			states = append(states, state)
+			chans = append(chans, s.Chan())
+		}
+	}
+	if len(states) == 0 {
+		book.TrackChanges(false)
+		delete(b.feeds, key)
+		b.subLock.Unlock()
+		return
+	}
+	b.feeds[key] = book
+	book.TrackChanges(true)
+	delta, changed := book.Delta()
+	for i, state := range states {
+		if delta.Snapshot || state.due(now) {
+			snapshots = append(snapshots, chans[i])
+			state.sent(now)
+		} else if changed {
+			deltas = append(deltas, chans[i])
+		}
+	}
+	b.subLock.Unlock()
+
+	if len(snapshots) > 0 {
+		snapshot := delta
+		if !delta.Snapshot {
+			snapshot = book.SnapshotDelta()
+		}
+		b.ConnectionMgr.PublishTo(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookDelta, Data: snapshot}, snapshots...)
+	}
+	if len(deltas) > 0 {
+		b.ConnectionMgr.PublishTo(url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookDelta, Data: delta}, deltas...)
+	}
+}
+
+// RequestOrderBookSnapshot send the whole order book to the channel subscribing the deltas of the symbol,
+// the snapshot is sent with the next update if the book is not in sync
+func (b *BaseExchange) RequestOrderBookSnapshot(symbol string, sub ExchangeApi.MessageChan) error {
+	symbol = strings.ToUpper(symbol)
+	// the books are updated with RwLock held
+	b.RwLock.Lock()
+	defer b.RwLock.Unlock()
+	type request struct {
+		url  string
+		book *ExchangeApi.Book
+	}
+	var requests []request
+	found, now := false, time.Now()
+	b.subLock.Lock()
+	for url, subs := range b.subscriptions {
+		for s := range subs {
+			state, ok := b.deltas[s]
+			if !ok || s.Chan() != sub || strings.ToUpper(s.Symbol()) != symbol {
+				continue
+			}
+			found = true
+			book := b.feeds[feedKey{url: url, symbol: symbol}]
+			if book == nil {
+				state.pending = true
+				continue
+			}
+			state.sent(now)
+			requests = append(requests, request{url: url, book: book})
+		}
+	}
+	b.subLock.Unlock()
+
+	if !found {
+		return ExchangeApi.ExError{Code: ExchangeApi.ErrChannelNotExist, Message: "order book delta of " + symbol + " not subscribed"}
+	}
+	for _, r := range requests {
+		b.ConnectionMgr.PublishTo(r.url, ExchangeApi.Message{Type: ExchangeApi.MsgOrderBookDelta, Data: r.book.SnapshotDelta()}, sub)
+	}
+	return nil
+}
+
+// dropFeeds forget the books of the connection of url, must be called with subLock held
+func (b *BaseExchange) dropFeeds(url string) {
+	for key := range b.feeds {
+		if key.url == url {
+			delete(b.feeds, key)
+		}
+	}
+}
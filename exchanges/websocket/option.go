@@ -29,6 +29,7 @@ type Options struct {
 	ReqHeaders            map[string][]string
 	HeartbeatIntervalTime time.Duration
 	ReadDeadLineTime      time.Duration
+	PingIntervalTime      time.Duration // interval of the ping frames measuring the round trip time, 0 means disabled
 
 	IsAutoReconnect   bool
 	EnableCompression bool
@@ -80,6 +81,12 @@ func SetReadDeadLineTime(t time.Duration) Option {
 	}
 }
 
+func SetPingIntervalTime(t time.Duration) Option {
+	return func(o *Options) {
+		o.PingIntervalTime = t
+	}
+}
+
 func SetIsAutoReconnect(isAuto bool) Option {
 	return func(o *Options) {
 		o.IsAutoReconnect = isAuto
@@ -1,12 +1,15 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -17,16 +20,41 @@ type Message struct {
 	Type int
 }
 
+// Frame the last data frame received
+type Frame struct {
+	ConnID   uint64
+	Seq      uint64
+	RecvTime time.Time
+	Payload  []byte // decompressed if the decompress handler is set
+}
+
+// lastConnID the id of the physical connection is unique in the process
+var lastConnID uint64
+
 type WsConn struct {
 	conn *websocket.Conn
 	Options
 
 	messageBufferChan chan Message
 	stop              chan struct{}
+	stopOnce          sync.Once
 	lock              sync.Mutex
 	once              sync.Once
+	closing           int32          // set when the connection is closed on purpose, no reconnect any more
+	loops             sync.WaitGroup // read loop and write loop
+
+	lastRecv int64 // unix nano of the last frame received
+	rtt      int64 // round trip time of the last ping
+	avgRtt   int64 // smoothed round trip time
+
+	connID uint64       // the id of the current physical connection
+	seq    uint64       // the sequence number of the last frame, only used by the read loop
+	frame  atomic.Value // Frame
 }
 
+// the max time to wait for the close frame replied by the server
+const closeWaitTime = time.Second * 3
+
 func (w *WsConn) Connect(options ...Option) (err error) {
 	for _, o := range options {
 		o(&w.Options)
@@ -42,11 +70,13 @@ func (w *WsConn) Connect(options ...Option) (err error) {
 	if w.conn, err = w.connect(); err != nil {
 		return err
 	}
+	w.start()
 
 	return
 }
 
 func (w *WsConn) Close() {
+	atomic.StoreInt32(&w.closing, 1)
 	w.once.Do(func() {
 		err := w.conn.Close()
 		if err != nil {
@@ -58,6 +88,81 @@ func (w *WsConn) Close() {
 	})
 }
 
+// Shutdown close the connection gracefully, a close frame is sent and it returns after the read and write loops exited.
+func (w *WsConn) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&w.closing, 1)
+	if w.conn != nil {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(closeWaitTime)
+		}
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		if err := w.conn.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+			log.Printf("[WsConn] %s - send close frame error: %s", w.ExchangeName, err)
+		}
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		w.loops.Wait()
+		close(exited)
+	}()
+	// the read loop exits after the server replied the close frame
+	select {
+	case <-exited:
+	case <-ctx.Done():
+	case <-time.After(closeWaitTime):
+	}
+	w.Close()
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LastReceived when the last frame was received, including the control frames
+func (w *WsConn) LastReceived() time.Time {
+	if t := atomic.LoadInt64(&w.lastRecv); t > 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// PingRTT the round trip time of the last ping and the smoothed one, zero if ping is disabled
+func (w *WsConn) PingRTT() (last, avg time.Duration) {
+	return time.Duration(atomic.LoadInt64(&w.rtt)), time.Duration(atomic.LoadInt64(&w.avgRtt))
+}
+
+// Reconnect drop the underlying connection, it is reconnected if auto reconnect is enabled, otherwise closed
+func (w *WsConn) Reconnect() {
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+// ConnID the id of the current physical connection
+func (w *WsConn) ConnID() uint64 {
+	return atomic.LoadUint64(&w.connID)
+}
+
+// LastFrame the data frame being handled or handled last time
+func (w *WsConn) LastFrame() Frame {
+	frame, _ := w.frame.Load().(Frame)
+	return frame
+}
+
+// Url the url of the connection, it is passed to all of the handlers
+func (w *WsConn) Url() string {
+	return w.wsUrl
+}
+
+func (w *WsConn) isClosing() bool {
+	return atomic.LoadInt32(&w.closing) == 1
+}
+
 func (w *WsConn) SendMessage(msg []byte) {
 	w.messageBufferChan <- Message{Msg: msg, Type: websocket.TextMessage}
 }
@@ -100,12 +205,23 @@ func (w *WsConn) connect() (*websocket.Conn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("[WsConn] %s -  connect host: %s error:%s", w.ExchangeName, w.wsUrl, err)
 	}
+	return conn, err
+}
 
+// start the read and write loop of current connection
+func (w *WsConn) start() {
 	w.stop = make(chan struct{})
+	w.stopOnce = sync.Once{}
 	w.once = sync.Once{}
+	atomic.StoreUint64(&w.connID, atomic.AddUint64(&lastConnID, 1))
+	w.seq = 0
+	w.loops.Add(2)
 	go w.readLoop()
 	go w.writeLoop()
-	return conn, err
+}
+
+func (w *WsConn) stopLoops() {
+	w.stopOnce.Do(func() { close(w.stop) })
 }
 
 func (w *WsConn) reconnect() {
@@ -116,6 +232,9 @@ func (w *WsConn) reconnect() {
 
 	var conn *websocket.Conn
 	for retry := 0; retry < 20; retry++ {
+		if w.isClosing() {
+			return
+		}
 		conn, err = w.connect()
 		if err == nil {
 			break
@@ -130,13 +249,15 @@ func (w *WsConn) reconnect() {
 		return
 	}
 
+	w.conn = conn
+	w.start()
 	if w.reConnectHandler != nil {
 		w.reConnectHandler(w.wsUrl)
 	}
-	w.conn = conn
 }
 
 func (w *WsConn) readLoop() {
+	defer w.loops.Done()
 	log.Printf("[WsConn] %s - start read loop\n", w.ExchangeName)
 
 	w.conn.SetPingHandler(func(appData string) error {
@@ -146,7 +267,13 @@ func (w *WsConn) readLoop() {
 	})
 
 	w.conn.SetPongHandler(func(appData string) error {
-		w.conn.SetReadDeadline(time.Now().Add(w.ReadDeadLineTime))
+		now := time.Now()
+		atomic.StoreInt64(&w.lastRecv, now.UnixNano())
+		w.conn.SetReadDeadline(now.Add(w.ReadDeadLineTime))
+		// the payload of our ping is the time it was sent
+		if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			w.observeRTT(now.Sub(time.Unix(0, sent)))
+		}
 		return nil
 	})
 
@@ -164,13 +291,18 @@ func (w *WsConn) readLoop() {
 			w.conn.SetReadDeadline(time.Now().Add(w.ReadDeadLineTime))
 			t, msg, err := w.conn.ReadMessage()
 			if err != nil {
+				if w.isClosing() {
+					log.Printf("[WsConn] %s - websocket closed, exit read message loop", w.ExchangeName)
+					w.stopLoops()
+					return
+				}
 				log.Printf("[WsConn] %s - read message error:%s", w.ExchangeName, err)
 
 				if w.disConnectedHandler != nil {
 					w.disConnectedHandler(w.wsUrl, err)
 				}
 
-				close(w.stop)
+				w.stopLoops()
 				if w.IsAutoReconnect {
 					w.reconnect()
 				} else {
@@ -178,15 +310,17 @@ func (w *WsConn) readLoop() {
 				}
 				return
 			}
+			now := time.Now()
+			atomic.StoreInt64(&w.lastRecv, now.UnixNano())
 			if w.messageHandler == nil {
 				return
 			}
 			switch t {
 			case websocket.TextMessage:
-				w.messageHandler(w.wsUrl, msg)
+				w.handleFrame(now, msg)
 			case websocket.BinaryMessage:
 				if w.decompressHandler == nil {
-					w.messageHandler(w.wsUrl, msg)
+					w.handleFrame(now, msg)
 				} else {
 					msg2, err := w.decompressHandler(msg)
 					if err != nil {
@@ -194,7 +328,7 @@ func (w *WsConn) readLoop() {
 							w.errorHandler(w.wsUrl, fmt.Errorf("[WsConn] %s - decompress message error:%s", w.ExchangeName, err))
 						}
 					} else {
-						w.messageHandler(w.wsUrl, msg2)
+						w.handleFrame(now, msg2)
 					}
 				}
 			}
@@ -202,12 +336,26 @@ func (w *WsConn) readLoop() {
 	}
 }
 
+// handleFrame remember the frame so it can be attached to the messages parsed from it, then handle it
+func (w *WsConn) handleFrame(recvTime time.Time, msg []byte) {
+	w.seq++
+	w.frame.Store(Frame{ConnID: w.ConnID(), Seq: w.seq, RecvTime: recvTime, Payload: msg})
+	w.messageHandler(w.wsUrl, msg)
+}
+
 func (w *WsConn) writeLoop() {
+	defer w.loops.Done()
 	log.Printf("[WsConn] %s - start write message\n", w.ExchangeName)
 	if w.HeartbeatIntervalTime == 0 {
 		w.HeartbeatIntervalTime = time.Hour
 	}
 	heartTimer := time.NewTimer(w.HeartbeatIntervalTime)
+	var ping <-chan time.Time
+	if w.PingIntervalTime > 0 {
+		pingTicker := time.NewTicker(w.PingIntervalTime)
+		defer pingTicker.Stop()
+		ping = pingTicker.C
+	}
 	for {
 		select {
 		case <-w.stop:
@@ -220,6 +368,11 @@ func (w *WsConn) writeLoop() {
 					w.errorHandler(w.wsUrl, fmt.Errorf("[WsConn] %s - write message error:%s", w.ExchangeName, err))
 				}
 			}
+		case now := <-ping:
+			err := w.conn.WriteMessage(websocket.PingMessage, []byte(strconv.FormatInt(now.UnixNano(), 10)))
+			if err != nil && w.errorHandler != nil {
+				w.errorHandler(w.wsUrl, fmt.Errorf("[WsConn] %s - write ping error:%s", w.ExchangeName, err))
+			}
 		case <-heartTimer.C:
 			if w.heartbeatHandler != nil {
 				go w.heartbeatHandler(w.wsUrl)
@@ -228,3 +381,15 @@ func (w *WsConn) writeLoop() {
 		}
 	}
 }
+
+// observeRTT update the smoothed round trip time like TCP does, avg = 7/8 avg + 1/8 rtt
+func (w *WsConn) observeRTT(rtt time.Duration) {
+	atomic.StoreInt64(&w.rtt, int64(rtt))
+	avg := atomic.LoadInt64(&w.avgRtt)
+	if avg == 0 {
+		avg = int64(rtt)
+	} else {
+		avg += (int64(rtt) - avg) / 8
+	}
+	atomic.StoreInt64(&w.avgRtt, avg)
+}
@@ -0,0 +1,562 @@
+// Package execution works a large order through an IExchange by child limit orders: TWAP slices it evenly in time,
+// VWAP follows a volume profile, and Iceberg shows a small order at the touch and replenishes it.
+// The child orders never cross the limit price, the fills are followed by the order subscription.
+package execution
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+const (
+	DefaultSlices   = 10
+	DefaultInterval = time.Second * 5
+	// maxErrors the consecutive failures of placing the child orders before the execution fails
+	maxErrors = 3
+)
+
+var (
+	ErrStarted = errors.New("execution already started")
+	ErrOptions = errors.New("invalid execution options")
+)
+
+type Algo int
+
+const (
+	TWAP Algo = iota
+	VWAP
+	Iceberg
+)
+
+func (a Algo) String() string {
+	switch a {
+	case TWAP:
+		return "TWAP"
+	case VWAP:
+		return "VWAP"
+	case Iceberg:
+		return "Iceberg"
+	}
+	return fmt.Sprintf("Algo(%d)", int(a))
+}
+
+type State int
+
+const (
+	Pending State = iota
+	Running
+	Paused
+	Done     // the amount is filled
+	Canceled // canceled by Cancel
+	Expired  // the duration is over before the amount is filled
+	Failed   // the child orders can't be placed, Progress.Err tells why
+)
+
+func (s State) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Running:
+		return "running"
+	case Paused:
+		return "paused"
+	case Done:
+		return "done"
+	case Canceled:
+		return "canceled"
+	case Expired:
+		return "expired"
+	case Failed:
+		return "failed"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Finished whether the execution has ended
+func (s State) Finished() bool {
+	return s >= Done
+}
+
+type Options struct {
+	Algo       Algo
+	Symbol     string
+	Side       ExchangeApi.Side
+	Amount     float64
+	LimitPrice float64 // the buys are never placed above it and the sells never below it, no limit if 0
+
+	Duration time.Duration // TWAP and VWAP: the time to work the order over. Iceberg: the time limit, none if 0
+	Slices   int           // TWAP: the number of child orders, DefaultSlices if 0
+	Profile  []float64     // VWAP: the weights of the slices of Duration, eg. from VolumeProfile
+	Visible  float64       // Iceberg: the amount shown at once
+	Interval time.Duration // Iceberg: how often the order is checked against the touch, DefaultInterval if 0
+}
+
+// Progress the state of an execution, it is the data of the MsgOrder sent by the execution
+type Progress struct {
+	Algo   Algo
+	Symbol string
+	Side   ExchangeApi.Side
+	State  State
+	Amount float64 // the whole amount
+	Filled float64
+	Cost   float64 // the quote amount of the fills
+	Target float64 // the amount the schedule wants filled by now
+	Orders int     // the child orders placed
+	Err    error   // the last error of placing or canceling a child order
+}
+
+// AvgPrice the average price of the fills
+func (p Progress) AvgPrice() float64 {
+	if p.Filled == 0 {
+		return 0
+	}
+	return p.Cost / p.Filled
+}
+
+func (p Progress) Remaining() float64 {
+	return math.Max(p.Amount-p.Filled, 0)
+}
+
+// differs whether the progress has changed, the errors are told by their messages
+func (p Progress) differs(q Progress) bool {
+	if p.State != q.State || p.Filled != q.Filled || p.Cost != q.Cost || p.Target != q.Target || p.Orders != q.Orders {
+		return true
+	}
+	return (p.Err == nil) != (q.Err == nil) || p.Err != nil && p.Err.Error() != q.Err.Error()
+}
+
+// child the child order working
+type child struct {
+	id       string
+	price    float64
+	slice    int // the slice of TWAP or VWAP it was placed in
+	filled   float64
+	cost     float64
+	finished bool
+}
+
+// Execution an algorithm working an order, it is safe for concurrent use
+type Execution struct {
+	exchange ExchangeApi.IExchange
+	options  Options
+	sums     []float64 // the cumulative weights of the slices of TWAP and VWAP
+	market   ExchangeApi.Market
+	rules    bool // whether the market is known, so the child orders are normalized by it
+
+	lock     sync.Mutex
+	state    State
+	start    time.Time
+	child    *child
+	filled   float64 // of the finished child orders
+	cost     float64
+	target   float64
+	orders   int
+	errs     int // the consecutive failures
+	err      error
+	msgChan  ExchangeApi.MessageChan
+	out      ExchangeApi.MessageChan
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	listened sync.WaitGroup
+	reported Progress // the last progress sent, only used by run
+
+	// the listener never waits for lock, which is held during the requests of run while the exchange may be
+	// publishing to it, the order events are queued and applied by run
+	queueLock sync.Mutex
+	queue     []ExchangeApi.Order
+	sub       *ExchangeApi.Resubscription
+}
+
+func New(exchange ExchangeApi.IExchange, options Options) (*Execution, error) {
+	if options.Symbol == "" || options.Amount <= 0 || options.LimitPrice < 0 {
+		return nil, ErrOptions
+	}
+	e := &Execution{exchange: exchange, wake: make(chan struct{}, 1), done: make(chan struct{}), stop: make(chan struct{})}
+	switch options.Algo {
+	case TWAP:
+		if options.Duration <= 0 {
+			return nil, ErrOptions
+		}
+		if options.Slices <= 0 {
+			options.Slices = DefaultSlices
+		}
+		e.sums = cumulate(even(options.Slices))
+	case VWAP:
+		if options.Duration <= 0 || len(options.Profile) == 0 {
+			return nil, ErrOptions
+		}
+		e.sums = cumulate(options.Profile)
+		if math.IsNaN(e.sums[len(e.sums)-1]) {
+			return nil, ErrOptions
+		}
+	case Iceberg:
+		if options.Visible <= 0 {
+			return nil, ErrOptions
+		}
+		if options.Interval <= 0 {
+			options.Interval = DefaultInterval
+		}
+	default:
+		return nil, ErrOptions
+	}
+	e.options = options
+	return e, nil
+}
+
+// Start work the order, the progress is sent to out after each change as MsgOrder with the Progress, out may be nil
+func (e *Execution) Start(out ExchangeApi.MessageChan) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.state != Pending {
+		return ErrStarted
+	}
+	if markets, err := e.exchange.FetchMarkets(); err == nil {
+		e.market, e.rules = markets[e.options.Symbol]
+	}
+	e.msgChan = make(ExchangeApi.MessageChan)
+	msgChan := e.msgChan
+	sub, err := ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+		return e.exchange.SubscribeOrder(e.options.Symbol, msgChan)
+	})
+	if err != nil {
+		return err
+	}
+	e.sub, e.out = sub, out
+	e.state, e.start = Running, time.Now()
+	e.listened.Add(1)
+	go e.listen()
+	go e.run()
+	return nil
+}
+
+// Pause cancel the child order and place no more until Resume, the schedule of TWAP and VWAP keeps going
+func (e *Execution) Pause() {
+	e.setState(Running, Paused)
+}
+
+func (e *Execution) Resume() {
+	e.setState(Paused, Running)
+}
+
+// Cancel cancel the child order and end the execution, it returns once it has ended
+func (e *Execution) Cancel() {
+	e.lock.Lock()
+	switch {
+	case e.state == Pending:
+		e.state = Canceled
+		close(e.done)
+	case !e.state.Finished():
+		e.state = Canceled
+	}
+	e.lock.Unlock()
+	e.signal()
+	<-e.done
+}
+
+// Done closed once the execution has ended
+func (e *Execution) Done() <-chan struct{} {
+	return e.done
+}
+
+func (e *Execution) Progress() Progress {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.progress()
+}
+
+func (e *Execution) progress() Progress {
+	p := Progress{
+		Algo:   e.options.Algo,
+		Symbol: e.options.Symbol,
+		Side:   e.options.Side,
+		State:  e.state,
+		Amount: e.options.Amount,
+		Filled: e.filled,
+		Cost:   e.cost,
+		Target: e.target,
+		Orders: e.orders,
+		Err:    e.err,
+	}
+	if e.child != nil {
+		p.Filled += e.child.filled
+		p.Cost += e.child.cost
+	}
+	return p
+}
+
+func (e *Execution) setState(from, to State) {
+	e.lock.Lock()
+	changed := e.state == from
+	if changed {
+		e.state = to
+	}
+	e.lock.Unlock()
+	if changed {
+		e.signal()
+	}
+}
+
+func (e *Execution) signal() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Execution) run() {
+	defer close(e.done)
+	interval := e.options.Interval
+	if e.options.Algo != Iceberg {
+		interval = e.options.Duration / time.Duration(len(e.sums))
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for !e.step(time.Now()) {
+		select {
+		case <-ticker.C:
+		case <-e.wake:
+		}
+	}
+	// unsubscribe before the listener exits so the exchange isn't left publishing to it
+	e.sub.Close()
+	close(e.stop)
+	e.listened.Wait()
+}
+
+// step bring the child order in line with the state, the schedule and the touch, it returns true once the execution ends
+func (e *Execution) step(now time.Time) bool {
+	e.queueLock.Lock()
+	queue := e.queue
+	e.queue = nil
+	e.queueLock.Unlock()
+	e.lock.Lock()
+	for _, order := range queue {
+		e.apply(order)
+	}
+	finished := e.advance(now)
+	p := e.progress()
+	e.lock.Unlock()
+	if finished || p.differs(e.reported) {
+		e.reported = p
+		e.emit(p)
+	}
+	return finished
+}
+
+// advance must be called with the lock held
+func (e *Execution) advance(now time.Time) bool {
+	if e.child != nil && e.child.finished {
+		e.settle()
+	}
+	if e.state == Paused {
+		e.withdraw()
+		return false
+	}
+	if e.state.Finished() {
+		// the child order is canceled until the exchange accepts
+		return e.withdraw()
+	}
+	remaining := e.options.Amount - e.progress().Filled
+	if remaining <= e.options.Amount*1e-9 || e.rules && e.market.NormalizeAmount(remaining) <= 0 {
+		e.state = Done
+		return e.withdraw()
+	}
+	elapsed := now.Sub(e.start)
+	if e.options.Duration > 0 && elapsed >= e.options.Duration {
+		e.state = Expired
+		return e.withdraw()
+	}
+
+	slice := 0
+	e.target = e.options.Amount
+	if e.options.Algo != Iceberg {
+		slice = int(elapsed * time.Duration(len(e.sums)) / e.options.Duration)
+		if slice >= len(e.sums) {
+			slice = len(e.sums) - 1
+		}
+		e.target = e.options.Amount * e.sums[slice]
+	}
+	price, err := e.price()
+	if err != nil {
+		return e.fail(err)
+	}
+	if e.rules {
+		price = e.market.NormalizePrice(price, ExchangeApi.PassiveRounding(e.options.Side))
+	}
+	if e.child != nil {
+		// the child order stays until the next slice, or until the touch moves away from an iceberg
+		stay := e.child.slice == slice
+		if e.options.Algo == Iceberg {
+			stay = e.child.price == price
+		}
+		if stay || !e.withdraw() {
+			return false
+		}
+	}
+
+	amount := math.Min(e.target-e.filled, remaining)
+	if e.options.Algo == Iceberg {
+		amount = math.Min(e.options.Visible, remaining)
+	}
+	if amount <= 0 {
+		return false
+	}
+	if e.rules {
+		p, a, err := e.market.NormalizeOrder(price, amount, e.options.Side, ExchangeApi.LIMIT)
+		if err != nil {
+			rule, _ := err.(ExchangeApi.ExError).Data["rule"]
+			if rule != ExchangeApi.RuleMinQty && rule != ExchangeApi.RuleMinNotional || e.options.Algo == Iceberg && amount < remaining {
+				return e.fail(err)
+			}
+			// a slice too small is carried over to the next one, the rest too small ends the execution
+			if amount >= remaining {
+				e.state, e.err = Done, err
+				return true
+			}
+			return false
+		}
+		price, amount = p, a
+	}
+	order, err := e.exchange.CreateOrder(e.options.Symbol, price, amount, e.options.Side, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	if err != nil {
+		return e.fail(err)
+	}
+	e.errs = 0
+	e.orders++
+	e.child = &child{id: order.ID, price: price, slice: slice}
+	return false
+}
+
+// price the price of the child order at the touch: a TWAP or VWAP slice takes the opposite side, an iceberg rests on
+// its own side. It is capped by the limit price.
+func (e *Execution) price() (float64, error) {
+	book, err := e.exchange.FetchOrderBook(e.options.Symbol, 5)
+	if err != nil {
+		return 0, err
+	}
+	buy := e.buying()
+	levels := book.Bids
+	if buy == (e.options.Algo != Iceberg) {
+		levels = book.Asks
+	}
+	if len(levels) == 0 {
+		return 0, ExchangeApi.ExError{Code: ExchangeApi.ErrInvalidDepth, Message: "no touch of " + e.options.Symbol}
+	}
+	price := SafeParseFloat(levels[0].Price)
+	if limit := e.options.LimitPrice; limit > 0 {
+		if buy {
+			price = math.Min(price, limit)
+		} else {
+			price = math.Max(price, limit)
+		}
+	}
+	return price, nil
+}
+
+func (e *Execution) buying() bool {
+	switch e.options.Side {
+	case ExchangeApi.Buy, ExchangeApi.OpenLong, ExchangeApi.CloseShort:
+		return true
+	}
+	return false
+}
+
+// fail count the failure, it returns true once the execution has failed and its child order is canceled
+func (e *Execution) fail(err error) bool {
+	e.err = err
+	e.errs++
+	if e.errs < maxErrors {
+		return false
+	}
+	e.state = Failed
+	return e.withdraw()
+}
+
+// withdraw cancel the child order, it returns true if there's no child order working any more
+func (e *Execution) withdraw() bool {
+	c := e.child
+	if c == nil {
+		return true
+	}
+	var cancelErr error
+	if !c.finished {
+		if cancelErr = e.exchange.CancelOrder(e.options.Symbol, c.id); cancelErr != nil {
+			e.err = cancelErr
+		}
+	}
+	// the fills up to the cancel
+	if order, err := e.exchange.FetchOrder(e.options.Symbol, c.id); err == nil {
+		e.apply(order)
+	}
+	if cancelErr != nil && !c.finished {
+		// the order may still be working, it's canceled again at the next step
+		return false
+	}
+	e.settle()
+	return true
+}
+
+// settle move the fills of the child order to the execution
+func (e *Execution) settle() {
+	e.filled += e.child.filled
+	e.cost += e.child.cost
+	e.child = nil
+}
+
+// apply the state of the child order, the events may come out of order
+func (e *Execution) apply(order ExchangeApi.Order) {
+	c := e.child
+	if c == nil || order.ID != c.id {
+		return
+	}
+	if filled := SafeParseFloat(order.Filled); filled > c.filled {
+		c.filled = filled
+		c.cost = SafeParseFloat(order.Cost)
+		if c.cost == 0 {
+			c.cost = filled * c.price
+		}
+	}
+	switch order.Status {
+	case ExchangeApi.Close, ExchangeApi.Canceled, ExchangeApi.Rejected:
+		c.finished = true
+	}
+}
+
+func (e *Execution) listen() {
+	defer e.listened.Done()
+	for {
+		select {
+		case msg := <-e.msgChan:
+			switch msg.Type {
+			case ExchangeApi.MsgOrder:
+				order, ok := msg.Data.(ExchangeApi.Order)
+				if !ok {
+					continue
+				}
+				e.queueLock.Lock()
+				e.queue = append(e.queue, order)
+				e.queueLock.Unlock()
+				e.signal()
+			case ExchangeApi.MsgReConnected:
+				// the fills in between are fetched by the steps
+				e.sub.Renew()
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// emit the progress is sent in order by the goroutine of run, out must be read until the execution ends
+func (e *Execution) emit(p Progress) {
+	if e.out != nil {
+		e.out <- ExchangeApi.Message{Type: ExchangeApi.MsgOrder, Data: p}
+	}
+}
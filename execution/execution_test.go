@@ -0,0 +1,192 @@
+package execution
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+func testExchange() *mock.Exchange {
+	exchange := mock.New("binance")
+	exchange.SetOrderBook(ExchangeApi.OrderBook{
+		Symbol: "BTC/USDT",
+		Bids:   ExchangeApi.Depth{{Price: "99", Amount: "1"}},
+		Asks:   ExchangeApi.Depth{{Price: "101", Amount: "1"}},
+	})
+	return exchange
+}
+
+func openOrders(t *testing.T, exchange *mock.Exchange) []ExchangeApi.Order {
+	t.Helper()
+	orders, err := exchange.FetchOpenOrders("BTC/USDT", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orders
+}
+
+// drain read the progress until the execution ends, fill is called with the open orders after each change
+func drain(t *testing.T, out ExchangeApi.MessageChan, fill func(p Progress)) Progress {
+	t.Helper()
+	for {
+		select {
+		case msg := <-out:
+			p := msg.Data.(Progress)
+			if p.State.Finished() {
+				return p
+			}
+			if fill != nil {
+				fill(p)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal("the execution is not finished in time")
+		}
+	}
+}
+
+func TestTWAP(t *testing.T) {
+	exchange := testExchange()
+	e, err := New(exchange, Options{Algo: TWAP, Symbol: "BTC/USDT", Side: ExchangeApi.Buy, Amount: 1, LimitPrice: 100.5, Duration: time.Millisecond * 200, Slices: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(ExchangeApi.MessageChan)
+	if err := e.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	p := drain(t, out, func(p Progress) {
+		for _, order := range openOrders(t, exchange) {
+			// the ask of 101 is over the limit
+			if order.Price != "100.5" || order.Amount != "0.25" {
+				t.Errorf("unexpected child order %+v", order)
+			}
+			exchange.Fill(order.ID, 100.5, 0.25)
+		}
+	})
+	if p.State != Done || !testutil.Near(p.Filled, 1) || p.Orders != 4 || !testutil.Near(p.AvgPrice(), 100.5) {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+}
+
+func TestTWAP_Expired(t *testing.T) {
+	exchange := testExchange()
+	e, _ := New(exchange, Options{Algo: TWAP, Symbol: "BTC/USDT", Side: ExchangeApi.Sell, Amount: 1, Duration: time.Millisecond * 100, Slices: 2})
+	out := make(ExchangeApi.MessageChan)
+	e.Start(out)
+	var amounts []string
+	p := drain(t, out, func(p Progress) {
+		for _, order := range openOrders(t, exchange) {
+			if len(amounts) == 0 || amounts[len(amounts)-1] != order.Amount {
+				amounts = append(amounts, order.Amount)
+			}
+		}
+	})
+	// the unfilled first slice is carried over to the second one
+	if p.State != Expired || p.Filled != 0 || len(amounts) != 2 || amounts[0] != "0.5" || amounts[1] != "1" {
+		t.Fatalf("unexpected progress %+v %v", p, amounts)
+	}
+	if len(openOrders(t, exchange)) != 0 {
+		t.Fatal("the child order is not canceled")
+	}
+}
+
+func TestVWAP(t *testing.T) {
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := func(t time.Time) time.Duration { return time.Duration(t.UnixNano() / 1e6) }
+	klines := []ExchangeApi.KLine{
+		{Timestamp: ms(day.Add(time.Hour)), Volume: 30},
+		{Timestamp: ms(day.Add(time.Hour * 2)), Volume: 10},
+		{Timestamp: ms(day.Add(time.Hour * 25)), Volume: 30}, // the next day
+		{Timestamp: ms(day.Add(time.Hour * 5)), Volume: 100}, // out of the window
+	}
+	profile := VolumeProfile(klines, day.Add(time.Hour*24*30+time.Hour), time.Hour*2, 2)
+	if len(profile) != 2 || !testutil.Near(profile[0], 60.0/70) || !testutil.Near(profile[1], 10.0/70) {
+		t.Fatalf("unexpected profile %v", profile)
+	}
+	if even := VolumeProfile(nil, day, time.Hour, 4); !testutil.Near(even[3], 0.25) {
+		t.Fatalf("unexpected even profile %v", even)
+	}
+
+	exchange := testExchange()
+	e, _ := New(exchange, Options{Algo: VWAP, Symbol: "BTC/USDT", Side: ExchangeApi.Buy, Amount: 1, Duration: time.Millisecond * 100, Profile: []float64{3, 1}})
+	out := make(ExchangeApi.MessageChan)
+	e.Start(out)
+	var first string
+	drain(t, out, func(p Progress) {
+		if orders := openOrders(t, exchange); first == "" && len(orders) > 0 {
+			first = orders[0].Amount
+		}
+	})
+	if first != "0.75" {
+		t.Fatalf("unexpected first slice %s", first)
+	}
+}
+
+func TestIceberg(t *testing.T) {
+	exchange := testExchange()
+	e, _ := New(exchange, Options{Algo: Iceberg, Symbol: "BTC/USDT", Side: ExchangeApi.Sell, Amount: 1, Visible: 0.4, LimitPrice: 100, Interval: time.Millisecond * 10})
+	out := make(ExchangeApi.MessageChan, 100)
+	if err := e.Start(out); err != nil {
+		t.Fatal(err)
+	}
+	// the mock has no market rules, so the amounts aren't rounded
+	waitOpen := func(price string, amount float64) ExchangeApi.Order {
+		t.Helper()
+		deadline := time.Now().Add(time.Second * 3)
+		for time.Now().Before(deadline) {
+			if orders := openOrders(t, exchange); len(orders) == 1 && orders[0].Price == price && testutil.Near(SafeParseFloat(orders[0].Amount), amount) {
+				return orders[0]
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("no open order of %v at %s: %+v", amount, price, openOrders(t, exchange))
+		return ExchangeApi.Order{}
+	}
+
+	// it rests at the ask, and replenishes when filled
+	order := waitOpen("101", 0.4)
+	exchange.Fill(order.ID, 101, 0.4)
+	order = waitOpen("101", 0.4)
+	// it follows the touch but not below the limit
+	exchange.SetOrderBook(ExchangeApi.OrderBook{
+		Symbol: "BTC/USDT",
+		Bids:   ExchangeApi.Depth{{Price: "98", Amount: "1"}},
+		Asks:   ExchangeApi.Depth{{Price: "99", Amount: "1"}},
+	})
+	order = waitOpen("100", 0.4)
+
+	e.Pause()
+	// the child order is canceled by Pause
+	testutil.WaitFor(t, func() bool { return len(openOrders(t, exchange)) == 0 })
+	e.Resume()
+	order = waitOpen("100", 0.4)
+	exchange.Fill(order.ID, 100, 0.4)
+	order = waitOpen("100", 0.2)
+	exchange.Fill(order.ID, 100, 0.1)
+
+	e.Cancel()
+	p := e.Progress()
+	if p.State != Canceled || !testutil.Near(p.Filled, 0.9) || !testutil.Near(p.Cost, 0.4*101+0.5*100) {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+	if len(openOrders(t, exchange)) != 0 {
+		t.Fatal("the child order is not canceled by Cancel")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, options := range []Options{
+		{Algo: TWAP, Symbol: "BTC/USDT", Amount: 1},
+		{Algo: VWAP, Symbol: "BTC/USDT", Amount: 1, Duration: time.Minute, Profile: []float64{0, 0}},
+		{Algo: Iceberg, Symbol: "BTC/USDT", Amount: 1},
+		{Algo: TWAP, Amount: 1, Duration: time.Minute},
+	} {
+		if _, err := New(mock.New("binance"), options); err != ErrOptions {
+			t.Errorf("expected ErrOptions of %+v, got %v", options, err)
+		}
+	}
+}
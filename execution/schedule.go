@@ -0,0 +1,69 @@
+package execution
+
+import (
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// VolumeProfile the weights of the slices of the window beginning at start, from the volume of the klines at the same
+// time of the day (UTC), eg. the klines of the last days. The window is up to a day, the klines out of it are ignored.
+// The weights are even if the klines have no volume in the window.
+func VolumeProfile(klines []ExchangeApi.KLine, start time.Time, duration time.Duration, slices int) []float64 {
+	if slices <= 0 {
+		return nil
+	}
+	const day = time.Hour * 24
+	if duration <= 0 || duration > day {
+		duration = day
+	}
+	offset := time.Duration(start.UnixNano()) % day
+	weights := make([]float64, slices)
+	var total float64
+	for _, k := range klines {
+		// the timestamps of the klines are in milliseconds
+		at := time.Duration(int64(k.Timestamp)*int64(time.Millisecond)) % day
+		d := (at - offset + day) % day
+		if d >= duration || k.Volume <= 0 {
+			continue
+		}
+		weights[int(d*time.Duration(slices)/duration)] += k.Volume
+		total += k.Volume
+	}
+	for i := range weights {
+		if total == 0 {
+			weights[i] = 1 / float64(slices)
+		} else {
+			weights[i] /= total
+		}
+	}
+	return weights
+}
+
+// cumulate the running sums of the weights, normalized so the last one is 1
+func cumulate(weights []float64) []float64 {
+	var total float64
+	for _, w := range weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	sums := make([]float64, len(weights))
+	var sum float64
+	for i, w := range weights {
+		if w > 0 {
+			sum += w
+		}
+		sums[i] = sum / total
+	}
+	return sums
+}
+
+// even the weights of n equal slices
+func even(n int) []float64 {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return weights
+}
@@ -0,0 +1,140 @@
+package indicator
+
+import (
+	"math"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// SMA the simple moving average of the close prices
+type SMA struct {
+	series
+	w window
+}
+
+// NewSMA the period less than 1 is taken as 1
+func NewSMA(n int) *SMA {
+	return &SMA{w: window{n: period(n)}}
+}
+
+func (s *SMA) Update(kline ExchangeApi.KLine) (float64, bool) {
+	s.next(kline, func(last ExchangeApi.KLine) { s.w.push(last.Close) })
+	return s.Value()
+}
+
+func (s *SMA) Value() (float64, bool) {
+	if !s.started {
+		return 0, false
+	}
+	mean, _, ok := s.w.mean(s.last.Close)
+	return mean, ok
+}
+
+// EMA the exponential moving average of the close prices, seeded by the SMA of the first n klines
+type EMA struct {
+	series
+	e ema
+}
+
+func NewEMA(n int) *EMA {
+	n = period(n)
+	return &EMA{e: newEMA(n, 2/float64(n+1))}
+}
+
+func (e *EMA) Update(kline ExchangeApi.KLine) (float64, bool) {
+	e.next(kline, func(last ExchangeApi.KLine) { e.e.commit(last.Close) })
+	return e.Value()
+}
+
+func (e *EMA) Value() (float64, bool) {
+	if !e.started {
+		return 0, false
+	}
+	return e.e.next(e.last.Close)
+}
+
+// Bands the Bollinger bands
+type Bands struct {
+	Middle float64
+	Upper  float64
+	Lower  float64
+}
+
+// Bollinger the SMA of the close prices and the bands of k standard deviations around it
+type Bollinger struct {
+	series
+	k float64
+	w window
+}
+
+func NewBollinger(n int, k float64) *Bollinger {
+	return &Bollinger{k: k, w: window{n: period(n)}}
+}
+
+func (b *Bollinger) Update(kline ExchangeApi.KLine) (Bands, bool) {
+	b.next(kline, func(last ExchangeApi.KLine) { b.w.push(last.Close) })
+	return b.Value()
+}
+
+func (b *Bollinger) Value() (Bands, bool) {
+	if !b.started {
+		return Bands{}, false
+	}
+	mean, sd, ok := b.w.mean(b.last.Close)
+	if !ok {
+		return Bands{}, false
+	}
+	return Bands{Middle: mean, Upper: mean + b.k*sd, Lower: mean - b.k*sd}, true
+}
+
+// ComputeBollinger the bands aligned with the klines, NaN until ready
+func ComputeBollinger(n int, k float64, klines []ExchangeApi.KLine) []Bands {
+	b := NewBollinger(n, k)
+	bands := make([]Bands, len(klines))
+	for i, kline := range klines {
+		v, ok := b.Update(kline)
+		if !ok {
+			v = Bands{Middle: math.NaN(), Upper: math.NaN(), Lower: math.NaN()}
+		}
+		bands[i] = v
+	}
+	return bands
+}
+
+// VWAP the volume weighted average of the typical prices (high+low+close)/3
+type VWAP struct {
+	series
+	pv  window
+	vol window
+}
+
+// NewVWAP the average of the last n klines, or of all the klines if n is 0
+func NewVWAP(n int) *VWAP {
+	if n < 0 {
+		n = 0
+	}
+	return &VWAP{pv: window{n: n}, vol: window{n: n}}
+}
+
+func (v *VWAP) Update(kline ExchangeApi.KLine) (float64, bool) {
+	v.next(kline, func(last ExchangeApi.KLine) {
+		v.pv.push(typical(last) * last.Volume)
+		v.vol.push(last.Volume)
+	})
+	return v.Value()
+}
+
+func (v *VWAP) Value() (float64, bool) {
+	if !v.started || !v.vol.full() {
+		return 0, false
+	}
+	volume := v.vol.sum + v.last.Volume
+	if volume <= 0 {
+		return 0, false
+	}
+	return (v.pv.sum + typical(v.last)*v.last.Volume) / volume, true
+}
+
+func typical(kline ExchangeApi.KLine) float64 {
+	return (kline.High + kline.Low + kline.Close) / 3
+}
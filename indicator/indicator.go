@@ -0,0 +1,153 @@
+// Package indicator computes the technical indicators of the kline series, in batch or incrementally.
+// An indicator is updated by each kline: a kline of the same timestamp as the last one replaces it, so the candle in progress
+// can be fed on every update, a newer kline closes the last one, and an older kline is ignored.
+package indicator
+
+import (
+	"math"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/candle"
+)
+
+// Indicator an indicator of a single value, the value is not ready until enough klines are fed
+type Indicator interface {
+	Update(kline ExchangeApi.KLine) (float64, bool)
+	Value() (float64, bool)
+}
+
+// Compute feed the klines to the indicator, the values are aligned with the klines and NaN until the indicator is ready
+func Compute(indicator Indicator, klines []ExchangeApi.KLine) []float64 {
+	values := make([]float64, len(klines))
+	for i, kline := range klines {
+		v, ok := indicator.Update(kline)
+		if !ok {
+			v = math.NaN()
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// KLines the klines carried by a MsgKLine message, which is a KLine, []KLine or candle.Candle
+func KLines(msg ExchangeApi.Message) []ExchangeApi.KLine {
+	if msg.Type != ExchangeApi.MsgKLine {
+		return nil
+	}
+	switch data := msg.Data.(type) {
+	case ExchangeApi.KLine:
+		return []ExchangeApi.KLine{data}
+	case []ExchangeApi.KLine:
+		return data
+	case candle.Candle:
+		return []ExchangeApi.KLine{data.KLine}
+	}
+	return nil
+}
+
+// series the last kline fed, which is not committed to the state of an indicator until a newer one arrives
+type series struct {
+	last    ExchangeApi.KLine
+	started bool
+}
+
+// next take the kline as the last one, commit is called with the previous last kline if the kline is newer.
+// It returns false if the kline is older than the last one.
+func (s *series) next(kline ExchangeApi.KLine, commit func(ExchangeApi.KLine)) bool {
+	switch {
+	case !s.started:
+		s.started = true
+	case kline.Timestamp > s.last.Timestamp:
+		commit(s.last)
+	case kline.Timestamp < s.last.Timestamp:
+		return false
+	}
+	s.last = kline
+	return true
+}
+
+// Timestamp the timestamp of the last kline fed
+func (s *series) Timestamp() time.Duration {
+	return s.last.Timestamp
+}
+
+// window the sums of the committed values of the last n-1 klines, the value of the last kline completes it
+type window struct {
+	n      int // 0 for all the values
+	values []float64
+	sum    float64
+	sumSq  float64
+}
+
+func (w *window) push(v float64) {
+	w.sum += v
+	w.sumSq += v * v
+	if w.n == 0 {
+		return
+	}
+	w.values = append(w.values, v)
+	if len(w.values) > w.n-1 {
+		old := w.values[0]
+		w.values = w.values[1:]
+		w.sum -= old
+		w.sumSq -= old * old
+	}
+}
+
+func (w *window) full() bool {
+	return w.n == 0 || len(w.values) == w.n-1
+}
+
+// mean the mean and the population standard deviation of the window completed by v
+func (w *window) mean(v float64) (mean, sd float64, ok bool) {
+	if !w.full() {
+		return 0, 0, false
+	}
+	n := float64(w.n)
+	mean = (w.sum + v) / n
+	variance := (w.sumSq+v*v)/n - mean*mean
+	// the rounding of the running sums may turn a zero variance negative
+	return mean, math.Sqrt(math.Max(variance, 0)), true
+}
+
+// ema an exponential moving average seeded by the simple average of the first n values
+type ema struct {
+	n     int
+	alpha float64
+	count int
+	sum   float64
+	value float64
+}
+
+func newEMA(n int, alpha float64) ema {
+	return ema{n: n, alpha: alpha}
+}
+
+// next the average completed by v
+func (e *ema) next(v float64) (float64, bool) {
+	switch count := e.count + 1; {
+	case count < e.n:
+		return 0, false
+	case count == e.n:
+		return (e.sum + v) / float64(e.n), true
+	}
+	return e.alpha*v + (1-e.alpha)*e.value, true
+}
+
+func (e *ema) commit(v float64) {
+	value, ok := e.next(v)
+	if ok {
+		e.value = value
+	} else {
+		e.sum += v
+	}
+	e.count++
+}
+
+func period(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
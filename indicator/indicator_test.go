@@ -0,0 +1,114 @@
+package indicator
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/candle"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+func klines(closes ...float64) []ExchangeApi.KLine {
+	var klines []ExchangeApi.KLine
+	for i, c := range closes {
+		klines = append(klines, ExchangeApi.KLine{
+			Timestamp: time.Duration(i) * time.Minute,
+			Open:      c, Close: c, High: c + 1, Low: c - 1, Volume: float64(i + 1),
+		})
+	}
+	return klines
+}
+
+func TestCompute(t *testing.T) {
+	series := klines(1, 2, 3, 4, 5, 6)
+	sma := Compute(NewSMA(3), series)
+	if !math.IsNaN(sma[1]) || !testutil.Near(sma[2], 2) || !testutil.Near(sma[5], 5) {
+		t.Errorf("unexpected sma %v", sma)
+	}
+	// seeded by the sma 2 of the first 3, then 0.5*4+0.5*2
+	ema := Compute(NewEMA(3), series)
+	if !math.IsNaN(ema[1]) || !testutil.Near(ema[2], 2) || !testutil.Near(ema[3], 3) {
+		t.Errorf("unexpected ema %v", ema)
+	}
+	if rsi := Compute(NewRSI(3), series); !math.IsNaN(rsi[2]) || !testutil.Near(rsi[3], 100) {
+		t.Errorf("unexpected rsi %v", rsi)
+	}
+	if rsi := Compute(NewRSI(2), klines(1, 2, 1, 2)); !testutil.Near(rsi[2], 50) || !testutil.Near(rsi[3], 75) {
+		t.Errorf("unexpected rsi %v", rsi)
+	}
+	// the true range of each kline is 2, extended to the previous close by 1
+	if atr := Compute(NewATR(2), series); !testutil.Near(atr[1], 2) || !testutil.Near(atr[5], 2) {
+		t.Errorf("unexpected atr %v", atr)
+	}
+	// the typical price is the close, weighted by the volume 1, 2
+	if vwap := Compute(NewVWAP(0), series); !testutil.Near(vwap[1], 5.0/3) {
+		t.Errorf("unexpected vwap %v", vwap)
+	}
+	bands := ComputeBollinger(2, 2, series)
+	if !math.IsNaN(bands[0].Middle) || !testutil.Near(bands[1].Middle, 1.5) || !testutil.Near(bands[1].Upper, 2.5) || !testutil.Near(bands[1].Lower, 0.5) {
+		t.Errorf("unexpected bands %v", bands)
+	}
+	macd := ComputeMACD(2, 3, 2, series)
+	if !math.IsNaN(macd[2].MACD) || math.IsNaN(macd[3].MACD) || !testutil.Near(macd[3].Histogram, macd[3].MACD-macd[3].Signal) {
+		t.Errorf("unexpected macd %v", macd)
+	}
+}
+
+// TestReplace the in-progress klines replaced by the updates must give the same values as the final klines
+func TestReplace(t *testing.T) {
+	final := klines(10, 12, 11, 15, 14, 13, 16, 18, 17, 19, 20, 18)
+	indicators := map[string]func() Indicator{
+		"sma":  func() Indicator { return NewSMA(4) },
+		"ema":  func() Indicator { return NewEMA(4) },
+		"rsi":  func() Indicator { return NewRSI(4) },
+		"atr":  func() Indicator { return NewATR(4) },
+		"vwap": func() Indicator { return NewVWAP(3) },
+	}
+	for name, newIndicator := range indicators {
+		batch := Compute(newIndicator(), final)
+		stream := newIndicator()
+		for i, kline := range final {
+			partial := kline
+			partial.Close, partial.High, partial.Volume = kline.Close*2, kline.High*2, kline.Volume/2
+			stream.Update(partial)
+			v, ok := stream.Update(kline)
+			if ok == math.IsNaN(batch[i]) || ok && !testutil.Near(v, batch[i]) {
+				t.Fatalf("%s: value %v %v of kline %d, expected %v", name, v, ok, i, batch[i])
+			}
+		}
+		// an older kline is ignored
+		if v, _ := stream.Update(final[0]); !testutil.Near(v, batch[len(batch)-1]) {
+			t.Errorf("%s: the older kline changed the value to %v", name, v)
+		}
+	}
+
+	macd := NewMACD(3, 5, 3)
+	batch := ComputeMACD(3, 5, 3, final)
+	for i, kline := range final {
+		partial := kline
+		partial.Close = 0
+		macd.Update(partial)
+		if v, ok := macd.Update(kline); ok && v != batch[i] || !ok && !math.IsNaN(batch[i].MACD) {
+			t.Fatalf("macd: value %v of kline %d, expected %v", v, i, batch[i])
+		}
+	}
+}
+
+func TestKLines(t *testing.T) {
+	kline := ExchangeApi.KLine{Close: 1}
+	msgs := []ExchangeApi.Message{
+		{Type: ExchangeApi.MsgKLine, Data: kline},
+		{Type: ExchangeApi.MsgKLine, Data: []ExchangeApi.KLine{kline}},
+		{Type: ExchangeApi.MsgKLine, Data: candle.Candle{KLine: kline}},
+	}
+	for _, msg := range msgs {
+		if k := KLines(msg); len(k) != 1 || k[0] != kline {
+			t.Errorf("unexpected klines %v of %+v", k, msg.Data)
+		}
+	}
+	if k := KLines(ExchangeApi.Message{Type: ExchangeApi.MsgTrade}); k != nil {
+		t.Errorf("unexpected klines %v", k)
+	}
+}
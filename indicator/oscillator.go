@@ -0,0 +1,165 @@
+package indicator
+
+import (
+	"math"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// RSI the relative strength index of the close prices with the Wilder smoothing
+type RSI struct {
+	series
+	prev    float64 // the close of the last committed kline
+	hasPrev bool
+	gain    ema
+	loss    ema
+}
+
+func NewRSI(n int) *RSI {
+	n = period(n)
+	return &RSI{gain: newEMA(n, 1/float64(n)), loss: newEMA(n, 1/float64(n))}
+}
+
+func (r *RSI) Update(kline ExchangeApi.KLine) (float64, bool) {
+	r.next(kline, func(last ExchangeApi.KLine) {
+		if r.hasPrev {
+			change := last.Close - r.prev
+			r.gain.commit(math.Max(change, 0))
+			r.loss.commit(math.Max(-change, 0))
+		}
+		r.prev, r.hasPrev = last.Close, true
+	})
+	return r.Value()
+}
+
+func (r *RSI) Value() (float64, bool) {
+	if !r.started || !r.hasPrev {
+		return 0, false
+	}
+	change := r.last.Close - r.prev
+	gain, ok := r.gain.next(math.Max(change, 0))
+	loss, _ := r.loss.next(math.Max(-change, 0))
+	switch {
+	case !ok:
+		return 0, false
+	case loss == 0 && gain == 0:
+		return 50, true
+	case loss == 0:
+		return 100, true
+	}
+	return 100 - 100/(1+gain/loss), true
+}
+
+// MACDValue the MACD line, its signal line and the histogram of their difference
+type MACDValue struct {
+	MACD      float64
+	Signal    float64
+	Histogram float64
+}
+
+// MACD the difference of the fast and the slow EMA of the close prices, and the EMA of the difference
+type MACD struct {
+	series
+	fast   ema
+	slow   ema
+	signal ema
+}
+
+// NewMACD the common periods are 12, 26 and 9
+func NewMACD(fast, slow, signal int) *MACD {
+	fast, slow, signal = period(fast), period(slow), period(signal)
+	return &MACD{
+		fast:   newEMA(fast, 2/float64(fast+1)),
+		slow:   newEMA(slow, 2/float64(slow+1)),
+		signal: newEMA(signal, 2/float64(signal+1)),
+	}
+}
+
+func (m *MACD) Update(kline ExchangeApi.KLine) (MACDValue, bool) {
+	m.next(kline, func(last ExchangeApi.KLine) {
+		if macd, ok := m.macd(last.Close); ok {
+			m.signal.commit(macd)
+		}
+		m.fast.commit(last.Close)
+		m.slow.commit(last.Close)
+	})
+	return m.Value()
+}
+
+func (m *MACD) Value() (MACDValue, bool) {
+	if !m.started {
+		return MACDValue{}, false
+	}
+	macd, ok := m.macd(m.last.Close)
+	if !ok {
+		return MACDValue{}, false
+	}
+	signal, ok := m.signal.next(macd)
+	if !ok {
+		return MACDValue{}, false
+	}
+	return MACDValue{MACD: macd, Signal: signal, Histogram: macd - signal}, true
+}
+
+func (m *MACD) macd(close float64) (float64, bool) {
+	fast, ok := m.fast.next(close)
+	if !ok {
+		return 0, false
+	}
+	slow, ok := m.slow.next(close)
+	if !ok {
+		return 0, false
+	}
+	return fast - slow, true
+}
+
+// ComputeMACD the values aligned with the klines, NaN until ready
+func ComputeMACD(fast, slow, signal int, klines []ExchangeApi.KLine) []MACDValue {
+	m := NewMACD(fast, slow, signal)
+	values := make([]MACDValue, len(klines))
+	for i, kline := range klines {
+		v, ok := m.Update(kline)
+		if !ok {
+			v = MACDValue{MACD: math.NaN(), Signal: math.NaN(), Histogram: math.NaN()}
+		}
+		values[i] = v
+	}
+	return values
+}
+
+// ATR the average true range with the Wilder smoothing
+type ATR struct {
+	series
+	prev    float64 // the close of the last committed kline
+	hasPrev bool
+	tr      ema
+}
+
+func NewATR(n int) *ATR {
+	n = period(n)
+	return &ATR{tr: newEMA(n, 1/float64(n))}
+}
+
+func (a *ATR) Update(kline ExchangeApi.KLine) (float64, bool) {
+	a.next(kline, func(last ExchangeApi.KLine) {
+		a.tr.commit(a.trueRange(last))
+		a.prev, a.hasPrev = last.Close, true
+	})
+	return a.Value()
+}
+
+func (a *ATR) Value() (float64, bool) {
+	if !a.started {
+		return 0, false
+	}
+	return a.tr.next(a.trueRange(a.last))
+}
+
+// trueRange the range of the kline extended to the previous close
+func (a *ATR) trueRange(kline ExchangeApi.KLine) float64 {
+	tr := kline.High - kline.Low
+	if a.hasPrev {
+		tr = math.Max(tr, math.Max(math.Abs(kline.High-a.prev), math.Abs(kline.Low-a.prev)))
+	}
+	return tr
+}
@@ -0,0 +1,42 @@
+// Package testutil the helpers shared by the tests of the packages built on IExchange
+package testutil
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+// Timeout how long the helpers wait before failing the test
+const Timeout = time.Second * 3
+
+// Near whether the floats are equal but for the rounding errors
+func Near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// WaitFor poll cond until it is met, the test fails after Timeout
+func WaitFor(t testing.TB, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Receive the next message of out, the test fails if none comes in Timeout, what names it in the failure
+func Receive(t testing.TB, out ExchangeApi.MessageChan, what string) ExchangeApi.Message {
+	t.Helper()
+	select {
+	case msg := <-out:
+		return msg
+	case <-time.After(Timeout):
+		t.Fatalf("%s not received", what)
+	}
+	return ExchangeApi.Message{}
+}
@@ -1,5 +1,7 @@
 package ExchangeApi
 
+import "time"
+
 type MessageType int
 
 const (
@@ -17,14 +19,89 @@ const (
 	MsgDisConnected//网络连接已断开
 	MsgClosed //连接已关闭
 	MsgError//发生了某种错误
+	MsgStale // the subscription has received no data for a while, the data is Stale
+	MsgOrderBookStatus // the local order book is out of sync and being rebuilt, the data is OrderBookStatus
+	MsgOrderBookDelta  // the levels of the order book changed, or the whole book, the data is OrderBookDelta
+	MsgBar             // a bar sampled from the trades, the data is Bar
+	MsgArbitrage       // an arbitrage opportunity found, the data is arbitrage.Opportunity or []arbitrage.Cycle
 )
 
 type Message struct {
 	Type MessageType
 	Data interface{}
+
+	// the following fields are set for the data messages by the connection received them
+	RecvTime  time.Time     // local wall clock when the frame was read from the socket
+	RecvMono  time.Duration // local monotonic clock when the frame was read, see MonoTime
+	EventTime time.Time     // the event time given by the exchange, zero if unknown
+	ConnID    uint64        // the id of the physical connection, a reconnected connection has a new id
+	Seq       uint64        // the sequence number of the frame on the connection, messages parsed from one frame share it
+	Raw       []byte        // the original payload after decompressed, only set if Options.RawMessage is enabled
 }
 type MessageChan chan Message
 
+// IsData whether it is a market or account data message, not a notification of the connection
+func (t MessageType) IsData() bool {
+	return t < MsgReConnected || t == MsgOrderBookDelta || t == MsgBar || t == MsgArbitrage
+}
+
+// IsUserData whether it is an update of the account, which comes only after trading
+func (t MessageType) IsUserData() bool {
+	return t == MsgBalance || t == MsgOrder || t == MsgPositions
+}
+
+// processStart the base of the monotonic clock
+var processStart = time.Now()
+
+// MonoTime the monotonic reading of t, it's the time elapsed since the process started and not affected by the wall clock changes
+func MonoTime(t time.Time) time.Duration {
+	return t.Sub(processStart)
+}
+
+// Symbol the symbol of the data, empty if the data is not about one market
+func (m Message) Symbol() string {
+	switch data := m.Data.(type) {
+	case OrderBook:
+		return data.Symbol
+	case Ticker:
+		return data.Symbol
+	case Trade:
+		return data.Symbol
+	case []Trade:
+		if len(data) > 0 {
+			return data[0].Symbol
+		}
+	case KLine:
+		return data.Symbol
+	case []KLine:
+		if len(data) > 0 {
+			return data[0].Symbol
+		}
+	case Order:
+		return data.Symbol
+	case MarkPrice:
+		return data.Symbol
+	case FuturePositonsUpdate:
+		return data.Symbol
+	case OrderBookStatus:
+		return data.Symbol
+	case OrderBookDelta:
+		return data.Symbol
+	case Bar:
+		return data.Symbol
+	}
+	return ""
+}
+
+// Stale the data of MsgStale
+type Stale struct {
+	Topic   string
+	Symbol  string
+	Type    MessageType   // the message type of the subscription
+	Silence time.Duration // how long no data received
+	Action  StaleAction   // the action taken after notified
+}
+
 var (
 	ReConnectedMessage  = Message{Type: MsgReConnected}
 	DisConnectedMessage = Message{Type: MsgDisConnected}
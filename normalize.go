@@ -0,0 +1,144 @@
+package ExchangeApi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Rounding how a price is snapped to the tick of the market
+type Rounding int
+
+const (
+	RoundNearest Rounding = iota
+	RoundDown
+	RoundUp
+)
+
+// the market rules named in the Data of the ErrInvalidOrder errors of NormalizeOrder
+const (
+	RulePrice       = "Price"
+	RuleMinQty      = "MinQty"
+	RuleMaxQty      = "MaxQty"
+	RuleMinNotional = "MinNotional"
+	RuleMaxNotional = "MaxNotional"
+)
+
+// Tick the price increment of the market, TickSize or else 10^-PricePrecision
+func (m Market) Tick() float64 {
+	if m.TickSize > 0 {
+		return m.TickSize
+	}
+	return math.Pow10(-m.PricePrecision)
+}
+
+// Step the amount increment of the market, StepSize or else 10^-AmountPrecision
+func (m Market) Step() float64 {
+	if m.StepSize > 0 {
+		return m.StepSize
+	}
+	return math.Pow10(-m.AmountPrecision)
+}
+
+// NormalizePrice snap the price to the tick
+func (m Market) NormalizePrice(price float64, rounding Rounding) float64 {
+	return snap(price, m.Tick(), rounding)
+}
+
+// NormalizeAmount floor the amount to the step, an order is never larger than asked
+func (m Market) NormalizeAmount(amount float64) float64 {
+	return snap(amount, m.Step(), RoundDown)
+}
+
+// FormatPrice the price with the decimals of the tick, as sent to the exchange
+func (m Market) FormatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', decimals(m.Tick()), 64)
+}
+
+// FormatAmount the amount with the decimals of the step, as sent to the exchange
+func (m Market) FormatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', decimals(m.Step()), 64)
+}
+
+// PassiveRounding the rounding that never makes the price worse for the side: down when buying, up when selling
+func PassiveRounding(side Side) Rounding {
+	if isBuySide(side) {
+		return RoundDown
+	}
+	return RoundUp
+}
+
+// NormalizeOrder snap the price of a limit order to the tick by PassiveRounding, floor the amount to the step,
+// and check the amount and the notional against the rules of the market.
+// The price of a market order is kept, the notional is only checked if it is given as an estimate.
+// A broken rule fails with the ExError of ErrInvalidOrder whose Data tells the rule, the value and the limit.
+func (m Market) NormalizeOrder(price, amount float64, side Side, tradeType TradeType) (float64, float64, error) {
+	if tradeType != MARKET {
+		if price <= 0 {
+			return 0, 0, m.invalid(RulePrice, "the price must be positive", price, 0)
+		}
+		normalized := m.NormalizePrice(price, PassiveRounding(side))
+		if normalized <= 0 {
+			return 0, 0, m.invalid(RulePrice, "the price is below the tick", price, m.Tick())
+		}
+		price = normalized
+	}
+	normalized := m.NormalizeAmount(amount)
+	minQty := m.MinQty
+	if minQty <= 0 {
+		minQty = m.Lot
+	}
+	if normalized <= 0 || normalized < minQty {
+		return 0, 0, m.invalid(RuleMinQty, "the amount is too small", normalized, math.Max(minQty, m.Step()))
+	}
+	if m.MaxQty > 0 && normalized > m.MaxQty {
+		return 0, 0, m.invalid(RuleMaxQty, "the amount is too large", normalized, m.MaxQty)
+	}
+	if price > 0 {
+		notional := price * normalized
+		if m.MinNotional > 0 && notional < m.MinNotional {
+			return 0, 0, m.invalid(RuleMinNotional, "the order is too small", notional, m.MinNotional)
+		}
+		if m.MaxNotional > 0 && notional > m.MaxNotional {
+			return 0, 0, m.invalid(RuleMaxNotional, "the order is too large", notional, m.MaxNotional)
+		}
+	}
+	return price, normalized, nil
+}
+
+func (m Market) invalid(rule, message string, value, limit float64) ExError {
+	return ExError{
+		Code:    ErrInvalidOrder,
+		Message: fmt.Sprintf("%s %s: %s, %v against %v", rule, m.Symbol, message, value, limit),
+		Data:    map[string]interface{}{"rule": rule, "symbol": m.Symbol, "value": value, "limit": limit},
+	}
+}
+
+// snap the value to a multiple of the step, the binary error of the multiplication is cut by the decimals of the step
+func snap(value, step float64, rounding Rounding) float64 {
+	if step <= 0 {
+		return value
+	}
+	// the tolerance keeps a value already on the step, eg. 0.3/0.1 is 2.9999999999999996
+	n := value / step
+	switch rounding {
+	case RoundDown:
+		n = math.Floor(n + 1e-9)
+	case RoundUp:
+		n = math.Ceil(n - 1e-9)
+	default:
+		n = math.Round(n)
+	}
+	snapped, _ := strconv.ParseFloat(strconv.FormatFloat(n*step, 'f', decimals(step), 64), 64)
+	return snapped
+}
+
+// decimals the decimals of the step, eg. 2 for 0.01 or 0.05
+func decimals(step float64) int {
+	s := strconv.FormatFloat(step, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package ExchangeApi
+
+import "testing"
+
+func testMarket() Market {
+	return Market{
+		Symbol:          "BTC/USDT",
+		PricePrecision:  2,
+		AmountPrecision: 4,
+		TickSize:        0.05,
+		StepSize:        0.001,
+		MinQty:          0.002,
+		MaxQty:          100,
+		MinNotional:     10,
+	}
+}
+
+func TestMarket_Normalize(t *testing.T) {
+	m := testMarket()
+	if p := m.NormalizePrice(100.07, RoundNearest); p != 100.05 {
+		t.Errorf("expect 100.05, got %v", p)
+	}
+	if p := m.NormalizePrice(100.07, RoundUp); p != 100.1 {
+		t.Errorf("expect 100.1, got %v", p)
+	}
+	// a price on the tick is kept whatever the rounding
+	if p := m.NormalizePrice(0.3, RoundDown); p != 0.3 {
+		t.Errorf("expect 0.3, got %v", p)
+	}
+	if a := m.NormalizeAmount(1.23456); a != 1.234 {
+		t.Errorf("expect 1.234, got %v", a)
+	}
+	if s := m.FormatPrice(100.1); s != "100.10" {
+		t.Errorf("expect 100.10, got %s", s)
+	}
+	if s := m.FormatAmount(1.2); s != "1.200" {
+		t.Errorf("expect 1.200, got %s", s)
+	}
+	// the precisions are the increments if the sizes are missing
+	if s := (Market{PricePrecision: 2}).FormatPrice(1.5); s != "1.50" {
+		t.Errorf("expect 1.50, got %s", s)
+	}
+	if p := (Market{PricePrecision: 1}).NormalizePrice(1.27, RoundDown); p != 1.2 {
+		t.Errorf("expect 1.2, got %v", p)
+	}
+}
+
+func TestMarket_NormalizeOrder(t *testing.T) {
+	m := testMarket()
+	price, amount, err := m.NormalizeOrder(100.07, 0.5555, Buy, LIMIT)
+	if err != nil || price != 100.05 || amount != 0.555 {
+		t.Fatalf("unexpected buy %v %v %v", price, amount, err)
+	}
+	price, _, err = m.NormalizeOrder(100.07, 0.5555, CloseLong, LIMIT)
+	if err != nil || price != 100.1 {
+		t.Fatalf("unexpected sell %v %v", price, err)
+	}
+	// the price of a market order is kept
+	if price, _, err = m.NormalizeOrder(100.07, 1, Buy, MARKET); err != nil || price != 100.07 {
+		t.Fatalf("unexpected market order %v %v", price, err)
+	}
+
+	for _, c := range []struct {
+		price, amount float64
+		tradeType     TradeType
+		rule          string
+	}{
+		{0, 1, LIMIT, RulePrice},
+		{0.01, 1, LIMIT, RulePrice},
+		{100, 0.0019, LIMIT, RuleMinQty},
+		{100, 0.0009, MARKET, RuleMinQty},
+		{100, 101, LIMIT, RuleMaxQty},
+		{100, 0.05, LIMIT, RuleMinNotional},
+	} {
+		_, _, err := m.NormalizeOrder(c.price, c.amount, Buy, c.tradeType)
+		e, ok := err.(ExError)
+		if !ok || e.Code != ErrInvalidOrder || e.Data["rule"] != c.rule {
+			t.Errorf("expect the rule %s of %v x %v, got %v", c.rule, c.price, c.amount, err)
+		}
+	}
+	m.MaxNotional = 1000
+	if _, _, err := m.NormalizeOrder(100, 20, Sell, LIMIT); err.(ExError).Data["rule"] != RuleMaxNotional {
+		t.Errorf("expect the rule %s, got %v", RuleMaxNotional, err)
+	}
+}
@@ -0,0 +1,623 @@
+// Package oms tracks the orders created through an IExchange: their transitions are taken from the order subscriptions
+// and the missed events are reconciled by fetching the orders periodically.
+package oms
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+const (
+	DefaultReconcileInterval = time.Second * 30
+	// earlyTimeout how long an event of an order unknown yet is kept, it may come before CreateOrder returns
+	earlyTimeout = time.Minute
+)
+
+var ErrStarted = errors.New("oms already started")
+
+type Options struct {
+	ReconcileInterval time.Duration // how often the open orders are fetched, DefaultReconcileInterval if 0, never if negative
+	Retention         time.Duration // how long the finished orders are kept, forever if 0
+	Submit            SubmitOptions // the retries of Submit
+}
+
+// Change an order is created or changes its status or filled amount, it is the data of the MsgOrder sent by the OMS
+type Change struct {
+	Order ExchangeApi.Order
+	Prev  ExchangeApi.OrderStatus // the status before, empty for a new order
+	Err   error                   // why the order was rejected, or why it is in doubt after Submit
+}
+
+type record struct {
+	seq     int
+	order   ExchangeApi.Order
+	updated time.Time
+}
+
+type early struct {
+	order    ExchangeApi.Order
+	received time.Time
+}
+
+// watcher the order subscription of a symbol
+type watcher struct {
+	symbol  string
+	sub     *ExchangeApi.Resubscription
+	msgChan ExchangeApi.MessageChan
+}
+
+// OMS the local order management on top of an IExchange
+type OMS struct {
+	exchange ExchangeApi.IExchange
+	options  Options
+
+	lock     sync.Mutex
+	seq      int
+	records  map[int]*record
+	ids      map[string]*record // key: the order ID
+	clients  map[string]*record // key: the client ID
+	early    map[string]early   // key: the order ID
+	watchers map[string]*watcher
+	started  bool
+	stop     chan struct{}
+	loops    sync.WaitGroup
+
+	out     ExchangeApi.MessageChan
+	changes []Change
+	wake    chan struct{}
+}
+
+func New(exchange ExchangeApi.IExchange, options Options) *OMS {
+	if options.ReconcileInterval == 0 {
+		options.ReconcileInterval = DefaultReconcileInterval
+	}
+	return &OMS{
+		exchange: exchange,
+		options:  options,
+		records:  make(map[int]*record),
+		ids:      make(map[string]*record),
+		clients:  make(map[string]*record),
+		early:    make(map[string]early),
+		watchers: make(map[string]*watcher),
+	}
+}
+
+// Start subscribe the orders of the symbols, more symbols are subscribed when their orders are created or tracked.
+// The changes are sent to out in order as the MsgOrder messages of Change, out may be nil.
+func (o *OMS) Start(symbols []string, out ExchangeApi.MessageChan) error {
+	o.lock.Lock()
+	if o.started {
+		o.lock.Unlock()
+		return ErrStarted
+	}
+	o.started = true
+	o.stop = make(chan struct{})
+	o.out = out
+	o.wake = make(chan struct{}, 1)
+	for _, symbol := range symbols {
+		if err := o.watch(symbol); err != nil {
+			o.started = false
+			close(o.stop)
+			o.closeSubscriptions()
+			o.lock.Unlock()
+			o.loops.Wait()
+			return err
+		}
+	}
+	o.loops.Add(1)
+	go o.notify()
+	if o.options.ReconcileInterval > 0 {
+		o.loops.Add(1)
+		go o.reconcileLoop()
+	}
+	o.lock.Unlock()
+	return nil
+}
+
+// Stop close the subscriptions and wait for the goroutines to exit, the orders are kept
+func (o *OMS) Stop() error {
+	o.lock.Lock()
+	if !o.started {
+		o.lock.Unlock()
+		return nil
+	}
+	o.started = false
+	close(o.stop)
+	err := o.closeSubscriptions()
+	o.lock.Unlock()
+	o.loops.Wait()
+	return err
+}
+
+// CreateOrder create the order through the exchange and track it, a failed order is kept as Rejected.
+// The order is not sent when the orders of its symbol can't be subscribed.
+func (o *OMS) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	template := newOrder(symbol, price, amount, side, tradeType, orderType)
+	return o.create(template, func() (ExchangeApi.Order, error) {
+		return o.exchange.CreateOrder(symbol, price, amount, side, tradeType, orderType, useClientID)
+	})
+}
+
+// Submit create the order with the client order ID by Submit and track it. The order failed ambiguously is kept as Open
+// with the client order ID only, Reconcile looks it up by the client order ID until it's found or surely not placed.
+func (o *OMS) Submit(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	template := newOrder(symbol, price, amount, side, tradeType, orderType)
+	template.ClientID = clientID
+	return o.create(template, func() (ExchangeApi.Order, error) {
+		return Submit(o.exchange, symbol, price, amount, side, tradeType, orderType, clientID, o.options.Submit)
+	})
+}
+
+// newOrder the order as requested, before the exchange answers
+func newOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType) ExchangeApi.Order {
+	return ExchangeApi.Order{
+		Symbol:     symbol,
+		Price:      strconv.FormatFloat(price, 'f', -1, 64),
+		Amount:     strconv.FormatFloat(amount, 'f', -1, 64),
+		Filled:     "0",
+		Status:     ExchangeApi.Rejected,
+		Side:       side,
+		Type:       tradeType,
+		OrderType:  orderType,
+		CreateTime: time.Duration(time.Now().UnixNano() / 1e6),
+	}
+}
+
+// create send the order and record it, the fields the exchange doesn't return are taken from the template
+func (o *OMS) create(template ExchangeApi.Order, send func() (ExchangeApi.Order, error)) (ExchangeApi.Order, error) {
+	// subscribe before creating, so no event of the order is missed, the order is not sent if it fails
+	o.lock.Lock()
+	err := o.watch(template.Symbol)
+	o.lock.Unlock()
+	if err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	order, err := send()
+	if err != nil {
+		if Ambiguous(err) && template.ClientID != "" {
+			template.Status = ExchangeApi.Open
+			return o.add(template, err), err
+		}
+		o.add(template, err)
+		return order, err
+	}
+	if order.Symbol == "" {
+		order.Symbol = template.Symbol
+	}
+	if order.ClientID == "" {
+		order.ClientID = template.ClientID
+	}
+	if order.Side == "" {
+		order.Side = template.Side
+	}
+	if order.Price == "" {
+		order.Price = template.Price
+	}
+	if order.Amount == "" {
+		order.Amount = template.Amount
+	}
+	if rank(order.Status) < 0 {
+		order.Status = ExchangeApi.Open
+	}
+	return o.add(order, nil), nil
+}
+
+// CancelOrder cancel the order through the exchange, it is taken as Canceled once the exchange accepts
+func (o *OMS) CancelOrder(symbol, orderID string) error {
+	if err := o.exchange.CancelOrder(symbol, orderID); err != nil {
+		return err
+	}
+	if order, ok := o.Order(orderID); ok {
+		order.Status = ExchangeApi.Canceled
+		o.update(order)
+	}
+	return nil
+}
+
+// Track start tracking an order created elsewhere, it's not tracked if the orders of its symbol can't be subscribed
+func (o *OMS) Track(order ExchangeApi.Order) error {
+	o.lock.Lock()
+	err := o.watch(order.Symbol)
+	o.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	o.add(order, nil)
+	return nil
+}
+
+// Order the order by its ID or client ID
+func (o *OMS) Order(id string) (ExchangeApi.Order, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if r, ok := o.ids[id]; ok {
+		return r.order, true
+	}
+	if r, ok := o.clients[id]; ok {
+		return r.order, true
+	}
+	return ExchangeApi.Order{}, false
+}
+
+// Orders the orders of the symbol, or of all the symbols if empty, in any of the statuses if given, from the oldest
+func (o *OMS) Orders(symbol string, statuses ...ExchangeApi.OrderStatus) []ExchangeApi.Order {
+	o.lock.Lock()
+	var records []record
+	for _, r := range o.records {
+		if symbol != "" && !strings.EqualFold(r.order.Symbol, symbol) {
+			continue
+		}
+		if len(statuses) > 0 && !hasStatus(statuses, r.order.Status) {
+			continue
+		}
+		records = append(records, *r)
+	}
+	o.lock.Unlock()
+	sort.Slice(records, func(i, j int) bool { return records[i].seq < records[j].seq })
+	orders := make([]ExchangeApi.Order, len(records))
+	for i, r := range records {
+		orders[i] = r.order
+	}
+	return orders
+}
+
+// OpenOrders the orders not finished
+func (o *OMS) OpenOrders(symbol string) []ExchangeApi.Order {
+	return o.Orders(symbol, ExchangeApi.Open, ExchangeApi.Partial)
+}
+
+func hasStatus(statuses []ExchangeApi.OrderStatus, status ExchangeApi.OrderStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// rank the order of the statuses in the lifecycle, -1 for unknown
+func rank(status ExchangeApi.OrderStatus) int {
+	switch status {
+	case ExchangeApi.Open:
+		return 0
+	case ExchangeApi.Partial:
+		return 1
+	case ExchangeApi.Close, ExchangeApi.Canceled, ExchangeApi.Rejected:
+		return 2
+	}
+	return -1
+}
+
+// Finished whether the order reached a final status
+func Finished(status ExchangeApi.OrderStatus) bool {
+	return rank(status) == 2
+}
+
+// advance whether next is a later state of the order than cur, the events may come out of order or repeated
+func advance(cur, next ExchangeApi.Order) bool {
+	if Finished(cur.Status) || rank(next.Status) < rank(cur.Status) {
+		return false
+	}
+	filled, nextFilled := SafeParseFloat(cur.Filled), SafeParseFloat(next.Filled)
+	if next.Filled != "" && nextFilled < filled {
+		return false
+	}
+	return rank(next.Status) > rank(cur.Status) || nextFilled > filled
+}
+
+// merge the fields of next over cur, the empty ones are kept
+func merge(cur, next ExchangeApi.Order) ExchangeApi.Order {
+	set := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	set(&cur.ID, next.ID)
+	set(&cur.ClientID, next.ClientID)
+	set(&cur.Price, next.Price)
+	set(&cur.Amount, next.Amount)
+	set(&cur.Filled, next.Filled)
+	set(&cur.Cost, next.Cost)
+	cur.Status = next.Status
+	if next.TransactionTime > cur.TransactionTime {
+		cur.TransactionTime = next.TransactionTime
+	}
+	if cur.CreateTime == 0 {
+		cur.CreateTime = next.CreateTime
+	}
+	return cur
+}
+
+// add record the new order, an event already received for it is applied then
+func (o *OMS) add(order ExchangeApi.Order, err error) ExchangeApi.Order {
+	o.lock.Lock()
+	if r := o.find(order); r != nil {
+		o.lock.Unlock()
+		return o.update(order)
+	}
+	o.seq++
+	r := &record{seq: o.seq, order: order, updated: time.Now()}
+	o.records[r.seq] = r
+	o.index(r)
+	o.publish(Change{Order: order, Err: err})
+	e, ok := o.early[order.ID]
+	delete(o.early, order.ID)
+	o.pruneEarly(time.Now())
+	o.lock.Unlock()
+	if ok {
+		return o.update(e.order)
+	}
+	return order
+}
+
+func (o *OMS) find(order ExchangeApi.Order) *record {
+	if r, ok := o.ids[order.ID]; ok && order.ID != "" {
+		return r
+	}
+	if r, ok := o.clients[order.ClientID]; ok && order.ClientID != "" {
+		return r
+	}
+	return nil
+}
+
+func (o *OMS) index(r *record) {
+	if r.order.ID != "" {
+		o.ids[r.order.ID] = r
+	}
+	if r.order.ClientID != "" {
+		o.clients[r.order.ClientID] = r
+	}
+}
+
+// update apply the state of the order if it's later than the recorded one, it returns the recorded order
+func (o *OMS) update(order ExchangeApi.Order) ExchangeApi.Order {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	r := o.find(order)
+	if r == nil {
+		// it may be created right now, the order is not returned by CreateOrder yet
+		if order.ID != "" {
+			o.early[order.ID] = early{order: order, received: time.Now()}
+		}
+		return order
+	}
+	if !advance(r.order, order) {
+		// the order submitted ambiguously is known by the client ID until it's found
+		if r.order.ID == "" && order.ID != "" {
+			r.order.ID = order.ID
+			o.index(r)
+		}
+		return r.order
+	}
+	prev := r.order.Status
+	r.order = merge(r.order, order)
+	r.updated = time.Now()
+	o.index(r)
+	o.publish(Change{Order: r.order, Prev: prev})
+	return r.order
+}
+
+// publish queue the change for out, it must be called with the lock held
+func (o *OMS) publish(change Change) {
+	if !o.started || o.out == nil {
+		return
+	}
+	o.changes = append(o.changes, change)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// notify send the changes to out in order, without blocking the updates
+func (o *OMS) notify() {
+	defer o.loops.Done()
+	for {
+		select {
+		case <-o.wake:
+		case <-o.stop:
+			return
+		}
+		o.lock.Lock()
+		changes := o.changes
+		o.changes = nil
+		o.lock.Unlock()
+		for _, change := range changes {
+			select {
+			case o.out <- ExchangeApi.Message{Type: ExchangeApi.MsgOrder, Data: change}:
+			case <-o.stop:
+				return
+			}
+		}
+	}
+}
+
+// watch subscribe the orders of the symbol if not yet, it must be called with the lock held
+func (o *OMS) watch(symbol string) error {
+	if !o.started || symbol == "" {
+		return nil
+	}
+	if _, ok := o.watchers[symbol]; ok {
+		return nil
+	}
+	w := &watcher{symbol: symbol, msgChan: make(ExchangeApi.MessageChan)}
+	sub, err := ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+		return o.exchange.SubscribeOrder(symbol, w.msgChan)
+	})
+	if err != nil {
+		return err
+	}
+	w.sub = sub
+	o.watchers[symbol] = w
+	o.loops.Add(1)
+	go o.listen(w)
+	return nil
+}
+
+func (o *OMS) closeSubscriptions() error {
+	var subs ExchangeApi.Resubscriptions
+	for symbol, w := range o.watchers {
+		subs = append(subs, w.sub)
+		delete(o.watchers, symbol)
+	}
+	return subs.Close()
+}
+
+func (o *OMS) listen(w *watcher) {
+	defer o.loops.Done()
+	for {
+		select {
+		case msg := <-w.msgChan:
+			switch msg.Type {
+			case ExchangeApi.MsgOrder:
+				if order, ok := msg.Data.(ExchangeApi.Order); ok {
+					o.update(order)
+				}
+			case ExchangeApi.MsgReConnected:
+				// the events in between are fetched
+				w.sub.Renew()
+				o.loops.Add(1)
+				go func() {
+					defer o.loops.Done()
+					o.Reconcile()
+				}()
+			}
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+func (o *OMS) reconcileLoop() {
+	defer o.loops.Done()
+	ticker := time.NewTicker(o.options.ReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.Reconcile()
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// Reconcile fetch the open orders of the symbols with unfinished orders, and each unfinished order not open any more,
+// so the missed events are applied. The finished orders older than Options.Retention are dropped.
+// It returns the first error of the fetches, the others are still done.
+func (o *OMS) Reconcile() error {
+	o.lock.Lock()
+	open := make(map[string][]string)
+	for _, r := range o.records {
+		if Finished(r.order.Status) {
+			continue
+		}
+		// the order submitted ambiguously is fetched by the client ID
+		if id := r.order.ID; id != "" || r.order.ClientID != "" {
+			if id == "" {
+				id = r.order.ClientID
+			}
+			open[r.order.Symbol] = append(open[r.order.Symbol], id)
+		}
+	}
+	o.lock.Unlock()
+
+	var first error
+	for symbol, ids := range open {
+		orders, err := o.exchange.FetchOpenOrders(symbol, 0, 0)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		listed := make(map[string]bool, len(orders))
+		for _, order := range orders {
+			listed[order.ID] = true
+			if order.ClientID != "" {
+				listed[order.ClientID] = true
+			}
+			o.reconcile(order)
+		}
+		for _, id := range ids {
+			if listed[id] {
+				continue
+			}
+			order, err := o.exchange.FetchOrder(symbol, id)
+			if err != nil {
+				o.notPlaced(id, err)
+				if first == nil {
+					first = err
+				}
+				continue
+			}
+			o.reconcile(order)
+		}
+	}
+	o.prune(time.Now())
+	return first
+}
+
+// reconcile apply the fetched order only if it's tracked, the events of unknown orders are kept only from the subscriptions
+func (o *OMS) reconcile(order ExchangeApi.Order) {
+	o.lock.Lock()
+	r := o.find(order)
+	o.lock.Unlock()
+	if r != nil {
+		o.update(order)
+	}
+}
+
+// notPlaced reject the order submitted ambiguously once the exchange tells it is not found by the client ID
+func (o *OMS) notPlaced(clientID string, err error) {
+	if !notFound(err) {
+		return
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	r, ok := o.clients[clientID]
+	if !ok || r.order.ID != "" || Finished(r.order.Status) {
+		return
+	}
+	prev := r.order.Status
+	r.order.Status = ExchangeApi.Rejected
+	r.updated = time.Now()
+	o.publish(Change{Order: r.order, Prev: prev, Err: err})
+}
+
+func (o *OMS) prune(now time.Time) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.pruneEarly(now)
+	if o.options.Retention <= 0 {
+		return
+	}
+	for seq, r := range o.records {
+		if !Finished(r.order.Status) || now.Sub(r.updated) <= o.options.Retention {
+			continue
+		}
+		delete(o.records, seq)
+		if o.ids[r.order.ID] == r {
+			delete(o.ids, r.order.ID)
+		}
+		if o.clients[r.order.ClientID] == r {
+			delete(o.clients, r.order.ClientID)
+		}
+	}
+}
+
+// pruneEarly drop the events of the orders not created in time, they are of the orders created elsewhere
+func (o *OMS) pruneEarly(now time.Time) {
+	for id, e := range o.early {
+		if now.Sub(e.received) > earlyTimeout {
+			delete(o.early, id)
+		}
+	}
+}
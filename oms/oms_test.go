@@ -0,0 +1,195 @@
+package oms
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+func receive(t *testing.T, out ExchangeApi.MessageChan) Change {
+	t.Helper()
+	return testutil.Receive(t, out, "change").Data.(Change)
+}
+
+func TestOMS_Lifecycle(t *testing.T) {
+	exchange := mock.New("binance")
+	o := New(exchange, Options{ReconcileInterval: -1})
+	out := make(ExchangeApi.MessageChan, 10)
+	if err := o.Start(nil, out); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Stop()
+
+	order, err := o.CreateOrder("BTC/USDT", 100, 2, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := receive(t, out); c.Order.ID != order.ID || c.Order.Status != ExchangeApi.Open || c.Prev != "" {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	if exchange.Subscribers(ExchangeApi.MsgOrder, "BTC/USDT") != 1 {
+		t.Fatal("the orders of the symbol are not subscribed")
+	}
+
+	exchange.Fill(order.ID, 100, 1)
+	if c := receive(t, out); c.Order.Status != ExchangeApi.Partial || c.Prev != ExchangeApi.Open || c.Order.Filled != "1" {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	if open := o.OpenOrders("BTC/USDT"); len(open) != 1 || open[0].Status != ExchangeApi.Partial {
+		t.Fatalf("unexpected open orders %+v", open)
+	}
+	exchange.Fill(order.ID, 100, 1)
+	if c := receive(t, out); c.Order.Status != ExchangeApi.Close || c.Prev != ExchangeApi.Partial {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	if open := o.OpenOrders(""); len(open) != 0 {
+		t.Fatal("the filled order is still open")
+	}
+
+	// a late event of an earlier state is ignored
+	o.update(ExchangeApi.Order{ID: order.ID, Status: ExchangeApi.Partial, Filled: "1"})
+	if got, _ := o.Order(order.ID); got.Status != ExchangeApi.Close || got.Filled != "2" {
+		t.Fatalf("the order went back to %+v", got)
+	}
+
+	exchange.SetError("CreateOrder", errors.New("refused"))
+	if _, err := o.CreateOrder("BTC/USDT", 100, 1, ExchangeApi.Sell, ExchangeApi.LIMIT, ExchangeApi.Normal, false); err == nil {
+		t.Fatal("expected error")
+	}
+	if c := receive(t, out); c.Order.Status != ExchangeApi.Rejected || c.Err == nil {
+		t.Fatalf("unexpected change %+v", c)
+	}
+	if rejected := o.Orders("BTC/USDT", ExchangeApi.Rejected); len(rejected) != 1 || rejected[0].Side != ExchangeApi.Sell {
+		t.Fatalf("unexpected rejected orders %+v", rejected)
+	}
+	if orders := o.Orders(""); len(orders) != 2 || orders[0].ID != order.ID {
+		t.Fatalf("unexpected orders %+v", orders)
+	}
+	exchange.SetError("CreateOrder", nil)
+
+	// the order is not sent if its events can't be received
+	exchange.SetError("SubscribeOrder", errors.New("refused"))
+	if _, err := o.CreateOrder("ETH/USDT", 10, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, false); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := o.Track(ExchangeApi.Order{ID: "1", Symbol: "ETH/USDT", Status: ExchangeApi.Open}); err == nil {
+		t.Fatal("expected error")
+	}
+	if orders := o.Orders("ETH/USDT"); len(orders) != 0 {
+		t.Fatalf("unexpected orders %+v", orders)
+	}
+	exchange.SetError("SubscribeOrder", nil)
+	if open, _ := exchange.FetchOpenOrders("ETH/USDT", 0, 0); len(open) != 0 {
+		t.Fatalf("the order was sent %+v", open)
+	}
+}
+
+func TestOMS_Reconcile(t *testing.T) {
+	exchange := mock.New("binance")
+	// not started, so the events are missed
+	o := New(exchange, Options{Retention: time.Millisecond * 50})
+	var ids []string
+	for i := 0; i < 3; i++ {
+		order, err := o.CreateOrder("BTC/USDT", 100, 2, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, order.ID)
+	}
+	exchange.Fill(ids[0], 100, 1)
+	exchange.Fill(ids[1], 100, 2)
+	exchange.CancelOrder("BTC/USDT", ids[2])
+
+	if err := o.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if order, _ := o.Order(ids[0]); order.Status != ExchangeApi.Partial || order.Filled != "1" {
+		t.Errorf("unexpected order %+v", order)
+	}
+	// the finished orders are dropped after the retention by the next reconcile
+	if orders := o.Orders("", ExchangeApi.Close, ExchangeApi.Canceled); len(orders) != 2 {
+		t.Fatalf("unexpected finished orders %+v", orders)
+	}
+	time.Sleep(time.Millisecond * 60)
+	exchange.SetError("FetchOpenOrders", errors.New("timeout"))
+	if err := o.Reconcile(); err == nil {
+		t.Error("expected the error of the fetch")
+	}
+	if orders := o.Orders(""); len(orders) != 1 || orders[0].ID != ids[0] {
+		t.Fatalf("unexpected orders %+v", orders)
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	exchange := mock.New("binance")
+	timeout := ExchangeApi.ExError{Code: ExchangeApi.ErrTimeout, Message: "timeout"}
+
+	// the response is lost, the order placed is found by the client ID
+	exchange.SetErrorAfter("CreateOrderWithClientID", timeout)
+	order, err := Submit(exchange, "BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c1", SubmitOptions{Retries: 2})
+	if err != nil || order.ID == "" || order.ClientID != "c1" {
+		t.Fatalf("unexpected order %+v %v", order, err)
+	}
+	if orders, _ := exchange.FetchOpenOrders("BTC/USDT", 0, 0); len(orders) != 1 {
+		t.Fatalf("the order is submitted twice %+v", orders)
+	}
+	exchange.SetErrorAfter("CreateOrderWithClientID", nil)
+
+	// the order not placed is sent again up to the retries
+	exchange.SetError("CreateOrderWithClientID", timeout)
+	if _, err := Submit(exchange, "BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c2", SubmitOptions{Retries: 1}); !Ambiguous(err) {
+		t.Fatalf("expected the timeout, got %v", err)
+	}
+	exchange.SetError("CreateOrderWithClientID", nil)
+	if _, err := Submit(exchange, "BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c1", SubmitOptions{}); err == nil || Ambiguous(err) {
+		t.Fatalf("expected the refused duplicate, got %v", err)
+	}
+	if _, err := Submit(mockWithoutClientID{exchange}, "BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c3", SubmitOptions{}); err == nil {
+		t.Fatal("expected the error of the exchange without client IDs")
+	}
+}
+
+func TestOMS_Submit(t *testing.T) {
+	exchange := mock.New("binance")
+	o := New(exchange, Options{ReconcileInterval: -1})
+	out := make(ExchangeApi.MessageChan, 10)
+	if err := o.Start(nil, out); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Stop()
+	timeout := ExchangeApi.ExError{Code: ExchangeApi.ErrTimeout, Message: "timeout"}
+
+	exchange.SetErrorAfter("CreateOrderWithClientID", timeout)
+	order, err := o.Submit("BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c1")
+	if err != nil || order.ID == "" {
+		t.Fatalf("unexpected order %+v %v", order, err)
+	}
+	if got, ok := o.Order("c1"); !ok || got.ID != order.ID {
+		t.Fatalf("the order is not tracked by the client ID %+v", got)
+	}
+	exchange.SetErrorAfter("CreateOrderWithClientID", nil)
+
+	// the lookup fails too, the order is in doubt until Reconcile
+	exchange.SetError("CreateOrderWithClientID", timeout)
+	exchange.SetError("FetchOrder", timeout)
+	if _, err := o.Submit("BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c2"); err == nil {
+		t.Fatal("expected the timeout")
+	}
+	if got, _ := o.Order("c2"); got.Status != ExchangeApi.Open || got.ID != "" {
+		t.Fatalf("unexpected order in doubt %+v", got)
+	}
+	exchange.SetError("FetchOrder", nil)
+	o.Reconcile()
+	if got, _ := o.Order("c2"); got.Status != ExchangeApi.Rejected {
+		t.Fatalf("the order not placed is not rejected %+v", got)
+	}
+}
+
+// mockWithoutClientID hides CreateOrderWithClientID of the mock
+type mockWithoutClientID struct {
+	ExchangeApi.IExchange
+}
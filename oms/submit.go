@@ -0,0 +1,76 @@
+package oms
+
+import (
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+)
+
+type SubmitOptions struct {
+	Retries int           // how many times an order surely not placed is sent again after an ambiguous failure
+	Delay   time.Duration // the wait before the order failed ambiguously is looked up, the exchange may show it late
+}
+
+// Ambiguous whether the order may have reached the exchange though its request failed:
+// the request timed out, its response is lost or broken, or the exchange failed inside
+func Ambiguous(err error) bool {
+	e, ok := err.(ExchangeApi.ExError)
+	if !ok {
+		return false
+	}
+	switch e.Code {
+	case ExchangeApi.ErrTimeout, ExchangeApi.ErrBadRequest, ExchangeApi.ErrBadResponse, ExchangeApi.ErrExchangeSystem:
+		return true
+	}
+	return false
+}
+
+func notFound(err error) bool {
+	e, ok := err.(ExchangeApi.ExError)
+	return ok && e.Code == ExchangeApi.ErrOrderNotFound
+}
+
+// Submit create the order with the client order ID at most once, the exchange must be an IClientOrderExchange.
+// After an ambiguous failure the order is looked up by the client order ID: the order found is returned,
+// the order not found is sent again with the same client order ID up to Retries times.
+// If the lookup fails too, the ambiguous error is returned and the order may still exist.
+func Submit(exchange ExchangeApi.IExchange, symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string, options SubmitOptions) (ExchangeApi.Order, error) {
+	c, ok := exchange.(ExchangeApi.IClientOrderExchange)
+	if !ok {
+		return ExchangeApi.Order{}, ExchangeApi.ExError{Code: ExchangeApi.NotImplement, Message: "the exchange takes no client order id"}
+	}
+	for attempt := 0; ; attempt++ {
+		order, err := c.CreateOrderWithClientID(symbol, price, amount, side, tradeType, orderType, clientID)
+		if err == nil {
+			return order, nil
+		}
+		if !Ambiguous(err) {
+			// the order sent before may show up late, then the exchange refuses the client order ID used again
+			if attempt > 0 {
+				if found, ferr := lookup(exchange, symbol, clientID); ferr == nil {
+					return found, nil
+				}
+			}
+			return order, err
+		}
+		if options.Delay > 0 {
+			time.Sleep(options.Delay)
+		}
+		found, ferr := lookup(exchange, symbol, clientID)
+		if ferr == nil {
+			return found, nil
+		}
+		if !notFound(ferr) || attempt >= options.Retries {
+			return order, err
+		}
+	}
+}
+
+// lookup fetch the order by the client order ID
+func lookup(exchange ExchangeApi.IExchange, symbol, clientID string) (ExchangeApi.Order, error) {
+	order, err := exchange.FetchOrder(symbol, clientID)
+	if err == nil && order.ClientID == "" {
+		order.ClientID = clientID
+	}
+	return order, err
+}
@@ -0,0 +1,679 @@
+// Package position tracks the futures positions of an IFutureExchange with their PnL:
+// the unrealized PnL by the mark prices, the realized PnL by the fills of the orders and by the fundings.
+// The PnL is in the quote currency of linear contracts, the amounts are taken as in the base currency.
+package position
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+const (
+	// fundingRefresh the rate is fetched again this long before the funding time
+	fundingRefresh = time.Minute
+	// fundingRetry the wait after a failed fetch of the rate, or one of the funding time applied already,
+	// it doubles on each such fetch up to fundingRetryMax
+	fundingRetry    = time.Second * 5
+	fundingRetryMax = time.Minute * 5
+	// fillRetention how long the fills of a finished order are kept
+	fillRetention = time.Hour
+)
+
+var ErrStarted = errors.New("position tracker already started")
+
+type Options struct {
+	MarginRatioAlert         float64 // alert when the margin ratio of a position reaches it, eg. 0.8, never if 0
+	LiquidationDistanceAlert float64 // alert when the mark price is within the fraction from the liquidation price, eg. 0.05, never if 0
+}
+
+// Position a position of a symbol and side, the positions of the one-way mode are long or short by their sign
+type Position struct {
+	Symbol         string
+	Side           ExchangeApi.PositionType
+	Amount         float64 // always positive
+	AvgPrice       float64
+	MarkPrice      float64
+	LiquidatePrice float64
+	Margin         float64
+	MaintainMargin float64
+	MarginMode     ExchangeApi.FutureMarginMode
+	Leverage       int
+	UnrealizedPnL  float64
+	RealizedPnL    float64 // by the fills closing the position, the fees are not counted
+	Funding        float64 // the fundings received, negative if paid
+	Updated        time.Time
+}
+
+// Notional the value of the position at the mark price
+func (p Position) Notional() float64 {
+	return p.Amount * p.MarkPrice
+}
+
+// MarginRatio the maintenance margin over the margin with the unrealized PnL, the position is liquidated at 1.
+// It is false if the margins are unknown.
+func (p Position) MarginRatio() (float64, bool) {
+	balance := p.Margin + p.UnrealizedPnL
+	if p.MaintainMargin <= 0 || p.Margin <= 0 {
+		return 0, false
+	}
+	if balance <= 0 {
+		return math.Inf(1), true
+	}
+	return p.MaintainMargin / balance, true
+}
+
+// LiquidationDistance how far the mark price is from the liquidation price, as a fraction of the mark price.
+// It is negative past the liquidation price, and false if either price is unknown.
+func (p Position) LiquidationDistance() (float64, bool) {
+	if p.LiquidatePrice <= 0 || p.MarkPrice <= 0 {
+		return 0, false
+	}
+	if p.Side == ExchangeApi.PositionShort {
+		return (p.LiquidatePrice - p.MarkPrice) / p.MarkPrice, true
+	}
+	return (p.MarkPrice - p.LiquidatePrice) / p.MarkPrice, true
+}
+
+func (p *Position) mark(price float64) {
+	p.MarkPrice = price
+	if p.Amount == 0 || price == 0 {
+		p.UnrealizedPnL = 0
+		return
+	}
+	if p.Side == ExchangeApi.PositionShort {
+		p.UnrealizedPnL = (p.AvgPrice - price) * p.Amount
+	} else {
+		p.UnrealizedPnL = (price - p.AvgPrice) * p.Amount
+	}
+}
+
+func (p *Position) open(amount, price float64) {
+	p.AvgPrice = (p.AvgPrice*p.Amount + price*amount) / (p.Amount + amount)
+	p.Amount += amount
+}
+
+// close reduce the position by amount at price, it returns the amount beyond the position
+func (p *Position) close(amount, price float64) float64 {
+	closed := math.Min(amount, p.Amount)
+	if p.Side == ExchangeApi.PositionShort {
+		p.RealizedPnL += (p.AvgPrice - price) * closed
+	} else {
+		p.RealizedPnL += (price - p.AvgPrice) * closed
+	}
+	p.Amount -= closed
+	if p.Amount <= 1e-12 {
+		p.Amount, p.AvgPrice = 0, 0
+	}
+	return amount - closed
+}
+
+type AlertType int
+
+const (
+	MarginRatioAlert AlertType = iota // Value is the margin ratio
+	LiquidationAlert                  // Value is the liquidation distance
+)
+
+// Alert a position crossed a threshold of Options, it is the data of the MsgPositions sent by the tracker.
+// It is sent again with Cleared when the position is back.
+type Alert struct {
+	Type      AlertType
+	Position  Position
+	Value     float64
+	Threshold float64
+	Cleared   bool
+}
+
+type key struct {
+	symbol string
+	side   ExchangeApi.PositionType
+}
+
+type alertKey struct {
+	key
+	t AlertType
+}
+
+// fill the filled amount and cost of an order seen
+type fill struct {
+	filled   float64
+	cost     float64
+	finished time.Time // the late events of a finished order are still recognized for fillRetention
+}
+
+// feed a subscription of a symbol
+type feed struct {
+	t       ExchangeApi.MessageType
+	symbol  string
+	sub     *ExchangeApi.Resubscription
+	msgChan ExchangeApi.MessageChan
+}
+
+// funding the funding rate of a symbol and the funding time applied last
+type funding struct {
+	rate      ExchangeApi.FundingRate
+	applied   time.Duration
+	refreshed bool          // whether the rate is fetched again before the funding time
+	retry     time.Duration // the current wait of the retries, 0 after a new rate is fetched
+	retryAt   time.Duration // the rate of the coming funding is not fetched before it
+}
+
+// Tracker the positions of the symbols
+type Tracker struct {
+	exchange ExchangeApi.IFutureExchange
+	options  Options
+
+	lock      sync.Mutex
+	positions map[key]*Position
+	alerted   map[alertKey]bool
+	fills     map[string]fill     // key: the order ID
+	fundings  map[string]*funding // used by the funding goroutine only
+	symbols   map[string]bool
+	feeds     []*feed
+	started   bool
+	stop      chan struct{}
+	loops     sync.WaitGroup
+
+	out    ExchangeApi.MessageChan
+	alerts []Alert
+	wake   chan struct{}
+}
+
+func New(exchange ExchangeApi.IFutureExchange, options Options) *Tracker {
+	return &Tracker{
+		exchange:  exchange,
+		options:   options,
+		positions: make(map[key]*Position),
+		alerted:   make(map[alertKey]bool),
+		fills:     make(map[string]fill),
+		fundings:  make(map[string]*funding),
+		symbols:   make(map[string]bool),
+	}
+}
+
+// Start fetch the positions of the symbols and subscribe their positions, mark prices and orders.
+// The alerts are sent to out as the MsgPositions messages of Alert, out may be nil.
+func (t *Tracker) Start(symbols []string, out ExchangeApi.MessageChan) error {
+	t.lock.Lock()
+	if t.started {
+		t.lock.Unlock()
+		return ErrStarted
+	}
+	t.started = true
+	t.stop = make(chan struct{})
+	t.out = out
+	t.wake = make(chan struct{}, 1)
+	for _, symbol := range symbols {
+		t.symbols[symbol] = true
+		for _, mt := range []ExchangeApi.MessageType{ExchangeApi.MsgPositions, ExchangeApi.MsgMarkPrice, ExchangeApi.MsgOrder} {
+			f := &feed{t: mt, symbol: symbol, msgChan: make(ExchangeApi.MessageChan)}
+			if err := t.subscribe(f); err != nil {
+				t.started = false
+				close(t.stop)
+				t.closeSubscriptions()
+				t.lock.Unlock()
+				t.loops.Wait()
+				return err
+			}
+			t.feeds = append(t.feeds, f)
+			t.loops.Add(1)
+			go t.listen(f)
+		}
+	}
+	t.loops.Add(2)
+	go t.notify()
+	go t.fundingLoop()
+	t.lock.Unlock()
+
+	for _, symbol := range symbols {
+		if err := t.Seed(symbol); err != nil {
+			t.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop close the subscriptions and wait for the goroutines to exit, the positions are kept
+func (t *Tracker) Stop() error {
+	t.lock.Lock()
+	if !t.started {
+		t.lock.Unlock()
+		return nil
+	}
+	t.started = false
+	close(t.stop)
+	err := t.closeSubscriptions()
+	t.lock.Unlock()
+	t.loops.Wait()
+	return err
+}
+
+// Seed replace the positions of the symbol by FetchPositions, the PnL is kept
+func (t *Tracker) Seed(symbol string) error {
+	positions, err := t.exchange.FetchPositions(symbol)
+	if err != nil {
+		return err
+	}
+	t.update(ExchangeApi.FuturePositonsUpdate{Symbol: symbol, Positons: positions}, true)
+	return nil
+}
+
+// Position the position of the symbol and side
+func (t *Tracker) Position(symbol string, side ExchangeApi.PositionType) (Position, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p, ok := t.positions[key{symbol: symbol, side: side}]
+	if !ok {
+		return Position{Symbol: symbol, Side: side}, false
+	}
+	return *p, true
+}
+
+// Positions the positions of the symbol, or of all the symbols if empty, the closed ones with their PnL included
+func (t *Tracker) Positions(symbol string) []Position {
+	t.lock.Lock()
+	var positions []Position
+	for k, p := range t.positions {
+		if symbol == "" || k.symbol == symbol {
+			positions = append(positions, *p)
+		}
+	}
+	t.lock.Unlock()
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Symbol != positions[j].Symbol {
+			return positions[i].Symbol < positions[j].Symbol
+		}
+		return positions[i].Side < positions[j].Side
+	})
+	return positions
+}
+
+// PnL the realized PnL with the fundings and the unrealized PnL of all positions
+func (t *Tracker) PnL() (realized, unrealized float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, p := range t.positions {
+		realized += p.RealizedPnL + p.Funding
+		unrealized += p.UnrealizedPnL
+	}
+	return
+}
+
+// position the position of the key, created if not exist, it must be called with the lock held
+func (t *Tracker) position(k key) *Position {
+	p, ok := t.positions[k]
+	if !ok {
+		p = &Position{Symbol: k.symbol, Side: k.side}
+		if mark, ok := t.markPrice(k.symbol); ok {
+			p.MarkPrice = mark
+		}
+		t.positions[k] = p
+	}
+	return p
+}
+
+// markPrice the mark price of any position of the symbol
+func (t *Tracker) markPrice(symbol string) (float64, bool) {
+	for _, side := range []ExchangeApi.PositionType{ExchangeApi.PositionLong, ExchangeApi.PositionShort} {
+		if p, ok := t.positions[key{symbol: symbol, side: side}]; ok && p.MarkPrice > 0 {
+			return p.MarkPrice, true
+		}
+	}
+	return 0, false
+}
+
+// update apply the positions from the exchange, they are the amounts, not the changes.
+// The sides missing are closed if all is true, that is the whole positions of the symbol.
+func (t *Tracker) update(update ExchangeApi.FuturePositonsUpdate, all bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	seen := make(map[key]bool)
+	now := time.Now()
+	for _, fp := range update.Positons {
+		symbol := fp.Symbol
+		if symbol == "" {
+			symbol = update.Symbol
+		}
+		side, amount := fp.PositionType, fp.Amount
+		if side != ExchangeApi.PositionLong && side != ExchangeApi.PositionShort {
+			// the one-way mode, the sign is the side
+			side = ExchangeApi.PositionLong
+			if amount < 0 {
+				side = ExchangeApi.PositionShort
+			}
+			if amount == 0 {
+				// nothing to tell which side is closed
+				for _, s := range []ExchangeApi.PositionType{ExchangeApi.PositionLong, ExchangeApi.PositionShort} {
+					if p, ok := t.positions[key{symbol: symbol, side: s}]; ok {
+						p.Amount, p.AvgPrice, p.Updated = 0, 0, now
+						p.mark(p.MarkPrice)
+					}
+				}
+				continue
+			}
+		}
+		k := key{symbol: symbol, side: side}
+		seen[k] = true
+		p := t.position(k)
+		p.Amount = math.Abs(amount)
+		p.AvgPrice = SafeParseFloat(fp.AvgPrice)
+		p.LiquidatePrice = SafeParseFloat(fp.LiquidatePrice)
+		p.Margin = SafeParseFloat(fp.Margin)
+		p.MaintainMargin = SafeParseFloat(fp.MaintainMargin)
+		if fp.MarginMode != "" {
+			p.MarginMode = fp.MarginMode
+		}
+		if fp.Leverage > 0 {
+			p.Leverage = fp.Leverage
+		}
+		p.Updated = now
+		p.mark(p.MarkPrice)
+		t.check(k, p)
+	}
+	if !all {
+		return
+	}
+	for k, p := range t.positions {
+		if k.symbol == update.Symbol && !seen[k] && p.Amount != 0 {
+			p.Amount, p.AvgPrice, p.Updated = 0, 0, now
+			p.mark(p.MarkPrice)
+		}
+	}
+}
+
+// mark update the unrealized PnL of the positions of the symbol by the mark price
+func (t *Tracker) mark(symbol string, price float64) {
+	if price <= 0 {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, side := range []ExchangeApi.PositionType{ExchangeApi.PositionLong, ExchangeApi.PositionShort} {
+		k := key{symbol: symbol, side: side}
+		if p, ok := t.positions[k]; ok {
+			p.mark(price)
+			t.check(k, p)
+		}
+	}
+}
+
+// order apply the newly filled amount of the order to the positions, the realized PnL is taken from it.
+// The position subscription corrects the amounts later.
+func (t *Tracker) order(order ExchangeApi.Order) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	filled, cost := SafeParseFloat(order.Filled), SafeParseFloat(order.Cost)
+	last := t.fills[order.ID]
+	next := last
+	if filled > last.filled {
+		next.filled, next.cost = filled, cost
+	}
+	if next.finished.IsZero() && (order.Status == ExchangeApi.Close || order.Status == ExchangeApi.Canceled || order.Status == ExchangeApi.Rejected) {
+		next.finished = time.Now()
+	}
+	t.fills[order.ID] = next
+	amount := filled - last.filled
+	if amount <= 0 {
+		// a repeated or late event
+		return
+	}
+	price := SafeParseFloat(order.Price)
+	if cost > last.cost {
+		price = (cost - last.cost) / amount
+	}
+	long, short := key{order.Symbol, ExchangeApi.PositionLong}, key{order.Symbol, ExchangeApi.PositionShort}
+	switch order.Side {
+	case ExchangeApi.OpenLong:
+		t.position(long).open(amount, price)
+	case ExchangeApi.OpenShort:
+		t.position(short).open(amount, price)
+	case ExchangeApi.CloseLong:
+		t.position(long).close(amount, price)
+	case ExchangeApi.CloseShort:
+		t.position(short).close(amount, price)
+	case ExchangeApi.Buy:
+		// the one-way mode, a buy closes the short first
+		if rest := t.position(short).close(amount, price); rest > 0 {
+			t.position(long).open(rest, price)
+		}
+	case ExchangeApi.Sell:
+		if rest := t.position(long).close(amount, price); rest > 0 {
+			t.position(short).open(rest, price)
+		}
+	default:
+		return
+	}
+	now := time.Now()
+	for _, k := range []key{long, short} {
+		if p, ok := t.positions[k]; ok {
+			p.Updated = now
+			p.mark(p.MarkPrice)
+			t.check(k, p)
+		}
+	}
+}
+
+// fund apply the funding rate to the positions of the symbol at their mark prices, the longs pay a positive rate
+func (t *Tracker) fund(symbol string, rate float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, side := range []ExchangeApi.PositionType{ExchangeApi.PositionLong, ExchangeApi.PositionShort} {
+		p, ok := t.positions[key{symbol: symbol, side: side}]
+		if !ok || p.Amount == 0 {
+			continue
+		}
+		price := p.MarkPrice
+		if price == 0 {
+			price = p.AvgPrice
+		}
+		payment := rate * p.Amount * price
+		if side == ExchangeApi.PositionLong {
+			payment = -payment
+		}
+		p.Funding += payment
+	}
+}
+
+// check send the alerts of the thresholds the position crossed, it must be called with the lock held
+func (t *Tracker) check(k key, p *Position) {
+	if ratio, ok := p.MarginRatio(); ok && t.options.MarginRatioAlert > 0 {
+		t.alert(alertKey{k, MarginRatioAlert}, p, ratio, t.options.MarginRatioAlert, ratio >= t.options.MarginRatioAlert)
+	}
+	if distance, ok := p.LiquidationDistance(); ok && t.options.LiquidationDistanceAlert > 0 {
+		t.alert(alertKey{k, LiquidationAlert}, p, distance, t.options.LiquidationDistanceAlert, distance <= t.options.LiquidationDistanceAlert)
+	}
+	if p.Amount == 0 {
+		// a closed position is back whatever its prices
+		for _, at := range []AlertType{MarginRatioAlert, LiquidationAlert} {
+			ak := alertKey{k, at}
+			if t.alerted[ak] {
+				t.alert(ak, p, 0, 0, false)
+			}
+		}
+	}
+}
+
+func (t *Tracker) alert(k alertKey, p *Position, value, threshold float64, crossed bool) {
+	crossed = crossed && p.Amount > 0
+	if t.alerted[k] == crossed {
+		return
+	}
+	t.alerted[k] = crossed
+	if !t.started || t.out == nil {
+		return
+	}
+	t.alerts = append(t.alerts, Alert{Type: k.t, Position: *p, Value: value, Threshold: threshold, Cleared: !crossed})
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// notify send the alerts to out in order, without blocking the updates
+func (t *Tracker) notify() {
+	defer t.loops.Done()
+	for {
+		select {
+		case <-t.wake:
+		case <-t.stop:
+			return
+		}
+		t.lock.Lock()
+		alerts := t.alerts
+		t.alerts = nil
+		t.lock.Unlock()
+		for _, alert := range alerts {
+			select {
+			case t.out <- ExchangeApi.Message{Type: ExchangeApi.MsgPositions, Data: alert}:
+			case <-t.stop:
+				return
+			}
+		}
+	}
+}
+
+func (t *Tracker) subscribe(f *feed) (err error) {
+	f.sub, err = ExchangeApi.Resubscribe(func() (*ExchangeApi.Subscription, error) {
+		switch f.t {
+		case ExchangeApi.MsgPositions:
+			return t.exchange.SubscribePositions(f.symbol, f.msgChan)
+		case ExchangeApi.MsgMarkPrice:
+			return t.exchange.SubscribeMarkPrice(f.symbol, f.msgChan)
+		}
+		return t.exchange.SubscribeOrder(f.symbol, f.msgChan)
+	})
+	return err
+}
+
+func (t *Tracker) closeSubscriptions() error {
+	var subs ExchangeApi.Resubscriptions
+	for _, f := range t.feeds {
+		subs = append(subs, f.sub)
+	}
+	t.feeds = nil
+	return subs.Close()
+}
+
+func (t *Tracker) listen(f *feed) {
+	defer t.loops.Done()
+	for {
+		select {
+		case msg := <-f.msgChan:
+			t.handle(f, msg)
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+func (t *Tracker) handle(f *feed, msg ExchangeApi.Message) {
+	switch data := msg.Data.(type) {
+	case ExchangeApi.FuturePositonsUpdate:
+		// the account wide subscriptions carry the positions of other symbols
+		t.lock.Lock()
+		tracked := t.symbols[data.Symbol]
+		t.lock.Unlock()
+		if tracked {
+			t.update(data, false)
+		}
+	case ExchangeApi.MarkPrice:
+		if strings.EqualFold(data.Symbol, f.symbol) {
+			t.mark(f.symbol, SafeParseFloat(data.Price))
+		}
+	case ExchangeApi.Order:
+		if strings.EqualFold(data.Symbol, f.symbol) {
+			data.Symbol = f.symbol
+			t.order(data)
+		}
+	}
+	if msg.Type != ExchangeApi.MsgReConnected {
+		return
+	}
+	// the positions changed in between are fetched
+	f.sub.Renew()
+	if f.t == ExchangeApi.MsgPositions {
+		t.Seed(f.symbol)
+	}
+}
+
+// fundingLoop apply the fundings when their times come, the next rates are fetched then.
+// The fills of the orders finished long ago are dropped by it too.
+func (t *Tracker) fundingLoop() {
+	defer t.loops.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		t.checkFundings(time.Duration(time.Now().UnixNano() / 1e6))
+		select {
+		case <-ticker.C:
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+func (t *Tracker) checkFundings(now time.Duration) {
+	t.lock.Lock()
+	for id, f := range t.fills {
+		if !f.finished.IsZero() && time.Since(f.finished) > fillRetention {
+			delete(t.fills, id)
+		}
+	}
+	var symbols []string
+	for symbol := range t.symbols {
+		symbols = append(symbols, symbol)
+	}
+	t.lock.Unlock()
+	for _, symbol := range symbols {
+		f := t.fundings[symbol]
+		switch {
+		case f == nil || f.rate.NextTimestamp <= f.applied:
+			// no rate of the coming funding yet, it may take a while for the exchange to roll over
+			if f == nil || now >= f.retryAt {
+				t.fetchFunding(symbol, now)
+			}
+		case f.rate.NextTimestamp <= now:
+			t.fund(symbol, SafeParseFloat(f.rate.Rate))
+			f.applied = f.rate.NextTimestamp
+			t.fetchFunding(symbol, now)
+		case !f.refreshed && f.rate.NextTimestamp-now <= time.Duration(fundingRefresh.Milliseconds()):
+			// the rate changes until the funding time
+			t.fetchFunding(symbol, now)
+			f.refreshed = true
+		}
+	}
+}
+
+// fetchFunding fetch the rate of the coming funding, the next fetch backs off if it fails or isn't rolled over yet
+func (t *Tracker) fetchFunding(symbol string, now time.Duration) {
+	f, ok := t.fundings[symbol]
+	if !ok {
+		f = &funding{}
+		t.fundings[symbol] = f
+	}
+	rate, err := t.exchange.FetchFundingRate(symbol)
+	if err != nil || rate.NextTimestamp <= f.applied {
+		f.retry *= 2
+		if f.retry < fundingRetry {
+			f.retry = fundingRetry
+		}
+		if f.retry > fundingRetryMax {
+			f.retry = fundingRetryMax
+		}
+		f.retryAt = now + time.Duration(f.retry.Milliseconds())
+		return
+	}
+	f.retry, f.retryAt = 0, 0
+	if rate.NextTimestamp != f.rate.NextTimestamp {
+		f.refreshed = false
+	}
+	f.rate = rate
+}
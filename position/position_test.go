@@ -0,0 +1,145 @@
+package position
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+)
+
+func receive(t *testing.T, out ExchangeApi.MessageChan) Alert {
+	t.Helper()
+	return testutil.Receive(t, out, "alert").Data.(Alert)
+}
+
+func TestTracker(t *testing.T) {
+	exchange := mock.New("binance")
+	exchange.SetPositions("BTC/USDT", []ExchangeApi.FuturePositons{{
+		Symbol: "BTC/USDT", PositionType: ExchangeApi.PositionLong, Amount: 2, AvgPrice: "100",
+		LiquidatePrice: "80", Margin: "40", MaintainMargin: "2",
+	}})
+	tracker := New(exchange, Options{LiquidationDistanceAlert: 0.1, MarginRatioAlert: 0.5})
+	out := make(ExchangeApi.MessageChan, 10)
+	if err := tracker.Start([]string{"BTC/USDT"}, out); err != nil {
+		t.Fatal(err)
+	}
+	defer tracker.Stop()
+	if p, ok := tracker.Position("BTC/USDT", ExchangeApi.PositionLong); !ok || p.Amount != 2 || p.AvgPrice != 100 {
+		t.Fatalf("unexpected seeded position %+v", p)
+	}
+
+	exchange.SetMarkPrice("BTC/USDT", 110)
+	testutil.WaitFor(t, func() bool { p, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionLong); return p.MarkPrice == 110 })
+	p, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionLong)
+	if !testutil.Near(p.UnrealizedPnL, 20) || !testutil.Near(p.Notional(), 220) {
+		t.Fatalf("unexpected position %+v", p)
+	}
+	if ratio, ok := p.MarginRatio(); !ok || !testutil.Near(ratio, 2.0/60) {
+		t.Fatalf("unexpected margin ratio %v", ratio)
+	}
+
+	// within 10% of the liquidation price
+	exchange.SetMarkPrice("BTC/USDT", 85)
+	if a := receive(t, out); a.Type != LiquidationAlert || a.Cleared || !testutil.Near(a.Value, 5.0/85) {
+		t.Fatalf("unexpected alert %+v", a)
+	}
+	exchange.SetMarkPrice("BTC/USDT", 100)
+	if a := receive(t, out); a.Type != LiquidationAlert || !a.Cleared {
+		t.Fatalf("unexpected alert %+v", a)
+	}
+
+	// the fill closing half of the position realizes its PnL
+	order, err := exchange.CreateOrder("BTC/USDT", 120, 1, ExchangeApi.CloseLong, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange.Fill(order.ID, 120, 1)
+	testutil.WaitFor(t, func() bool { realized, _ := tracker.PnL(); return realized != 0 })
+	p, _ = tracker.Position("BTC/USDT", ExchangeApi.PositionLong)
+	if !testutil.Near(p.RealizedPnL, 20) || p.Amount != 1 || !testutil.Near(p.UnrealizedPnL, 0) {
+		t.Fatalf("unexpected position after the fill %+v", p)
+	}
+
+	// the positions from the exchange are the amounts, the missing side of the symbol is closed by the seed
+	exchange.SetPositions("BTC/USDT", nil)
+	if err := tracker.Seed("BTC/USDT"); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionLong); p.Amount != 0 || !testutil.Near(p.RealizedPnL, 20) {
+		t.Fatalf("unexpected closed position %+v", p)
+	}
+}
+
+func TestTracker_OneWay(t *testing.T) {
+	tracker := New(mock.New("binance"), Options{})
+	tracker.update(ExchangeApi.FuturePositonsUpdate{Symbol: "BTC/USDT", Positons: []ExchangeApi.FuturePositons{
+		{Amount: -1, AvgPrice: "100", PositionType: ExchangeApi.PositionTypeUnKonwn},
+	}}, true)
+	// a buy of 3 closes the short of 1 and opens a long of 2
+	tracker.order(ExchangeApi.Order{ID: "1", Symbol: "BTC/USDT", Side: ExchangeApi.Buy, Filled: "3", Cost: "270", Status: ExchangeApi.Close})
+	short, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionShort)
+	long, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionLong)
+	if short.Amount != 0 || !testutil.Near(short.RealizedPnL, 10) || long.Amount != 2 || !testutil.Near(long.AvgPrice, 90) {
+		t.Fatalf("unexpected positions %+v %+v", short, long)
+	}
+	// a repeated event is not applied again
+	tracker.order(ExchangeApi.Order{ID: "1", Symbol: "BTC/USDT", Side: ExchangeApi.Buy, Filled: "3", Cost: "270", Status: ExchangeApi.Partial})
+	if long, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionLong); long.Amount != 2 {
+		t.Fatalf("the repeated fill is applied %+v", long)
+	}
+}
+
+func TestTracker_Funding(t *testing.T) {
+	exchange := mock.New("binance")
+	exchange.SetFundingRate("BTC/USDT", ExchangeApi.FundingRate{Rate: "0.001", NextTimestamp: 1000})
+	tracker := New(exchange, Options{})
+	tracker.symbols["BTC/USDT"] = true
+	tracker.update(ExchangeApi.FuturePositonsUpdate{Symbol: "BTC/USDT", Positons: []ExchangeApi.FuturePositons{
+		{PositionType: ExchangeApi.PositionLong, Amount: 2, AvgPrice: "100"},
+		{PositionType: ExchangeApi.PositionShort, Amount: 1, AvgPrice: "100"},
+	}}, true)
+	tracker.mark("BTC/USDT", 200)
+
+	tracker.checkFundings(500)
+	tracker.checkFundings(999)
+	if realized, _ := tracker.PnL(); realized != 0 {
+		t.Fatalf("funded before the time %v", realized)
+	}
+	tracker.checkFundings(1000)
+	// the long pays 0.001*2*200, the short receives 0.001*1*200
+	long, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionLong)
+	short, _ := tracker.Position("BTC/USDT", ExchangeApi.PositionShort)
+	if !testutil.Near(long.Funding, -0.4) || !testutil.Near(short.Funding, 0.2) {
+		t.Fatalf("unexpected fundings %v %v", long.Funding, short.Funding)
+	}
+	// the exchange hasn't rolled over to the next funding time, it is not applied twice,
+	// and the rate is fetched again after a wait
+	tracker.checkFundings(1001)
+	exchange.SetFundingRate("BTC/USDT", ExchangeApi.FundingRate{Rate: "-0.001", NextTimestamp: 100000})
+	tracker.checkFundings(1002)
+	if f := tracker.fundings["BTC/USDT"]; f.rate.NextTimestamp != 1000 {
+		t.Fatalf("the rate is fetched again before the wait %+v", f.rate)
+	}
+	retry := time.Duration(fundingRetry.Milliseconds())
+	tracker.checkFundings(1000 + retry)
+	tracker.checkFundings(100000)
+	if realized, _ := tracker.PnL(); !testutil.Near(realized, -0.2+0.2) {
+		t.Fatalf("unexpected realized PnL %v", realized)
+	}
+
+	// the wait doubles while the fetch fails
+	exchange.SetError("FetchFundingRate", errors.New("timeout"))
+	tracker.checkFundings(100000 + retry)
+	if f := tracker.fundings["BTC/USDT"]; f.retry != fundingRetry*2 || f.retryAt != 100000+retry*3 {
+		t.Fatalf("unexpected retry %+v", f)
+	}
+	exchange.SetError("FetchFundingRate", nil)
+	exchange.SetFundingRate("BTC/USDT", ExchangeApi.FundingRate{Rate: "0.001", NextTimestamp: 200000})
+	tracker.checkFundings(100000 + retry*3)
+	if f := tracker.fundings["BTC/USDT"]; f.retry != 0 || f.rate.NextTimestamp != 200000 {
+		t.Fatalf("unexpected rate %+v", f)
+	}
+}
@@ -0,0 +1,302 @@
+// Package risk checks the orders before they are sent to the exchange: the notional, the position, the price band,
+// the daily loss, the open orders and the symbols allowed. A refused order fails with the ExError of ErrRiskRejected.
+package risk
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+// the checks named in the Data of the rejections
+const (
+	CheckSymbol     = "Symbol"
+	CheckNotional   = "MaxNotional"
+	CheckPosition   = "MaxPosition"
+	CheckPriceBand  = "PriceBand"
+	CheckDailyLoss  = "MaxDailyLoss"
+	CheckOpenOrders = "MaxOpenOrders"
+)
+
+// Limits the limits of the orders of a symbol, a zero limit is not checked
+type Limits struct {
+	MaxNotional   float64 // the price times the amount of an order, in the quote currency
+	MaxPosition   float64 // the absolute position in the base amount, if the order and the open orders of its side are filled
+	PriceBand     float64 // how far the price of a limit order may be from the reference price, eg. 0.05 means 5%
+	MaxOpenOrders int     // the open orders of the symbol before the order
+}
+
+type Options struct {
+	Limits                         // the limits of all symbols
+	Symbols      map[string]Limits // the limits of the symbols, their zero fields fall back to Options.Limits
+	MaxDailyLoss float64           // the loss of the UTC day beyond which only the closing orders are sent, measured by PnL
+	Allow        []string          // only the symbols are traded if not empty
+	Deny         []string          // the symbols never traded
+
+	// Price the reference price of the symbol, the mark price of a futures exchange or the last price of the ticker if nil
+	Price func(symbol string) (float64, error)
+	// Position the position of the symbol in the base amount, negative if short.
+	// It is the long minus the short positions of a futures exchange, or the base balance if nil.
+	Position func(symbol string) (float64, error)
+	// OpenOrders the open orders of the symbol, eg. the OpenOrders of an OMS, FetchOpenOrders if nil
+	OpenOrders func(symbol string) ([]ExchangeApi.Order, error)
+	// PnL the total PnL, eg. from a position tracker, it's needed by MaxDailyLoss.
+	// The loss of the day is measured from its value at the first check of the day.
+	PnL func() float64
+}
+
+// Gate an IExchange whose CreateOrder and CreateOrderWithClientID are checked, the other methods are passed through
+type Gate struct {
+	ExchangeApi.IExchange
+	options Options
+
+	// sending serializes the orders, so the checks of an order count the orders before
+	sending sync.Mutex
+	lock    sync.Mutex
+	day     time.Time // the UTC midnight of the day checked
+	dayPnL  float64   // the PnL at the first check of the day
+}
+
+func New(exchange ExchangeApi.IExchange, options Options) *Gate {
+	return &Gate{IExchange: exchange, options: options}
+}
+
+// CreateOrder the order is sent only if it passes the checks
+func (g *Gate) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	g.sending.Lock()
+	defer g.sending.Unlock()
+	if err := g.Check(symbol, price, amount, side, tradeType); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	return g.IExchange.CreateOrder(symbol, price, amount, side, tradeType, orderType, useClientID)
+}
+
+// CreateOrderWithClientID the order is sent only if it passes the checks, so the gate can be used by oms.Submit.
+// It fails with NotImplement if the exchange is not an IClientOrderExchange.
+func (g *Gate) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	c, ok := g.IExchange.(ExchangeApi.IClientOrderExchange)
+	if !ok {
+		return ExchangeApi.Order{}, ExchangeApi.ExError{Code: ExchangeApi.NotImplement, Message: "the exchange takes no client order id"}
+	}
+	g.sending.Lock()
+	defer g.sending.Unlock()
+	if err := g.Check(symbol, price, amount, side, tradeType); err != nil {
+		return ExchangeApi.Order{}, err
+	}
+	return c.CreateOrderWithClientID(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+// Check the order against the limits without sending it.
+// The checks fail closed: a reference price, position or open orders that can't be had refuse the order.
+func (g *Gate) Check(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType) error {
+	if !g.allowed(symbol) {
+		return reject(CheckSymbol, symbol, "the symbol is not allowed", 0, 0)
+	}
+	limits := g.limits(symbol)
+	market := tradeType == ExchangeApi.MARKET
+	if limits.PriceBand > 0 && !market || limits.MaxNotional > 0 && (market || price <= 0) {
+		ref, err := g.price(symbol)
+		if err != nil || ref <= 0 {
+			return reject(CheckPriceBand, symbol, fmt.Sprintf("no reference price: %v", err), 0, 0)
+		}
+		if limits.PriceBand > 0 && !market {
+			if deviation := math.Abs(price/ref - 1); deviation > limits.PriceBand {
+				return reject(CheckPriceBand, symbol, fmt.Sprintf("the price %v is off the reference %v", price, ref), deviation, limits.PriceBand)
+			}
+		}
+		// a market order is valued at the reference price
+		if market || price <= 0 {
+			price = ref
+		}
+	}
+	if notional := price * amount; limits.MaxNotional > 0 && notional > limits.MaxNotional {
+		return reject(CheckNotional, symbol, "the order is too large", notional, limits.MaxNotional)
+	}
+	closing := side == ExchangeApi.CloseLong || side == ExchangeApi.CloseShort
+	if g.options.MaxDailyLoss > 0 && g.options.PnL != nil && !closing {
+		if loss := -g.dailyPnL(time.Now()); loss >= g.options.MaxDailyLoss {
+			return reject(CheckDailyLoss, symbol, "the daily loss limit is reached", loss, g.options.MaxDailyLoss)
+		}
+	}
+	if limits.MaxOpenOrders <= 0 && limits.MaxPosition <= 0 {
+		return nil
+	}
+	orders, err := g.openOrders(symbol)
+	if err != nil {
+		return reject(CheckOpenOrders, symbol, fmt.Sprintf("no open orders: %v", err), 0, 0)
+	}
+	if limits.MaxOpenOrders > 0 && len(orders) >= limits.MaxOpenOrders {
+		return reject(CheckOpenOrders, symbol, "too many open orders", float64(len(orders)), float64(limits.MaxOpenOrders))
+	}
+	if limits.MaxPosition <= 0 || closing {
+		return nil
+	}
+	position, err := g.position(symbol)
+	if err != nil {
+		return reject(CheckPosition, symbol, fmt.Sprintf("no position: %v", err), 0, 0)
+	}
+	sign := Sign(side)
+	exposure := position + sign*amount
+	for _, o := range orders {
+		// the worst case, the open orders of the same side are filled too
+		if s := Sign(o.Side); s == sign {
+			exposure += s * (SafeParseFloat(o.Amount) - SafeParseFloat(o.Filled))
+		}
+	}
+	if math.Abs(exposure) > limits.MaxPosition && math.Abs(exposure) > math.Abs(position) {
+		return reject(CheckPosition, symbol, "the position would be too large", math.Abs(exposure), limits.MaxPosition)
+	}
+	return nil
+}
+
+// Sign +1 for the sides buying, -1 for the sides selling
+func Sign(side ExchangeApi.Side) float64 {
+	switch side {
+	case ExchangeApi.Sell, ExchangeApi.OpenShort, ExchangeApi.CloseLong:
+		return -1
+	}
+	return 1
+}
+
+func reject(check, symbol, message string, value, limit float64) ExchangeApi.ExError {
+	return ExchangeApi.ExError{
+		Code:    ExchangeApi.ErrRiskRejected,
+		Message: fmt.Sprintf("[risk] %s %s: %s", check, symbol, message),
+		Data:    map[string]interface{}{"check": check, "symbol": symbol, "value": value, "limit": limit},
+	}
+}
+
+func (g *Gate) allowed(symbol string) bool {
+	for _, s := range g.options.Deny {
+		if strings.EqualFold(s, symbol) {
+			return false
+		}
+	}
+	if len(g.options.Allow) == 0 {
+		return true
+	}
+	for _, s := range g.options.Allow {
+		if strings.EqualFold(s, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+// limits the limits of the symbol over the default ones
+func (g *Gate) limits(symbol string) Limits {
+	limits := g.options.Limits
+	s, ok := g.options.Symbols[symbol]
+	if !ok {
+		return limits
+	}
+	if s.MaxNotional != 0 {
+		limits.MaxNotional = s.MaxNotional
+	}
+	if s.MaxPosition != 0 {
+		limits.MaxPosition = s.MaxPosition
+	}
+	if s.PriceBand != 0 {
+		limits.PriceBand = s.PriceBand
+	}
+	if s.MaxOpenOrders != 0 {
+		limits.MaxOpenOrders = s.MaxOpenOrders
+	}
+	return limits
+}
+
+func (g *Gate) price(symbol string) (float64, error) {
+	if g.options.Price != nil {
+		return g.options.Price(symbol)
+	}
+	if future, ok := g.IExchange.(ExchangeApi.IFutureExchange); ok {
+		mark, err := future.FetchMarkPrice(symbol)
+		if err != nil {
+			return 0, err
+		}
+		return SafeParseFloat(mark.Price), nil
+	}
+	ticker, err := g.IExchange.FetchTicker(symbol)
+	if err != nil {
+		return 0, err
+	}
+	return ticker.Last, nil
+}
+
+func (g *Gate) position(symbol string) (float64, error) {
+	if g.options.Position != nil {
+		return g.options.Position(symbol)
+	}
+	if future, ok := g.IExchange.(ExchangeApi.IFutureExchange); ok {
+		positions, err := future.FetchPositions(symbol)
+		if err != nil {
+			return 0, err
+		}
+		var net float64
+		for _, p := range positions {
+			switch p.PositionType {
+			case ExchangeApi.PositionShort:
+				net -= math.Abs(p.Amount)
+			case ExchangeApi.PositionLong:
+				net += math.Abs(p.Amount)
+			default:
+				// the one-way mode, the sign is the side
+				net += p.Amount
+			}
+		}
+		return net, nil
+	}
+	balances, err := g.IExchange.FetchBalance()
+	if err != nil {
+		return 0, err
+	}
+	base := strings.ToUpper(strings.Split(symbol, "/")[0])
+	b := balances[base]
+	return b.Available + b.Frozen, nil
+}
+
+func (g *Gate) openOrders(symbol string) ([]ExchangeApi.Order, error) {
+	if g.options.OpenOrders != nil {
+		return g.options.OpenOrders(symbol)
+	}
+	return g.IExchange.FetchOpenOrders(symbol, 0, 0)
+}
+
+// dailyPnL the PnL since the first check of the UTC day
+func (g *Gate) dailyPnL(now time.Time) float64 {
+	pnl := g.options.PnL()
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if day := now.UTC().Truncate(time.Hour * 24); !day.Equal(g.day) {
+		g.day, g.dayPnL = day, pnl
+	}
+	return pnl - g.dayPnL
+}
+
+// FutureGate an IFutureExchange whose CreateOrder and CreateOrderWithClientID are checked
+type FutureGate struct {
+	ExchangeApi.IFutureExchange
+	gate *Gate
+}
+
+func NewFuture(exchange ExchangeApi.IFutureExchange, options Options) *FutureGate {
+	return &FutureGate{IFutureExchange: exchange, gate: New(exchange, options)}
+}
+
+func (g *FutureGate) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	return g.gate.CreateOrder(symbol, price, amount, side, tradeType, orderType, useClientID)
+}
+
+func (g *FutureGate) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	return g.gate.CreateOrderWithClientID(symbol, price, amount, side, tradeType, orderType, clientID)
+}
+
+// Check the order against the limits without sending it
+func (g *FutureGate) Check(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType) error {
+	return g.gate.Check(symbol, price, amount, side, tradeType)
+}
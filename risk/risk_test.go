@@ -0,0 +1,101 @@
+package risk
+
+import (
+	"testing"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/oms"
+)
+
+func check(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" {
+		if err != nil {
+			t.Fatalf("unexpected rejection %v", err)
+		}
+		return
+	}
+	e, ok := err.(ExchangeApi.ExError)
+	if !ok || e.Code != ExchangeApi.ErrRiskRejected || e.Data["check"] != want {
+		t.Fatalf("expected the rejection of %s, got %v", want, err)
+	}
+}
+
+func TestGate(t *testing.T) {
+	exchange := mock.New("binance")
+	exchange.SetMarkPrice("BTC/USDT", 100)
+	exchange.SetPositions("BTC/USDT", []ExchangeApi.FuturePositons{{Symbol: "BTC/USDT", PositionType: ExchangeApi.PositionLong, Amount: 3}})
+	pnl := 0.0
+	gate := NewFuture(exchange, Options{
+		Limits:       Limits{MaxNotional: 1000, PriceBand: 0.05, MaxPosition: 5, MaxOpenOrders: 2},
+		Symbols:      map[string]Limits{"ETH/USDT": {MaxNotional: 10}},
+		MaxDailyLoss: 50,
+		Deny:         []string{"DOGE/USDT"},
+		PnL:          func() float64 { return pnl },
+	})
+
+	// a market order 100x too large is valued at the mark price
+	_, err := gate.CreateOrder("BTC/USDT", 0, 1000, ExchangeApi.OpenLong, ExchangeApi.MARKET, ExchangeApi.Normal, false)
+	check(t, err, CheckNotional)
+	check(t, gate.Check("BTC/USDT", 110, 1, ExchangeApi.OpenLong, ExchangeApi.LIMIT), CheckPriceBand)
+	check(t, gate.Check("DOGE/USDT", 1, 1, ExchangeApi.OpenLong, ExchangeApi.LIMIT), CheckSymbol)
+	check(t, gate.Check("ETH/USDT", 100, 1, ExchangeApi.OpenLong, ExchangeApi.LIMIT), CheckPriceBand)
+	// the position of 3 with an open order of 1 and the order of 2 is over 5
+	order, err := gate.CreateOrder("BTC/USDT", 100, 1, ExchangeApi.OpenLong, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	check(t, err, "")
+	check(t, gate.Check("BTC/USDT", 100, 2, ExchangeApi.OpenLong, ExchangeApi.LIMIT), CheckPosition)
+	// the orders reducing the position pass
+	check(t, gate.Check("BTC/USDT", 100, 2, ExchangeApi.CloseLong, ExchangeApi.LIMIT), "")
+	check(t, gate.Check("BTC/USDT", 100, 6, ExchangeApi.Sell, ExchangeApi.LIMIT), "")
+
+	_, err = gate.CreateOrder("BTC/USDT", 100, 0.5, ExchangeApi.OpenShort, ExchangeApi.LIMIT, ExchangeApi.Normal, false)
+	check(t, err, "")
+	check(t, gate.Check("BTC/USDT", 100, 0.5, ExchangeApi.OpenShort, ExchangeApi.LIMIT), CheckOpenOrders)
+	exchange.CancelOrder("BTC/USDT", order.ID)
+
+	pnl = -60
+	check(t, gate.Check("BTC/USDT", 100, 0.5, ExchangeApi.OpenShort, ExchangeApi.LIMIT), CheckDailyLoss)
+	check(t, gate.Check("BTC/USDT", 100, 0.5, ExchangeApi.CloseLong, ExchangeApi.LIMIT), "")
+	if orders, _ := exchange.FetchOpenOrders("BTC/USDT", 0, 0); len(orders) != 1 {
+		t.Fatalf("unexpected orders sent %+v", orders)
+	}
+}
+
+func TestGate_Spot(t *testing.T) {
+	exchange := mock.New("binance")
+	exchange.SetBalance(ExchangeApi.Balance{Asset: "BTC", Available: 1, Frozen: 1})
+	exchange.SetTicker(ExchangeApi.Ticker{Symbol: "BTC/USDT", Last: 100})
+	gate := New(spot{exchange}, Options{Limits: Limits{MaxPosition: 3, PriceBand: 0.1}, Allow: []string{"BTC/USDT"}})
+
+	check(t, gate.Check("ETH/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT), CheckSymbol)
+	check(t, gate.Check("BTC/USDT", 95, 1, ExchangeApi.Buy, ExchangeApi.LIMIT), "")
+	check(t, gate.Check("BTC/USDT", 95, 1.5, ExchangeApi.Buy, ExchangeApi.LIMIT), CheckPosition)
+	check(t, gate.Check("BTC/USDT", 80, 0.5, ExchangeApi.Buy, ExchangeApi.LIMIT), CheckPriceBand)
+}
+
+func TestGate_Submit(t *testing.T) {
+	exchange := mock.New("binance")
+	gate := New(exchange, Options{Limits: Limits{MaxNotional: 1000}})
+
+	_, err := oms.Submit(gate, "BTC/USDT", 100, 20, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c1", oms.SubmitOptions{})
+	check(t, err, CheckNotional)
+	order, err := oms.Submit(gate, "BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c2", oms.SubmitOptions{})
+	if err != nil || order.ClientID != "c2" {
+		t.Fatalf("unexpected order %+v %v", order, err)
+	}
+	if orders, _ := exchange.FetchOpenOrders("BTC/USDT", 0, 0); len(orders) != 1 {
+		t.Fatalf("unexpected orders sent %+v", orders)
+	}
+
+	// the exchange takes no client order ID
+	_, err = oms.Submit(New(spot{exchange}, Options{}), "BTC/USDT", 100, 1, ExchangeApi.Buy, ExchangeApi.LIMIT, ExchangeApi.Normal, "c3", oms.SubmitOptions{})
+	if e, ok := err.(ExchangeApi.ExError); !ok || e.Code != ExchangeApi.NotImplement {
+		t.Fatalf("expected NotImplement, got %v", err)
+	}
+}
+
+// spot hides the futures methods of the mock
+type spot struct {
+	ExchangeApi.IExchange
+}
@@ -0,0 +1,445 @@
+// Package router splits an order across the exchanges trading the pair. The levels of their books are taken from the
+// best price after the taker fee, within the available balance of each venue. The child orders are sent at once as
+// IOC limit orders, the amount left unfilled is routed again on the new books.
+package router
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/oms"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+const (
+	DefaultDepth   = 20
+	DefaultRounds  = 3
+	DefaultTimeout = time.Second * 5
+	DefaultPoll    = time.Millisecond * 200
+)
+
+var (
+	ErrNoVenues = errors.New("no venue to route")
+	ErrAmount   = errors.New("the amount to route must be positive")
+)
+
+// Venue an exchange the order may be routed to
+type Venue struct {
+	Name     string
+	Exchange ExchangeApi.IExchange
+	Symbol   string  // the symbol traded on this exchange, the symbol of the router if empty
+	TakerFee float64 // the taker fee rate, eg. 0.001 means 0.1%
+
+	// the Options.ClientOrderIDPrefix of the exchange, the child orders are sent with client order IDs of it
+	// if the exchange is an IClientOrderExchange, so the orders failed ambiguously are looked up
+	ClientIDPrefix string
+}
+
+type Options struct {
+	Depth         int           // the levels of each book fetched, DefaultDepth if 0
+	Rounds        int           // how many times the order is routed, the first time included, DefaultRounds if 0
+	Timeout       time.Duration // how long a child order may stay open before it's canceled, DefaultTimeout if 0
+	Poll          time.Duration // how often the child orders are fetched until they end, DefaultPoll if 0
+	IgnoreBalance bool          // the balances are not fetched nor checked, eg. for a futures account
+
+	// Book the book of the venue, eg. kept from SubscribeOrderBook, FetchOrderBook if nil
+	Book func(v Venue) (ExchangeApi.OrderBook, error)
+	// Submit how the child orders failed ambiguously are looked up and sent again on an IClientOrderExchange
+	Submit oms.SubmitOptions
+}
+
+// Child a child order sent to a venue
+type Child struct {
+	Venue  string
+	Round  int
+	Price  float64 // the limit price, the worst level taken
+	Amount float64
+	Order  ExchangeApi.Order // the last state of the order
+	Filled float64
+	Cost   float64 // the quote amount of the fills
+	Fee    float64 // the taker fee of the fills, estimated by the fee rate of the venue
+	Err    error   // why the order failed, or can't be canceled or fetched
+
+	// the order may exist though its creation failed ambiguously (eg. timed out) and it's not found,
+	// its amount is not routed again
+	Unknown bool
+}
+
+// VenueError a venue left out of a round
+type VenueError struct {
+	Venue string
+	Round int
+	Err   error
+}
+
+func (e VenueError) Error() string {
+	return fmt.Sprintf("[router] %s is left out of round %d: %v", e.Venue, e.Round, e.Err)
+}
+
+// Report the parent order after it has been routed
+type Report struct {
+	Symbol   string
+	Side     ExchangeApi.Side
+	Amount   float64
+	Filled   float64
+	Cost     float64
+	Fees     float64
+	Unknown  float64 // the amount of the child orders which may exist, see Child.Unknown
+	Rounds   int     // the rounds that sent child orders
+	Children []Child
+	Errors   []VenueError
+	Start    time.Time
+	End      time.Time
+}
+
+// AvgPrice the average price of the fills before the fees
+func (r Report) AvgPrice() float64 {
+	if r.Filled == 0 {
+		return 0
+	}
+	return r.Cost / r.Filled
+}
+
+// EffectivePrice the average price of the fills after the fees: raised for a buy, lowered for a sell
+func (r Report) EffectivePrice() float64 {
+	if r.Filled == 0 {
+		return 0
+	}
+	if buying(r.Side) {
+		return (r.Cost + r.Fees) / r.Filled
+	}
+	return (r.Cost - r.Fees) / r.Filled
+}
+
+// Remaining the amount not filled, the Unknown included
+func (r Report) Remaining() float64 {
+	return math.Max(r.Amount-r.Filled, 0)
+}
+
+// Router route the orders of a symbol across the venues, it is safe for concurrent use
+type Router struct {
+	symbol  string
+	venues  []Venue
+	options Options
+
+	lock    sync.Mutex
+	markets map[string]*ExchangeApi.Market // of the venues, nil if the market is unknown
+}
+
+func New(symbol string, venues []Venue, options Options) *Router {
+	if options.Depth <= 0 {
+		options.Depth = DefaultDepth
+	}
+	if options.Rounds <= 0 {
+		options.Rounds = DefaultRounds
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.Poll <= 0 {
+		options.Poll = DefaultPoll
+	}
+	r := &Router{symbol: symbol, options: options, markets: make(map[string]*ExchangeApi.Market)}
+	for _, v := range venues {
+		if v.Symbol == "" {
+			v.Symbol = symbol
+		}
+		r.venues = append(r.venues, v)
+	}
+	return r
+}
+
+// Route fill the amount across the venues no worse than the limit price (none if 0), it returns once the child
+// orders have ended. The venue whose order fails is left out of the next rounds, and the amount of an order failed
+// ambiguously is not routed again unless the order is found not to exist. What's not filled after the rounds,
+// when no level is left within the limit, or when no venue can take what's left (eg. below their minimums),
+// is the Remaining of the report.
+func (r *Router) Route(side ExchangeApi.Side, amount, limitPrice float64) (Report, error) {
+	report := Report{Symbol: r.symbol, Side: side, Amount: amount, Start: time.Now()}
+	if len(r.venues) == 0 {
+		return report, ErrNoVenues
+	}
+	if amount <= 0 {
+		return report, ErrAmount
+	}
+	failed := make(map[string]bool)
+	for round := 1; round <= r.options.Rounds; round++ {
+		remaining := amount - report.Filled - report.Unknown
+		if remaining <= amount*1e-9 {
+			break
+		}
+		var venues []Venue
+		for _, v := range r.venues {
+			if !failed[v.Name] {
+				venues = append(venues, v)
+			}
+		}
+		children, errs := r.plan(venues, side, remaining, limitPrice, round)
+		report.Errors = append(report.Errors, errs...)
+		if len(children) == 0 {
+			// the next rounds would plan the same
+			break
+		}
+		report.Rounds = round
+		r.execute(side, children)
+		for _, c := range children {
+			if c.Err != nil && c.Order.ID == "" {
+				failed[c.Venue] = true
+			}
+			if c.Unknown {
+				report.Unknown += c.Amount
+			}
+			report.Filled += c.Filled
+			report.Cost += c.Cost
+			report.Fees += c.Fee
+			report.Children = append(report.Children, *c)
+		}
+	}
+	report.End = time.Now()
+	return report, nil
+}
+
+// quote a level of a venue
+type quote struct {
+	venue     int
+	price     float64
+	effective float64 // after the taker fee
+	amount    float64
+}
+
+// plan split the amount across the venues by the effective prices of their books
+func (r *Router) plan(venues []Venue, side ExchangeApi.Side, amount, limitPrice float64, round int) ([]*Child, []VenueError) {
+	buy := buying(side)
+	books := make([]ExchangeApi.OrderBook, len(venues))
+	capacity := make([]float64, len(venues)) // the base amount of a sell, the quote amount of a buy, -1 if unlimited
+	errs := make([]error, len(venues))
+	var wg sync.WaitGroup
+	for i := range venues {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			books[i], capacity[i], errs[i] = r.fetch(venues[i], buy)
+		}(i)
+	}
+	wg.Wait()
+
+	var venueErrs []VenueError
+	var quotes []quote
+	for i, v := range venues {
+		if errs[i] != nil {
+			venueErrs = append(venueErrs, VenueError{Venue: v.Name, Round: round, Err: errs[i]})
+			continue
+		}
+		levels, fee := books[i].Bids, -v.TakerFee
+		if buy {
+			levels, fee = books[i].Asks, v.TakerFee
+		}
+		for _, item := range levels {
+			price, size := SafeParseFloat(item.Price), SafeParseFloat(item.Amount)
+			if price <= 0 || size <= 0 || limitPrice > 0 && (buy && price > limitPrice || !buy && price < limitPrice) {
+				continue
+			}
+			quotes = append(quotes, quote{venue: i, price: price, effective: price * (1 + fee), amount: size})
+		}
+	}
+	// the best effective prices first, the venues listed first win the ties
+	sort.SliceStable(quotes, func(i, j int) bool {
+		if buy {
+			return quotes[i].effective < quotes[j].effective
+		}
+		return quotes[i].effective > quotes[j].effective
+	})
+
+	allocated := make([]*Child, len(venues))
+	for _, q := range quotes {
+		if amount <= 0 {
+			break
+		}
+		take := math.Min(q.amount, amount)
+		if c := capacity[q.venue]; c >= 0 {
+			if buy {
+				take = math.Min(take, c/q.effective)
+				capacity[q.venue] -= take * q.effective
+			} else {
+				take = math.Min(take, c)
+				capacity[q.venue] -= take
+			}
+		}
+		if take <= 0 {
+			continue
+		}
+		child := allocated[q.venue]
+		if child == nil {
+			child = &Child{Venue: venues[q.venue].Name, Round: round}
+			allocated[q.venue] = child
+		}
+		// the levels of a venue come from the best, so the last one is the worst price
+		child.Price = q.price
+		child.Amount += take
+		amount -= take
+	}
+
+	var children []*Child
+	for i, child := range allocated {
+		if child == nil {
+			continue
+		}
+		if market := r.market(venues[i]); market != nil {
+			price, size, err := market.NormalizeOrder(child.Price, child.Amount, side, ExchangeApi.LIMIT)
+			if err != nil {
+				// the allocation the venue can't take (eg. below its minimum) is not sent, it's routed again with
+				// what the other children leave if there is a next round, or it's in the Remaining of the report
+				venueErrs = append(venueErrs, VenueError{Venue: child.Venue, Round: round, Err: err})
+				continue
+			}
+			child.Price, child.Amount = price, size
+		}
+		children = append(children, child)
+	}
+	return children, venueErrs
+}
+
+// fetch the book and the capacity of the venue
+func (r *Router) fetch(v Venue, buy bool) (ExchangeApi.OrderBook, float64, error) {
+	var book ExchangeApi.OrderBook
+	var err error
+	if r.options.Book != nil {
+		book, err = r.options.Book(v)
+	} else {
+		book, err = v.Exchange.FetchOrderBook(v.Symbol, r.options.Depth)
+	}
+	if err != nil || r.options.IgnoreBalance {
+		return book, -1, err
+	}
+	balances, err := v.Exchange.FetchBalance()
+	if err != nil {
+		return book, 0, err
+	}
+	// the assets are named by the symbol of the router, eg. BTC/USDT
+	assets := strings.Split(strings.ToUpper(r.symbol), "/")
+	asset := assets[0]
+	if buy && len(assets) > 1 {
+		asset = assets[1]
+	}
+	return book, balances[asset].Available, nil
+}
+
+// market the market of the venue, it's fetched once and nil if unknown
+func (r *Router) market(v Venue) *ExchangeApi.Market {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if market, ok := r.markets[v.Name]; ok {
+		return market
+	}
+	markets, err := v.Exchange.FetchMarkets()
+	if err != nil {
+		// fetched again next time
+		return nil
+	}
+	var market *ExchangeApi.Market
+	if m, ok := markets[v.Symbol]; ok {
+		market = &m
+	}
+	r.markets[v.Name] = market
+	return market
+}
+
+// execute send the child orders at once and wait for them to end
+func (r *Router) execute(side ExchangeApi.Side, children []*Child) {
+	var wg sync.WaitGroup
+	for _, c := range children {
+		v := r.venue(c.Venue)
+		wg.Add(1)
+		go func(c *Child) {
+			defer wg.Done()
+			r.send(v, side, c)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// send the child order, then fetch it until it ends, or cancel it after the timeout
+func (r *Router) send(v Venue, side ExchangeApi.Side, c *Child) {
+	var order ExchangeApi.Order
+	var err error
+	if _, ok := v.Exchange.(ExchangeApi.IClientOrderExchange); ok {
+		clientID := GenerateOrderClientId(v.ClientIDPrefix, 32)
+		order, err = oms.Submit(v.Exchange, v.Symbol, c.Price, c.Amount, side, ExchangeApi.LIMIT, ExchangeApi.IOC, clientID, r.options.Submit)
+	} else {
+		order, err = v.Exchange.CreateOrder(v.Symbol, c.Price, c.Amount, side, ExchangeApi.LIMIT, ExchangeApi.IOC, false)
+	}
+	if err != nil {
+		c.Err = err
+		// the order not looked up may be live, routing its amount again may fill it twice
+		c.Unknown = oms.Ambiguous(err)
+		return
+	}
+	r.apply(v, c, order)
+	deadline := time.Now().Add(r.options.Timeout)
+	for !finished(c.Order) {
+		if time.Now().After(deadline) {
+			// not all exchanges take IOC, the order left is canceled so the remainder can be routed again
+			if err := v.Exchange.CancelOrder(v.Symbol, c.Order.ID); err != nil {
+				c.Err = err
+			}
+			if order, err := v.Exchange.FetchOrder(v.Symbol, c.Order.ID); err == nil {
+				r.apply(v, c, order)
+			}
+			return
+		}
+		time.Sleep(r.options.Poll)
+		order, err := v.Exchange.FetchOrder(v.Symbol, c.Order.ID)
+		if err != nil {
+			c.Err = err
+			continue
+		}
+		r.apply(v, c, order)
+	}
+}
+
+// apply the state of the order to the child, the fills only grow
+func (r *Router) apply(v Venue, c *Child, order ExchangeApi.Order) {
+	if order.ID == "" {
+		order.ID = c.Order.ID
+	}
+	c.Order = order
+	if filled := SafeParseFloat(order.Filled); filled > c.Filled {
+		c.Filled = filled
+		c.Cost = SafeParseFloat(order.Cost)
+		if c.Cost == 0 {
+			c.Cost = filled * c.Price
+		}
+		c.Fee = c.Cost * v.TakerFee
+	}
+}
+
+func (r *Router) venue(name string) Venue {
+	for _, v := range r.venues {
+		if v.Name == name {
+			return v
+		}
+	}
+	return Venue{}
+}
+
+func finished(order ExchangeApi.Order) bool {
+	switch order.Status {
+	case ExchangeApi.Close, ExchangeApi.Canceled, ExchangeApi.Rejected:
+		return true
+	}
+	return false
+}
+
+func buying(side ExchangeApi.Side) bool {
+	switch side {
+	case ExchangeApi.Buy, ExchangeApi.OpenLong, ExchangeApi.CloseShort:
+		return true
+	}
+	return false
+}
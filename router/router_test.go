@@ -0,0 +1,247 @@
+package router
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaolo66/ExchangeApi"
+	"github.com/xiaolo66/ExchangeApi/exchanges/mock"
+	"github.com/xiaolo66/ExchangeApi/internal/testutil"
+	. "github.com/xiaolo66/ExchangeApi/utils"
+)
+
+// taker a mock whose orders take the levels of its book at once, the rest is canceled as IOC.
+// The levels taken are removed, only hidden of them can be filled if it's not negative.
+type taker struct {
+	*mock.Exchange
+	lock   sync.Mutex
+	hidden float64
+}
+
+func newTaker(name string, asks ExchangeApi.Depth, hidden float64) *taker {
+	t := &taker{Exchange: mock.New(name), hidden: hidden}
+	t.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Asks: asks})
+	t.SetBalance(ExchangeApi.Balance{Asset: "USDT", Available: 1e6})
+	return t
+}
+
+func (t *taker) CreateOrder(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, useClientID bool) (ExchangeApi.Order, error) {
+	order, err := t.Exchange.CreateOrder(symbol, price, amount, side, tradeType, orderType, useClientID)
+	if err != nil {
+		return order, err
+	}
+	return t.take(symbol, price, amount, order), nil
+}
+
+func (t *taker) CreateOrderWithClientID(symbol string, price, amount float64, side ExchangeApi.Side, tradeType ExchangeApi.TradeType, orderType ExchangeApi.OrderType, clientID string) (ExchangeApi.Order, error) {
+	order, err := t.Exchange.CreateOrderWithClientID(symbol, price, amount, side, tradeType, orderType, clientID)
+	if err != nil {
+		return order, err
+	}
+	return t.take(symbol, price, amount, order), nil
+}
+
+// take fill the order by the asks within the price, the rest is canceled
+func (t *taker) take(symbol string, price, amount float64, order ExchangeApi.Order) ExchangeApi.Order {
+	t.lock.Lock()
+	book, _ := t.FetchOrderBook(symbol, 0)
+	var asks ExchangeApi.Depth
+	left := amount
+	for _, item := range book.Asks {
+		p, a := SafeParseFloat(item.Price), SafeParseFloat(item.Amount)
+		consumed := math.Min(a, left)
+		if p > price || consumed <= 0 {
+			asks = append(asks, item)
+			continue
+		}
+		take := consumed
+		if t.hidden >= 0 {
+			take = math.Min(take, t.hidden)
+			t.hidden -= take
+		}
+		if take > 0 {
+			order, _ = t.Fill(order.ID, p, take)
+		}
+		left -= consumed
+		if rest := a - consumed; rest > 0 {
+			asks = append(asks, ExchangeApi.DepthItem{Price: item.Price, Amount: strconv.FormatFloat(rest, 'f', -1, 64)})
+		}
+	}
+	book.Asks = asks
+	t.SetOrderBook(book)
+	t.lock.Unlock()
+	if order.Status != ExchangeApi.Close {
+		t.CancelOrder(symbol, order.ID)
+		order, _ = t.FetchOrder(symbol, order.ID)
+	}
+	return order
+}
+
+func TestRouter_Split(t *testing.T) {
+	binance := newTaker("binance", ExchangeApi.Depth{{Price: "100", Amount: "1"}, {Price: "101", Amount: "5"}}, -1)
+	okex := newTaker("okex", ExchangeApi.Depth{{Price: "100.05", Amount: "2"}}, -1)
+	r := New("BTC/USDT", []Venue{
+		{Name: "binance", Exchange: binance, TakerFee: 0.001},
+		{Name: "okex", Exchange: okex, TakerFee: 0.0001},
+	}, Options{Poll: time.Millisecond})
+
+	// after the fees okex is 100.060005, binance is 100.1 then 101.101
+	report, err := r.Route(ExchangeApi.Buy, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rounds != 1 || len(report.Children) != 2 || !testutil.Near(report.Filled, 3) || report.Remaining() != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, c := range report.Children {
+		switch c.Venue {
+		case "binance":
+			if c.Price != 100 || !testutil.Near(c.Filled, 1) || !testutil.Near(c.Fee, 0.1) {
+				t.Errorf("unexpected binance child %+v", c)
+			}
+		case "okex":
+			if c.Price != 100.05 || !testutil.Near(c.Filled, 2) || !testutil.Near(c.Cost, 200.1) {
+				t.Errorf("unexpected okex child %+v", c)
+			}
+		}
+	}
+	if !testutil.Near(report.Cost, 300.1) || !testutil.Near(report.EffectivePrice(), (300.1+0.1+0.02001)/3) {
+		t.Errorf("unexpected cost %v fees %v", report.Cost, report.Fees)
+	}
+}
+
+func TestRouter_Balance(t *testing.T) {
+	binance := newTaker("binance", ExchangeApi.Depth{{Price: "100", Amount: "5"}}, -1)
+	okex := newTaker("okex", ExchangeApi.Depth{{Price: "101", Amount: "5"}}, -1)
+	binance.SetBalance(ExchangeApi.Balance{Asset: "USDT", Available: 100})
+	r := New("BTC/USDT", []Venue{{Name: "binance", Exchange: binance}, {Name: "okex", Exchange: okex}}, Options{Poll: time.Millisecond})
+
+	report, _ := r.Route(ExchangeApi.Buy, 2, 0)
+	if len(report.Children) != 2 || !testutil.Near(report.Filled, 2) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	// binance can only pay for 1
+	for _, c := range report.Children {
+		if c.Venue == "binance" && !testutil.Near(c.Amount, 1) || c.Venue == "okex" && !testutil.Near(c.Amount, 1) {
+			t.Errorf("unexpected child %+v", c)
+		}
+	}
+}
+
+func TestRouter_Reroute(t *testing.T) {
+	// okex shows 2 but only 1 is there, the rest goes to the second level of binance
+	binance := newTaker("binance", ExchangeApi.Depth{{Price: "100", Amount: "1"}, {Price: "101", Amount: "5"}}, -1)
+	okex := newTaker("okex", ExchangeApi.Depth{{Price: "100.05", Amount: "2"}}, 1)
+	r := New("BTC/USDT", []Venue{
+		{Name: "binance", Exchange: binance, TakerFee: 0.001},
+		{Name: "okex", Exchange: okex, TakerFee: 0.0001},
+	}, Options{Poll: time.Millisecond})
+
+	report, _ := r.Route(ExchangeApi.Buy, 3, 0)
+	if report.Rounds != 2 || len(report.Children) != 3 || !testutil.Near(report.Filled, 3) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	last := report.Children[2]
+	if last.Venue != "binance" || last.Round != 2 || last.Price != 101 || !testutil.Near(last.Filled, 1) {
+		t.Errorf("unexpected rerouted child %+v", last)
+	}
+}
+
+func TestRouter_Limit(t *testing.T) {
+	binance := newTaker("binance", ExchangeApi.Depth{{Price: "100", Amount: "1"}, {Price: "101", Amount: "5"}}, -1)
+	okex := newTaker("okex", ExchangeApi.Depth{{Price: "100.05", Amount: "2"}}, -1)
+	r := New("BTC/USDT", []Venue{{Name: "binance", Exchange: binance}, {Name: "okex", Exchange: okex}}, Options{Poll: time.Millisecond})
+
+	report, _ := r.Route(ExchangeApi.Buy, 3, 100.02)
+	if report.Rounds != 1 || len(report.Children) != 1 || !testutil.Near(report.Filled, 1) || !testutil.Near(report.Remaining(), 2) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestRouter_MinQty(t *testing.T) {
+	binance := newTaker("binance", ExchangeApi.Depth{{Price: "100", Amount: "1"}}, -1)
+	okex := newTaker("okex", ExchangeApi.Depth{{Price: "100.05", Amount: "2"}}, -1)
+	binance.SetMarket(ExchangeApi.Market{Symbol: "BTC/USDT", AmountPrecision: 2})
+	okex.SetMarket(ExchangeApi.Market{Symbol: "BTC/USDT", AmountPrecision: 2, MinQty: 1})
+	r := New("BTC/USDT", []Venue{{Name: "binance", Exchange: binance}, {Name: "okex", Exchange: okex}}, Options{Poll: time.Millisecond})
+
+	// the 0.5 left for okex is below its minimum, no venue can take it
+	report, _ := r.Route(ExchangeApi.Buy, 1.5, 0)
+	if report.Rounds != 1 || !testutil.Near(report.Filled, 1) || !testutil.Near(report.Remaining(), 0.5) || len(report.Errors) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestRouter_Errors(t *testing.T) {
+	binance := newTaker("binance", ExchangeApi.Depth{{Price: "100", Amount: "1"}, {Price: "101", Amount: "5"}}, -1)
+	okex := newTaker("okex", ExchangeApi.Depth{{Price: "100.05", Amount: "2"}}, -1)
+	huobi := newTaker("huobi", ExchangeApi.Depth{{Price: "99", Amount: "2"}}, -1)
+	okex.SetError("CreateOrderWithClientID", errors.New("rejected"))
+	huobi.SetError("FetchOrderBook", errors.New("timeout"))
+	r := New("BTC/USDT", []Venue{
+		{Name: "binance", Exchange: binance},
+		{Name: "okex", Exchange: okex},
+		{Name: "huobi", Exchange: huobi},
+	}, Options{Poll: time.Millisecond})
+
+	// okex is left out after its order fails, huobi has no book in any round
+	report, _ := r.Route(ExchangeApi.Buy, 3, 0)
+	if report.Rounds != 2 || !testutil.Near(report.Filled, 3) || len(report.Errors) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, c := range report.Children {
+		if c.Venue == "okex" && c.Err == nil || c.Venue == "binance" && c.Err != nil {
+			t.Errorf("unexpected child %+v", c)
+		}
+	}
+
+	if _, err := New("BTC/USDT", nil, Options{}).Route(ExchangeApi.Buy, 1, 0); err != ErrNoVenues {
+		t.Errorf("expect ErrNoVenues, got %v", err)
+	}
+	if _, err := r.Route(ExchangeApi.Buy, 0, 0); err != ErrAmount {
+		t.Errorf("expect ErrAmount, got %v", err)
+	}
+}
+
+func TestRouter_Ambiguous(t *testing.T) {
+	binance := newTaker("binance", ExchangeApi.Depth{{Price: "100", Amount: "1"}, {Price: "101", Amount: "5"}}, -1)
+	okex := newTaker("okex", ExchangeApi.Depth{{Price: "100.05", Amount: "2"}}, -1)
+	// the order of okex is placed but its response is lost, and it can't be looked up
+	timeout := ExchangeApi.ExError{Code: ExchangeApi.ErrTimeout}
+	okex.SetErrorAfter("CreateOrderWithClientID", timeout)
+	okex.SetError("FetchOrder", timeout)
+	r := New("BTC/USDT", []Venue{{Name: "binance", Exchange: binance}, {Name: "okex", Exchange: okex}}, Options{Poll: time.Millisecond})
+
+	// the amount of okex is not routed to binance again
+	report, _ := r.Route(ExchangeApi.Buy, 3, 0)
+	if report.Rounds != 1 || !testutil.Near(report.Filled, 1) || !testutil.Near(report.Unknown, 2) || !testutil.Near(report.Remaining(), 2) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, c := range report.Children {
+		if c.Venue == "okex" && (!c.Unknown || c.Err == nil) {
+			t.Errorf("unexpected okex child %+v", c)
+		}
+	}
+	if orders, _ := okex.FetchOpenOrders("BTC/USDT", 0, 0); len(orders) != 1 || orders[0].ClientID == "" {
+		t.Errorf("expect the order of okex placed with a client order id, got %+v", orders)
+	}
+}
+
+func TestRouter_Timeout(t *testing.T) {
+	// the plain mock never fills, the order is canceled after the timeout
+	exchange := mock.New("binance")
+	exchange.SetOrderBook(ExchangeApi.OrderBook{Symbol: "BTC/USDT", Bids: ExchangeApi.Depth{{Price: "100", Amount: "1"}}})
+	r := New("BTC/USDT", []Venue{{Name: "binance", Exchange: exchange}}, Options{Rounds: 1, Timeout: time.Millisecond * 20, Poll: time.Millisecond, IgnoreBalance: true})
+
+	report, _ := r.Route(ExchangeApi.Sell, 1, 0)
+	if len(report.Children) != 1 || report.Children[0].Order.Status != ExchangeApi.Canceled || report.Filled != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if orders, _ := exchange.FetchOpenOrders("BTC/USDT", 0, 0); len(orders) != 0 {
+		t.Errorf("the child order is left open")
+	}
+}
@@ -0,0 +1,110 @@
+package ExchangeApi
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// TradeStream the trades of a symbol subscribed through an IExchange, for the builders deriving the messages from them
+type TradeStream struct {
+	symbol  string
+	sub     *Resubscription
+	msgChan MessageChan
+	lock    sync.Mutex
+	stop    chan struct{}
+	loop    sync.WaitGroup
+}
+
+// SubscribeTradeStream subscribe the trades of the symbol, they are not received until Run
+func SubscribeTradeStream(exchange IExchange, symbol string) (*TradeStream, error) {
+	s := &TradeStream{
+		symbol:  symbol,
+		msgChan: make(MessageChan),
+		stop:    make(chan struct{}),
+	}
+	sub, err := Resubscribe(func() (*Subscription, error) {
+		return exchange.SubscribeTrades(symbol, s.msgChan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.sub = sub
+	return s, nil
+}
+
+// Run hand the trades of the symbol to handle, and call tick every interval if it's positive,
+// the messages they return are sent to out until Stop
+func (s *TradeStream) Run(out MessageChan, interval time.Duration, handle func([]Trade) []Message, tick func() []Message) {
+	s.loop.Add(1)
+	go func() {
+		defer s.loop.Done()
+		var ticks <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			ticks = ticker.C
+		}
+		for {
+			var msgs []Message
+			select {
+			case msg := <-s.msgChan:
+				if trades := s.trades(msg); len(trades) > 0 {
+					msgs = handle(trades)
+				}
+			case <-ticks:
+				msgs = tick()
+			case <-s.stop:
+				return
+			}
+			for _, msg := range msgs {
+				select {
+				case out <- msg:
+				case <-s.stop:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Stop close the subscription and wait for the goroutine to exit, it is safe to call it more than once
+func (s *TradeStream) Stop() error {
+	s.lock.Lock()
+	select {
+	case <-s.stop:
+		s.lock.Unlock()
+		return nil
+	default:
+	}
+	close(s.stop)
+	err := s.sub.Close()
+	s.lock.Unlock()
+	s.loop.Wait()
+	return err
+}
+
+func (s *TradeStream) trades(msg Message) []Trade {
+	var trades []Trade
+	switch msg.Type {
+	case MsgTrade:
+		switch data := msg.Data.(type) {
+		case Trade:
+			trades = []Trade{data}
+		case []Trade:
+			trades = data
+		}
+	case MsgReConnected:
+		// the trades in between are missed
+		s.sub.Renew()
+		return nil
+	}
+	// the connection may carry the trades of other symbols
+	var own []Trade
+	for _, trade := range trades {
+		if strings.EqualFold(trade.Symbol, s.symbol) {
+			own = append(own, trade)
+		}
+	}
+	return own
+}
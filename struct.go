@@ -34,11 +34,59 @@ type Options struct {
 	// if not set, the rest API will be called to get the market data
 	Markets map[string]Market
 
+	// the limits of each websocket connection, the public topics are spread over more connections when a connection is full.
+	// the default value of the exchange will be used if not set, a negative value means no limit
+	WsMaxTopics     int // max topics subscribed on one websocket connection
+	WsSubscribeRate int // max subscribe/unsubscribe requests per second sent on one websocket connection
+
+	// the stale feed watchdog, a subscription receiving no data for StaleTimeout is notified by MsgStale, 0 means disabled.
+	// StaleTimeouts overrides the timeout of the message type, eg. the trades of an inactive market come slowly.
+	// The order, balance and position updates come only after trading, they are watched only if StaleTimeouts sets them
+	StaleTimeout  time.Duration
+	StaleTimeouts map[MessageType]time.Duration
+	StaleAction   StaleAction // what to do with the stale subscription after notified
+
+	RawMessage bool // keep the original payload in Message.Raw, for debugging the parsers
+
+	OrderBookDepth int // the max levels of each side of the incremental order books, DefaultBookDepth if not set, a negative value means no limit
+
 	AutoReconnect       bool   // whether enable auto reconnect
 	ProxyUrl            string // proxy, http://host:port
 	ClientOrderIDPrefix string // Prefix of client order id，len better(0~10)
 }
 
+type StaleAction int
+
+const (
+	StaleActionNone        StaleAction = iota // only notify the subscriber
+	StaleActionResubscribe                    // unsubscribe and subscribe the topic again on the same connection
+	StaleActionReconnect                      // reconnect the connection of the subscription, all of its subscriptions must be renewed
+)
+
+func (a StaleAction) String() string {
+	switch a {
+	case StaleActionNone:
+		return "None"
+	case StaleActionResubscribe:
+		return "Resubscribe"
+	case StaleActionReconnect:
+		return "Reconnect"
+	}
+	return "Unknown"
+}
+
+// ConnStats the statistics of a websocket connection
+type ConnStats struct {
+	Url         string
+	ConnID      uint64        // the id of the current physical connection
+	Topics      int           // the number of topics subscribed
+	LastRecv    time.Time     // when the last frame was received
+	PingRTT     time.Duration // the round trip time of the last ping
+	AvgPingRTT  time.Duration // the smoothed round trip time of ping
+	EventLag    time.Duration // the receive time minus the event time of the exchange, of the last event
+	AvgEventLag time.Duration // the smoothed event lag
+}
+
 type FutureOptions struct {
 	ContractType      ContractType
 	FutureAccountType FutureAccountType
@@ -53,6 +101,12 @@ type Market struct {
 	PricePrecision  int     // price precision
 	AmountPrecision int     // amount precision
 	Lot             float64 // min size
+	TickSize        float64 // the price increment, 10^-PricePrecision if 0
+	StepSize        float64 // the amount increment, 10^-AmountPrecision if 0
+	MinQty          float64 // the min amount of an order, Lot if 0
+	MaxQty          float64 // the max amount of an order, unlimited if 0
+	MinNotional     float64 // the min price*amount of an order, unlimited if 0
+	MaxNotional     float64 // the max price*amount of an order, unlimited if 0
 }
 
 func (m Market) String() string {
@@ -126,6 +180,8 @@ func (d Depth) RemoveByIndex(index int) Depth {
 	return d
 }
 
+// Update merge the levels and re-sort the depth, it's fine for a snapshot of a few levels,
+// the local order book maintained by the incremental updates should use Book instead
 func (d Depth) Update(newDepth RawDepth, reverse bool) Depth {
 	for _, rawItem := range newDepth {
 		item, err := rawItem.ParseRawDepthItem()
@@ -169,6 +225,45 @@ func (o *OrderBook) Sort() {
 	sort.Sort(o.Asks)
 }
 
+type BookSyncState int
+
+const (
+	BookResyncing BookSyncState = iota // a gap is found, the updates are buffered until the book is rebuilt from a snapshot
+	BookResynced                       // the book is rebuilt, the order books come again
+)
+
+func (s BookSyncState) String() string {
+	switch s {
+	case BookResyncing:
+		return "Resyncing"
+	case BookResynced:
+		return "Resynced"
+	}
+	return "Unknown"
+}
+
+// OrderBookStatus tell the subscribers of an incremental order book that it is being resynchronized,
+// no order book of the symbol comes between BookResyncing and BookResynced
+type OrderBookStatus struct {
+	Symbol  string
+	State   BookSyncState
+	Resyncs int    // the times the book has been resynchronized since subscribed
+	Reason  string // why the book is out of sync
+}
+
+// OrderBookDelta the levels of an incremental order book changed since the previous delta of the symbol,
+// the prices and amounts are normalized decimals and a level of amount "0" is removed.
+// Seq increases by 1 with each delta, a snapshot carries the whole book and replaces the local one,
+// the following deltas continue from its Seq. A missed Seq means the local book is broken until the next snapshot,
+// see Book.ApplyDelta and IExchange.RequestOrderBookSnapshot.
+type OrderBookDelta struct {
+	Symbol   string
+	Seq      uint64
+	Snapshot bool
+	Bids     Depth
+	Asks     Depth
+}
+
 type (
 	KLineType   int
 	Side        string
@@ -196,6 +291,41 @@ const (
 	KLine1Month
 )
 
+// Duration the length of the kline, 0 for KLine1Month and KLineUnknown since a month is not of fixed length
+func (t KLineType) Duration() time.Duration {
+	switch t {
+	case KLine1Minute:
+		return time.Minute
+	case KLine3Minute:
+		return time.Minute * 3
+	case KLine5Minute:
+		return time.Minute * 5
+	case KLine15Minute:
+		return time.Minute * 15
+	case KLine30Minute:
+		return time.Minute * 30
+	case KLine1Hour:
+		return time.Hour
+	case KLine2Hour:
+		return time.Hour * 2
+	case KLine4Hour:
+		return time.Hour * 4
+	case KLine6Hour:
+		return time.Hour * 6
+	case KLine8Hour:
+		return time.Hour * 8
+	case KLine12Hour:
+		return time.Hour * 12
+	case KLine1Day:
+		return time.Hour * 24
+	case KLine3Day:
+		return time.Hour * 24 * 3
+	case KLine1Week:
+		return time.Hour * 24 * 7
+	}
+	return 0
+}
+
 const (
 	SideUnknown Side = "Unknown"
 	Buy              = "BUY"
@@ -225,6 +355,7 @@ const (
 	Partial                        = "partial"
 	Close                          = "close"
 	Canceled                       = "canceled"
+	Rejected                       = "rejected" // the order was refused by the exchange or before sent to it
 )
 
 type Ticker struct {
@@ -260,6 +391,55 @@ type KLine struct {
 	Volume    float64
 }
 
+// BarType how the trades are sampled into a bar
+type BarType int
+
+const (
+	TickBar          BarType = iota // a bar of a number of trades
+	VolumeBar                       // a bar of an amount of base currency
+	DollarBar                       // a bar of an amount of quote currency
+	TickImbalanceBar                // a bar closed when the imbalance of the buy and sell trades exceeds its expectation
+)
+
+func (t BarType) String() string {
+	switch t {
+	case TickBar:
+		return "Tick"
+	case VolumeBar:
+		return "Volume"
+	case DollarBar:
+		return "Dollar"
+	case TickImbalanceBar:
+		return "TickImbalance"
+	}
+	return "Unknown"
+}
+
+// Bar the trades sampled by activity rather than time, it is the data of MsgBar
+type Bar struct {
+	Symbol      string
+	Type        BarType
+	Start       time.Duration // the timestamp of the first trade
+	End         time.Duration // the timestamp of the last trade
+	Open        float64
+	High        float64
+	Low         float64
+	Close       float64
+	Volume      float64 // the base amount
+	QuoteVolume float64 // the quote amount
+	BuyVolume   float64 // the base amount of the buy trades
+	Trades      int
+	Imbalance   float64 // the sum of the signs of the trades, +1 for a buy and -1 for a sell
+}
+
+// VWAP the volume weighted average price
+func (b Bar) VWAP() float64 {
+	if b.Volume == 0 {
+		return 0
+	}
+	return b.QuoteVolume / b.Volume
+}
+
 type Order struct {
 	ID              string
 	ClientID        string
@@ -286,6 +466,7 @@ type Balance struct {
 type BalanceUpdate struct {
 	UpdateTime time.Duration
 	Balances   map[string]Balance
+	Delta      bool // the Available of the balances are the changes by a deposit, withdrawal or transfer, not the amounts
 }
 
 type ContractType string
@@ -0,0 +1,215 @@
+package ExchangeApi
+
+import (
+	"errors"
+	"sync"
+)
+
+type SubscriptionState int
+
+const (
+	SubscriptionActive SubscriptionState = iota // receiving data
+	SubscriptionClosed                          // closed by the caller
+	SubscriptionFailed                          // ended by the exchange side, see Err()
+)
+
+func (s SubscriptionState) String() string {
+	switch s {
+	case SubscriptionActive:
+		return "Active"
+	case SubscriptionClosed:
+		return "Closed"
+	case SubscriptionFailed:
+		return "Failed"
+	}
+	return "Unknown"
+}
+
+var (
+	// ErrSubscriptionReset the connection has been re-established, the subscription must be renewed
+	ErrSubscriptionReset = errors.New("connection re-established, subscription must be renewed")
+	// ErrConnectionClosed the connection carrying the subscription has been closed
+	ErrConnectionClosed = errors.New("connection closed")
+)
+
+// Subscription is the handle returned by the Subscribe* methods.
+// It remembers which topic was subscribed on which channel, so the caller can unsubscribe it without
+// having to know how the exchange names its streams (eg. the topic of binance user data is the listen key).
+type Subscription struct {
+	topic   string
+	symbol  string
+	msgType MessageType
+	msgChan MessageChan
+
+	lock      sync.Mutex
+	state     SubscriptionState
+	closing   bool // Close is unsubscribing, the concurrent calls return at once
+	err       error
+	done      chan struct{}
+	closeFunc func() error
+}
+
+// NewSubscription create an active subscription, closeFunc is invoked once when the caller closes it
+func NewSubscription(topic, symbol string, t MessageType, sub MessageChan, closeFunc func() error) *Subscription {
+	return &Subscription{
+		topic:     topic,
+		symbol:    symbol,
+		msgType:   t,
+		msgChan:   sub,
+		state:     SubscriptionActive,
+		done:      make(chan struct{}),
+		closeFunc: closeFunc,
+	}
+}
+
+// Topic the exchange specific stream name
+func (s *Subscription) Topic() string { return s.topic }
+
+// Symbol the unified symbol of the subscription, empty for account wide or all market streams
+func (s *Subscription) Symbol() string { return s.symbol }
+
+// Type the type of message delivered by this subscription
+func (s *Subscription) Type() MessageType { return s.msgType }
+
+// Chan the channel which receives the messages
+func (s *Subscription) Chan() MessageChan { return s.msgChan }
+
+func (s *Subscription) State() SubscriptionState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state
+}
+
+// Err the reason why the subscription failed, nil while active or closed by the caller
+func (s *Subscription) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Done is closed when the subscription is no longer active
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+// Close unsubscribe the topic, it is safe to call it more than once
+func (s *Subscription) Close() error {
+	s.lock.Lock()
+	if s.state != SubscriptionActive || s.closing {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closing = true
+	s.lock.Unlock()
+
+	var err error
+	if s.closeFunc != nil {
+		err = s.closeFunc()
+	}
+	s.End(nil)
+	return err
+}
+
+// End mark the subscription as finished without unsubscribing, used by the exchange implementations.
+// A nil err means closed normally, otherwise failed.
+func (s *Subscription) End(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state != SubscriptionActive {
+		return
+	}
+	if err == nil {
+		s.state = SubscriptionClosed
+	} else {
+		s.state = SubscriptionFailed
+		s.err = err
+	}
+	close(s.done)
+}
+
+type Subscriptions []*Subscription
+
+// Close unsubscribe all of the subscriptions, the first error is returned
+func (subs Subscriptions) Close() error {
+	var firstErr error
+	for _, s := range subs {
+		if s == nil {
+			continue
+		}
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// UnSubscribeAll unsubscribe a set of subscriptions, maybe from different exchanges
+func UnSubscribeAll(subs ...*Subscription) error {
+	return Subscriptions(subs).Close()
+}
+
+// Resubscription keep a subscription across the reconnections, the messages in between are missed
+type Resubscription struct {
+	lock      sync.Mutex
+	subscribe func() (*Subscription, error)
+	sub       *Subscription
+	closed    bool
+}
+
+// Resubscribe subscribe by the function, it is invoked again by Renew after the connection is re-established
+func Resubscribe(subscribe func() (*Subscription, error)) (*Resubscription, error) {
+	sub, err := subscribe()
+	if err != nil {
+		return nil, err
+	}
+	return &Resubscription{subscribe: subscribe, sub: sub}, nil
+}
+
+// Renew subscribe again on the new connection, it is called on MsgReConnected and does nothing once closed.
+// The exchange is not called with the lock held, so the receiver of the messages may call it.
+func (r *Resubscription) Renew() error {
+	r.lock.Lock()
+	closed := r.closed
+	r.lock.Unlock()
+	if closed {
+		return nil
+	}
+	// the old subscription went with the old connection
+	sub, err := r.subscribe()
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		// closed meanwhile
+		return UnSubscribeAll(sub)
+	}
+	r.sub = sub
+	r.lock.Unlock()
+	return err
+}
+
+// Close unsubscribe the current subscription, it is safe to call it more than once
+func (r *Resubscription) Close() error {
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return nil
+	}
+	r.closed = true
+	sub := r.sub
+	r.lock.Unlock()
+	return UnSubscribeAll(sub)
+}
+
+type Resubscriptions []*Resubscription
+
+// Close unsubscribe all of the subscriptions, the first error is returned
+func (subs Resubscriptions) Close() error {
+	var firstErr error
+	for _, s := range subs {
+		if s == nil {
+			continue
+		}
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,114 @@
+package ExchangeApi
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSubscription_Close(t *testing.T) {
+	calls := 0
+	sub := NewSubscription("btcusdt@trade", "BTC/USDT", MsgTrade, make(MessageChan), func() error {
+		calls++
+		return nil
+	})
+	if sub.State() != SubscriptionActive {
+		t.Fatalf("expect active, got %v", sub.State())
+	}
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = sub.Close()
+	if calls != 1 {
+		t.Errorf("close func called %d times", calls)
+	}
+	select {
+	case <-sub.Done():
+	default:
+		t.Error("done channel not closed")
+	}
+	if sub.State() != SubscriptionClosed || sub.Err() != nil {
+		t.Errorf("unexpected state %v err %v", sub.State(), sub.Err())
+	}
+}
+
+func TestSubscription_CloseConcurrently(t *testing.T) {
+	var calls int32
+	sub := NewSubscription("btcusdt@trade", "BTC/USDT", MsgTrade, make(MessageChan), func() error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = sub.Close()
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("close func called %d times", calls)
+	}
+}
+
+func TestSubscription_End(t *testing.T) {
+	sub := NewSubscription("topic", "", MsgBalance, make(MessageChan), func() error {
+		t.Error("close func should not be called after the subscription failed")
+		return nil
+	})
+	sub.End(ErrConnectionClosed)
+	if sub.State() != SubscriptionFailed || sub.Err() != ErrConnectionClosed {
+		t.Errorf("unexpected state %v err %v", sub.State(), sub.Err())
+	}
+	_ = sub.Close()
+}
+
+func TestUnSubscribeAll(t *testing.T) {
+	failed := errors.New("failed")
+	closed := 0
+	subs := []*Subscription{
+		NewSubscription("a", "", MsgTrade, nil, func() error { closed++; return nil }),
+		NewSubscription("b", "", MsgTrade, nil, func() error { closed++; return failed }),
+		nil,
+		NewSubscription("c", "", MsgTrade, nil, func() error { closed++; return nil }),
+	}
+	if err := UnSubscribeAll(subs...); err != failed {
+		t.Errorf("expect first error, got %v", err)
+	}
+	if closed != 3 {
+		t.Errorf("expect 3 closed, got %d", closed)
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	failed := errors.New("failed")
+	var subs []*Subscription
+	var err error
+	r, _ := Resubscribe(func() (*Subscription, error) {
+		if err != nil {
+			return nil, err
+		}
+		sub := NewSubscription("topic", "", MsgTrade, nil, nil)
+		subs = append(subs, sub)
+		return sub, nil
+	})
+	if err := r.Renew(); err != nil || len(subs) != 2 {
+		t.Fatalf("expect renewed, got %v %d", err, len(subs))
+	}
+	err = failed
+	if err := r.Renew(); err != failed {
+		t.Fatalf("expect renew error, got %v", err)
+	}
+	err = nil
+	_ = r.Renew()
+	if err := r.Close(); err != nil || subs[2].State() != SubscriptionClosed || subs[0].State() != SubscriptionActive {
+		t.Fatalf("the current subscription is not closed, %v", err)
+	}
+	if err := r.Renew(); err != nil || len(subs) != 3 {
+		t.Fatal("renewed after closed")
+	}
+}